@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("audit", "Check the checksums, product info, metadata and indexes in the targets", runAudit)
+}
+
+func runAudit(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	prefix := fs.String("prefix", "", "The path in the targets to audit, like org/foo/, default is all")
+	packageType := fs.String("type", pkgs.PACKAGE_TYPE_MAVEN, "The package type of the targets, maven or npm")
+	repair := fs.Bool("repair", false, "Repair the problems which can be repaired")
+	verifyContent := fs.Bool("verify-content", false,
+		"Download the artifacts to verify their checksums, which is needed to repair the checksums")
+	report := fs.String("report", "", "Write the audit report as json to this file")
+	fs.Parse(args)
+
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	_, ok = pkgs.HandleAudit(ctx, *packageType, *prefix, targets, opts.awsProfile, opts.workDir,
+		*repair, *verifyContent, conf.AwsCFEnable, opts.dryRun, *report)
+	if !ok {
+		return 1
+	}
+	return 0
+}
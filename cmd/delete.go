@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("delete", "Roll back a maven product release tarball from the targets", runDelete)
+}
+
+func runDelete(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	opts := &releaseOptions{}
+	opts.register(fs)
+	repo, prodKey, ok := opts.parse(fs, args)
+	if !ok {
+		return 1
+	}
+
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	_, ok = pkgs.HandleMavenDeletion(ctx, repo, prodKey, opts.patterns(conf.IgnorePatterns), opts.rootPath,
+		targets, opts.awsProfile, opts.workDir, !opts.noIndex, conf.AwsCFEnable, opts.dryRun,
+		conf.ManifestBucket, opts.report)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("diff", "Compare the keys, checksums and products between two targets", runDiff)
+	registerCommand("sync", "Copy the files and products missing in a target from another target", runSync)
+}
+
+// Resolve the targets of --from and --to, both should have exactly one bucket
+func loadFromTo(fs *flag.FlagSet, args []string) (*commonOptions, *config.CharonConfig,
+	config.Target, config.Target, string, bool) {
+	opts := &commonOptions{}
+	opts.register(fs)
+	from := fs.String("from", "", "The target to compare from, like stage")
+	to := fs.String("to", "", "The target to compare to, like prod")
+	prefix := fs.String("prefix", "", "The path in the targets to compare, like org/foo/, default is all")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		logger.Error("--from and --to are required")
+		return nil, nil, config.Target{}, config.Target{}, "", false
+	}
+	opts.targets = append(opts.targets, *from, *to)
+	conf, targets, ok := opts.load()
+	if !ok {
+		return nil, nil, config.Target{}, config.Target{}, "", false
+	}
+	if len(conf.GetTarget(*from)) != 1 || len(conf.GetTarget(*to)) != 1 {
+		logger.Error("The targets of --from and --to should have exactly one bucket")
+		return nil, nil, config.Target{}, config.Target{}, "", false
+	}
+	return opts, conf, targets[0], targets[1], *prefix, true
+}
+
+func runDiff(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJson := fs.Bool("json", false, "Print the differences as json")
+	opts, _, from, to, prefix, ok := loadFromTo(fs, args)
+	if !ok {
+		return 1
+	}
+	diff, ok := pkgs.HandleTargetDiff(ctx, from, to, prefix, opts.awsProfile)
+	if !ok {
+		return 1
+	}
+	if *asJson {
+		content, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not format the differences as json: %s", err))
+			return 1
+		}
+		fmt.Println(string(content))
+	} else {
+		for _, e := range diff.Entries {
+			switch e.Type {
+			case pkgs.DIFF_ONLY_IN_FROM:
+				fmt.Printf("only in %s: %s\n", diff.From, e.Key)
+			case pkgs.DIFF_ONLY_IN_TO:
+				fmt.Printf("only in %s: %s\n", diff.To, e.Key)
+			case pkgs.DIFF_CHECKSUM_MISMATCH:
+				fmt.Printf("checksum differs: %s (%s vs %s)\n", e.Key, e.From, e.To)
+			case pkgs.DIFF_PRODUCTS_MISMATCH:
+				fmt.Printf("products differ: %s (%s vs %s)\n", e.Key, e.From, e.To)
+			}
+		}
+	}
+	// Like diff(1), the differences make the exit code 1
+	if len(diff.Entries) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runSync(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	noIndex := fs.Bool("no-index", false, "Do not generate the index files")
+	opts, conf, from, to, prefix, ok := loadFromTo(fs, args)
+	if !ok {
+		return 1
+	}
+	_, ok = pkgs.HandleTargetSync(ctx, from, to, prefix, opts.awsProfile, opts.workDir,
+		!*noIndex, conf.AwsCFEnable, opts.dryRun)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("index", "Maintain the index.html in the targets, sub commands: rebuild", runIndex)
+}
+
+func runIndex(ctx context.Context, args []string) int {
+	if len(args) < 1 || args[0] != "rebuild" {
+		fmt.Fprintln(os.Stderr, "Usage: charon index rebuild --target <target> [--path <path>] [--type maven|npm]")
+		return 1
+	}
+	fs := flag.NewFlagSet("index rebuild", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	subPath := fs.String("path", "", "The folder to start the rebuilding, like org/foo/, default is the root")
+	packageType := fs.String("type", pkgs.PACKAGE_TYPE_MAVEN, "The package type of the targets, maven or npm")
+	checkpoint := fs.String("checkpoint", "",
+		"The checkpoint file to record and resume the progress, default is the one in work dir")
+	fs.Parse(args[1:])
+
+	_, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	_, ok = pkgs.HandleIndexRebuild(ctx, *packageType, *subPath, targets,
+		opts.awsProfile, opts.workDir, *checkpoint, opts.dryRun)
+	if !ok {
+		return 1
+	}
+	return 0
+}
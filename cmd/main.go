@@ -1,58 +1,148 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/pkgs"
+	"org.commonjava/charon/module/storage"
 )
 
 var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+// A sub command of charon, which parses its own flags from the args
+// and returns the exit code. The ctx is cancelled by SIGINT or SIGTERM.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) int
+}
+
+var commands = map[string]command{}
+
+func registerCommand(name, usage string, run func(ctx context.Context, args []string) int) {
+	commands[name] = command{usage: usage, run: run}
+}
+
 func main() {
-	// s3Client, err := storage.NewS3Client("ronda", 0, false)
-	// if err != nil {
-	// 	logger.Error(err.Error())
-	// 	os.Exit(1)
-	// }
-
-	// files, ok := s3Client.GetFiles("dev-maven-bucket", "", "")
-	// if ok {
-	// 	for _, file := range files {
-	// 		fmt.Println(file)
-	// 	}
-	// }
-
-	// content, err := s3Client.ReadFileContent("dev-maven-bucket", "ga/org/apache/activemq/artemis-native/2.6.3.jbossorg-001/artemis-native-2.6.3.jbossorg-001.jar")
-	// if err == nil {
-	// 	fmt.Println(content)
-	// }
-
-	// content := s3Client.ListFolderContent("dev-maven-bucket", "ga/org/")
-	// for _, c := range content {
-	// 	fmt.Println(c)
-	// }
-
-	// ok, err := s3Client.FileExistsInBucket("dev-maven-bucket", "ga/org/index.html")
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// fmt.Println(ok)
-
-	// err = s3Client.SimpleUploadFile("org/test/simpletest", "this is a test",
-	// 	[2]string{"dev-maven-bucket", "ga"}, "plain/text", "", true)
-	// if err == nil {
-	// 	fmt.Println("Upload Successfully!")
-	// } else {
-	// 	fmt.Printf("Error: %s", err)
-	// }
-	// ok, _ := s3Client.FileExistsInBucket("dev-maven-bucket", "ga/org/test/simpletest")
-	// fmt.Println(ok)
-	// ok = s3Client.SimpleDeleteFile("org/test/simpletest", util.Target{Bucket: "dev-maven-bucket", Prefix: "ga"})
-	// if ok {
-	// 	fmt.Println("Delete Successfully!")
-	// } else {
-	// 	fmt.Println("Delete not successfully!")
-	// }
-	// ok, _ = s3Client.FileExistsInBucket("dev-maven-bucket", "ga/org/test/simpletest")
-	// fmt.Println(ok)
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", os.Args[1])
+		printUsage()
+		os.Exit(1)
+	}
+	// The first SIGINT or SIGTERM stops the command from starting new work,
+	// and the requests in flight are finished. The second one kills it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		logger.Warn("Interrupted, waiting for the requests in flight to finish. Interrupt again to exit now.")
+	}()
+	os.Exit(cmd.run(ctx, os.Args[2:]))
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: charon <command> [options]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+}
+
+// The options shared by all commands
+type commonOptions struct {
+	configFile string
+	targets    stringList
+	awsProfile string
+	workDir    string
+	dryRun     bool
+}
+
+func (o *commonOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", "", "The charon configuration file, default is ~/.charon/charon.yaml")
+	fs.Var(&o.targets, "target", "The target defined in charon configuration, can be specified multiple times")
+	fs.StringVar(&o.awsProfile, "profile", "", "The aws profile to use, default is the one in charon configuration")
+	fs.StringVar(&o.workDir, "work-dir", "", "The work dir to store temporary files, default is system tmp dir")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Do not change anything in the remote storage")
+}
+
+// Load the configuration and resolve all the targets specified by --target
+func (o *commonOptions) load() (*config.CharonConfig, []config.Target, bool) {
+	conf, ok := o.loadConfig()
+	if !ok {
+		return nil, nil, false
+	}
+	if len(o.targets) == 0 {
+		logger.Error("At least one --target is required")
+		return nil, nil, false
+	}
+	targets := []config.Target{}
+	for _, name := range o.targets {
+		ts := conf.GetTarget(name)
+		if len(ts) == 0 {
+			return nil, nil, false
+		}
+		for _, t := range ts {
+			targets = append(targets, *t)
+		}
+	}
+	return conf, targets, true
+}
+
+// Load the configuration, and apply its aws profile, retry policy and index
+// templates which are used by all commands
+func (o *commonOptions) loadConfig() (*config.CharonConfig, bool) {
+	conf, err := config.GetConfig(o.configFile)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not load charon configuration: %s", err))
+		return nil, false
+	}
+	if o.awsProfile == "" {
+		o.awsProfile = conf.AwsProfile
+	}
+	setRetryPolicy(conf)
+	for pkgType, templateFile := range conf.IndexTemplates {
+		if err := pkgs.SetIndexTemplate(pkgType, templateFile); err != nil {
+			logger.Error(fmt.Sprintf("Can not load index template for %s: %s", pkgType, err))
+			return nil, false
+		}
+	}
+	return conf, true
+}
+
+// Use the retry policy in the configuration for all the storage clients
+func setRetryPolicy(conf *config.CharonConfig) {
+	storage.SetDefaultRetryPolicy(storage.RetryPolicy{
+		MaxAttempts:    conf.Retry.MaxAttempts,
+		InitialBackoff: conf.Retry.InitialBackoff,
+		MaxBackoff:     conf.Retry.MaxBackoff,
+	})
+}
+
+// A flag value which can be specified multiple times
+type stringList []string
+
+func (s *stringList) String() string {
+	return fmt.Sprint([]string(*s))
+}
 
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("maven-index", "Export the maven indexer index for the maven targets", runMavenIndex)
+}
+
+func runMavenIndex(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("maven-index", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	repoId := fs.String("repo-id", "", "The repository id recorded in the index, default is the target name")
+	fs.Parse(args)
+
+	_, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	if *repoId == "" {
+		*repoId = opts.targets[0]
+	}
+	_, ok = pkgs.HandleMavenIndexing(ctx, targets, *repoId, opts.awsProfile, opts.workDir, opts.dryRun)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("metadata", "Maintain the maven-metadata.xml in the targets, sub commands: refresh", runMetadata)
+}
+
+func runMetadata(ctx context.Context, args []string) int {
+	if len(args) < 1 || args[0] != "refresh" {
+		fmt.Fprintln(os.Stderr, "Usage: charon metadata refresh --target <target> [--ga <groupId:artifactId>] [--path <path>]")
+		return 1
+	}
+	fs := flag.NewFlagSet("metadata refresh", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	var gas, paths stringList
+	fs.Var(&gas, "ga", "The GA to refresh, like org.foo:bar, can be specified multiple times")
+	fs.Var(&paths, "path", "The path to refresh all GAs under it, like org/foo/, can be specified multiple times")
+	fs.Parse(args[1:])
+
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	if len(gas) == 0 && len(paths) == 0 {
+		logger.Error("At least one --ga or --path is required")
+		return 1
+	}
+	_, ok = pkgs.HandleMetadataRefresh(ctx, gas, paths, targets,
+		opts.awsProfile, opts.workDir, conf.AwsCFEnable, opts.dryRun)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("mirror", "Mirror GAVs from an upstream maven repository to the targets", runMirror)
+}
+
+func runMirror(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	from := fs.String("from", "", "The url of the upstream maven repository to mirror from")
+	var gavs stringList
+	fs.Var(&gavs, "gav", "The groupId:artifactId:version to mirror, can be specified multiple times")
+	withDeps := fs.Bool("with-deps", false, "Mirror the parent and the compile and runtime dependencies too")
+	product := fs.String("product", "", "The product key, used to identify which product the mirrored files belong to")
+	version := fs.String("version", "", "The product version, used with the product as the product key")
+	noIndex := fs.Bool("no-index", false, "Do not generate the index files")
+	containSignature := fs.Bool("contain-signature", false, "Generate the signature files for the artifacts")
+	signKey := fs.String("sign-key", "", "The key used to sign the artifacts")
+	report := fs.String("report", "", "The file to write the json report of the process")
+	fs.Parse(args)
+
+	if *from == "" || len(gavs) == 0 {
+		logger.Error("--from and --gav are required")
+		return 1
+	}
+	if *product == "" || *version == "" {
+		logger.Error("--product and --version are required")
+		return 1
+	}
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	_, ok = pkgs.HandleMavenMirror(ctx, *from, gavs, *withDeps, fmt.Sprintf("%s-%s", *product, *version),
+		targets, opts.awsProfile, opts.workDir, !*noIndex, *containSignature, conf.AwsCFEnable, *signKey,
+		opts.dryRun, conf.ManifestBucket, opts.configFile, *report)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("migrate-prodinfo", "Migrate the .prodinfo files to the product_info mode of the targets",
+		runMigrateProdInfo)
+}
+
+func runMigrateProdInfo(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("migrate-prodinfo", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	prefix := fs.String("prefix", "", "The path in the targets to migrate, like org/foo/, default is all")
+	fs.Parse(args)
+
+	_, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	if !pkgs.HandleProdInfoMigration(ctx, targets, *prefix, opts.awsProfile, opts.dryRun) {
+		return 1
+	}
+	return 0
+}
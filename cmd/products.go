@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("products", "Query the products in the targets, sub commands: of, files", runProducts)
+}
+
+func runProducts(ctx context.Context, args []string) int {
+	if len(args) < 1 || (args[0] != "of" && args[0] != "files") {
+		fmt.Fprintln(os.Stderr, "Usage: charon products of <path> --target <target> [--json]")
+		fmt.Fprintln(os.Stderr, "       charon products files <product> --target <target> [--prefix <path>] [--json]")
+		return 1
+	}
+	fs := flag.NewFlagSet("products "+args[0], flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	asJson := fs.Bool("json", false, "Print the result as json")
+	prefix := fs.String("prefix", "", "The path to scan for the product files when there is no manifest")
+	// The flags can be given after the positional argument
+	positional := []string{}
+	rest := args[1:]
+	for {
+		fs.Parse(rest)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: charon products %s <%s> --target <target>\n",
+			args[0], map[string]string{"of": "path", "files": "product"}[args[0]])
+		return 1
+	}
+
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	var result any
+	if args[0] == "of" {
+		fileProducts, ok := pkgs.HandleProductsOf(ctx, positional[0], targets, opts.awsProfile)
+		if !ok {
+			return 1
+		}
+		result = fileProducts
+		if !*asJson {
+			for _, r := range fileProducts {
+				switch {
+				case !r.Exists:
+					fmt.Printf("%s/%s: not found\n", r.Bucket, r.Key)
+				case len(r.Products) == 0:
+					fmt.Printf("%s/%s: no product\n", r.Bucket, r.Key)
+				default:
+					fmt.Printf("%s/%s: %s\n", r.Bucket, r.Key, strings.Join(r.Products, ", "))
+				}
+			}
+		}
+	} else {
+		productFiles, ok := pkgs.HandleProductFiles(ctx, positional[0], targets, opts.awsProfile,
+			conf.ManifestBucket, *prefix)
+		if !ok {
+			return 1
+		}
+		result = productFiles
+		if !*asJson {
+			for _, r := range productFiles {
+				fmt.Printf("Files of product %s in bucket %s (from %s, %d):\n", r.Product, r.Bucket, r.Source, len(r.Files))
+				for _, f := range r.Files {
+					fmt.Printf("  %s\n", f)
+				}
+			}
+		}
+	}
+	if *asJson {
+		content, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not encode the result due to error: %s", err))
+			return 1
+		}
+		fmt.Println(string(content))
+	}
+	return 0
+}
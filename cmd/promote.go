@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("promote", "Promote a product from a target to other targets, like stage to ga", runPromote)
+}
+
+func runPromote(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	product := fs.String("product", "", "The product key to promote")
+	from := fs.String("from", "", "The target which the product is promoted from")
+	var to stringList
+	fs.Var(&to, "to", "The target which the product is promoted to, can be specified multiple times")
+	noIndex := fs.Bool("no-index", false, "Do not generate the index files")
+	fs.Parse(args)
+
+	if *product == "" || *from == "" || len(to) == 0 {
+		logger.Error("--product, --from and --to are required")
+		return 1
+	}
+	// The targets to promote to are the targets of the run
+	opts.targets = append(opts.targets, to...)
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	sources := conf.GetTarget(*from)
+	if len(sources) != 1 {
+		logger.Error(fmt.Sprintf("The target %s to promote from should have exactly one bucket", *from))
+		return 1
+	}
+	_, ok = pkgs.HandleMavenPromotion(ctx, *product, *sources[0], targets, opts.awsProfile,
+		opts.workDir, conf.ManifestBucket, !*noIndex, conf.AwsCFEnable, opts.dryRun)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("relocate", "Publish a relocation pom from the old GAV to the new GAV", runRelocate)
+}
+
+func runRelocate(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("relocate", flag.ExitOnError)
+	opts := &commonOptions{}
+	opts.register(fs)
+	oldGAV := fs.String("old", "", "The old GAV to be relocated, like org.foo:bar:1.0")
+	newGAV := fs.String("new", "", "The new GAV, like org.baz:bar:1.0. Empty parts are the same as the old GAV")
+	product := fs.String("product", "", "The product key which the relocation pom belongs to")
+	message := fs.String("message", "", "The message of the relocation")
+	fs.Parse(args)
+
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	if *product == "" {
+		logger.Error("--product is required")
+		return 1
+	}
+	relocation, err := pkgs.NewMavenRelocation(*oldGAV, *newGAV, *message)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Invalid relocation: %s", err))
+		return 1
+	}
+	_, ok = pkgs.HandleMavenRelocation(ctx, relocation, *product, targets,
+		opts.awsProfile, opts.workDir, conf.AwsCFEnable, opts.dryRun)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"org.commonjava/charon/module/pkgs"
+)
+
+func init() {
+	registerCommand("upload", "Upload a maven product release tarball to the targets", runUpload)
+}
+
+// The options shared by upload and delete
+type releaseOptions struct {
+	commonOptions
+	product        string
+	version        string
+	rootPath       string
+	ignorePatterns stringList
+	noIndex        bool
+	report         string
+}
+
+func (o *releaseOptions) register(fs *flag.FlagSet) {
+	o.commonOptions.register(fs)
+	fs.StringVar(&o.product, "product", "", "The product key, used to identify which product the repo tar belongs to")
+	fs.StringVar(&o.version, "version", "", "The product version, used with the product as the product key")
+	fs.StringVar(&o.rootPath, "root-path", "maven-repository", "The root path in the tarball before the real maven paths")
+	fs.Var(&o.ignorePatterns, "ignore-patterns", "The regex pattern of the paths to be ignored, can be specified multiple times")
+	fs.BoolVar(&o.noIndex, "no-index", false, "Do not generate the index files")
+	fs.StringVar(&o.report, "report", "", "The file to write the json report of the process")
+}
+
+// Parse the flags and check the repo tarball, returns the repo and the product key
+func (o *releaseOptions) parse(fs *flag.FlagSet, args []string) (string, string, bool) {
+	fs.Parse(args)
+	return o.check(fs)
+}
+
+func (o *releaseOptions) check(fs *flag.FlagSet) (string, string, bool) {
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: charon %s [options] <repo tarball>\n", fs.Name())
+		return "", "", false
+	}
+	if o.product == "" || o.version == "" {
+		logger.Error("--product and --version are required")
+		return "", "", false
+	}
+	return fs.Arg(0), fmt.Sprintf("%s-%s", o.product, o.version), true
+}
+
+func (o *releaseOptions) patterns(confPatterns []string) []string {
+	if len(o.ignorePatterns) > 0 {
+		return o.ignorePatterns
+	}
+	return confPatterns
+}
+
+func runUpload(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	opts := &releaseOptions{}
+	opts.register(fs)
+	containSignature := fs.Bool("contain-signature", false, "Generate the signature files for the artifacts")
+	signKey := fs.String("sign-key", "", "The key used to sign the artifacts")
+	genChecksum := fs.Bool("gen-checksum", false, "Generate the missing digest files for the artifacts")
+	prefetch := fs.Bool("prefetch", false, "Check the existing files of each GA with one listing "+
+		"instead of a request for each file")
+	plan := fs.Bool("plan", false, "Only show what the uploading would change without any writing, "+
+		"the plan will be written to --report as json")
+	resume := fs.String("resume", "", "Resume the interrupted uploading from its work dir, "+
+		"the options of the uploading are read from the journal in the work dir")
+	fs.Parse(args)
+	if *resume != "" {
+		return runUploadResume(ctx, opts, *resume)
+	}
+	repo, prodKey, ok := opts.check(fs)
+	if !ok {
+		return 1
+	}
+
+	conf, targets, ok := opts.load()
+	if !ok {
+		return 1
+	}
+	if *plan {
+		_, ok = pkgs.HandleMavenUploadPlan(ctx, repo, prodKey, opts.patterns(conf.IgnorePatterns), opts.rootPath,
+			targets, opts.awsProfile, opts.workDir, !opts.noIndex, conf.AwsCFEnable, opts.report)
+		if !ok {
+			return 1
+		}
+		return 0
+	}
+	_, ok = pkgs.HandleMavenUploading(ctx, repo, prodKey, opts.patterns(conf.IgnorePatterns), opts.rootPath,
+		targets, opts.awsProfile, opts.workDir, !opts.noIndex, *containSignature, *genChecksum,
+		*prefetch, conf.AwsCFEnable, *signKey, opts.dryRun, conf.ManifestBucket, opts.configFile, opts.report)
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+func runUploadResume(ctx context.Context, opts *releaseOptions, workDir string) int {
+	if _, ok := opts.loadConfig(); !ok {
+		return 1
+	}
+	_, ok := pkgs.HandleMavenUploadResume(ctx, workDir, opts.awsProfile, opts.dryRun, opts.report)
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 	"org.commonjava/charon/module/util"
@@ -29,6 +30,26 @@ type CharonConfig struct {
 	ManifestBucket        string               `yaml:"manifest_bucket"`
 	IgnoreSignatureSuffix map[string][]string  `yaml:"ignore_signature_suffix"`
 	SignatureCommand      string               `yaml:"detach_signature_command"`
+	// The template files to override the default index.html templates,
+	// keyed by package type, like "maven: /path/to/index.html.tmpl"
+	IndexTemplates map[string]string `yaml:"index_templates"`
+	// The retry policy of the failed requests to s3 and CloudFront
+	Retry RetryConfig `yaml:"retry"`
+}
+
+// RetryConfig is the retry policy for the failed requests, like
+//
+//	retry:
+//	  max_attempts: 5
+//	  initial_backoff: 500ms
+//	  max_backoff: 30s
+//
+// The fields not set will use the defaults of the storage clients.
+type RetryConfig struct {
+	// The attempts of a request including the first one, 1 means no retry
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
 }
 
 type Target struct {
@@ -36,8 +57,29 @@ type Target struct {
 	Prefix   string `yaml:"prefix"`
 	Registry string `yaml:"registry"`
 	Domain   string `yaml:"domain"`
+	// Generate the index.json along with index.html for each directory
+	IndexJson bool `yaml:"index_json"`
+	// The validation rules enabled for this target, which maps the rule
+	// name to its severity, like "no-snapshot: error"
+	ValidationRules map[string]string `yaml:"validation_rules"`
+	// How the products of the files are tracked in this target, one of
+	// "sidecar" (the .prodinfo files, by default), "tagging" (the object
+	// tags) or "metadata" (the user metadata of the objects). With
+	// "metadata", each product change copies the object to itself, which
+	// creates a new full version in a versioned bucket, and objects larger
+	// than 5GB can not be changed
+	ProductInfo string `yaml:"product_info"`
 }
 
+const (
+	SEVERITY_ERROR   = "error"
+	SEVERITY_WARNING = "warning"
+
+	PRODUCT_INFO_SIDECAR  = "sidecar"
+	PRODUCT_INFO_TAGGING  = "tagging"
+	PRODUCT_INFO_METADATA = "metadata"
+)
+
 func (c *CharonConfig) GetTarget(t string) []*Target {
 	target_ := c.Targets[t]
 	if target_ == nil {
@@ -108,11 +150,32 @@ func validateConfig(conf *CharonConfig) error {
 	if len(targets) == 0 {
 		return fmt.Errorf(MISSING_FIELD, "targets")
 	}
+	for pkgType := range conf.IndexTemplates {
+		if pkgType != util.PACKAGE_TYPE_MAVEN && pkgType != util.PACKAGE_TYPE_NPM {
+			return fmt.Errorf("package type of index template must be one of '%s' or '%s', but got '%s'",
+				util.PACKAGE_TYPE_MAVEN, util.PACKAGE_TYPE_NPM, pkgType)
+		}
+	}
+	if conf.Retry.MaxAttempts < 0 || conf.Retry.InitialBackoff < 0 || conf.Retry.MaxBackoff < 0 {
+		return fmt.Errorf("retry max_attempts, initial_backoff and max_backoff must not be negative")
+	}
 	for _, v := range targets {
 		for _, t := range v {
 			if util.IsBlankString(t.Bucket) {
 				return fmt.Errorf(MISSING_FIELD, "bucket")
 			}
+			switch t.ProductInfo {
+			case "", PRODUCT_INFO_SIDECAR, PRODUCT_INFO_TAGGING, PRODUCT_INFO_METADATA:
+			default:
+				return fmt.Errorf("product_info of bucket '%s' must be one of '%s', '%s' or '%s', but got '%s'",
+					t.Bucket, PRODUCT_INFO_SIDECAR, PRODUCT_INFO_TAGGING, PRODUCT_INFO_METADATA, t.ProductInfo)
+			}
+			for rule, severity := range t.ValidationRules {
+				if severity != SEVERITY_ERROR && severity != SEVERITY_WARNING {
+					return fmt.Errorf("severity of validation rule '%s' must be one of '%s' or '%s', but got '%s'",
+						rule, SEVERITY_ERROR, SEVERITY_WARNING, severity)
+				}
+			}
 		}
 	}
 	return nil
@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"org.commonjava/charon/module/util/files"
@@ -139,6 +141,103 @@ targets:
 	assert.Equal(t, "localhost", conf.GetTarget("npm")[0].Registry)
 }
 
+func TestConfigValidationRules(t *testing.T) {
+	content := `targets:
+  ga:
+  - bucket: charon-test
+    validation_rules:
+      no-snapshot: error
+      sha1-checksum: warning
+`
+	resetGlobal()
+	defer bt.TearDown()
+	bt.ChangeConfigContent(content)
+	conf, err := GetConfig("")
+	assert.Nil(t, err)
+	assert.NotNil(t, conf)
+	rules := conf.GetTarget("ga")[0].ValidationRules
+	assert.Equal(t, map[string]string{"no-snapshot": "error", "sha1-checksum": "warning"}, rules)
+
+	content = `targets:
+  ga:
+  - bucket: charon-test
+    validation_rules:
+      no-snapshot: fatal
+`
+	resetGlobal()
+	bt.ChangeConfigContent(content)
+	_, err = GetConfig("")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no-snapshot")
+}
+
+func TestConfigIndexJson(t *testing.T) {
+	content := `targets:
+  ga:
+  - bucket: charon-test
+    index_json: true
+  ea:
+  - bucket: charon-test-ea
+`
+	resetGlobal()
+	defer bt.TearDown()
+	bt.ChangeConfigContent(content)
+	conf, err := GetConfig("")
+	assert.Nil(t, err)
+	assert.True(t, conf.GetTarget("ga")[0].IndexJson)
+	assert.False(t, conf.GetTarget("ea")[0].IndexJson)
+}
+
+func TestConfigRetry(t *testing.T) {
+	content := `targets:
+  ga:
+  - bucket: charon-test
+retry:
+  max_attempts: 5
+  initial_backoff: 500ms
+  max_backoff: 30s
+`
+	resetGlobal()
+	defer bt.TearDown()
+	bt.ChangeConfigContent(content)
+	conf, err := GetConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, RetryConfig{MaxAttempts: 5, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+		conf.Retry)
+
+	resetGlobal()
+	bt.ChangeConfigContent(strings.Replace(content, "max_attempts: 5", "max_attempts: -1", 1))
+	_, err = GetConfig("")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "must not be negative")
+}
+
+func TestConfigProductInfo(t *testing.T) {
+	content := `targets:
+  ga:
+  - bucket: charon-test
+    product_info: tagging
+  - bucket: charon-test-meta
+    product_info: metadata
+  - bucket: charon-test-sidecar
+`
+	resetGlobal()
+	defer bt.TearDown()
+	bt.ChangeConfigContent(content)
+	conf, err := GetConfig("")
+	assert.Nil(t, err)
+	targets := conf.GetTarget("ga")
+	assert.Equal(t, PRODUCT_INFO_TAGGING, targets[0].ProductInfo)
+	assert.Equal(t, PRODUCT_INFO_METADATA, targets[1].ProductInfo)
+	assert.Equal(t, "", targets[2].ProductInfo)
+
+	resetGlobal()
+	bt.ChangeConfigContent(strings.Replace(content, "product_info: tagging", "product_info: tags", 1))
+	_, err = GetConfig("")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "product_info of bucket 'charon-test'")
+}
+
 func TestIgnorePatterns(t *testing.T) {
 	contentMissingTargets := `ignore_patterns:
   - '\.nexus.*' # noqa: W605
@@ -0,0 +1,562 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+)
+
+// The types of the problems found by the audit
+const (
+	AUDIT_CHECKSUM_MISMATCH = "checksum_mismatch"
+	AUDIT_ORPHAN_PRODINFO   = "orphan_prodinfo"
+	AUDIT_MISSING_PRODINFO  = "missing_prodinfo"
+	AUDIT_EMPTY_PRODINFO    = "empty_prodinfo"
+	AUDIT_METADATA_MISMATCH = "metadata_mismatch"
+	AUDIT_DANGLING_INDEX    = "dangling_index"
+)
+
+// AuditProblem is a problem found by the audit. The key is the path in the
+// bucket without the prefix of the target.
+type AuditProblem struct {
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// AuditReport is the result of the audit of a target
+type AuditReport struct {
+	Bucket   string         `json:"bucket"`
+	Prefix   string         `json:"prefix"`
+	Objects  int            `json:"objects"`
+	Problems []AuditProblem `json:"problems"`
+}
+
+// The remote maven-metadata.xml, only the fields needed by the audit are parsed
+type remoteMavenMetadata struct {
+	Versions []string      `xml:"versioning>versions>version"`
+	Plugins  []MavenPlugin `xml:"plugins>plugin"`
+}
+
+// The audit of a single target. All the objects under the scanned path are
+// listed once, and the checks are done against the listing.
+type auditor struct {
+	ctx           context.Context
+	s3Client      *storage.S3Client
+	target        config.Target
+	subPath       string
+	packageType   string
+	repair        bool
+	verifyContent bool
+	cfEnable      bool
+	awsProfile    string
+	root          string
+
+	keys   map[string]bool
+	mu     sync.Mutex
+	report *AuditReport
+}
+
+// Handle the audit of the targets. The objects under the subPath of each
+// target are checked for these problems:
+//   - the checksum metadata of the artifacts disagrees with their .sha1
+//     files, or with their content if verifyContent is enabled
+//   - the .prodinfo files without their files and the files without
+//     .prodinfo, or the files with empty product info
+//   - the versions in the GA level maven-metadata.xml disagree with the
+//     poms in the GA
+//   - the entries in index.html point to the files or folders which do
+//     not exist
+//
+// If repair is enabled, the problems which can be repaired will be fixed:
+// the checksums are fixed from the content, the orphan .prodinfo files are
+// deleted, and the maven-metadata.xml and index.html are regenerated. The
+// reports will be written as json to reportFile if it is not empty.
+//
+// Returns the directory used for the regenerated files and if there is no
+// problem left
+func HandleAudit(
+	ctx context.Context,
+	packageType,
+	subPath string,
+	targets []config.Target,
+	awsProfile,
+	dir_ string,
+	repair,
+	verifyContent,
+	cfEnable,
+	dryRun bool,
+	reportFile string,
+) (string, bool) {
+	if packageType != PACKAGE_TYPE_MAVEN && packageType != PACKAGE_TYPE_NPM {
+		logger.Error(fmt.Sprintf("Unsupported package type %s for audit", packageType))
+		return "", false
+	}
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return "", false
+	}
+	workDir, err := os.MkdirTemp(dir_, "charon-audit-*")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create work dir for audit due to error: %s", err))
+		return "", false
+	}
+	succeeded := true
+	reports := []*AuditReport{}
+	for _, target := range targets {
+		if interrupted(ctx, "audit for bucket "+target.Bucket) {
+			succeeded = false
+			break
+		}
+		t := fixTarget(target)
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		a := &auditor{
+			ctx:           ctx,
+			s3Client:      s3Client,
+			target:        t,
+			subPath:       strings.Trim(subPath, "/"),
+			packageType:   packageType,
+			repair:        repair,
+			verifyContent: verifyContent,
+			cfEnable:      cfEnable,
+			awsProfile:    awsProfile,
+			root:          path.Join(workDir, t.Bucket),
+		}
+		report, ok := a.run()
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if !ok {
+			succeeded = false
+		}
+	}
+	if reportFile != "" {
+		content, err := json.MarshalIndent(reports, "", "  ")
+		if err == nil {
+			err = os.WriteFile(reportFile, content, 0644)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not write report %s due to error: %s", reportFile, err))
+		} else {
+			logger.Info("Report is written to " + reportFile)
+		}
+	}
+	return workDir, succeeded
+}
+
+// Run all the checks for the target. Returns the report and if there is no
+// problem left.
+func (a *auditor) run() (*AuditReport, bool) {
+	bucketName := a.target.Bucket
+	scanPrefix := strings.Trim(path.Join(a.target.Prefix, a.subPath), "/")
+	if scanPrefix != "" {
+		scanPrefix += "/"
+	}
+	logger.Info(fmt.Sprintf("Start auditing %s in bucket %s", scanPrefix, bucketName))
+	infos, ok := a.s3Client.GetFileInfos(bucketName, scanPrefix, "")
+	if !ok {
+		return nil, false
+	}
+	a.report = &AuditReport{Bucket: bucketName, Prefix: scanPrefix, Objects: len(infos), Problems: []AuditProblem{}}
+	a.keys = make(map[string]bool, len(infos))
+	for _, info := range infos {
+		a.keys[a.relKey(info.Key)] = true
+	}
+	keys := make([]string, 0, len(a.keys))
+	for k := range a.keys {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	a.auditChecksums(keys)
+	a.auditProductInfo(keys)
+	if a.packageType == PACKAGE_TYPE_MAVEN {
+		a.auditMetadata(keys)
+	}
+	a.auditIndexes(keys)
+
+	slices.SortFunc(a.report.Problems, func(p1, p2 AuditProblem) int {
+		if c := strings.Compare(p1.Key, p2.Key); c != 0 {
+			return c
+		}
+		return strings.Compare(p1.Type, p2.Type)
+	})
+	left := 0
+	counts := map[string]int{}
+	for _, p := range a.report.Problems {
+		counts[p.Type]++
+		if !p.Repaired {
+			left++
+		}
+	}
+	logger.Info(fmt.Sprintf("Audit done for bucket %s: %d objects, %d problems %v, %d not repaired\n",
+		bucketName, len(infos), len(a.report.Problems), counts, left))
+	return a.report, left == 0 && a.ctx.Err() == nil
+}
+
+// The key relative to the prefix of the target
+func (a *auditor) relKey(key string) string {
+	if a.target.Prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(key, a.target.Prefix), "/")
+}
+
+func (a *auditor) fullKey(rel string) string {
+	return path.Join(a.target.Prefix, rel)
+}
+
+func (a *auditor) addProblem(problemType, key, detail string, repaired bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	msg := fmt.Sprintf("[%s] %s in bucket %s: %s", problemType, key, a.target.Bucket, detail)
+	if repaired {
+		logger.Info(msg + ", repaired")
+	} else {
+		logger.Warn(msg)
+	}
+	a.report.Problems = append(a.report.Problems, AuditProblem{
+		Type: problemType, Key: key, Detail: detail, Repaired: repaired})
+}
+
+// The artifacts which should have the checksum metadata and product info,
+// which excludes the metadata, digests of metadata and the .prodinfo files
+func isAuditedArtifact(key string) bool {
+	if strings.HasSuffix(key, util.PROD_INFO_SUFFIX) || IsMetadata(key) {
+		return false
+	}
+	if isVerificationFile(key) && IsMetadata(strings.TrimSuffix(key, path.Ext(key))) {
+		return false
+	}
+	return true
+}
+
+// Check the checksum metadata of the artifacts against their .sha1 files,
+// and against their content if verifyContent is enabled. The checksums can
+// only be repaired when the content is verified.
+func (a *auditor) auditChecksums(keys []string) {
+	logger.Info("Start auditing checksums in bucket " + a.target.Bucket)
+	bucketName := a.target.Bucket
+	g := new(errgroup.Group)
+	g.SetLimit(storage.DEFAULT_CONCURRENT_LIMIT)
+	for _, key := range keys {
+		if !isAuditedArtifact(key) || isVerificationFile(key) {
+			continue
+		}
+		key := key
+		g.Go(func() error {
+			if a.ctx.Err() != nil {
+				return nil
+			}
+			fullKey := a.fullKey(key)
+			fMeta, existed, err := a.s3Client.GetFileMetadata(bucketName, fullKey)
+			if err != nil || !existed {
+				logger.Warn(fmt.Sprintf("Can not get metadata of %s in bucket %s, existed: %v, error: %v",
+					key, bucketName, existed, err))
+				return nil
+			}
+			checksum := strings.TrimSpace(fMeta[storage.CHECKSUM_META_KEY])
+			sha1File := ""
+			if a.keys[key+".sha1"] {
+				if content, err := a.s3Client.ReadFileContent(bucketName, fullKey+".sha1"); err == nil {
+					if fields := strings.Fields(content); len(fields) > 0 {
+						sha1File = strings.ToLower(fields[0])
+					}
+				}
+			}
+			contentSHA1 := ""
+			if a.verifyContent {
+				contentSHA1, err = a.s3Client.DigestFile(bucketName, fullKey, crypto.SHA1)
+				if err != nil {
+					return nil
+				}
+			}
+			a.checkChecksum(key, checksum, sha1File, contentSHA1)
+			return nil
+		})
+	}
+	g.Wait()
+	logger.Info("Checksums auditing done\n")
+}
+
+func (a *auditor) checkChecksum(key, checksum, sha1File, contentSHA1 string) {
+	details := []string{}
+	switch {
+	case checksum == "":
+		details = append(details, "no checksum metadata")
+	case sha1File != "" && checksum != sha1File:
+		details = append(details, fmt.Sprintf("checksum metadata %s disagrees with .sha1 %s", checksum, sha1File))
+	case contentSHA1 != "" && checksum != contentSHA1:
+		details = append(details, fmt.Sprintf("checksum metadata %s disagrees with content %s", checksum, contentSHA1))
+	}
+	if contentSHA1 != "" && sha1File != "" && sha1File != contentSHA1 {
+		details = append(details, fmt.Sprintf(".sha1 %s disagrees with content %s", sha1File, contentSHA1))
+	}
+	if len(details) == 0 {
+		return
+	}
+	repaired := false
+	if a.repair && contentSHA1 != "" {
+		repaired = true
+		if checksum != contentSHA1 {
+			repaired = a.s3Client.SetChecksumMetadata(a.target.Bucket, a.fullKey(key), contentSHA1)
+		}
+		if repaired && sha1File != "" && sha1File != contentSHA1 {
+			repaired = a.s3Client.ReplaceFileContent(a.target.Bucket, a.fullKey(key+".sha1"), contentSHA1)
+		}
+	}
+	a.addProblem(AUDIT_CHECKSUM_MISMATCH, key, strings.Join(details, "; "), repaired)
+}
+
+// Check the product info of the artifacts. In sidecar mode the .prodinfo
+// files are checked against the listing, and the orphan ones are deleted
+// when repairing. In other modes the product info of each artifact is read.
+func (a *auditor) auditProductInfo(keys []string) {
+	logger.Info("Start auditing product info in bucket " + a.target.Bucket)
+	bucketName := a.target.Bucket
+	sidecar := a.target.ProductInfo == "" || a.target.ProductInfo == config.PRODUCT_INFO_SIDECAR
+	g := new(errgroup.Group)
+	g.SetLimit(storage.DEFAULT_CONCURRENT_LIMIT)
+	for _, key := range keys {
+		key := key
+		if sidecar && strings.HasSuffix(key, util.PROD_INFO_SUFFIX) {
+			file := strings.TrimSuffix(key, util.PROD_INFO_SUFFIX)
+			if !a.keys[file] {
+				repaired := a.repair && a.s3Client.SimpleDeleteFile(key, a.target)
+				a.addProblem(AUDIT_ORPHAN_PRODINFO, key, "the file of the product info does not exist", repaired)
+				continue
+			}
+			g.Go(func() error {
+				if a.ctx.Err() != nil {
+					return nil
+				}
+				content, err := a.s3Client.ReadFileContent(bucketName, a.fullKey(key))
+				if err == nil && util.IsBlankString(strings.ReplaceAll(content, ",", "")) {
+					a.addProblem(AUDIT_EMPTY_PRODINFO, file, "the .prodinfo file has no product", false)
+				}
+				return nil
+			})
+			continue
+		}
+		if !isAuditedArtifact(key) || strings.HasPrefix(key, ".") || strings.Contains(key, "/.") {
+			continue
+		}
+		if sidecar {
+			if !a.keys[key+util.PROD_INFO_SUFFIX] {
+				a.addProblem(AUDIT_MISSING_PRODINFO, key, "the file has no .prodinfo", false)
+			}
+			continue
+		}
+		g.Go(func() error {
+			if a.ctx.Err() != nil {
+				return nil
+			}
+			prods, ok := a.s3Client.GetProductInfo(a.fullKey(key), bucketName)
+			if ok && len(prods) == 0 {
+				a.addProblem(AUDIT_EMPTY_PRODINFO, key, fmt.Sprintf("the file has no product in %s",
+					a.target.ProductInfo), false)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	logger.Info("Product info auditing done\n")
+}
+
+// Check the GA level maven-metadata.xml against the poms of the GAs. Only
+// the GAs fully under the audited path are checked, and the mismatched ones
+// are refreshed when repairing.
+func (a *auditor) auditMetadata(keys []string) {
+	logger.Info("Start auditing maven-metadata.xml in bucket " + a.target.Bucket)
+	inScope := func(gaPath string) bool {
+		return a.subPath == "" || strings.HasPrefix(gaPath+"/", a.subPath+"/")
+	}
+	gaVersions := make(map[string][]string)
+	metaPaths := []string{}
+	for _, key := range keys {
+		if path.Ext(key) == ".pom" && isGAVPath(key, "") {
+			gaPath := path.Dir(path.Dir(key))
+			v := path.Base(path.Dir(key))
+			if inScope(gaPath) && !slices.Contains(gaVersions[gaPath], v) {
+				gaVersions[gaPath] = append(gaVersions[gaPath], v)
+			}
+		} else if path.Base(key) == MAVEN_METADATA_FILE && inScope(path.Dir(key)) {
+			metaPaths = append(metaPaths, path.Dir(key))
+		}
+	}
+
+	mismatched := []string{}
+	details := make(map[string]string)
+	for gaPath := range gaVersions {
+		if !a.keys[path.Join(gaPath, MAVEN_METADATA_FILE)] {
+			mismatched = append(mismatched, gaPath)
+			details[gaPath] = "maven-metadata.xml does not exist"
+		}
+	}
+	for _, gaPath := range metaPaths {
+		if a.ctx.Err() != nil {
+			return
+		}
+		// The version level metadata of SNAPSHOT versions is not checked
+		if strings.HasSuffix(gaPath, SNAPSHOT_SUFFIX) &&
+			slices.Contains(gaVersions[path.Dir(gaPath)], path.Base(gaPath)) {
+			continue
+		}
+		content, err := a.s3Client.ReadFileContent(a.target.Bucket,
+			a.fullKey(path.Join(gaPath, MAVEN_METADATA_FILE)))
+		if err != nil {
+			continue
+		}
+		var remote remoteMavenMetadata
+		if err := xml.Unmarshal([]byte(content), &remote); err != nil {
+			mismatched = append(mismatched, gaPath)
+			details[gaPath] = fmt.Sprintf("maven-metadata.xml can not be parsed: %s", err)
+			continue
+		}
+		// The group level metadata only has the plugins
+		if len(remote.Versions) == 0 && len(remote.Plugins) > 0 {
+			continue
+		}
+		local := gaVersions[gaPath]
+		missing := slices.DeleteFunc(slices.Clone(local), func(v string) bool {
+			return slices.Contains(remote.Versions, v)
+		})
+		extra := slices.DeleteFunc(slices.Clone(remote.Versions), func(v string) bool {
+			return slices.Contains(local, v)
+		})
+		if len(missing) > 0 || len(extra) > 0 {
+			slices.SortFunc(missing, versionCompare)
+			slices.SortFunc(extra, versionCompare)
+			mismatched = append(mismatched, gaPath)
+			details[gaPath] = fmt.Sprintf("versions without poms %v, poms not in versions %v", extra, missing)
+		}
+	}
+	if len(mismatched) == 0 {
+		logger.Info("maven-metadata.xml auditing done\n")
+		return
+	}
+	slices.Sort(mismatched)
+	repaired := false
+	if a.repair {
+		gas := make([]string, 0, len(mismatched))
+		for _, gaPath := range mismatched {
+			ga := parseGA(gaPath, "")
+			gas = append(gas, ga[0]+":"+ga[1])
+		}
+		repaired = refreshTargetMetadatas(a.ctx, a.s3Client, a.target, gas, nil,
+			a.root, a.awsProfile, a.cfEnable)
+	}
+	for _, gaPath := range mismatched {
+		a.addProblem(AUDIT_METADATA_MISMATCH, path.Join(gaPath, MAVEN_METADATA_FILE), details[gaPath], repaired)
+	}
+	logger.Info("maven-metadata.xml auditing done\n")
+}
+
+// Check the entries of the index.html against the listing. The index.html
+// with dangling entries are regenerated when repairing.
+func (a *auditor) auditIndexes(keys []string) {
+	logger.Info("Start auditing index.html in bucket " + a.target.Bucket)
+	// The folders with only .prodinfo or index files are not listed in index
+	folders := make(map[string]bool)
+	for _, key := range keys {
+		if strings.HasSuffix(key, util.PROD_INFO_SUFFIX) || isIndexFile(key) {
+			continue
+		}
+		for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+			folders[dir] = true
+		}
+	}
+	dangling := map[string][]string{}
+	for _, key := range keys {
+		if path.Base(key) != INDEX_HTML_FILE {
+			continue
+		}
+		if a.ctx.Err() != nil {
+			return
+		}
+		content, err := a.s3Client.ReadFileContent(a.target.Bucket, a.fullKey(key))
+		if err != nil {
+			continue
+		}
+		if entries := danglingIndexEntries(content, path.Dir(key), a.keys, folders); len(entries) > 0 {
+			dangling[key] = entries
+		}
+	}
+	indexKeys := make([]string, 0, len(dangling))
+	for k := range dangling {
+		indexKeys = append(indexKeys, k)
+	}
+	slices.Sort(indexKeys)
+	regenerated := []string{}
+	for _, key := range indexKeys {
+		repaired := false
+		if a.repair {
+			folder := path.Dir(key) + "/"
+			if folder == "./" {
+				folder = "/"
+			}
+			indexFiles, _, ok := generateIndexFiles(a.s3Client, a.packageType, a.target.Bucket, folder,
+				a.root, a.target.Prefix, a.target.IndexJson)
+			if ok && len(indexFiles) > 0 {
+				ok = len(a.s3Client.UploadMetadatas(indexFiles, a.target, "", a.root)) == 0
+				regenerated = append(regenerated, indexFiles...)
+			}
+			repaired = ok
+		}
+		a.addProblem(AUDIT_DANGLING_INDEX, key, fmt.Sprintf("entries do not exist: %s",
+			strings.Join(dangling[key], ", ")), repaired)
+	}
+	if a.cfEnable && len(regenerated) > 0 {
+		cfClient, err := storage.NewCFClient(a.ctx, a.awsProfile)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Cannot do Cloudfront cache invalidating due to error: %s", err))
+		} else {
+			invalidateCFPaths(cfClient, a.target, regenerated, a.root, storage.INVALIDATION_BATCH_DEFAULT)
+		}
+	}
+	logger.Info("index.html auditing done\n")
+}
+
+// Find the entries in the index.html content of the folder which do not
+// exist in the keys or folders. The parent, breadcrumb and external links
+// are not checked.
+func danglingIndexEntries(content, folder string, keys, folders map[string]bool) []string {
+	dangling := []string{}
+	for _, m := range indexHrefPattern.FindAllStringSubmatch(content, -1) {
+		href := html.UnescapeString(m[1])
+		if href == "" || strings.HasPrefix(href, "../") || strings.HasPrefix(href, "./") ||
+			strings.HasPrefix(href, "/") || strings.HasPrefix(href, "#") || strings.Contains(href, "://") {
+			continue
+		}
+		// The folders are linked to their index.html in npm index
+		if strings.HasSuffix(href, "/"+INDEX_HTML_FILE) {
+			href = strings.TrimSuffix(href, INDEX_HTML_FILE)
+		}
+		entry := path.Join(folder, href)
+		if strings.HasSuffix(href, "/") {
+			if !folders[entry] {
+				dangling = append(dangling, href)
+			}
+		} else if !keys[entry] {
+			dangling = append(dangling, href)
+		}
+	}
+	return dangling
+}
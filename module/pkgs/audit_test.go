@@ -0,0 +1,148 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestAudit(t *testing.T) {
+	bucket := storage.TEST_BUCKET
+	remote := newMemS3()
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.pom", "pom-1.0")
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.pom.prodinfo", "foo-1.0")
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.jar", "jar-1.0")
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.jar.sha1", files.DigestContent("jar-1.0", crypto.SHA1))
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.jar.prodinfo", "foo-1.0")
+	remote.put(bucket, "org/foo/bar/2.0/bar-2.0.pom", "pom-2.0")
+	remote.put(bucket, "org/foo/bar/2.0/bar-2.0.pom.prodinfo", " ")
+	remote.put(bucket, "org/foo/bar/3.0/bar-3.0.pom.prodinfo", "foo-3.0")
+	remote.put(bucket, "org/foo/bar/maven-metadata.xml", `<metadata><groupId>org.foo</groupId>
+<artifactId>bar</artifactId><versioning><versions><version>1.0</version><version>3.0</version>
+</versions></versioning></metadata>`)
+	remote.put(bucket, "org/foo/bar/index.html", `<a href="../">../</a><a href="1.0/">1.0/</a>
+<a href="2.0/">2.0/</a><a href="3.0/">3.0/</a><a href="maven-metadata.xml">maven-metadata.xml</a>`)
+	remote.put(bucket, "org/foo/bar/1.0/index.html", `<a href="../">../</a><a href="bar-1.0.jar">bar-1.0.jar</a>
+<a href="bar-1.0.pom">bar-1.0.pom</a>`)
+	// The checksum metadata of the jar disagrees with its content and .sha1
+	jar, _ := remote.get(bucket, "org/foo/bar/1.0/bar-1.0.jar")
+	jar.meta[storage.CHECKSUM_META_KEY] = "0000"
+
+	newAuditor := func(repair bool) *auditor {
+		return &auditor{
+			ctx:           context.Background(),
+			s3Client:      remote.client(t),
+			target:        config.Target{Bucket: bucket},
+			packageType:   PACKAGE_TYPE_MAVEN,
+			repair:        repair,
+			verifyContent: true,
+			root:          t.TempDir(),
+		}
+	}
+	problemsOf := func(report *AuditReport) map[string]string {
+		problems := map[string]string{}
+		for _, p := range report.Problems {
+			problems[p.Key] = p.Type
+		}
+		return problems
+	}
+
+	report, ok := newAuditor(false).run()
+	assert.False(t, ok)
+	assert.Equal(t, map[string]string{
+		"org/foo/bar/1.0/bar-1.0.jar":          AUDIT_CHECKSUM_MISMATCH,
+		"org/foo/bar/1.0/bar-1.0.jar.sha1":     AUDIT_MISSING_PRODINFO,
+		"org/foo/bar/2.0/bar-2.0.pom":          AUDIT_EMPTY_PRODINFO,
+		"org/foo/bar/3.0/bar-3.0.pom.prodinfo": AUDIT_ORPHAN_PRODINFO,
+		"org/foo/bar/maven-metadata.xml":       AUDIT_METADATA_MISMATCH,
+		"org/foo/bar/index.html":               AUDIT_DANGLING_INDEX,
+	}, problemsOf(report))
+	for _, p := range report.Problems {
+		assert.False(t, p.Repaired)
+	}
+	_, existed := remote.get(bucket, "org/foo/bar/3.0/bar-3.0.pom.prodinfo")
+	assert.True(t, existed)
+
+	report, ok = newAuditor(true).run()
+	assert.False(t, ok)
+	for _, p := range report.Problems {
+		repairable := p.Type != AUDIT_MISSING_PRODINFO && p.Type != AUDIT_EMPTY_PRODINFO
+		assert.Equal(t, repairable, p.Repaired, p.Key)
+	}
+	jar, _ = remote.get(bucket, "org/foo/bar/1.0/bar-1.0.jar")
+	assert.Equal(t, files.DigestContent("jar-1.0", crypto.SHA1), jar.meta[storage.CHECKSUM_META_KEY])
+	_, existed = remote.get(bucket, "org/foo/bar/3.0/bar-3.0.pom.prodinfo")
+	assert.False(t, existed)
+	meta, _ := remote.get(bucket, "org/foo/bar/maven-metadata.xml")
+	assert.Contains(t, meta.content, "<version>2.0</version>")
+	assert.NotContains(t, meta.content, "<version>3.0</version>")
+	index, _ := remote.get(bucket, "org/foo/bar/index.html")
+	assert.NotContains(t, index.content, `href="3.0/"`)
+
+	// Only the problems which can not be repaired are left
+	report, _ = newAuditor(false).run()
+	assert.Equal(t, map[string]string{
+		"org/foo/bar/1.0/bar-1.0.jar.sha1": AUDIT_MISSING_PRODINFO,
+		"org/foo/bar/2.0/bar-2.0.pom":      AUDIT_EMPTY_PRODINFO,
+	}, problemsOf(report))
+
+	assert.Equal(t, []string{"baz/", "1.0/baz.jar"},
+		danglingIndexEntries(`<a href="../">../</a><a href="./">bar/</a><a href="baz/index.html">baz/</a>
+<a href="http://foo.com/a">a</a><a href="1.0/baz.jar">baz.jar</a><a href="foo.jar">foo.jar</a>`,
+			"org/bar", map[string]bool{"org/bar/foo.jar": true}, map[string]bool{}))
+}
+
+func TestAuditRepairKeepsProducts(t *testing.T) {
+	jarKey := "org/foo/bar/1.0/bar-1.0.jar"
+	for _, mode := range []string{config.PRODUCT_INFO_TAGGING, config.PRODUCT_INFO_METADATA} {
+		remote := newMemS3()
+		bucket := storage.TEST_BUCKET
+		remote.put(bucket, jarKey, "jar-1.0")
+		// The .sha1 disagrees with the content and the checksum metadata
+		remote.put(bucket, jarKey+".sha1", "0000")
+		s3client := remote.client(t)
+		s3client.SetProductInfoMode(bucket, mode)
+		for _, key := range []string{jarKey, jarKey + ".sha1"} {
+			assert.True(t, s3client.AddProducts(key, bucket, []string{"foo-1.0", "foo-1.1"}), mode)
+		}
+		if mode == config.PRODUCT_INFO_TAGGING {
+			remote.setTags(bucket, jarKey+".sha1", append(remote.objects[bucket+"/"+jarKey+".sha1"].tags,
+				types.Tag{Key: aws.String("owner"), Value: aws.String("team a")}))
+		}
+
+		a := &auditor{
+			ctx:           context.Background(),
+			s3Client:      s3client,
+			target:        config.Target{Bucket: bucket, ProductInfo: mode},
+			packageType:   PACKAGE_TYPE_MAVEN,
+			repair:        true,
+			verifyContent: true,
+			root:          t.TempDir(),
+		}
+		report, _ := a.run()
+		repaired := false
+		for _, p := range report.Problems {
+			if p.Key == jarKey && p.Type == AUDIT_CHECKSUM_MISMATCH {
+				repaired = p.Repaired
+			}
+		}
+		assert.True(t, repaired, mode)
+
+		sha1, _ := remote.get(bucket, jarKey+".sha1")
+		assert.Equal(t, files.DigestContent("jar-1.0", crypto.SHA1), sha1.content, mode)
+		assert.Equal(t, files.DigestContent(sha1.content, crypto.SHA1), sha1.meta[storage.CHECKSUM_META_KEY], mode)
+		prods, ok := s3client.GetProductInfo(jarKey+".sha1", bucket)
+		assert.True(t, ok, mode)
+		assert.Equal(t, []string{"foo-1.0", "foo-1.1"}, prods, mode)
+		if mode == config.PRODUCT_INFO_TAGGING {
+			assert.Contains(t, sha1.tags, types.Tag{Key: aws.String("owner"), Value: aws.String("team a")})
+		}
+	}
+}
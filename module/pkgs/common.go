@@ -28,67 +28,144 @@ const (
     {{- end}}
   </versioning>
   {{- end}}
+  {{if .Plugins -}}
+  <plugins>
+    {{range $plugin := .Plugins -}}
+    <plugin>
+      <name>{{$plugin.Name}}</name>
+      <prefix>{{$plugin.Prefix}}</prefix>
+      <artifactId>{{$plugin.ArtifactId}}</artifactId>
+    </plugin>
+    {{end}}
+  </plugins>
+  {{- end}}
 </metadata>
 `
 
-	//TODO: need to change to use go template
+	SNAPSHOT_METADATA_TEMPLATE = `<metadata modelVersion="1.1.0">
+  <groupId>{{.GroupId}}</groupId>
+  <artifactId>{{.ArtifactId}}</artifactId>
+  <version>{{.Version}}</version>
+  <versioning>
+    <snapshot>
+      <timestamp>{{.Timestamp}}</timestamp>
+      <buildNumber>{{.BuildNumber}}</buildNumber>
+    </snapshot>
+    <lastUpdated>{{.LastUpdated}}</lastUpdated>
+    <snapshotVersions>
+      {{range $sv := .SnapshotVersions -}}
+      <snapshotVersion>
+        {{if $sv.Classifier -}}
+        <classifier>{{$sv.Classifier}}</classifier>
+        {{end -}}
+        <extension>{{$sv.Extension}}</extension>
+        <value>{{$sv.Value}}</value>
+        <updated>{{$sv.Updated}}</updated>
+      </snapshotVersion>
+      {{end}}
+    </snapshotVersions>
+  </versioning>
+</metadata>
+`
+
+	RELOCATION_POM_TEMPLATE = `<?xml version="1.0" encoding="UTF-8"?>
+<project xmlns="http://maven.apache.org/POM/4.0.0" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://maven.apache.org/POM/4.0.0 http://maven.apache.org/xsd/maven-4.0.0.xsd">
+  <modelVersion>4.0.0</modelVersion>
+  <groupId>{{.OldGroupId}}</groupId>
+  <artifactId>{{.OldArtifactId}}</artifactId>
+  <version>{{.OldVersion}}</version>
+  <packaging>pom</packaging>
+  <distributionManagement>
+    <relocation>
+      <groupId>{{.NewGroupId}}</groupId>
+      <artifactId>{{.NewArtifactId}}</artifactId>
+      <version>{{.NewVersion}}</version>
+      {{if .Message -}}
+      <message>{{html .Message}}</message>
+      {{- end}}
+    </relocation>
+  </distributionManagement>
+</project>
+`
+
 	INDEX_HTML_TEMPLATE = `<!DOCTYPE html>
 <html>
 <head>
-	<title>{{ index.title }}</title>
+	<title>{{.Title}}</title>
 	<meta name="viewport" content="width=device-width, initial-scale=1.0">
 	<style>
 body {
 	background: #fff;
+}
+td {
+	padding-right: 2em;
 }
 	</style>
 </head>
 <body>
 	<header>
-		<h1>{{ index.header }}</h1>
+		<h1>{{range $crumb := .Breadcrumbs}}<a href="{{$crumb.Href}}">{{$crumb.Name}}</a>{{end}}</h1>
 	</header>
 	<hr/>
 	<main>
-		<ul style="list-style: none outside;" id="contents">{% for item in index.items %}
-				<li><a href="{{ item }}" title="{{ item }}">{{ item }}</a></li>{% endfor%}
-		</ul>
+		<table id="contents">{{range $item := .Items}}
+			<tr>
+				<td><a href="{{$item.Href}}" title="{{$item.Name}}">{{$item.Name}}</a></td>
+				<td>{{$item.Size}}</td>
+				<td>{{$item.LastModified}}</td>
+			</tr>{{end}}
+		</table>
 	</main>
 	<hr/>
 </body>
 </html>
 `
-	// TODO: need to change to use go template
+	// The hrefs of the scoped packages and parents are pointed to their
+	// index.html in npm index, as the folders conflict with the package
+	// metadata in npm registry
 	NPM_INDEX_HTML_TEMPLATE = `<!DOCTYPE html>
 <html>
 <head>
-	<title>{{ index.title }}</title>
+	<title>{{.Title}}</title>
 	<meta name="viewport" content="width=device-width, initial-scale=1.0">
 	<style>
 body {
 	background: #fff;
+}
+td {
+	padding-right: 2em;
 }
 	</style>
 </head>
 <body>
 	<header>
-		<h1>{{ index.header }}</h1>
+		<h1>{{range $crumb := .Breadcrumbs}}<a href="{{$crumb.Href}}index.html">{{$crumb.Name}}</a>{{end}}</h1>
 	</header>
 	<hr/>
 	<main>
-		<ul style="list-style: none outside;" id="contents">
-				{% for item in index.items %}{% if item.startswith("@") or item.startswith("..") %}
-				<li><a href="{{ item }}index.html" title="{{ item }}">{{ item }}</a></li>{% else %}
-				<li><a href="{{ item }}" title="{{ item }}">{{ item }}</a></li>{% endif %}{% endfor%}
-		</ul>
+		<table id="contents">{{range $item := .Items}}
+			<tr>{{if or (hasPrefix $item.Name "@") (hasPrefix $item.Name "..")}}
+				<td><a href="{{$item.Href}}index.html" title="{{$item.Name}}">{{$item.Name}}</a></td>{{else}}
+				<td><a href="{{$item.Href}}" title="{{$item.Name}}">{{$item.Name}}</a></td>{{end}}
+				<td>{{$item.Size}}</td>
+				<td>{{$item.LastModified}}</td>
+			</tr>{{end}}
+		</table>
 	</main>
 	<hr/>
 </body>
 </html>
 `
 )
+const (
+	ARCHETYPE_CATALOG_XMLNS           = "http://maven.apache.org/plugins/maven-archetype-plugin/archetype-catalog/1.0.0"
+	ARCHETYPE_CATALOG_XMLNS_XSI       = "http://www.w3.org/2001/XMLSchema-instance"
+	ARCHETYPE_CATALOG_SCHEMA_LOCATION = ARCHETYPE_CATALOG_XMLNS + " http://maven.apache.org/xsd/archetype-catalog-1.0.0.xsd"
+)
 const (
 	MAVEN_METADATA_FILE = "maven-metadata.xml"
 	MAVEN_ARCH_FILE     = "archetype-catalog.xml"
+	MAVEN_PLUGIN_FILE   = "META-INF/maven/plugin.xml"
 	META_FILE_GEN_KEY   = "Generate"
 	META_FILE_DEL_KEY   = "Delete"
 	META_FILE_FAILED    = "Fail"
@@ -0,0 +1,363 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+)
+
+// The types of the differences between two targets
+const (
+	DIFF_ONLY_IN_FROM      = "only_in_from"
+	DIFF_ONLY_IN_TO        = "only_in_to"
+	DIFF_CHECKSUM_MISMATCH = "checksum_mismatch"
+	DIFF_PRODUCTS_MISMATCH = "products_mismatch"
+)
+
+// DiffEntry is a difference of a key between two targets. The key is the
+// path in the buckets without the prefixes of the targets. From and To are
+// the checksums or the products in both targets.
+type DiffEntry struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// TargetDiff is the differences between two targets under the prefix
+type TargetDiff struct {
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Prefix  string      `json:"prefix"`
+	Entries []DiffEntry `json:"entries"`
+}
+
+// Count the entries of each type
+func (d *TargetDiff) Counts() map[string]int {
+	counts := map[string]int{}
+	for _, e := range d.Entries {
+		counts[e.Type]++
+	}
+	return counts
+}
+
+// Handle the comparison of two targets, like stage and prod. The keys under
+// the subPath of both targets are compared, and the keys only in one target,
+// the keys with different checksums and the files with different products
+// are reported. The .prodinfo files are not compared as keys, but through
+// the products of their files.
+//
+// Returns the differences and if the comparison is done
+func HandleTargetDiff(
+	ctx context.Context,
+	from,
+	to config.Target,
+	subPath,
+	awsProfile string,
+) (*TargetDiff, bool) {
+	// The comparison never changes anything in the targets
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, true)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return nil, false
+	}
+	return diffTargets(ctx, s3Client, fixTarget(from), fixTarget(to), subPath)
+}
+
+// Handle the synchronization from a target to another one, like from stage
+// to prod. The files which are only in the from target are copied to the to
+// target with their products, and the products which are missing in the to
+// target are added. The files with different checksums are never
+// overwritten. Then the maven-metadata.xml of the GAs and the index.html of
+// the folders of the copied files are regenerated in the to target.
+//   - dir_ is base dir for holding the generated files, will use system
+//     tmp dir if empty.
+//
+// Returns the directory used for generated files and if the synchronization is successful
+func HandleTargetSync(
+	ctx context.Context,
+	from,
+	to config.Target,
+	subPath,
+	awsProfile,
+	dir_ string,
+	doIndex,
+	cfEnable,
+	dryRun bool,
+) (string, bool) {
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return "", false
+	}
+	workDir, err := os.MkdirTemp(dir_, "charon-sync-*")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create work dir for synchronization due to error: %s", err))
+		return "", false
+	}
+	fromT, toT := fixTarget(from), fixTarget(to)
+	diff, ok := diffTargets(ctx, s3Client, fromT, toT, subPath)
+	if !ok {
+		return workDir, false
+	}
+	ok = syncTargets(ctx, s3Client, diff, fromT, toT, path.Join(workDir, toT.Bucket),
+		awsProfile, doIndex, cfEnable)
+	return workDir, ok
+}
+
+// List the keys under the subPath of the target, relative to its prefix.
+// The .prodinfo files are listed separately by their files.
+func listDiffKeys(s3Client *storage.S3Client, t config.Target, subPath string) (
+	map[string]storage.FileInfo, map[string]storage.FileInfo, bool) {
+	scanPrefix := strings.Trim(path.Join(t.Prefix, strings.Trim(subPath, "/")), "/")
+	if scanPrefix != "" {
+		scanPrefix += "/"
+	}
+	infos, ok := s3Client.GetFileInfos(t.Bucket, scanPrefix, "")
+	if !ok {
+		return nil, nil, false
+	}
+	keys := make(map[string]storage.FileInfo, len(infos))
+	sidecars := make(map[string]storage.FileInfo)
+	for _, info := range infos {
+		key := info.Key
+		if t.Prefix != "" {
+			key = strings.TrimPrefix(strings.TrimPrefix(key, t.Prefix), "/")
+		}
+		if strings.HasSuffix(key, util.PROD_INFO_SUFFIX) {
+			sidecars[strings.TrimSuffix(key, util.PROD_INFO_SUFFIX)] = info
+		} else {
+			keys[key] = info
+		}
+	}
+	return keys, sidecars, true
+}
+
+func diffTargets(ctx context.Context, s3Client *storage.S3Client, from, to config.Target,
+	subPath string) (*TargetDiff, bool) {
+	s3Client.SetProductInfoMode(from.Bucket, from.ProductInfo)
+	s3Client.SetProductInfoMode(to.Bucket, to.ProductInfo)
+	diff := &TargetDiff{From: from.Bucket, To: to.Bucket, Prefix: strings.Trim(subPath, "/"), Entries: []DiffEntry{}}
+	logger.Info(fmt.Sprintf("Start comparing bucket %s with bucket %s", from.Bucket, to.Bucket))
+	fromKeys, fromSidecars, ok := listDiffKeys(s3Client, from, subPath)
+	if !ok {
+		return nil, false
+	}
+	toKeys, toSidecars, ok := listDiffKeys(s3Client, to, subPath)
+	if !ok {
+		return nil, false
+	}
+
+	var mu sync.Mutex
+	add := func(e DiffEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		diff.Entries = append(diff.Entries, e)
+	}
+	for key := range toKeys {
+		if _, ok := fromKeys[key]; !ok {
+			add(DiffEntry{Type: DIFF_ONLY_IN_TO, Key: key})
+		}
+	}
+	sidecarMode := func(t config.Target) bool {
+		return t.ProductInfo == "" || t.ProductInfo == config.PRODUCT_INFO_SIDECAR
+	}
+	g := new(errgroup.Group)
+	g.SetLimit(storage.DEFAULT_CONCURRENT_LIMIT)
+	for key, fromInfo := range fromKeys {
+		toInfo, ok := toKeys[key]
+		if !ok {
+			add(DiffEntry{Type: DIFF_ONLY_IN_FROM, Key: key})
+			continue
+		}
+		key, fromInfo := key, fromInfo
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// The same ETags mean the same content, so no need to check the checksums
+			if fromInfo.ETag == "" || fromInfo.ETag != toInfo.ETag {
+				fromSum, toSum, err := diffChecksums(s3Client, from, to, key)
+				if err != nil {
+					logger.Warn(fmt.Sprintf("Can not compare checksums of %s: %s", key, err))
+				} else if fromSum != toSum {
+					add(DiffEntry{Type: DIFF_CHECKSUM_MISMATCH, Key: key, From: fromSum, To: toSum})
+				}
+			}
+			if !isAuditedArtifact(key) {
+				return nil
+			}
+			if sidecarMode(from) && sidecarMode(to) {
+				fromSidecar, toSidecar := fromSidecars[key], toSidecars[key]
+				if fromSidecar.ETag != "" && fromSidecar.ETag == toSidecar.ETag {
+					return nil
+				}
+			}
+			fromProds, _ := s3Client.GetProductInfo(path.Join(from.Prefix, key), from.Bucket)
+			toProds, _ := s3Client.GetProductInfo(path.Join(to.Prefix, key), to.Bucket)
+			fromProds = slices.DeleteFunc(slices.Clone(fromProds), util.IsBlankString)
+			toProds = slices.DeleteFunc(slices.Clone(toProds), util.IsBlankString)
+			slices.Sort(fromProds)
+			slices.Sort(toProds)
+			if !slices.Equal(fromProds, toProds) {
+				add(DiffEntry{Type: DIFF_PRODUCTS_MISMATCH, Key: key,
+					From: strings.Join(fromProds, ","), To: strings.Join(toProds, ",")})
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if ctx.Err() != nil {
+		return nil, false
+	}
+	slices.SortFunc(diff.Entries, func(e1, e2 DiffEntry) int {
+		if c := strings.Compare(e1.Key, e2.Key); c != 0 {
+			return c
+		}
+		return strings.Compare(e1.Type, e2.Type)
+	})
+	logger.Info(fmt.Sprintf("Comparing done for bucket %s and bucket %s: %d keys in %s, %d keys in %s, %v\n",
+		from.Bucket, to.Bucket, len(fromKeys), from.Bucket, len(toKeys), to.Bucket, diff.Counts()))
+	return diff, true
+}
+
+// Get the sha1 checksums of the key in both targets
+func diffChecksums(s3Client *storage.S3Client, from, to config.Target, key string) (string, string, error) {
+	fromSum, err := diffChecksum(s3Client, from, key)
+	if err != nil {
+		return "", "", err
+	}
+	toSum, err := diffChecksum(s3Client, to, key)
+	if err != nil {
+		return "", "", err
+	}
+	return fromSum, toSum, nil
+}
+
+// Get the sha1 checksum of the key from its checksum metadata. The files
+// uploaded without the checksum metadata are digested from their contents.
+func diffChecksum(s3Client *storage.S3Client, t config.Target, key string) (string, error) {
+	fileKey := path.Join(t.Prefix, key)
+	meta, _, err := s3Client.GetFileMetadata(t.Bucket, fileKey)
+	if err != nil {
+		return "", err
+	}
+	if sum := strings.TrimSpace(meta[storage.CHECKSUM_META_KEY]); sum != "" {
+		return sum, nil
+	}
+	return s3Client.DigestFile(t.Bucket, fileKey, crypto.SHA1)
+}
+
+// Apply the differences from the from target to the to target. The
+// metadata and index files are not copied, but regenerated for the copied
+// files. The generated files are stored in root.
+func syncTargets(ctx context.Context, s3Client *storage.S3Client, diff *TargetDiff, from, to config.Target,
+	root, awsProfile string, doIndex, cfEnable bool) bool {
+	bucketName := to.Bucket
+	copied := []string{}
+	failed := []string{}
+	var mu sync.Mutex
+	g := new(errgroup.Group)
+	g.SetLimit(storage.DEFAULT_CONCURRENT_LIMIT)
+	logger.Info(fmt.Sprintf("Start synchronizing bucket %s to bucket %s", from.Bucket, bucketName))
+	for _, e := range diff.Entries {
+		e := e
+		if IsMetadata(e.Key) || (isVerificationFile(e.Key) && IsMetadata(strings.TrimSuffix(e.Key, path.Ext(e.Key)))) {
+			continue
+		}
+		switch e.Type {
+		case DIFF_ONLY_IN_FROM, DIFF_PRODUCTS_MISMATCH:
+		case DIFF_CHECKSUM_MISMATCH:
+			logger.Warn(fmt.Sprintf("%s differs between bucket %s and bucket %s, will not be synchronized",
+				e.Key, from.Bucket, bucketName))
+			continue
+		default:
+			continue
+		}
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				failed = append(failed, e.Key)
+				return nil
+			}
+			fromKey, toKey := path.Join(from.Prefix, e.Key), path.Join(to.Prefix, e.Key)
+			ok := true
+			if e.Type == DIFF_ONLY_IN_FROM {
+				ok = s3Client.CopyFile(from.Bucket, fromKey, bucketName, toKey, isAuditedArtifact(e.Key))
+				logger.Debug(fmt.Sprintf("Copied %s from bucket %s to bucket %s: %v", e.Key, from.Bucket, bucketName, ok))
+			} else if e.From != "" {
+				ok = s3Client.AddProducts(toKey, bucketName, strings.Split(e.From, ","))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !ok {
+				failed = append(failed, e.Key)
+			} else if e.Type == DIFF_ONLY_IN_FROM {
+				copied = append(copied, e.Key)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	slices.Sort(copied)
+	slices.Sort(failed)
+	logger.Info(fmt.Sprintf("Synchronizing done for bucket %s: %d files copied, %d failed\n",
+		bucketName, len(copied), len(failed)))
+	if len(failed) > 0 {
+		logger.Error(fmt.Sprintf("Failed to synchronize these files to bucket %s: \n%s\n",
+			bucketName, strings.Join(failed, "\n")))
+	}
+	if len(copied) == 0 || interrupted(ctx, "metadata and index updating for bucket "+bucketName) {
+		return len(failed) == 0 && ctx.Err() == nil
+	}
+
+	// Refresh the metadata of the GAs and the indexes of the folders of the copied files
+	gas := []string{}
+	dirs := map[string]bool{root: true}
+	for _, key := range copied {
+		if path.Ext(key) == ".pom" && isGAVPath(key, "") {
+			ga := parseGA(path.Dir(path.Dir(key)), "")
+			if gaStr := ga[0] + ":" + ga[1]; !slices.Contains(gas, gaStr) {
+				gas = append(gas, gaStr)
+			}
+		}
+		for dir := path.Dir(path.Join(root, key)); dir != root && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	ok := len(failed) == 0
+	if len(gas) > 0 && !refreshTargetMetadatas(ctx, s3Client, to, gas, nil, root, awsProfile, cfEnable) {
+		ok = false
+	}
+	if doIndex {
+		changedDirs := make([]string, 0, len(dirs))
+		for d := range dirs {
+			changedDirs = append(changedDirs, d)
+		}
+		logger.Info("Start generating index files to s3 bucket " + bucketName)
+		createdIndex := generateIndexes(*s3Client, changedDirs, PACKAGE_TYPE_MAVEN, root, bucketName,
+			to.Prefix, to.IndexJson)
+		logger.Info("Index files generation done.\n")
+		logger.Info("Start updating index files to s3 bucket " + bucketName)
+		if failedIndexes := s3Client.UploadMetadatas(createdIndex, to, "", root); len(failedIndexes) > 0 {
+			logger.Error(fmt.Sprintf("Failed to update index files in bucket %s: \n%s\n", bucketName, failedIndexes))
+			ok = false
+		}
+		logger.Info("Index files updating done\n")
+	}
+	return ok
+}
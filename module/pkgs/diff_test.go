@@ -0,0 +1,94 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestTargetDiffAndSync(t *testing.T) {
+	remote := newMemS3()
+	stage := config.Target{Bucket: "stage"}
+	prod := config.Target{Bucket: "prod", Prefix: "prod", ProductInfo: config.PRODUCT_INFO_METADATA}
+	putWithProducts := func(bucket, key, content, products string) {
+		remote.put(bucket, key, content)
+		o, _ := remote.get(bucket, key)
+		o.meta[storage.PRODUCTS_META_KEY] = products
+	}
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.pom", "pom-1.0")
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.pom.prodinfo", "foo-1.0")
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.jar", "jar-1.0")
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.jar.prodinfo", "foo-1.1,foo-1.0")
+	remote.put("stage", "org/foo/bar/2.0/bar-2.0.pom", "pom-2.0")
+	remote.put("stage", "org/foo/bar/2.0/bar-2.0.pom.prodinfo", "foo-2.0")
+	remote.put("stage", "org/foo/bar/maven-metadata.xml", "stage metadata")
+	remote.put("stage", "org/foo/baz.txt", "stage baz")
+	putWithProducts("prod", "prod/org/foo/bar/1.0/bar-1.0.pom", "pom-1.0", "foo-1.0")
+	putWithProducts("prod", "prod/org/foo/bar/1.0/bar-1.0.jar", "jar-1.0", "foo-1.0")
+	remote.put("prod", "prod/org/foo/bar/maven-metadata.xml", "prod metadata")
+	remote.put("prod", "prod/org/foo/baz.txt", "prod baz")
+	remote.put("prod", "prod/org/foo/old.txt", "old")
+
+	s3client := remote.client(t)
+	diff, ok := diffTargets(context.Background(), s3client, stage, prod, "org/foo")
+	assert.True(t, ok)
+	assert.Equal(t, []DiffEntry{
+		{Type: DIFF_PRODUCTS_MISMATCH, Key: "org/foo/bar/1.0/bar-1.0.jar", From: "foo-1.0,foo-1.1", To: "foo-1.0"},
+		{Type: DIFF_ONLY_IN_FROM, Key: "org/foo/bar/2.0/bar-2.0.pom"},
+		{Type: DIFF_CHECKSUM_MISMATCH, Key: "org/foo/bar/maven-metadata.xml",
+			From: files.DigestContent("stage metadata", crypto.SHA1), To: files.DigestContent("prod metadata", crypto.SHA1)},
+		{Type: DIFF_CHECKSUM_MISMATCH, Key: "org/foo/baz.txt",
+			From: files.DigestContent("stage baz", crypto.SHA1), To: files.DigestContent("prod baz", crypto.SHA1)},
+		{Type: DIFF_ONLY_IN_TO, Key: "org/foo/old.txt"},
+	}, diff.Entries)
+
+	assert.True(t, syncTargets(context.Background(), s3client, diff, stage, prod, t.TempDir(), "", true, false))
+	pom, ok := remote.get("prod", "prod/org/foo/bar/2.0/bar-2.0.pom")
+	assert.True(t, ok)
+	assert.Equal(t, "foo-2.0", pom.meta[storage.PRODUCTS_META_KEY])
+	assert.Equal(t, files.DigestContent("pom-2.0", crypto.SHA1), pom.meta[storage.CHECKSUM_META_KEY])
+	jar, _ := remote.get("prod", "prod/org/foo/bar/1.0/bar-1.0.jar")
+	assert.Equal(t, "foo-1.0,foo-1.1", jar.meta[storage.PRODUCTS_META_KEY])
+	meta, _ := remote.get("prod", "prod/org/foo/bar/maven-metadata.xml")
+	assert.Contains(t, meta.content, "<version>2.0</version>")
+	baz, _ := remote.get("prod", "prod/org/foo/baz.txt")
+	assert.Equal(t, "prod baz", baz.content)
+	index, _ := remote.get("prod", "prod/org/foo/bar/index.html")
+	assert.Contains(t, index.content, `href="2.0/"`)
+
+	// Nothing is left to synchronize except the files with different checksums
+	diff, ok = diffTargets(context.Background(), s3client, stage, prod, "org/foo")
+	assert.True(t, ok)
+	for _, e := range diff.Entries {
+		assert.NotContains(t, []string{DIFF_ONLY_IN_FROM, DIFF_PRODUCTS_MISMATCH}, e.Type, e.Key)
+	}
+}
+
+func TestTargetDiffWithoutChecksums(t *testing.T) {
+	remote := newMemS3()
+	stage := config.Target{Bucket: "stage"}
+	prod := config.Target{Bucket: "prod"}
+	putWithoutChecksum := func(bucket, key, content string) {
+		remote.put(bucket, key, content)
+		o, _ := remote.get(bucket, key)
+		delete(o.meta, storage.CHECKSUM_META_KEY)
+	}
+	putWithoutChecksum("stage", "org/foo/same.txt", "same")
+	putWithoutChecksum("prod", "org/foo/same.txt", "same")
+	putWithoutChecksum("stage", "org/foo/changed.txt", "stage changed")
+	putWithoutChecksum("prod", "org/foo/changed.txt", "prod changed")
+	remote.put("stage", "org/foo/half.txt", "half")
+	putWithoutChecksum("prod", "org/foo/half.txt", "half")
+
+	diff, ok := diffTargets(context.Background(), remote.client(t), stage, prod, "")
+	assert.True(t, ok)
+	assert.Equal(t, []DiffEntry{
+		{Type: DIFF_CHECKSUM_MISMATCH, Key: "org/foo/changed.txt",
+			From: files.DigestContent("stage changed", crypto.SHA1), To: files.DigestContent("prod changed", crypto.SHA1)},
+	}, diff.Entries)
+}
@@ -0,0 +1,218 @@
+package pkgs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+)
+
+const (
+	ERROR_CATEGORY_FILE               = "file"
+	ERROR_CATEGORY_METADATA           = "metadata"
+	ERROR_CATEGORY_INDEX              = "index"
+	ERROR_CATEGORY_SIGNATURE          = "signature"
+	ERROR_CATEGORY_VALIDATION_ERROR   = "validation error"
+	ERROR_CATEGORY_VALIDATION_WARNING = "validation warning"
+)
+
+// The order of the categories in the summary table
+var errorCategories = []string{
+	ERROR_CATEGORY_FILE,
+	ERROR_CATEGORY_METADATA,
+	ERROR_CATEGORY_INDEX,
+	ERROR_CATEGORY_SIGNATURE,
+	ERROR_CATEGORY_VALIDATION_ERROR,
+	ERROR_CATEGORY_VALIDATION_WARNING,
+}
+
+// An error happened in a run. An empty Target means the error is not
+// related to a single target, like the validation messages.
+type ErrorRecord struct {
+	Target   string
+	Category string
+	Path     string
+	Cause    string
+}
+
+func (r ErrorRecord) String() string {
+	target := r.Target
+	if target == "" {
+		target = "*"
+	}
+	cause := r.Cause
+	if cause == "" {
+		cause = "unknown cause"
+	}
+	if r.Path == "" {
+		return fmt.Sprintf("[%s] %s: %s", target, r.Category, cause)
+	}
+	return fmt.Sprintf("[%s] %s %s: %s", target, r.Category, r.Path, cause)
+}
+
+// ErrorCollector collects all the errors of a run, and writes them to the
+// errors.log in the work dir of the run.
+type ErrorCollector struct {
+	logFile string
+	records []ErrorRecord
+	// The retry counts by reasons of each client in the run
+	retries map[string]map[string]int
+	lock    sync.Mutex
+}
+
+func NewErrorCollector(workDir string) *ErrorCollector {
+	return &ErrorCollector{
+		logFile: path.Join(workDir, util.DEFAULT_ERRORS_LOG),
+		retries: map[string]map[string]int{},
+	}
+}
+
+func (e *ErrorCollector) LogFile() string {
+	return e.logFile
+}
+
+func (e *ErrorCollector) Add(target, category, path_, cause string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.records = append(e.records, ErrorRecord{Target: target, Category: category, Path: path_, Cause: cause})
+}
+
+func (e *ErrorCollector) Records() []ErrorRecord {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]ErrorRecord{}, e.records...)
+}
+
+// Set the retry counts of a client in the run, which replace the ones set
+// for the same client before
+func (e *ErrorCollector) SetRetries(client string, counts map[string]int) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.retries[client] = counts
+}
+
+// Get the retry counts of all the clients in the run by the reasons
+func (e *ErrorCollector) Retries() map[string]int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	total := map[string]int{}
+	for _, counts := range e.retries {
+		for reason, n := range counts {
+			total[reason] += n
+		}
+	}
+	return total
+}
+
+// Add the validation messages, which apply to all the targets
+func (e *ErrorCollector) addValidationMessages(msgs []ValidationMessage) {
+	for _, msg := range msgs {
+		category := ERROR_CATEGORY_VALIDATION_WARNING
+		if msg.Severity == config.SEVERITY_ERROR {
+			category = ERROR_CATEGORY_VALIDATION_ERROR
+		}
+		e.Add("", category, "", msg.String())
+	}
+}
+
+// Add the failed files and metadata files of a target, with the causes
+// recorded by the s3 client
+func (e *ErrorCollector) addFailures(s3Client *storage.S3Client, target string, failedFiles, failedMetas []string) {
+	for _, f := range failedFiles {
+		e.Add(target, ERROR_CATEGORY_FILE, f, s3Client.FailureCauseIn(target, f))
+	}
+	for _, f := range failedMetas {
+		e.Add(target, metaErrorCategory(f), f, s3Client.FailureCauseIn(target, f))
+	}
+}
+
+// The failed metadata files contain all generated files of the run,
+// so they are categorized by their names
+func metaErrorCategory(file string) string {
+	if isIndexFile(file) {
+		return ERROR_CATEGORY_INDEX
+	}
+	if strings.HasSuffix(file, ".asc") {
+		return ERROR_CATEGORY_SIGNATURE
+	}
+	return ERROR_CATEGORY_METADATA
+}
+
+// Count the errors of each category for the target, including the errors
+// not related to any target
+func (e *ErrorCollector) Counts(target string) map[string]int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	counts := map[string]int{}
+	for _, r := range e.records {
+		if r.Target == target || r.Target == "" {
+			counts[r.Category]++
+		}
+	}
+	return counts
+}
+
+// Format the counts of each category for the target as a table
+func (e *ErrorCollector) Summary(target string) string {
+	counts := e.Counts(target)
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CATEGORY\tCOUNT\n")
+	total := 0
+	for _, c := range errorCategories {
+		fmt.Fprintf(w, "%s\t%d\n", c, counts[c])
+		total += counts[c]
+	}
+	fmt.Fprintf(w, "total\t%d\n", total)
+	// The retries are counted for the whole run, not only the target
+	if retries := e.Retries(); len(retries) > 0 {
+		reasons := make([]string, 0, len(retries))
+		retried := 0
+		for reason, n := range retries {
+			reasons = append(reasons, reason)
+			retried += n
+		}
+		slices.Sort(reasons)
+		fmt.Fprintf(w, "\nRETRY REASON\tCOUNT\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "%s\t%d\n", reason, retries[reason])
+		}
+		fmt.Fprintf(w, "total retries\t%d\n", retried)
+	}
+	w.Flush()
+	return sb.String()
+}
+
+// Write all the collected errors to the errors.log. The file will not be
+// created if there is no error.
+func (e *ErrorCollector) Flush() error {
+	records := e.Records()
+	if len(records) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	for _, r := range records {
+		sb.WriteString(r.String())
+		sb.WriteString("\n")
+	}
+	return os.WriteFile(e.logFile, []byte(sb.String()), 0644)
+}
+
+// Print the error summary of the target and write the errors.log
+func (e *ErrorCollector) report(target string) {
+	if target == "" {
+		logger.Info(fmt.Sprintf("Error summary:\n%s", e.Summary(target)))
+	} else {
+		logger.Info(fmt.Sprintf("Error summary for bucket %s:\n%s", target, e.Summary(target)))
+	}
+	if err := e.Flush(); err != nil {
+		logger.Error(fmt.Sprintf("Can not write %s due to error: %s", e.logFile, err))
+	}
+}
@@ -0,0 +1,76 @@
+package pkgs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestErrorCollector(t *testing.T) {
+	root := t.TempDir()
+	okFile := path.Join(root, "org/foo/bar/1.0/bar-1.0.jar")
+	badFile := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	for _, f := range []string{okFile, badFile} {
+		assert.Nil(t, os.MkdirAll(path.Dir(f), 0755))
+		assert.Nil(t, os.WriteFile(f, []byte(f), 0644))
+	}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return nil, &types.NotFound{}
+		},
+		PutObj: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			if strings.HasSuffix(*params.Key, ".pom") {
+				return nil, fmt.Errorf("access denied")
+			}
+			return &s3.PutObjectOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+	target := config.Target{Bucket: storage.TEST_BUCKET}
+	failedFiles := s3client.UploadFiles([]string{okFile, badFile}, []config.Target{target}, "", root)[target.Bucket]
+	assert.Equal(t, []string{badFile}, failedFiles)
+
+	workDir := t.TempDir()
+	errs := NewErrorCollector(workDir)
+	errs.addValidationMessages([]ValidationMessage{
+		{Rule: RULE_NO_SNAPSHOT, Severity: config.SEVERITY_WARNING, Message: "snapshot found"},
+	})
+	failedMetas := []string{
+		path.Join(root, "org/foo/bar/maven-metadata.xml"),
+		path.Join(root, "org/foo/bar/index.html"),
+	}
+	uploadPostProcess(errs, s3client, failedFiles, failedMetas, "foo-1.0", storage.TEST_BUCKET)
+
+	counts := errs.Counts(storage.TEST_BUCKET)
+	assert.Equal(t, 1, counts[ERROR_CATEGORY_FILE])
+	assert.Equal(t, 1, counts[ERROR_CATEGORY_METADATA])
+	assert.Equal(t, 1, counts[ERROR_CATEGORY_INDEX])
+	assert.Equal(t, 1, counts[ERROR_CATEGORY_VALIDATION_WARNING])
+	assert.Equal(t, 1, errs.Counts("other_bucket")[ERROR_CATEGORY_VALIDATION_WARNING])
+	assert.Equal(t, 0, errs.Counts("other_bucket")[ERROR_CATEGORY_FILE])
+	assert.Contains(t, errs.Summary(storage.TEST_BUCKET), "total")
+	assert.NotContains(t, errs.Summary(storage.TEST_BUCKET), "RETRY REASON")
+	errs.SetRetries(RETRY_CLIENT_S3, map[string]int{storage.RETRY_REASON_THROTTLED: 2})
+	errs.SetRetries(retryClientCF(storage.TEST_BUCKET), map[string]int{storage.RETRY_REASON_THROTTLED: 1})
+	errs.SetRetries(RETRY_CLIENT_S3, map[string]int{storage.RETRY_REASON_THROTTLED: 3, "RequestTimeout": 1})
+	assert.Equal(t, map[string]int{storage.RETRY_REASON_THROTTLED: 4, "RequestTimeout": 1}, errs.Retries())
+	assert.Regexp(t, `total retries\s+5`, errs.Summary(storage.TEST_BUCKET))
+
+	content, err := files.ReadFile(path.Join(workDir, "errors.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, content, fmt.Sprintf("[%s] file %s: upload to bucket %s failed", storage.TEST_BUCKET,
+		badFile, storage.TEST_BUCKET))
+	assert.Contains(t, content, "access denied")
+	assert.Contains(t, content, "[*] validation warning: [no-snapshot] snapshot found")
+	assert.Contains(t, content, "index.html: unknown cause")
+}
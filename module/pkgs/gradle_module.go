@@ -0,0 +1,185 @@
+package pkgs
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+const GRADLE_MODULE_SUFFIX = ".module"
+
+// This GradleModule represents the Gradle Module Metadata file (.module),
+// which is published next to the pom and describes the variants of the
+// component with the files belonging to each variant.
+type GradleModule struct {
+	FormatVersion string                `json:"formatVersion"`
+	Component     GradleModuleComponent `json:"component"`
+	Variants      []GradleModuleVariant `json:"variants"`
+}
+
+type GradleModuleComponent struct {
+	Group   string `json:"group"`
+	Module  string `json:"module"`
+	Version string `json:"version"`
+}
+
+type GradleModuleVariant struct {
+	Name  string             `json:"name"`
+	Files []GradleModuleFile `json:"files"`
+}
+
+type GradleModuleFile struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Size   int64  `json:"size"`
+	Sha1   string `json:"sha1"`
+	Sha256 string `json:"sha256"`
+	Sha512 string `json:"sha512"`
+	Md5    string `json:"md5"`
+}
+
+func isGradleModule(p string) bool {
+	return filepath.Ext(p) == GRADLE_MODULE_SUFFIX
+}
+
+// Parse the Gradle Module Metadata file
+func parseGradleModule(modulePath string) (*GradleModule, error) {
+	content, err := os.ReadFile(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	module := &GradleModule{}
+	if err := json.Unmarshal(content, module); err != nil {
+		return nil, fmt.Errorf("can not parse gradle module %s: %w", modulePath, err)
+	}
+	return module, nil
+}
+
+// Get the local paths of all the files referenced by the variants of the
+// module. The url of a file is relative to the module file, and the same
+// file can be referenced by several variants, so the result is deduplicated.
+func (m *GradleModule) variantFiles(modulePath string) map[string]GradleModuleFile {
+	variantFiles := map[string]GradleModuleFile{}
+	for _, v := range m.Variants {
+		for _, f := range v.Files {
+			url := f.Url
+			if url == "" {
+				url = f.Name
+			}
+			variantFiles[path.Join(path.Dir(modulePath), url)] = f
+		}
+	}
+	return variantFiles
+}
+
+// Check all the Gradle modules to see if all the files referenced by their
+// variants are in the paths, and the size and checksums declared in the module
+// match the files. Returns the error messages for the files which do not match.
+func checkGradleModules(modules, paths []string) []string {
+	errMsgs := []string{}
+	pathSet := toSet(paths)
+	for _, m := range modules {
+		module, err := parseGradleModule(m)
+		if err != nil {
+			errMsgs = append(errMsgs, err.Error())
+			continue
+		}
+		for p, f := range module.variantFiles(m) {
+			if !pathSet[p] {
+				errMsgs = append(errMsgs,
+					fmt.Sprintf("%s referenced by gradle module %s is missing", f.Url, m))
+				continue
+			}
+			errMsgs = append(errMsgs, checkGradleModuleFile(m, p, f)...)
+		}
+	}
+	return errMsgs
+}
+
+func checkGradleModuleFile(module, p string, f GradleModuleFile) []string {
+	errMsgs := []string{}
+	info, err := os.Stat(p)
+	if err != nil {
+		return []string{fmt.Sprintf("can not read %s referenced by gradle module %s: %s", p, module, err)}
+	}
+	if f.Size > 0 && f.Size != info.Size() {
+		errMsgs = append(errMsgs, fmt.Sprintf("size of %s is %d but %d in gradle module %s",
+			p, info.Size(), f.Size, module))
+	}
+	expected := map[crypto.Hash]string{crypto.SHA1: f.Sha1, crypto.SHA256: f.Sha256}
+	hashes := []crypto.Hash{}
+	for h, v := range expected {
+		if v != "" {
+			hashes = append(hashes, h)
+		}
+	}
+	if len(hashes) == 0 {
+		return errMsgs
+	}
+	for h, actual := range files.DigestAll(p, hashes...) {
+		if !strings.EqualFold(expected[h], actual) {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s of %s does not match the one in gradle module %s",
+				strings.TrimPrefix(files.DIGEST_SUFFIXES[h], "."), p, module))
+		}
+	}
+	return errMsgs
+}
+
+// Split the paths to delete into the gradle module files (with their own
+// digest and signature files) and the others. The modules should only be
+// deleted after their variant files are deleted, so that a module will not
+// be lost while its variant files are still kept for other products.
+func splitGradleModulePaths(paths, modules []string) ([]string, []string) {
+	moduleSet := toSet(modules)
+	modulePaths, otherPaths := []string{}, []string{}
+	for _, p := range paths {
+		if moduleSet[p] || (isVerificationFile(p) && moduleSet[strings.TrimSuffix(p, filepath.Ext(p))]) {
+			modulePaths = append(modulePaths, p)
+		} else {
+			otherPaths = append(otherPaths, p)
+		}
+	}
+	return modulePaths, otherPaths
+}
+
+// Filter out the gradle module paths whose variant files still exist in
+// the bucket, which means these variant files are still used by other
+// products, so the modules which describe them should also be kept.
+func filterDeletableGradleModules(s3 storage.S3Client, modulePaths []string,
+	bucket, prefix, root string) []string {
+	kept := map[string]bool{}
+	for _, m := range modulePaths {
+		if !isGradleModule(m) {
+			continue
+		}
+		module, err := parseGradleModule(m)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Can not parse gradle module, will delete it directly: %s", err))
+			continue
+		}
+		for p := range module.variantFiles(m) {
+			key := path.Join(prefix, trimRoot(p, root))
+			if existed, err := s3.FileExistsInBucket(bucket, key); err != nil || existed {
+				logger.Warn(fmt.Sprintf(
+					"Gradle module %s will be kept as its variant file %s still exists in bucket %s",
+					trimRoot(m, root), key, bucket))
+				kept[m] = true
+				break
+			}
+		}
+	}
+	deletable := []string{}
+	for _, p := range modulePaths {
+		if !kept[p] && !kept[strings.TrimSuffix(p, filepath.Ext(p))] {
+			deletable = append(deletable, p)
+		}
+	}
+	return deletable
+}
@@ -0,0 +1,70 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestCheckGradleModules(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-gradle-test-*")
+	defer os.RemoveAll(root)
+	verDir := path.Join(root, "org/foo/bar/1.0")
+	jar := path.Join(verDir, "bar-1.0.jar")
+	files.StoreFile(jar, "jar content", true)
+	sources := path.Join(verDir, "bar-1.0-sources.jar")
+	files.StoreFile(sources, "sources content", true)
+	module := path.Join(verDir, "bar-1.0.module")
+	files.StoreFile(module, fmt.Sprintf(`{
+  "formatVersion": "1.1",
+  "component": {"group": "org.foo", "module": "bar", "version": "1.0"},
+  "variants": [
+    {"name": "apiElements", "files": [
+      {"name": "bar-1.0.jar", "url": "bar-1.0.jar", "size": 11, "sha1": "%s", "sha256": "%s"}]},
+    {"name": "runtimeElements", "files": [
+      {"name": "bar-1.0.jar", "url": "bar-1.0.jar", "size": 11, "sha1": "%s"}]},
+    {"name": "sourcesElements", "files": [
+      {"name": "bar-1.0-sources.jar", "url": "bar-1.0-sources.jar", "size": 99, "sha1": "wrong"}]},
+    {"name": "javadocElements", "files": [
+      {"name": "bar-1.0-javadoc.jar", "url": "bar-1.0-javadoc.jar"}]}
+  ]
+}`, files.Digest(jar, crypto.SHA1), files.Digest(jar, crypto.SHA256), files.Digest(jar, crypto.SHA1)), true)
+
+	msgs := validateGradleModules([]string{module, jar, sources}, root, "")
+	assert.Equal(t, 3, len(msgs))
+	joined := strings.Join(msgs, "\n")
+	assert.Contains(t, joined, "bar-1.0-javadoc.jar referenced by gradle module")
+	assert.Contains(t, joined, "size of "+sources+" is 15 but 99")
+	assert.Contains(t, joined, "sha1 of "+sources+" does not match")
+
+	modulePaths, otherPaths := splitGradleModulePaths(
+		[]string{module, module + ".sha1", jar, jar + ".sha1"}, []string{module})
+	assert.Equal(t, []string{module, module + ".sha1"}, modulePaths)
+	assert.Equal(t, []string{jar, jar + ".sha1"}, otherPaths)
+
+	existed := map[string]bool{}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if existed[*params.Key] {
+				return &s3.HeadObjectOutput{}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+	})
+	assert.Nil(t, err)
+	deletable := filterDeletableGradleModules(*s3client, modulePaths, storage.TEST_BUCKET, "ga", root)
+	assert.Equal(t, modulePaths, deletable)
+	existed["ga/org/foo/bar/1.0/bar-1.0.jar"] = true
+	deletable = filterDeletableGradleModules(*s3client, modulePaths, storage.TEST_BUCKET, "ga", root)
+	assert.Equal(t, 0, len(deletable))
+}
@@ -1,9 +1,617 @@
 package pkgs
 
-import "org.commonjava/charon/module/storage"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	humanize "github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+	"org.commonjava/charon/module/util/files"
+)
+
+const (
+	INDEX_HTML_FILE = "index.html"
+	INDEX_JSON_FILE = "index.json"
+	// The format of last modified time in index.html
+	INDEX_TIME_FORMAT = "2006-01-02 15:04"
+	// The default checkpoint file of index rebuilding in the work dir
+	INDEX_REBUILD_CHECKPOINT = "index-rebuild-checkpoint.json"
+)
+
+// This IndexedHTML represents the content of an index.html of a folder
+type IndexedHTML struct {
+	Title       string
+	Breadcrumbs []IndexBreadcrumb
+	Items       []IndexItem
+}
+
+// An item in index.html. The size and last modified are empty for folders.
+type IndexItem struct {
+	Name         string
+	Href         string
+	Size         string
+	LastModified string
+	IsDir        bool
+}
+
+// A link to the folder itself or one of its parents in the header of index.html
+type IndexBreadcrumb struct {
+	Name string
+	Href string
+}
+
+// The links in the index.html of a folder, which are generated by charon or
+// listed by the upstream repositories
+var indexHrefPattern = regexp.MustCompile(`href="([^"]*)"`)
+
+// The index.html templates set by operators to override the default ones,
+// keyed by package type
+var indexTemplates = map[string]string{}
+
+// Override the index.html template of the package type with the template
+// file, which is normally configured in index_templates of charon.yaml
+func SetIndexTemplate(packageType, templateFile string) error {
+	content, err := os.ReadFile(templateFile)
+	if err != nil {
+		return err
+	}
+	if _, err := parseIndexTemplate(string(content)); err != nil {
+		return fmt.Errorf("invalid index template %s: %w", templateFile, err)
+	}
+	indexTemplates[packageType] = string(content)
+	return nil
+}
+
+func parseIndexTemplate(content string) (*template.Template, error) {
+	return template.New("index").
+		Funcs(template.FuncMap{"hasPrefix": strings.HasPrefix}).Parse(content)
+}
+
+func (i *IndexedHTML) GenerateIndexFileContent(packageType string) (string, error) {
+	tmpl := INDEX_HTML_TEMPLATE
+	if packageType == PACKAGE_TYPE_NPM {
+		tmpl = NPM_INDEX_HTML_TEMPLATE
+	}
+	if override, ok := indexTemplates[packageType]; ok {
+		tmpl = override
+	}
+	t := template.Must(parseIndexTemplate(tmpl))
+	var buf bytes.Buffer
+	err := t.Execute(&buf, i)
+	if err != nil {
+		logger.Error(fmt.Sprintf("executing template: %s", err))
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// This IndexedJSON represents the content of an index.json of a folder,
+// which is a machine-readable version of index.html
+type IndexedJSON struct {
+	Path     string       `json:"path"`
+	Children []IndexEntry `json:"children"`
+}
+
+// An entry in index.json. The checksum is the md5 from the ETag of the
+// listing, which is empty for the objects uploaded with multipart.
+type IndexEntry struct {
+	Name         string     `json:"name"`
+	Type         string     `json:"type"`
+	Size         *int64     `json:"size,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Checksum     string     `json:"checksum,omitempty"`
+}
+
+// Generate the index.html for all the changed dirs and their parents,
+// based on the contents of these folders in the bucket. The index.json
+// will also be generated if indexJson is enabled. Returns the generated
+// index files.
 func generateIndexes(s3Client storage.S3Client, changedDirs []string,
-	packageType, topLevel, bucket, prefix string) []string {
-	// TODO: not implemented yet
-	return []string{}
+	packageType, topLevel, bucket, prefix string, indexJson bool) []string {
+	generated := []string{}
+	for _, folder := range indexFolders(changedDirs, topLevel) {
+		indexFiles, _, ok := generateIndexFiles(&s3Client, packageType, bucket, folder, topLevel, prefix, indexJson)
+		if ok {
+			generated = append(generated, indexFiles...)
+		}
+	}
+	return generated
+}
+
+// Get the folders whose index files need to be refreshed for the changed
+// dirs. The folders are relative to topLevel and end with "/", and the
+// root "/" is always the last one.
+func indexFolders(changedDirs []string, topLevel string) []string {
+	if !strings.HasSuffix(topLevel, "/") {
+		topLevel += "/"
+	}
+	folderSet := map[string]bool{}
+	for _, d := range changedDirs {
+		folder := strings.TrimPrefix(strings.TrimPrefix(d, topLevel), "/")
+		if folder == "" || !strings.HasSuffix(folder, "/") {
+			folder += "/"
+		}
+		if folder != "/" {
+			folderSet[folder] = true
+		}
+	}
+	folders := make([]string, 0, len(folderSet))
+	for f := range folderSet {
+		folders = append(folders, f)
+	}
+	slices.Sort(folders)
+	return append(folders, "/")
+}
+
+// Generate the index.html (and index.json if indexJson is enabled) of the
+// folder from its contents in the bucket. The folder is relative to the
+// prefix and ends with "/", and "/" means the root. The index files of the
+// folder which only contains index files will be deleted.
+// Returns the generated index files, the sub folders of the folder and if
+// the folder is handled successfully. The sub folders are nil if the folder
+// can not be listed.
+func generateIndexFiles(s3Client *storage.S3Client, packageType, bucket, folder,
+	topLevel, prefix string, indexJson bool) ([]string, []string, bool) {
+	searchFolder := folder
+	if folder == "/" {
+		searchFolder = prefix
+	} else if !util.IsBlankString(prefix) {
+		searchFolder = path.Join(prefix, folder) + "/"
+	}
+	listed, ok := s3Client.ListFolderInfos(bucket, searchFolder)
+	if !ok {
+		return []string{}, nil, false
+	}
+	contents := []storage.FileInfo{}
+	subFolders := []string{}
+	onlyIndexes := true
+	for _, c := range listed {
+		if strings.TrimSpace(c.Key) == "" || strings.HasSuffix(c.Key, util.PROD_INFO_SUFFIX) {
+			continue
+		}
+		if !util.IsBlankString(prefix) {
+			c.Key = strings.TrimPrefix(strings.TrimPrefix(c.Key, prefix), "/")
+		}
+		contents = append(contents, c)
+		if c.IsDir() {
+			subFolders = append(subFolders, c.Key)
+		}
+		if !isIndexFile(c.Key) {
+			onlyIndexes = false
+		}
+	}
+
+	if len(contents) == 0 {
+		return []string{}, subFolders, true
+	}
+	if onlyIndexes {
+		logger.Info(fmt.Sprintf("The folder %s only contains index files, will remove them.", folder))
+		indexFiles := []string{}
+		for _, c := range contents {
+			indexFiles = append(indexFiles, path.Join(topLevel, c.Key))
+		}
+		failed := s3Client.DeleteFiles(indexFiles,
+			config.Target{Bucket: bucket, Prefix: prefix}, "", topLevel)
+		return []string{}, subFolders, len(failed) == 0
+	}
+	htmlPath, err := toHtml(packageType, contents, folder, topLevel)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not generate index.html for folder %s: %s", folder, err))
+		return []string{}, subFolders, false
+	}
+	indexFiles := []string{htmlPath}
+	if indexJson {
+		jsonPath, err := toJson(contents, folder, topLevel)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not generate index.json for folder %s: %s", folder, err))
+			return []string{}, subFolders, false
+		}
+		indexFiles = append(indexFiles, jsonPath)
+	}
+	return indexFiles, subFolders, true
+}
+
+func isIndexFile(p string) bool {
+	return path.Base(p) == INDEX_HTML_FILE || path.Base(p) == INDEX_JSON_FILE
+}
+
+func toHtml(packageType string, contents []storage.FileInfo, folder, topLevel string) (string, error) {
+	items := []IndexItem{}
+	for _, c := range contents {
+		// index.html does not need to be included in html content.
+		if isIndexFile(c.Key) {
+			continue
+		}
+		name := c.Key
+		if folder != "/" {
+			name = strings.TrimPrefix(name, folder)
+		}
+		item := IndexItem{Name: name, Href: name, IsDir: c.IsDir()}
+		if !item.IsDir {
+			item.Size = humanize.Bytes(uint64(c.Size))
+			if !c.LastModified.IsZero() {
+				item.LastModified = c.LastModified.UTC().Format(INDEX_TIME_FORMAT)
+			}
+		}
+		items = append(items, item)
+	}
+	items = sortIndexItems(items)
+	if folder != "/" {
+		items = append([]IndexItem{{Name: "../", Href: "../", IsDir: true}}, items...)
+	}
+	index := &IndexedHTML{Title: folder, Breadcrumbs: indexBreadcrumbs(folder), Items: items}
+	content, err := index.GenerateIndexFileContent(packageType)
+	if err != nil {
+		return "", err
+	}
+	htmlPath := path.Join(topLevel, folder, INDEX_HTML_FILE)
+	if err := files.StoreFile(htmlPath, content, true); err != nil {
+		return "", err
+	}
+	return htmlPath, nil
+}
+
+// The breadcrumbs of the folder, from the root to the folder itself, with
+// the hrefs relative to the folder
+func indexBreadcrumbs(folder string) []IndexBreadcrumb {
+	segments := strings.Split(strings.Trim(folder, "/"), "/")
+	if segments[0] == "" {
+		segments = []string{}
+	}
+	crumbs := []IndexBreadcrumb{{Name: "/", Href: strings.Repeat("../", len(segments))}}
+	for i, seg := range segments {
+		href := strings.Repeat("../", len(segments)-i-1)
+		if href == "" {
+			href = "./"
+		}
+		crumbs = append(crumbs, IndexBreadcrumb{Name: seg + "/", Href: href})
+	}
+	if crumbs[0].Href == "" {
+		crumbs[0].Href = "./"
+	}
+	return crumbs
+}
+
+// Write the index.json of the folder from the listed contents. The metadata
+// files are excluded, same as other places which use IsMetadata.
+func toJson(contents []storage.FileInfo, folder, topLevel string) (string, error) {
+	index := &IndexedJSON{Path: folder, Children: []IndexEntry{}}
+	for _, c := range contents {
+		name := c.Key
+		if folder != "/" {
+			name = strings.TrimPrefix(name, folder)
+		}
+		if c.IsDir() {
+			index.Children = append(index.Children, IndexEntry{Name: name, Type: "dir"})
+			continue
+		}
+		if IsMetadata(name) {
+			continue
+		}
+		size := c.Size
+		entry := IndexEntry{Name: name, Type: "file", Size: &size}
+		if !c.LastModified.IsZero() {
+			lastModified := c.LastModified.UTC()
+			entry.LastModified = &lastModified
+		}
+		if !strings.Contains(c.ETag, "-") {
+			entry.Checksum = c.ETag
+		}
+		index.Children = append(index.Children, entry)
+	}
+	slices.SortFunc(index.Children, func(e1, e2 IndexEntry) int {
+		return strings.Compare(e1.Name, e2.Name)
+	})
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	jsonPath := path.Join(topLevel, folder, INDEX_JSON_FILE)
+	if err := files.StoreFile(jsonPath, string(content)+"\n", true); err != nil {
+		return "", err
+	}
+	return jsonPath, nil
+}
+
+// Sort the items with the folders first. The folders in a GA folder, which
+// holds the maven-metadata.xml, are versions and will be sorted as versions.
+// The metadata files are the last ones.
+func sortIndexItems(items []IndexItem) []IndexItem {
+	isGA := slices.ContainsFunc(items, func(item IndexItem) bool {
+		return item.Name == MAVEN_METADATA_FILE
+	})
+	isMeta := func(item IndexItem) bool {
+		return strings.HasPrefix(item.Name, MAVEN_METADATA_FILE)
+	}
+	rank := func(item IndexItem) int {
+		switch {
+		case item.IsDir:
+			return 0
+		case isMeta(item):
+			return 2
+		default:
+			return 1
+		}
+	}
+	sorted := slices.Clone(items)
+	slices.SortStableFunc(sorted, func(i1, i2 IndexItem) int {
+		if r1, r2 := rank(i1), rank(i2); r1 != r2 {
+			return r1 - r2
+		}
+		if isGA && i1.IsDir {
+			return versionCompare(strings.TrimSuffix(i1.Name, "/"), strings.TrimSuffix(i2.Name, "/"))
+		}
+		return strings.Compare(i1.Name, i2.Name)
+	})
+	return sorted
+}
+
+// The checkpoint of index rebuilding for all targets, keyed by bucket and
+// prefix, which records the folders not handled yet
+type indexCheckpoint struct {
+	Targets map[string]*indexRebuildState `json:"targets"`
+}
+
+// The progress of the index rebuilding of a target. The sub folders of the
+// failed folders are pending once they are listed, and the unlisted ones are
+// the failed folders which can not be listed, whose sub folders are walked
+// when they are retried.
+type indexRebuildState struct {
+	Pending   []string `json:"pending"`
+	Failed    []string `json:"failed"`
+	Unlisted  []string `json:"unlisted,omitempty"`
+	Generated int      `json:"generated"`
+	Completed bool     `json:"completed"`
+}
+
+func loadIndexCheckpoint(checkpointFile string) (*indexCheckpoint, error) {
+	checkpoint := &indexCheckpoint{Targets: map[string]*indexRebuildState{}}
+	if !files.FileOrDirExists(checkpointFile) {
+		return checkpoint, nil
+	}
+	content, err := os.ReadFile(checkpointFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, checkpoint); err != nil {
+		return nil, fmt.Errorf("can not parse index checkpoint %s: %w", checkpointFile, err)
+	}
+	if checkpoint.Targets == nil {
+		checkpoint.Targets = map[string]*indexRebuildState{}
+	}
+	return checkpoint, nil
+}
+
+// Save the checkpoint through a temp file, so that the checkpoint will not
+// be broken if the process is killed during saving
+func (c *indexCheckpoint) save(checkpointFile string) error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := checkpointFile + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, checkpointFile)
+}
+
+// Handle the rebuilding of all index.html in the targets. The folders will be
+// walked from the path level by level with ListFolderContent, and the index.html
+// of each folder will be regenerated and uploaded with bounded concurrency.
+//   - packageType is maven or npm, which decides the layout of index.html
+//   - subPath is the folder to start the rebuilding, empty means the root
+//   - targets contains the target name with its bucket name and prefix
+//   - dir_ is base dir for holding the index files and checkpoint, will use
+//     system tmp dir if empty.
+//   - checkpointFile is used to record the progress, so that an interrupted
+//     rebuilding can be resumed with the same checkpoint file. Will use the
+//     one in dir_ if empty.
+//
+// Returns the directory used for index files and if the rebuilding is successful
+func HandleIndexRebuild(
+	ctx context.Context,
+	packageType,
+	subPath string,
+	targets []config.Target,
+	awsProfile,
+	dir_,
+	checkpointFile string,
+	dryRun bool,
+) (string, bool) {
+	if packageType != PACKAGE_TYPE_MAVEN && packageType != PACKAGE_TYPE_NPM {
+		logger.Error(fmt.Sprintf("Unsupported package type %s for index rebuilding", packageType))
+		return "", false
+	}
+	workDir := dir_
+	if util.IsBlankString(workDir) {
+		workDir = os.TempDir()
+	}
+	if util.IsBlankString(checkpointFile) {
+		checkpointFile = path.Join(workDir, INDEX_REBUILD_CHECKPOINT)
+	}
+	checkpoint, err := loadIndexCheckpoint(checkpointFile)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not load index rebuilding checkpoint: %s", err))
+		return workDir, false
+	}
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return workDir, false
+	}
+
+	startFolder := strings.Trim(subPath, "/") + "/"
+	succeeded := true
+	for _, target := range targets {
+		t := fixTarget(target)
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		key := path.Join(t.Bucket, t.Prefix, startFolder)
+		state, ok := checkpoint.Targets[key]
+		if !ok {
+			state = &indexRebuildState{Pending: []string{startFolder}}
+			checkpoint.Targets[key] = state
+		} else if state.Completed {
+			logger.Info(fmt.Sprintf("Index rebuilding for %s is already completed, skipping", key))
+			continue
+		} else {
+			logger.Info(fmt.Sprintf("Resuming index rebuilding for %s with %d pending folders",
+				key, len(state.Pending)))
+		}
+		root := path.Join(workDir, "charon-index-rebuild", t.Bucket)
+		logger.Info("Start rebuilding index files for bucket " + t.Bucket)
+		rebuildIndexes(s3Client, packageType, t, root, state, storage.DEFAULT_CONCURRENT_LIMIT,
+			func() {
+				if err := checkpoint.save(checkpointFile); err != nil {
+					logger.Warn(fmt.Sprintf("Can not save index rebuilding checkpoint: %s", err))
+				}
+			})
+		logger.Info(fmt.Sprintf("Index files rebuilding done for bucket %s, %d index files generated\n",
+			t.Bucket, state.Generated))
+		if len(state.Failed) > 0 {
+			logger.Error(fmt.Sprintf("Failed to rebuild index files for these folders in bucket %s: \n%s\n",
+				t.Bucket, strings.Join(state.Failed, "\n")))
+			succeeded = false
+		}
+		if !state.Completed && interrupted(ctx, "the pending folders in bucket "+t.Bucket) {
+			succeeded = false
+			break
+		}
+	}
+	if succeeded {
+		os.Remove(checkpointFile)
+	} else if err := checkpoint.save(checkpointFile); err == nil {
+		logger.Info("The progress of index rebuilding is saved in " + checkpointFile)
+	}
+	return workDir, succeeded
+}
+
+// Rebuild the index.html for the pending folders and all their sub folders
+// level by level. The checkpoint will be saved after each batch of folders.
+func rebuildIndexes(s3Client *storage.S3Client, packageType string, t config.Target,
+	root string, state *indexRebuildState, conLimit int, saveCheckpoint func()) {
+	// The failed folders of the last run are retried first. Only the sub
+	// folders of the unlisted ones are walked, as the sub folders of the
+	// others are already added to pending.
+	if retry := state.Failed; len(retry) > 0 {
+		logger.Info(fmt.Sprintf("Retrying %d failed folders in bucket %s", len(retry), t.Bucket))
+		unlisted := toSet(state.Unlisted)
+		batch := rebuildIndexBatch(s3Client, packageType, t, root, retry, conLimit)
+		for _, folder := range retry {
+			if unlisted[folder] {
+				state.Pending = append(state.Pending, batch.subFolders[folder]...)
+			}
+		}
+		// The folders not started are still failed for the next retrying
+		state.Failed = append(batch.failed, batch.notStarted...)
+		state.Unlisted = batch.unlisted
+		for _, folder := range batch.notStarted {
+			if unlisted[folder] {
+				state.Unlisted = append(state.Unlisted, folder)
+			}
+		}
+		state.Generated += batch.generated
+		saveCheckpoint()
+	}
+	batchSize := conLimit * 10
+	for len(state.Pending) > 0 && s3Client.Context().Err() == nil {
+		folders := state.Pending[:min(batchSize, len(state.Pending))]
+		batch := rebuildIndexBatch(s3Client, packageType, t, root, folders, conLimit)
+		pending := slices.Clone(state.Pending[len(folders):])
+		for _, folder := range folders {
+			pending = append(pending, batch.subFolders[folder]...)
+		}
+		state.Pending = append(pending, batch.notStarted...)
+		state.Failed = append(state.Failed, batch.failed...)
+		state.Unlisted = append(state.Unlisted, batch.unlisted...)
+		state.Generated += batch.generated
+		logger.Info(fmt.Sprintf("Rebuilt index files for %d folders in bucket %s, %d folders pending",
+			len(folders), t.Bucket, len(state.Pending)))
+		saveCheckpoint()
+	}
+	state.Completed = len(state.Failed) == 0 && len(state.Pending) == 0
+	saveCheckpoint()
+}
+
+// The result of rebuilding the index files of a batch of folders
+type indexBatch struct {
+	// The sub folders of each listed folder, including the failed ones
+	subFolders map[string][]string
+	// The failed folders, and the ones of them which can not be listed
+	failed   []string
+	unlisted []string
+	// The folders not started before the interruption
+	notStarted []string
+	generated  int
+}
+
+// Generate and upload the index.html for the folders concurrently. Returns
+// the sub folders, the failed folders and the number of generated index
+// files of the batch.
+func rebuildIndexBatch(s3Client *storage.S3Client, packageType string, t config.Target,
+	root string, folders []string, conLimit int) indexBatch {
+	var mu sync.Mutex
+	batch := indexBatch{subFolders: map[string][]string{}}
+	g := new(errgroup.Group)
+	g.SetLimit(conLimit)
+	for _, folder := range folders {
+		folder := folder
+		g.Go(func() error {
+			if s3Client.Context().Err() != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				batch.notStarted = append(batch.notStarted, folder)
+				return nil
+			}
+			indexFiles, subs, ok := generateIndexFiles(s3Client, packageType, t.Bucket, folder,
+				root, t.Prefix, t.IndexJson)
+			if ok && len(indexFiles) > 0 {
+				if failedMetas := s3Client.UploadMetadatas(indexFiles, t, "", root); len(failedMetas) > 0 {
+					ok = false
+				}
+				// The index files will not be used after uploading, so
+				// remove them to save the disk for a large bucket
+				for _, f := range indexFiles {
+					os.Remove(f)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if subs != nil {
+				batch.subFolders[folder] = subs
+			}
+			if !ok {
+				batch.failed = append(batch.failed, folder)
+				if subs == nil {
+					batch.unlisted = append(batch.unlisted, folder)
+				}
+			} else if len(indexFiles) > 0 {
+				batch.generated++
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	for _, subs := range batch.subFolders {
+		slices.Sort(subs)
+	}
+	slices.Sort(batch.failed)
+	slices.Sort(batch.unlisted)
+	slices.Sort(batch.notStarted)
+	return batch
 }
@@ -0,0 +1,280 @@
+package pkgs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestRebuildIndexes(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-index-rebuild-test-*")
+	defer os.RemoveAll(root)
+	objects := []string{
+		"ga/org/foo/bar/1.0/bar-1.0.pom",
+		"ga/org/foo/bar/1.0/bar-1.0.pom.prodinfo",
+		"ga/org/foo/bar/maven-metadata.xml",
+		"ga/org/foo/baz/1.0/baz-1.0.jar",
+		"ga/org/index.html",
+		"ga/com/index.html",
+	}
+	// List objects like s3 with delimiter
+	list := func(prefix string) []types.Object {
+		keys := []string{}
+		for _, o := range objects {
+			if !strings.HasPrefix(o, prefix) {
+				continue
+			}
+			remain := strings.TrimPrefix(o, prefix)
+			if i := strings.Index(remain, "/"); i >= 0 {
+				remain = remain[:i+1]
+			}
+			if !slices.Contains(keys, prefix+remain) {
+				keys = append(keys, prefix+remain)
+			}
+		}
+		contents := []types.Object{}
+		for _, k := range keys {
+			contents = append(contents, types.Object{Key: aws.String(k)})
+		}
+		return contents
+	}
+	var mu sync.Mutex
+	uploaded := map[string]string{}
+	deleted := []string{}
+	failUpload := ""
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{Contents: list(aws.ToString(params.Prefix))}, nil
+		},
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if slices.Contains(objects, *params.Key) {
+				return &s3.HeadObjectOutput{}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+		PutObj: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			if *params.Key == failUpload {
+				return nil, fmt.Errorf("upload failed")
+			}
+			content, _ := io.ReadAll(params.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			uploaded[*params.Key] = string(content)
+			return &s3.PutObjectOutput{}, nil
+		},
+		DelObj: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			deleted = append(deleted, *params.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+	target := config.Target{Bucket: storage.TEST_BUCKET, Prefix: "ga"}
+
+	failUpload = "ga/org/foo/index.html"
+	state := &indexRebuildState{Pending: []string{"/"}}
+	saved := 0
+	rebuildIndexes(s3client, PACKAGE_TYPE_MAVEN, target, root, state, 2, func() { saved++ })
+	assert.False(t, state.Completed)
+	assert.Equal(t, []string{"org/foo/"}, state.Failed)
+	assert.Equal(t, 0, len(state.Pending))
+	assert.True(t, saved > 1)
+	// com/ only contains index.html
+	assert.Contains(t, deleted, "ga/com/index.html")
+	for _, key := range []string{"ga/index.html", "ga/org/index.html", "ga/org/foo/bar/index.html",
+		"ga/org/foo/bar/1.0/index.html", "ga/org/foo/baz/index.html", "ga/org/foo/baz/1.0/index.html"} {
+		assert.Contains(t, uploaded, key)
+	}
+	assert.Equal(t, 6, state.Generated)
+	barIndex := uploaded["ga/org/foo/bar/index.html"]
+	assert.Contains(t, barIndex, `<a href="../" title="../">../</a>`)
+	assert.Contains(t, barIndex, `<a href="1.0/" title="1.0/">1.0/</a>`)
+	assert.Contains(t, barIndex, `<a href="maven-metadata.xml" title="maven-metadata.xml">maven-metadata.xml</a>`)
+	assert.NotContains(t, uploaded["ga/org/foo/bar/1.0/index.html"], "prodinfo")
+
+	// Resume only retries the failed folder
+	failUpload = ""
+	uploaded = map[string]string{}
+	rebuildIndexes(s3client, PACKAGE_TYPE_MAVEN, target, root, state, 2, func() {})
+	assert.True(t, state.Completed)
+	assert.Equal(t, 0, len(state.Failed))
+	assert.Equal(t, 7, state.Generated)
+	assert.Equal(t, 1, len(uploaded))
+	assert.Contains(t, uploaded["ga/org/foo/index.html"], `<a href="bar/" title="bar/">bar/</a>`)
+
+	checkpointFile := path.Join(root, INDEX_REBUILD_CHECKPOINT)
+	checkpoint := &indexCheckpoint{Targets: map[string]*indexRebuildState{"test_bucket/ga": state}}
+	assert.Nil(t, checkpoint.save(checkpointFile))
+	loaded, err := loadIndexCheckpoint(checkpointFile)
+	assert.Nil(t, err)
+	assert.Equal(t, checkpoint, loaded)
+}
+
+func TestGenerateIndexJson(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-index-json-test-*")
+	defer os.RemoveAll(root)
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				CommonPrefixes: []types.CommonPrefix{{Prefix: aws.String("ga/org/foo/bar/1.0/")}},
+				Contents: []types.Object{
+					{Key: aws.String("ga/org/foo/bar/maven-metadata.xml"), Size: aws.Int64(100)},
+					{Key: aws.String("ga/org/foo/bar/maven-metadata.xml.sha1"), Size: aws.Int64(40),
+						LastModified: aws.Time(modified), ETag: aws.String(`"abc"`)},
+					{Key: aws.String("ga/org/foo/bar/big.zip"), Size: aws.Int64(1 << 30),
+						LastModified: aws.Time(modified), ETag: aws.String(`"def-3"`)},
+					{Key: aws.String("ga/org/foo/bar/index.html")},
+					{Key: aws.String("ga/org/foo/bar/index.json")},
+					{Key: aws.String("ga/org/foo/bar/big.zip.prodinfo")},
+					{Key: aws.String("ga/org/foo/bar/schema-index.json"), Size: aws.Int64(10)},
+				},
+			}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	indexFiles, subFolders, ok := generateIndexFiles(s3client, PACKAGE_TYPE_MAVEN, storage.TEST_BUCKET,
+		"org/foo/bar/", root, "ga", true)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"org/foo/bar/1.0/"}, subFolders)
+	jsonPath := path.Join(root, "org/foo/bar", INDEX_JSON_FILE)
+	assert.Equal(t, []string{path.Join(root, "org/foo/bar", INDEX_HTML_FILE), jsonPath}, indexFiles)
+
+	content, _ := files.ReadFile(jsonPath)
+	index := IndexedJSON{}
+	assert.Nil(t, json.Unmarshal([]byte(content), &index))
+	assert.Equal(t, "org/foo/bar/", index.Path)
+	assert.Equal(t, 4, len(index.Children))
+	assert.Equal(t, IndexEntry{Name: "1.0/", Type: "dir"}, index.Children[0])
+	assert.Equal(t, "big.zip", index.Children[1].Name)
+	assert.Equal(t, int64(1<<30), *index.Children[1].Size)
+	assert.Equal(t, "", index.Children[1].Checksum)
+	assert.Equal(t, "maven-metadata.xml.sha1", index.Children[2].Name)
+	assert.Equal(t, "abc", index.Children[2].Checksum)
+	assert.Equal(t, modified, *index.Children[2].LastModified)
+	// Only the index.json itself is hidden, not the files ending with it
+	assert.Equal(t, "schema-index.json", index.Children[3].Name)
+
+	html, _ := files.ReadFile(indexFiles[0])
+	assert.NotContains(t, html, `href="index.json"`)
+	assert.Contains(t, html, `<a href="maven-metadata.xml" title="maven-metadata.xml">`)
+
+	indexFiles, _, ok = generateIndexFiles(s3client, PACKAGE_TYPE_MAVEN, storage.TEST_BUCKET,
+		"org/foo/bar/", root, "ga", false)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(indexFiles))
+}
+
+func TestRichIndexHtml(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-index-html-test-*")
+	defer os.RemoveAll(root)
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	contents := []storage.FileInfo{
+		{Key: "org/foo/bar/maven-metadata.xml.sha1", Size: 40, LastModified: modified},
+		{Key: "org/foo/bar/maven-metadata.xml", Size: 2048, LastModified: modified},
+		{Key: "org/foo/bar/1.10/"},
+		{Key: "org/foo/bar/1.9/"},
+		{Key: "org/foo/bar/1.9.1/"},
+		{Key: "org/foo/bar/README.txt", Size: 1500000, LastModified: modified},
+		{Key: "org/foo/bar/index.html"},
+	}
+	htmlPath, err := toHtml(PACKAGE_TYPE_MAVEN, contents, "org/foo/bar/", root)
+	assert.Nil(t, err)
+	html, _ := files.ReadFile(htmlPath)
+	names := []string{}
+	for _, m := range regexp.MustCompile(`title="([^"]+)"`).FindAllStringSubmatch(html, -1) {
+		names = append(names, m[1])
+	}
+	assert.Equal(t, []string{"../", "1.9/", "1.9.1/", "1.10/", "README.txt",
+		"maven-metadata.xml", "maven-metadata.xml.sha1"}, names)
+	assert.Contains(t, html, "<td>1.5 MB</td>")
+	assert.Contains(t, html, "<td>2.0 kB</td>")
+	assert.Contains(t, html, "<td>2024-01-02 03:04</td>")
+	assert.Contains(t, html, `<h1><a href="../../../">/</a><a href="../../">org/</a><a href="../">foo/</a><a href="./">bar/</a></h1>`)
+
+	// Folders which are not GA are sorted by names
+	sorted := sortIndexItems([]IndexItem{{Name: "b.jar"}, {Name: "1.10/", IsDir: true}, {Name: "1.9/", IsDir: true}})
+	assert.Equal(t, "1.10/", sorted[0].Name)
+	assert.Equal(t, "1.9/", sorted[1].Name)
+	assert.Equal(t, []IndexBreadcrumb{{Name: "/", Href: "./"}}, indexBreadcrumbs("/"))
+
+	htmlPath, err = toHtml(PACKAGE_TYPE_NPM, []storage.FileInfo{{Key: "@babel/"}, {Key: "jquery/"}}, "/", root)
+	assert.Nil(t, err)
+	html, _ = files.ReadFile(htmlPath)
+	assert.Contains(t, html, `<a href="@babel/index.html" title="@babel/">`)
+	assert.Contains(t, html, `<a href="jquery/" title="jquery/">`)
+	assert.Contains(t, html, `<h1><a href="./index.html">/</a></h1>`)
+
+	templateFile := path.Join(root, "custom.tmpl")
+	files.StoreFile(templateFile, `<h1>Branded {{.Title}}</h1>{{range .Items}}[{{.Name}}]{{end}}`, true)
+	assert.Nil(t, SetIndexTemplate(PACKAGE_TYPE_MAVEN, templateFile))
+	defer delete(indexTemplates, PACKAGE_TYPE_MAVEN)
+	htmlPath, err = toHtml(PACKAGE_TYPE_MAVEN, []storage.FileInfo{{Key: "org/"}}, "/", root)
+	assert.Nil(t, err)
+	html, _ = files.ReadFile(htmlPath)
+	assert.Equal(t, "<h1>Branded /</h1>[org/]", html)
+
+	files.StoreFile(templateFile, `{{.Title`, true)
+	assert.NotNil(t, SetIndexTemplate(PACKAGE_TYPE_NPM, templateFile))
+}
+
+func TestRebuildIndexesWithUnlistedFolder(t *testing.T) {
+	bucket := storage.TEST_BUCKET
+	root := t.TempDir()
+	remote := newMemS3()
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.pom", "pom")
+	remote.put(bucket, "org/foo/baz/1.0/baz-1.0.jar", "jar")
+	target := config.Target{Bucket: bucket}
+
+	// The sub folders of org/foo/ are not known as it can not be listed
+	remote.failList = "org/foo/"
+	state := &indexRebuildState{Pending: []string{"/"}}
+	rebuildIndexes(remote.client(t), PACKAGE_TYPE_MAVEN, target, root, state, 2, func() {})
+	assert.False(t, state.Completed)
+	assert.Equal(t, []string{"org/foo/"}, state.Failed)
+	assert.Equal(t, []string{"org/foo/"}, state.Unlisted)
+	assert.Empty(t, state.Pending)
+	_, ok := remote.get(bucket, "org/foo/bar/index.html")
+	assert.False(t, ok)
+
+	// The folders not started in the retrying are still failed
+	remote.failList = ""
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rebuildIndexes(remote.client(t).WithContext(ctx), PACKAGE_TYPE_MAVEN, target, root, state, 2, func() {})
+	assert.False(t, state.Completed)
+	assert.Equal(t, []string{"org/foo/"}, state.Failed)
+	assert.Equal(t, []string{"org/foo/"}, state.Unlisted)
+
+	// The sub folders are walked once the folder is listed by the retrying
+	rebuildIndexes(remote.client(t), PACKAGE_TYPE_MAVEN, target, root, state, 2, func() {})
+	assert.True(t, state.Completed)
+	assert.Empty(t, state.Unlisted)
+	for _, key := range []string{"org/foo/index.html", "org/foo/bar/index.html", "org/foo/bar/1.0/index.html",
+		"org/foo/baz/index.html", "org/foo/baz/1.0/index.html"} {
+		_, ok := remote.get(bucket, key)
+		assert.True(t, ok, key)
+	}
+}
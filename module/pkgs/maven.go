@@ -1,7 +1,9 @@
 package pkgs
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"crypto"
 	"encoding/xml"
 	"fmt"
@@ -11,10 +13,15 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 
 	"org.commonjava/charon/module/config"
 	"org.commonjava/charon/module/storage"
@@ -31,6 +38,7 @@ type MavenMetadata struct {
 	GroupId        string
 	ArtifactId     string
 	LastUpdateTime string
+	Plugins        []MavenPlugin
 	versions       []string
 	latestVersion  string
 	releaseVersion string
@@ -72,14 +80,32 @@ func (m *MavenMetadata) GenerateMetaFileContent() (string, error) {
 	return buf.String(), nil
 }
 
+// This MavenPlugin represents a plugin entry in the group level maven-metadata.xml,
+// which is used by maven to resolve a plugin through its prefix, like
+// "mvn compiler:compile"
+type MavenPlugin struct {
+	Name       string `xml:"name"`
+	Prefix     string `xml:"prefix"`
+	ArtifactId string `xml:"artifactId"`
+}
+
+// The plugin descriptor (META-INF/maven/plugin.xml) in a maven plugin jar. Only
+// the fields needed by group level metadata are parsed.
+type pluginDescriptor struct {
+	Name       string `xml:"name"`
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	GoalPrefix string `xml:"goalPrefix"`
+}
+
 // This ArchetypeRef will represent an entry in archetype-catalog.xml content
 // which will be used in jinja2 or other places
 type ArchetypeRef struct {
 	GroupId     string `xml:"groupId"`
 	ArtifactId  string `xml:"artifactId"`
 	Version     string `xml:"version"`
-	Repository  string `xml:"repository"`
-	Description string `xml:"description"`
+	Repository  string `xml:"repository,omitempty"`
+	Description string `xml:"description,omitempty"`
 }
 
 func (m ArchetypeRef) String() string {
@@ -87,17 +113,34 @@ func (m ArchetypeRef) String() string {
 		m.GroupId, m.ArtifactId, m.Version, m.Description)
 }
 
+// Archetypes are identified by their GAVs, the repository and description
+// are not considered
+func (m ArchetypeRef) sameGAV(other ArchetypeRef) bool {
+	return m.GroupId == other.GroupId &&
+		m.ArtifactId == other.ArtifactId &&
+		m.Version == other.Version
+}
+
 // This MavenArchetypeCatalog represents an archetype-catalog.xml which will be
 // used in jinja2 to regenerate the file with merged contents
 type MavenArchetypeCatalog struct {
-	Archetypes []ArchetypeRef `xml:"archetypes>archetype"`
+	XMLName        xml.Name       `xml:"archetype-catalog"`
+	Xmlns          string         `xml:"xmlns,attr"`
+	XmlnsXsi       string         `xml:"xmlns:xsi,attr"`
+	SchemaLocation string         `xml:"xsi:schemaLocation,attr"`
+	Archetypes     []ArchetypeRef `xml:"archetypes>archetype"`
 }
 
 func NewMavenArchetypeCatalog(archetypes []ArchetypeRef) MavenArchetypeCatalog {
 	archs := make([]ArchetypeRef, len(archetypes))
 	copy(archs, archetypes)
 	slices.SortFunc(archs, archetypeRefCompare)
-	return MavenArchetypeCatalog{Archetypes: archs}
+	return MavenArchetypeCatalog{
+		Xmlns:          ARCHETYPE_CATALOG_XMLNS,
+		XmlnsXsi:       ARCHETYPE_CATALOG_XMLNS_XSI,
+		SchemaLocation: ARCHETYPE_CATALOG_SCHEMA_LOCATION,
+		Archetypes:     archs,
+	}
 }
 
 func (m *MavenArchetypeCatalog) GenerateMetaFileContent() (string, error) {
@@ -106,15 +149,7 @@ func (m *MavenArchetypeCatalog) GenerateMetaFileContent() (string, error) {
 		logger.Error(fmt.Sprintf("executing template: %s", err))
 		return "", err
 	}
-	return string(bytes), nil
-	// t := template.Must(template.New("archetype").Parse(ARCHETYPE_CATALOG_TEMPLATE))
-	// var buf bytes.Buffer
-	// err := t.Execute(&buf, m)
-	// if err != nil {
-	// 	logger.Error(fmt.Sprintf("executing template: %s", err))
-	// 	return "", err
-	// }
-	// return buf.String(), nil
+	return xml.Header + string(bytes) + "\n", nil
 }
 
 func (m *MavenArchetypeCatalog) String() string {
@@ -134,9 +169,14 @@ func (m *MavenArchetypeCatalog) String() string {
 //     prefix. See target definition in Charon configuration for details
 //   - dir_ is base dir for extracting the tarball, will use system
 //     tmp dir if None.
+//   - prefetch is used to check the existing files of each GA with one
+//     listing, instead of a request for each file
+//   - reportFile is the file to write the json report of the uploading,
+//     no report will be written if it is empty.
 //
 // Returns the directory used for archive processing and if the uploading is successful
 func HandleMavenUploading(
+	ctx context.Context,
 	repo,
 	prodKey string,
 	ignorePatterns []string,
@@ -145,127 +185,335 @@ func HandleMavenUploading(
 	awsProfile,
 	dir_ string,
 	doIndex,
-	genSign bool,
+	genSign,
+	genChecksum,
+	prefetch bool,
 	cfEnable bool,
 	key string,
 	dryRun bool,
 	manifestBucketName,
-	configFilePath string,
+	configFilePath,
+	reportFile string,
 ) (string, bool) {
 	realRoot := root
 	if util.IsBlankString(realRoot) {
 		realRoot = "maven-repository"
 	}
+	params := uploadParams{
+		Repo:           repo,
+		ProdKey:        prodKey,
+		IgnorePatterns: ignorePatterns,
+		Root:           root,
+		Targets:        targets,
+		DoIndex:        doIndex,
+		GenSign:        genSign,
+		GenChecksum:    genChecksum,
+		Prefetch:       prefetch,
+		CFEnable:       cfEnable,
+		Key:            key,
+		ManifestBucket: manifestBucketName,
+		ConfigFile:     configFilePath,
+	}
+	report := newRunReport("upload", prodKey, repo, dryRun)
+	succeeded := false
+	defer func() { report.finish(succeeded, reportFile) }()
+
 	// step 1. extract tarball
-	tmpRoot := extractTarball(repo, prodKey, dir_)
+	start := time.Now()
+	tmpRoot, err := extractTarball(repo, prodKey, dir_)
+	report.timed("extract", start)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not extract tarball due to error: %s", err))
+		return tmpRoot, false
+	}
+
+	succeeded = uploadMavenJournaled(ctx, params, tmpRoot, awsProfile, dryRun, report)
+	return tmpRoot, succeeded
+}
+
+// Upload the files in tmpRoot with a new journal in it, so that the
+// uploading can be resumed from tmpRoot once it is interrupted.
+func uploadMavenJournaled(ctx context.Context, params uploadParams, tmpRoot, awsProfile string,
+	dryRun bool, report *RunReport) bool {
+	// The journal is not needed by dry run, as nothing is really uploaded
+	var journal *uploadJournal
+	if !dryRun {
+		j, err := newUploadJournal(tmpRoot, params)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Can not create upload journal, the uploading can not be resumed: %s", err))
+		} else {
+			journal = j
+			defer journal.close()
+			logger.Info(fmt.Sprintf("Upload journal is created, the uploading can be resumed by --resume %s", tmpRoot))
+		}
+	}
+	return uploadMaven(ctx, params, tmpRoot, journal, awsProfile, dryRun, report)
+}
+
+// Resume an interrupted uploading from its work dir, which is the dir
+// returned by HandleMavenUploading. The uploading is done again with the
+// parameters in the journal of the work dir, but the files and phases
+// recorded in the journal will be skipped.
+//
+// Returns the work dir and if the uploading is successful
+func HandleMavenUploadResume(ctx context.Context, workDir, awsProfile string,
+	dryRun bool, reportFile string) (string, bool) {
+	journal, err := loadUploadJournal(workDir)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not load upload journal from %s: %s", workDir, err))
+		return workDir, false
+	}
+	defer journal.close()
+	params := journal.params
+	report := newRunReport("upload", params.ProdKey, params.Repo, dryRun)
+	succeeded := false
+	defer func() { report.finish(succeeded, reportFile) }()
+
+	logger.Info(fmt.Sprintf("Resuming the uploading of product %s from %s", params.ProdKey, workDir))
+	succeeded = uploadMaven(ctx, params, workDir, journal, awsProfile, dryRun, report)
+	return workDir, succeeded
+}
+
+// Prefetch the existing files of the GAs of the poms in each target, so
+// that the files are checked with one listing for each GA
+func prefetchGAs(s3Client *storage.S3Client, poms []string, topLevel string, targets []config.Target) {
+	gaPaths := []string{}
+	for _, pom := range poms {
+		// The pom is in the version dir of the GA dir
+		gaPath := path.Dir(path.Dir(strings.TrimPrefix(strings.TrimPrefix(pom, topLevel), "/")))
+		if gaPath != "." && !slices.Contains(gaPaths, gaPath) {
+			gaPaths = append(gaPaths, gaPath)
+		}
+	}
+	logger.Info(fmt.Sprintf("Prefetching the existing files of %d GAs", len(gaPaths)))
+	for _, t := range targets {
+		for _, gaPath := range gaPaths {
+			s3Client.PrefetchPrefix(t.Bucket, path.Join(t.Prefix, gaPath)+"/")
+		}
+	}
+}
+
+// Upload the extracted tarball in tmpRoot, the files and phases done in
+// the journal will be skipped. Once ctx is cancelled, the files and phases
+// not started will be skipped, and they can be done by resuming.
+func uploadMaven(ctx context.Context, params uploadParams, tmpRoot string, journal *uploadJournal,
+	awsProfile string, dryRun bool, report *RunReport) bool {
+	prodKey, root, targets := params.ProdKey, params.Root, params.Targets
+	doIndex, genSign, cfEnable := params.DoIndex, params.GenSign, params.CFEnable
+	manifestBucketName := params.ManifestBucket
+	donePhase := func(bucket, phase string, paths []string) {
+		if !dryRun {
+			journal.donePhase(bucket, phase, paths)
+		}
+	}
+	errs := NewErrorCollector(tmpRoot)
 
 	// step 2. scan for paths and filter out the ignored paths,
-	// and also collect poms for later metadata generation
-	scannedPaths := scanPaths(ignorePatterns, tmpRoot, root)
+	// and also collect poms for later metadata generation. On resume the
+	// paths scanned by the interrupted uploading are used, as the generated
+	// files are also written in the extracted tarball
+	scannedPaths, ok := journal.scanned()
+	if ok {
+		logger.Info("Use the scanned paths in upload journal of " + tmpRoot)
+	} else {
+		scannedPaths = scanPaths(params.IgnorePatterns, tmpRoot, root)
+		if !dryRun {
+			journal.doneScan(scannedPaths)
+		}
+	}
 	validMvnPaths, topLevel := scannedPaths.mvnPaths, scannedPaths.topLevel
 
 	// This prefix is a subdir under top-level directory in tarball
 	// or root before real GAV dir structure
 	if !files.IsDir(topLevel) {
-		panic(fmt.Errorf("error: the extracted top-level path %s does not exist",
-			topLevel))
+		logger.Error(fmt.Sprintf("The extracted top-level path %s does not exist", topLevel))
+		return false
+	}
+
+	// Generate the missing digest files so that they can also be validated and
+	// uploaded along with the artifacts
+	if params.GenChecksum {
+		logger.Info("Generating missing digest files for artifacts.")
+		generated, mismatched := genMissingDigestFiles(validMvnPaths, runtime.NumCPU())
+		logger.Info(fmt.Sprintf("%d digest files generated.\n", len(generated)))
+		if len(mismatched) > 0 {
+			logger.Error(
+				fmt.Sprintf("These digest files do not match their artifacts, the uploading is aborted:\n%s",
+					strings.Join(mismatched, "\n")))
+			for _, m := range mismatched {
+				errs.Add("", ERROR_CATEGORY_VALIDATION_ERROR, m, "digest does not match the artifact")
+			}
+			errs.report("")
+			return false
+		}
+		validMvnPaths = append(validMvnPaths, generated...)
 	}
 
 	// step 3. do validation for the files, like product version checking
 	logger.Info("Validating paths with rules.")
-	errMsgs, passed := validateMaven(validMvnPaths)
+	start := time.Now()
+	msgs, passed := validateMaven(validMvnPaths, topLevel, prodKey, mergeValidationRules(targets))
+	report.timed("validate", start)
+	handleError(msgs)
+	errs.addValidationMessages(msgs)
 	if !passed {
-		handleError(errMsgs)
-		//Question: should we exit here?
+		logger.Error("Validation failed, the uploading is aborted before any file is uploaded.")
+		errs.report("")
+		return false
+	}
+
+	// The signing config is checked before any file is uploaded, so that a
+	// broken config will not leave the targets half updated
+	var signConf *config.CharonConfig
+	if genSign {
+		var err error
+		signConf, err = config.GetConfig(params.ConfigFile)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not load charon configuration for signing: %s", err))
+			errs.report("")
+			return false
+		}
+		if util.IsBlankString(signConf.SignatureCommand) {
+			logger.Error("No detach_signature_command is configured for signing")
+			errs.report("")
+			return false
+		}
 	}
 
 	// step 4. Do uploading
 	s3Client, err := storage.NewS3Client(
-		awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
 	if err != nil {
-		panic(err)
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return false
+	}
+	if journal != nil {
+		s3Client.SetUploadJournal(journal)
 	}
 	fixedTargets := make([]config.Target, len(targets))
 	buckets := make([]string, len(targets))
 	for i, t := range targets {
-		fixedTargets[i] = config.Target{
-			Bucket:   t.Bucket,
-			Prefix:   strings.TrimPrefix(t.Prefix, "/"),
-			Registry: t.Registry,
-			Domain:   t.Domain,
-		}
+		fixedTargets[i] = fixTarget(t)
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
 		buckets[i] = t.Bucket
 	}
+	if params.Prefetch {
+		prefetchGAs(s3Client, scannedPaths.poms, topLevel, fixedTargets)
+	}
 	logger.Info(fmt.Sprintf("Start uploading files to s3 buckets: %s", buckets))
-	failedFiles := s3Client.UploadFiles(
+	start = time.Now()
+	failedFilesByBucket := s3Client.UploadFiles(
 		validMvnPaths, fixedTargets, prodKey, topLevel)
+	report.timed("upload", start)
 	logger.Info("Files uploading done\n")
 	succeeded := true
-	generatedSigns := []string{}
 	for _, t := range fixedTargets {
+		failedFiles := failedFilesByBucket[t.Bucket]
+		tReport := report.addTarget(t)
+		tReport.setPaths(s3Client, validMvnPaths, failedFiles, topLevel, PATH_OUTCOME_UPLOADED)
+		if interrupted(ctx, "metadata, signature and index updating for bucket "+t.Bucket) {
+			uploadPostProcess(errs, s3Client, failedFiles, nil, prodKey, t.Bucket)
+			succeeded = false
+			continue
+		}
 		// prepare cf invalidate files
 		cfInvalidatePaths := []string{}
 		// step 5. Do manifest uploading
+		manifestUploaded := true
 		if util.IsBlankString(manifestBucketName) {
 			logger.Warn("Warning: No manifest bucket is provided, will ignore the process of manifest uploading\n")
 		} else {
 			logger.Info("Start uploading manifest to s3 bucket " + manifestBucketName)
 			manifestFolder := t.Bucket
-			manifestName, manifestFullPath := files.WriteManifest(validMvnPaths, topLevel, prodKey)
-			s3Client.UploadManifest(manifestName, manifestFullPath, manifestFolder, manifestBucketName)
-			logger.Info("Manifest uploading is done\n")
+			manifestName, manifestFullPath, err := files.WriteManifest(validMvnPaths, topLevel, prodKey)
+			if err != nil {
+				logger.Error(fmt.Sprintf("Can not write manifest %s due to error: %s", manifestName, err))
+				errs.Add(t.Bucket, ERROR_CATEGORY_FILE, manifestName, fmt.Sprintf("can not write manifest: %s", err))
+				manifestUploaded = false
+			} else if s3Client.UploadManifest(manifestName, manifestFullPath, manifestFolder, manifestBucketName) {
+				logger.Info("Manifest uploading is done\n")
+			} else {
+				errs.Add(t.Bucket, ERROR_CATEGORY_FILE, manifestName, "manifest uploading failed")
+				manifestUploaded = false
+			}
 		}
 
 		// step 6. Use uploaded poms to scan s3 for metadata refreshment
 		bucketName := t.Bucket
 		prefix := t.Prefix
 		validPoms := scannedPaths.poms
-		logger.Info("Start generating maven-metadata.xml files for bucket " + bucketName)
-		metaFiles := generateMetadatas(*s3Client, validPoms, bucketName, prefix, root)
-		logger.Info("maven-metadata.xml files generation done\n")
-		failedMetas := metaFiles[META_FILE_FAILED]
-
-		// step 7. Upload all maven-metadata.xml
-		if v, ok := metaFiles[META_FILE_GEN_KEY]; ok {
-			logger.Info("Start updating maven-metadata.xml to s3 bucket " + bucketName)
-			_failedMetas := s3Client.UploadMetadatas(v, t, "", topLevel)
-			failedMetas = append(failedMetas, _failedMetas...)
-			logger.Info(
-				fmt.Sprintf("maven-metadata.xml updating done in bucket %s\n", bucketName))
-			// Add maven-metadata.xml to CF invalidate paths
+		failedMetas := []string{}
+		if done, ok := journal.phaseDone(bucketName, JOURNAL_PHASE_METADATA); ok {
+			logger.Info("maven-metadata.xml files are already updated in bucket " + bucketName)
+			tReport.GeneratedMetadata = append(tReport.GeneratedMetadata, reportPaths(done, topLevel)...)
 			if cfEnable {
-				cfInvalidatePaths = append(cfInvalidatePaths, metaFiles[META_FILE_GEN_KEY]...)
+				cfInvalidatePaths = append(cfInvalidatePaths, done...)
+			}
+		} else {
+			logger.Info("Start generating maven-metadata.xml files for bucket " + bucketName)
+			start = time.Now()
+			metaFiles := generateMetadatas(*s3Client, validPoms, bucketName, prefix, topLevel)
+			logger.Info("maven-metadata.xml files generation done\n")
+			failedMetas = append(failedMetas, metaFiles[META_FILE_FAILED]...)
+
+			// step 7. Upload all maven-metadata.xml
+			if v, ok := metaFiles[META_FILE_GEN_KEY]; ok {
+				logger.Info("Start updating maven-metadata.xml to s3 bucket " + bucketName)
+				_failedMetas := s3Client.UploadMetadatas(v, t, "", topLevel)
+				failedMetas = append(failedMetas, _failedMetas...)
+				tReport.GeneratedMetadata = append(tReport.GeneratedMetadata, reportPaths(v, topLevel)...)
+				logger.Info(
+					fmt.Sprintf("maven-metadata.xml updating done in bucket %s\n", bucketName))
+				// Add maven-metadata.xml to CF invalidate paths
+				if cfEnable {
+					cfInvalidatePaths = append(cfInvalidatePaths, metaFiles[META_FILE_GEN_KEY]...)
+				}
+			}
+			tReport.timed("metadata", start)
+			if len(failedMetas) == 0 {
+				donePhase(bucketName, JOURNAL_PHASE_METADATA, metaFiles[META_FILE_GEN_KEY])
 			}
 		}
 
 		// step 8. Determine refreshment of archetype-catalog.xml
-		if files.FileOrDirExists(path.Join(topLevel, MAVEN_ARCH_FILE)) {
+		if done, ok := journal.phaseDone(bucketName, JOURNAL_PHASE_ARCHETYPE); ok {
+			logger.Info("archetype-catalog.xml is already updated in bucket " + bucketName)
+			tReport.GeneratedMetadata = append(tReport.GeneratedMetadata, reportPaths(done, topLevel)...)
+			if cfEnable {
+				cfInvalidatePaths = append(cfInvalidatePaths, done...)
+			}
+		} else if files.FileOrDirExists(path.Join(topLevel, MAVEN_ARCH_FILE)) {
 			logger.Info("Start generating archetype-catalog.xml for bucket " + bucketName)
 			uploadArchetypeFile := generateUploadArchetypeCatalog(s3Client, bucketName, topLevel, prefix)
 			logger.Info(
 				fmt.Sprintf("archetype-catalog.xml files generation done in bucket %s\n", bucketName))
+			archetypeFiles := []string{}
+			_failedMetas := []string{}
 			if uploadArchetypeFile {
-				archetypeFiles := []string{path.Join(topLevel, MAVEN_ARCH_FILE)}
+				archetypeFiles = append(archetypeFiles, path.Join(topLevel, MAVEN_ARCH_FILE))
 				archetypeFiles = append(archetypeFiles, hashDecorateMetadata(topLevel, MAVEN_ARCH_FILE)...)
 				logger.Info("Start updating archetype-catalog.xml to s3 bucket %s" + bucketName)
-				_failedMetas := s3Client.UploadMetadatas(archetypeFiles, t, "", topLevel)
+				_failedMetas = s3Client.UploadMetadatas(archetypeFiles, t, "", topLevel)
 				failedMetas = append(failedMetas, _failedMetas...)
+				tReport.GeneratedMetadata = append(tReport.GeneratedMetadata, reportPaths(archetypeFiles, topLevel)...)
 				logger.Info(fmt.Sprintf("archetype-catalog.xml updating done in bucket %s\n", bucketName))
 				// Add archtype-catalog to invalidate paths
 				if cfEnable {
 					cfInvalidatePaths = append(cfInvalidatePaths, archetypeFiles...)
 				}
 			}
+			if len(_failedMetas) == 0 {
+				donePhase(bucketName, JOURNAL_PHASE_ARCHETYPE, archetypeFiles)
+			}
 		}
 
 		// step 10. Generate signature file if contain_signature is set to True
-		if genSign {
-			conf, err := config.GetConfig(configFilePath)
-			if err != nil {
-				panic(err)
-			}
-			suffixList := getSuffix(PACKAGE_TYPE_MAVEN, *conf)
-			command := conf.SignatureCommand
+		if done, ok := journal.phaseDone(bucketName, JOURNAL_PHASE_SIGNATURE); genSign && ok {
+			logger.Info("Signature files are already uploaded to bucket " + bucketName)
+			tReport.Signatures = reportPaths(done, topLevel)
+		} else if genSign {
+			suffixList := getSuffix(PACKAGE_TYPE_MAVEN, *signConf)
+			command := signConf.SignatureCommand
 			artifacts := []string{}
 			for _, p := range validMvnPaths {
 				suffixed := false
@@ -281,32 +529,48 @@ func HandleMavenUploading(
 			}
 			logger.Info(
 				fmt.Sprintf("Start generating signature for s3 bucket %s\n", bucketName))
-			_failedMetas, _generatedSigns := generateSign(
+			start = time.Now()
+			_failedMetas, generatedSigns := generateSign(
 				*s3Client, artifacts, util.PACKAGE_TYPE_MAVEN,
-				topLevel, prefix, bucketName, key, command)
+				topLevel, prefix, bucketName, params.Key, command)
 			failedMetas = append(failedMetas, _failedMetas...)
-			generatedSigns = append(generatedSigns, _generatedSigns...)
 			logger.Info("Singature generation done.\n")
 			logger.Info(
 				fmt.Sprintf("Start upload singature files to s3 bucket %s\n", bucketName))
-			_failedMetas = s3Client.UploadSignatures(
+			_failedSigns := s3Client.UploadSignatures(
 				generatedSigns, t, "", topLevel)
-			failedMetas = append(failedMetas, _failedMetas...)
+			failedMetas = append(failedMetas, _failedSigns...)
+			tReport.Signatures = reportPaths(generatedSigns, topLevel)
+			tReport.timed("signature", start)
 			logger.Info("Signature uploading done.\n")
+			// Nothing is recorded if no signature is generated, so the
+			// signing will be tried again when resuming
+			if len(generatedSigns) > 0 && len(_failedMetas) == 0 && len(_failedSigns) == 0 {
+				donePhase(bucketName, JOURNAL_PHASE_SIGNATURE, generatedSigns)
+			}
 		}
 
 		//  this step generates index.html for each dir and add them to file list
 		//  index is similar to metadata, it will be overwritten everytime
 		validDirs := scannedPaths.dirs
-		if doIndex {
+		if done, ok := journal.phaseDone(bucketName, JOURNAL_PHASE_INDEX); doIndex && ok {
+			logger.Info("Index files are already updated in bucket " + bucketName)
+			tReport.Indexes = reportPaths(done, topLevel)
+		} else if doIndex {
 			logger.Info("Start generating index files to s3 bucket " + bucketName)
+			start = time.Now()
 			createdIndex := generateIndexes(*s3Client, validDirs,
-				PACKAGE_TYPE_MAVEN, topLevel, bucketName, prefix)
+				PACKAGE_TYPE_MAVEN, topLevel, bucketName, prefix, t.IndexJson)
 			logger.Info("Index files generation done.\n")
 			logger.Info("Start updating index files to s3 bucket " + bucketName)
 			_failed_metas := s3Client.UploadMetadatas(createdIndex, t, prodKey, topLevel)
 			failedMetas = append(failedMetas, _failed_metas...)
+			tReport.Indexes = reportPaths(createdIndex, topLevel)
+			tReport.timed("index", start)
 			logger.Info("Index files updating done\n")
+			if len(_failed_metas) == 0 {
+				donePhase(bucketName, JOURNAL_PHASE_INDEX, createdIndex)
+			}
 			// We will not invalidate the index files per cost consideration
 			// if cfEnable {
 			// 	cfInvalidatePaths = append(cfInvalidatePaths, createdIndex...)
@@ -316,19 +580,236 @@ func HandleMavenUploading(
 		}
 
 		// step 11. Finally do the CF invalidating for metadata files
+		if done, ok := journal.phaseDone(bucketName, JOURNAL_PHASE_CF); ok {
+			logger.Info("CF cache is already invalidated for bucket " + bucketName)
+			tReport.CFInvalidations = append(tReport.CFInvalidations, done...)
+		} else if cfEnable && len(cfInvalidatePaths) > 0 {
+			cfClient, err := storage.NewCFClient(ctx, awsProfile)
+			if err != nil {
+				logger.Error(
+					fmt.Sprintf("Cannot do Cloudfront cache invalidating due to error: %s", err))
+			} else {
+				start = time.Now()
+				cfInvalidatePaths = wildcardMetadataPaths(cfInvalidatePaths)
+				invalidations := invalidateCFPaths(
+					cfClient, t, cfInvalidatePaths, topLevel, storage.INVALIDATION_BATCH_DEFAULT)
+				tReport.setCFInvalidations(invalidations)
+				errs.SetRetries(retryClientCF(t.Bucket), cfClient.RetryCounts())
+				tReport.timed("cf", start)
+				// The invalidation will be tried again on resume if none is created
+				if len(invalidations) > 0 {
+					donePhase(bucketName, JOURNAL_PHASE_CF, tReport.CFInvalidations)
+				}
+			}
+		}
+
+		uploadPostProcess(errs, s3Client, failedFiles, failedMetas, prodKey, bucketName)
+		report.Retries = errs.Retries()
+		tReport.setFailedMetadata(s3Client, failedMetas, topLevel)
+		tReport.Success = len(failedFiles) <= 0 && len(failedMetas) <= 0 && manifestUploaded
+		succeeded = succeeded && tReport.Success
+	}
+
+	return succeeded
+}
+
+// Handle the maven product release tarball deletion process.
+//   - repo is the location of the tarball in filesystem
+//   - prod_key is used to identify which product this repo
+//     tar belongs to
+//   - ignore_patterns is used to filter out paths which don't
+//     need to upload in the tarball
+//   - root is a prefix in the tarball to identify which path is
+//     the beginning of the maven GAV path
+//   - targets contains the target name with its bucket name and prefix
+//     for the bucket, which will be used to store artifacts with the
+//     prefix. See target definition in Charon configuration for details
+//   - dir_ is base dir for extracting the tarball, will use system
+//     tmp dir if None.
+//   - reportFile is the file to write the json report of the deletion,
+//     no report will be written if it is empty.
+//
+// Returns the directory used for archive processing and if the rollback is successful
+func HandleMavenDeletion(
+	ctx context.Context,
+	repo,
+	prodKey string,
+	ignorePatterns []string,
+	root string,
+	targets []config.Target,
+	awsProfile,
+	dir_ string,
+	doIndex,
+	cfEnable bool,
+	dryRun bool,
+	manifestBucketName,
+	reportFile string,
+) (string, bool) {
+	report := newRunReport("delete", prodKey, repo, dryRun)
+	succeeded := false
+	defer func() { report.finish(succeeded, reportFile) }()
+
+	// step 1. extract tarball
+	start := time.Now()
+	tmpRoot, err := extractTarball(repo, prodKey, dir_)
+	report.timed("extract", start)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not extract tarball due to error: %s", err))
+		return tmpRoot, false
+	}
+	errs := NewErrorCollector(tmpRoot)
+
+	// step 2. scan for paths and filter out the ignored paths,
+	// and also collect poms for later metadata generation
+	scannedPaths := scanPaths(ignorePatterns, tmpRoot, root)
+	validMvnPaths, topLevel := scannedPaths.mvnPaths, scannedPaths.topLevel
+
+	// step 3. Delete all valid_paths from s3
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return tmpRoot, false
+	}
+	succeeded = true
+	for _, target := range targets {
+		if interrupted(ctx, "deletion from bucket "+target.Bucket) {
+			succeeded = false
+			continue
+		}
+		t := fixTarget(target)
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		bucketName := t.Bucket
+		prefix := t.Prefix
+		tReport := report.addTarget(t)
+		// prepare cf invalidate files
+		cfInvalidatePaths := []string{}
+		logger.Info(fmt.Sprintf("Start deleting files from s3 bucket %s", bucketName))
+		start = time.Now()
+		// The gradle modules are deleted after all other files, so they are kept
+		// if their variant files are still kept for other products
+		modulePaths, otherPaths := splitGradleModulePaths(validMvnPaths, scannedPaths.modules)
+		failedFiles := s3Client.DeleteFiles(otherPaths, t, prodKey, topLevel)
+		if len(modulePaths) > 0 {
+			deletableModules := filterDeletableGradleModules(
+				*s3Client, modulePaths, bucketName, prefix, topLevel)
+			failedFiles = append(failedFiles, s3Client.DeleteFiles(deletableModules, t, prodKey, topLevel)...)
+		}
+		tReport.setPaths(s3Client, validMvnPaths, failedFiles, topLevel, PATH_OUTCOME_DELETED)
+		tReport.timed("delete", start)
+		logger.Info("Files deletion done\n")
+		if interrupted(ctx, "metadata and index updating for bucket "+bucketName) {
+			rollbackPostProcess(errs, s3Client, failedFiles, nil, prodKey, bucketName)
+			succeeded = false
+			continue
+		}
+
+		// step 4. Delete related manifest
+		if !util.IsBlankString(manifestBucketName) {
+			logger.Info("Start deleting manifest from s3 bucket " + manifestBucketName)
+			if s3Client.DeleteManifest(prodKey, bucketName, manifestBucketName) {
+				logger.Info("Manifest deletion is done\n")
+			} else {
+				logger.Warn("Warning: Manifest deletion failed\n")
+			}
+		} else {
+			logger.Warn("Warning: No manifest bucket is provided, will ignore the process of manifest deletion\n")
+		}
+
+		// step 5. Use changed GA to scan s3 for metadata refreshment
+		logger.Info("Start generating maven-metadata.xml files for all changed GAs in s3 bucket " + bucketName)
+		start = time.Now()
+		metaFiles := generateMetadatas(*s3Client, scannedPaths.poms, bucketName, prefix, topLevel)
+		logger.Info("maven-metadata.xml files generation done\n")
+
+		// step 6. Upload or delete all changed maven-metadata.xml
+		failedMetas := metaFiles[META_FILE_FAILED]
+		if v, ok := metaFiles[META_FILE_DEL_KEY]; ok {
+			logger.Info("Start deleting stale maven-metadata.xml from s3 bucket " + bucketName)
+			failedMetas = append(failedMetas, s3Client.DeleteFiles(v, t, "", topLevel)...)
+			tReport.DeletedMetadata = append(tReport.DeletedMetadata, v...)
+			logger.Info(
+				fmt.Sprintf("maven-metadata.xml deletion done in bucket %s\n", bucketName))
+			if cfEnable {
+				cfInvalidatePaths = append(cfInvalidatePaths, v...)
+			}
+		}
+		if v, ok := metaFiles[META_FILE_GEN_KEY]; ok {
+			logger.Info("Start updating maven-metadata.xml to s3 bucket " + bucketName)
+			_failedMetas := s3Client.UploadMetadatas(v, t, "", topLevel)
+			failedMetas = append(failedMetas, _failedMetas...)
+			tReport.GeneratedMetadata = append(tReport.GeneratedMetadata, reportPaths(v, topLevel)...)
+			logger.Info(
+				fmt.Sprintf("maven-metadata.xml updating done in bucket %s\n", bucketName))
+			if cfEnable {
+				cfInvalidatePaths = append(cfInvalidatePaths, v...)
+			}
+		}
+		tReport.timed("metadata", start)
+
+		// step 7. Determine refreshment of archetype-catalog.xml
+		if files.FileOrDirExists(path.Join(topLevel, MAVEN_ARCH_FILE)) {
+			logger.Info("Start generating archetype-catalog.xml for bucket " + bucketName)
+			archetypeAction := generateRollbackArchetypeCatalog(s3Client, bucketName, topLevel, prefix)
+			logger.Info(
+				fmt.Sprintf("archetype-catalog.xml files generation done in bucket %s\n", bucketName))
+			archetypeFiles := []string{path.Join(topLevel, MAVEN_ARCH_FILE)}
+			archetypeFiles = append(archetypeFiles, hashDecorateMetadata(topLevel, MAVEN_ARCH_FILE)...)
+			if archetypeAction < 0 {
+				logger.Info("Start deleting archetype-catalog.xml from s3 bucket " + bucketName)
+				_failedMetas := s3Client.DeleteFiles(archetypeFiles, t, "", topLevel)
+				failedMetas = append(failedMetas, _failedMetas...)
+				tReport.DeletedMetadata = append(tReport.DeletedMetadata, reportPaths(archetypeFiles, topLevel)...)
+				logger.Info(fmt.Sprintf("archetype-catalog.xml deletion done in bucket %s\n", bucketName))
+			} else if archetypeAction > 0 {
+				logger.Info("Start updating archetype-catalog.xml to s3 bucket " + bucketName)
+				_failedMetas := s3Client.UploadMetadatas(archetypeFiles, t, "", topLevel)
+				failedMetas = append(failedMetas, _failedMetas...)
+				tReport.GeneratedMetadata = append(tReport.GeneratedMetadata, reportPaths(archetypeFiles, topLevel)...)
+				logger.Info(fmt.Sprintf("archetype-catalog.xml updating done in bucket %s\n", bucketName))
+			}
+			if archetypeAction != 0 && cfEnable {
+				cfInvalidatePaths = append(cfInvalidatePaths, archetypeFiles...)
+			}
+		}
+
+		if doIndex {
+			logger.Info("Start generating index files for all changed entries in bucket " + bucketName)
+			start = time.Now()
+			createdIndex := generateIndexes(*s3Client, scannedPaths.dirs,
+				PACKAGE_TYPE_MAVEN, topLevel, bucketName, prefix, t.IndexJson)
+			logger.Info("Index files generation done.\n")
+			logger.Info("Start updating index to s3 bucket " + bucketName)
+			_failedMetas := s3Client.UploadMetadatas(createdIndex, t, "", topLevel)
+			failedMetas = append(failedMetas, _failedMetas...)
+			tReport.Indexes = reportPaths(createdIndex, topLevel)
+			tReport.timed("index", start)
+			logger.Info("Index files updating done.\n")
+		} else {
+			logger.Info("Bypassing indexing")
+		}
+
+		// Finally do the CF invalidating for metadata files
 		if cfEnable && len(cfInvalidatePaths) > 0 {
-			cfClient, err := storage.NewCFClient(awsProfile)
+			cfClient, err := storage.NewCFClient(ctx, awsProfile)
 			if err != nil {
 				logger.Error(
 					fmt.Sprintf("Cannot do Cloudfront cache invalidating due to error: %s", err))
 			} else {
+				start = time.Now()
 				cfInvalidatePaths = wildcardMetadataPaths(cfInvalidatePaths)
-				invalidateCFPaths(cfClient, t, cfInvalidatePaths, root, storage.INVALIDATION_BATCH_DEFAULT)
+				tReport.setCFInvalidations(invalidateCFPaths(
+					cfClient, t, cfInvalidatePaths, topLevel, storage.INVALIDATION_BATCH_DEFAULT))
+				errs.SetRetries(retryClientCF(t.Bucket), cfClient.RetryCounts())
+				tReport.timed("cf", start)
 			}
 		}
 
-		uploadPostProcess(failedFiles, failedMetas, prodKey, bucketName)
-		succeeded = succeeded && len(failedFiles) <= 0 && len(failedMetas) <= 0
+		rollbackPostProcess(errs, s3Client, failedFiles, failedMetas, prodKey, bucketName)
+		report.Retries = errs.Retries()
+		tReport.setFailedMetadata(s3Client, failedMetas, topLevel)
+		tReport.Success = len(failedFiles) == 0 && len(failedMetas) == 0
+		succeeded = succeeded && tReport.Success
 	}
 
 	return tmpRoot, succeeded
@@ -401,6 +882,10 @@ func trimRoot(fullPath, root string) string {
 func parseGAVs(pomPaths []string, root string) map[string]map[string][]string {
 	gavs := make(map[string]map[string][]string)
 	for _, pom := range pomPaths {
+		if !isGAVPath(pom, root) {
+			logger.Warn(fmt.Sprintf("Skipping pom %s which is not in a GAV path", pom))
+			continue
+		}
 		gav := parseGAV(pom, root)
 		g := gav[0]
 		a := gav[1]
@@ -436,7 +921,47 @@ func genMetaFile(groupId, artifactId string,
 	gPath := strings.Join(strings.Split(groupId, "."), "/")
 	metaFiles := []string{}
 	finalMetaPath := path.Join(fixedRoot, gPath, artifactId, MAVEN_METADATA_FILE)
-	files.StoreFile(finalMetaPath, content, true)
+	if err := files.StoreFile(finalMetaPath, content, true); err != nil {
+		return []string{}, err
+	}
+	metaFiles = append(metaFiles, finalMetaPath)
+	if digest {
+		metaFiles = append(metaFiles, genAllDigestFiles(finalMetaPath)...)
+	}
+	return metaFiles, nil
+}
+
+// Generate the group level maven-metadata.xml which holds the plugin prefixes
+// for the group. The versioning of the existing metadata is kept, as the group
+// path may also be the GA path of another artifact.
+func genPluginMetaFile(groupId string, plugins []MavenPlugin, existing *groupMetadata,
+	root string, digest bool) ([]string, error) {
+	fixedRoot := fixRoot(root)
+	sorted := make([]MavenPlugin, len(plugins))
+	copy(sorted, plugins)
+	slices.SortFunc(sorted, func(p1, p2 MavenPlugin) int {
+		return strings.Compare(p1.ArtifactId, p2.ArtifactId)
+	})
+	meta := &MavenMetadata{Plugins: sorted}
+	if existing != nil && len(existing.Versions) > 0 {
+		meta.GroupId = existing.GroupId
+		meta.ArtifactId = existing.ArtifactId
+		meta.LastUpdateTime = existing.LastUpdated
+		meta.versions = existing.Versions
+		meta.latestVersion = existing.Latest
+		meta.releaseVersion = existing.Release
+	}
+	content, err := meta.GenerateMetaFileContent()
+	if err != nil {
+		return []string{}, err
+	}
+
+	gPath := strings.Join(strings.Split(groupId, "."), "/")
+	metaFiles := []string{}
+	finalMetaPath := path.Join(fixedRoot, gPath, MAVEN_METADATA_FILE)
+	if err := files.StoreFile(finalMetaPath, content, true); err != nil {
+		return []string{}, err
+	}
 	metaFiles = append(metaFiles, finalMetaPath)
 	if digest {
 		metaFiles = append(metaFiles, genAllDigestFiles(finalMetaPath)...)
@@ -444,6 +969,82 @@ func genMetaFile(groupId, artifactId string,
 	return metaFiles, nil
 }
 
+// Scan the jars along with the poms to find maven plugins. The result will be
+// a map like {groupId: [plugins]}
+func scanForPlugins(poms []string) map[string][]MavenPlugin {
+	plugins := make(map[string][]MavenPlugin)
+	for _, pom := range poms {
+		jar := strings.TrimSuffix(pom, ".pom") + ".jar"
+		if !files.IsFile(jar) {
+			continue
+		}
+		descriptor, err := readPluginDescriptor(jar)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Can not read plugin descriptor from %s: %s", jar, err))
+			continue
+		}
+		if descriptor == nil || util.IsBlankString(descriptor.GoalPrefix) {
+			continue
+		}
+		logger.Debug(fmt.Sprintf("Found maven plugin %s:%s with prefix %s",
+			descriptor.GroupId, descriptor.ArtifactId, descriptor.GoalPrefix))
+		plugins[descriptor.GroupId] = append(plugins[descriptor.GroupId], MavenPlugin{
+			Name:       descriptor.Name,
+			Prefix:     descriptor.GoalPrefix,
+			ArtifactId: descriptor.ArtifactId,
+		})
+	}
+	return plugins
+}
+
+// Read the plugin descriptor from a jar. Will return nil if the jar is not a
+// maven plugin.
+func readPluginDescriptor(jar string) (*pluginDescriptor, error) {
+	r, err := zip.OpenReader(jar)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name != MAVEN_PLUGIN_FILE {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		descriptor := &pluginDescriptor{}
+		if err := xml.NewDecoder(rc).Decode(descriptor); err != nil {
+			return nil, err
+		}
+		return descriptor, nil
+	}
+	return nil, nil
+}
+
+// The content of a group level maven-metadata.xml. Besides the plugins, it
+// may hold the versioning of an artifact whose GA path is the group path.
+type groupMetadata struct {
+	GroupId     string        `xml:"groupId"`
+	ArtifactId  string        `xml:"artifactId"`
+	Latest      string        `xml:"versioning>latest"`
+	Release     string        `xml:"versioning>release"`
+	Versions    []string      `xml:"versioning>versions>version"`
+	LastUpdated string        `xml:"versioning>lastUpdated"`
+	Plugins     []MavenPlugin `xml:"plugins>plugin"`
+}
+
+func parseGroupMetadata(metaXmlContent string) (*groupMetadata, error) {
+	meta := &groupMetadata{}
+	err := xml.Unmarshal([]byte(metaXmlContent), meta)
+	if err != nil {
+		logger.Error("Can not parse group metadata file " + metaXmlContent)
+		return nil, err
+	}
+	return meta, nil
+}
+
 func genAllDigestFiles(metaFilePath string) []string {
 	md5Path := metaFilePath + ".md5"
 	sha1Path := metaFilePath + ".sha1"
@@ -464,7 +1065,10 @@ func genAllDigestFiles(metaFilePath string) []string {
 func genDigestFile(hashFilePath, metaFilePath string, hashType crypto.Hash) bool {
 	digestContent := files.Digest(metaFilePath, hashType)
 	if digestContent != "" {
-		files.StoreFile(hashFilePath, digestContent, true)
+		if err := files.StoreFile(hashFilePath, digestContent, true); err != nil {
+			logger.Warn(fmt.Sprintf("Error: Can not create digest file %s due to error: %s", hashFilePath, err))
+			return false
+		}
 	} else {
 		logger.Warn(
 			fmt.Sprintf("Error: Can not create digest file %s for %s because of some missing folders",
@@ -474,6 +1078,61 @@ func genDigestFile(hashFilePath, metaFilePath string, hashType crypto.Hash) bool
 	return true
 }
 
+// Generate the missing digest files (.md5, .sha1, .sha256 and .sha512) for all
+// the artifacts. The digest files which already exist will be verified with
+// the artifact content instead of being trusted. The hashing is done in
+// parallel with at most conLimit files at the same time.
+//
+// Returns the generated digest files, and the existing digest files which
+// do not match their artifacts.
+func genMissingDigestFiles(paths []string, conLimit int) ([]string, []string) {
+	pathSet := toSet(paths)
+	hashTypes := []crypto.Hash{files.MD5, files.SHA1, files.SHA256, files.SHA512}
+	var mu sync.Mutex
+	generated := []string{}
+	mismatched := []string{}
+	g := new(errgroup.Group)
+	g.SetLimit(max(conLimit, 1))
+	for _, p := range paths {
+		if isVerificationFile(p) || IsMetadata(p) {
+			continue
+		}
+		p := p
+		g.Go(func() error {
+			digests := files.DigestAll(p, hashTypes...)
+			if len(digests) == 0 {
+				logger.Warn(fmt.Sprintf("Can not digest file %s, will not generate its digest files", p))
+				return nil
+			}
+			for _, h := range hashTypes {
+				digestFile := p + files.DIGEST_SUFFIXES[h]
+				if pathSet[digestFile] {
+					existed, err := files.ReadDigestFile(digestFile)
+					if err != nil || existed != digests[h] {
+						logger.Error(fmt.Sprintf("Digest file %s does not match the content of %s", digestFile, p))
+						mu.Lock()
+						mismatched = append(mismatched, digestFile)
+						mu.Unlock()
+					}
+					continue
+				}
+				if err := files.StoreFile(digestFile, digests[h], true); err != nil {
+					logger.Warn(fmt.Sprintf("Can not create digest file %s due to error: %s", digestFile, err))
+					continue
+				}
+				mu.Lock()
+				generated = append(generated, digestFile)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	slices.Sort(generated)
+	slices.Sort(mismatched)
+	return generated, mismatched
+}
+
 func fixRoot(root string) string {
 	slashRoot := strings.TrimSpace(root)
 	if slashRoot == "" {
@@ -534,26 +1193,29 @@ func hashDecorateMetadata(fPath, metadata string) []string {
 	return hashes
 }
 
-func extractTarball(repo, prefix, dir_ string) string {
-	if files.FileOrDirExists(repo) {
-		logger.Info(fmt.Sprintf("Extracting tarball: %s", repo))
-		tmpRoot, err := os.MkdirTemp(dir_, fmt.Sprintf("charon-%s-*", prefix))
-		if err != nil {
-			panic(err)
-		}
-		err = archive.ExtractZipAll(repo, tmpRoot)
-		if err != nil {
-			panic(err)
-		}
-		return tmpRoot
+// Extract the tarball to a new temp dir under dir_. Returns the temp dir,
+// which is also returned with the error if the extracting failed after it
+// is created.
+func extractTarball(repo, prefix, dir_ string) (string, error) {
+	if !files.FileOrDirExists(repo) {
+		return "", fmt.Errorf("archive %s does not exist", repo)
+	}
+	logger.Info(fmt.Sprintf("Extracting tarball: %s", repo))
+	tmpRoot, err := os.MkdirTemp(dir_, fmt.Sprintf("charon-%s-*", prefix))
+	if err != nil {
+		return "", err
+	}
+	if err = archive.ExtractZipAll(repo, tmpRoot); err != nil {
+		return tmpRoot, fmt.Errorf("can not extract archive %s: %w", repo, err)
 	}
-	panic(fmt.Errorf("error: archive %s does not exist", repo))
+	return tmpRoot, nil
 }
 
 type scannedPaths struct {
 	topLevel string
 	mvnPaths []string
 	poms     []string
+	modules  []string
 	dirs     []string
 }
 
@@ -571,6 +1233,8 @@ func (s scannedPaths) String() string {
 	appendLines(s.mvnPaths)
 	sb.WriteString("Pom paths:\n")
 	appendLines(s.poms)
+	sb.WriteString("Gradle module paths:\n")
+	appendLines(s.modules)
 	sb.WriteString("Dirs:\n")
 	appendLines(s.dirs)
 
@@ -578,7 +1242,8 @@ func (s scannedPaths) String() string {
 }
 
 // scan for paths and filter out the ignored paths,
-// and also collect poms for later metadata generation
+// and also collect poms for later metadata generation, and gradle
+// modules for later validation
 func scanPaths(ignorePatterns []string, filesRoot, root string) scannedPaths {
 	logger.Info(fmt.Sprintf("Scan %s to collect files", filesRoot))
 	topLevel := root
@@ -586,6 +1251,7 @@ func scanPaths(ignorePatterns []string, filesRoot, root string) scannedPaths {
 	nonMvnPaths := []string{}
 	ignoredPaths := []string{}
 	validPoms := []string{}
+	validModules := []string{}
 	validDirs := []string{}
 	changedDirs := make(map[string]bool)
 	topFound := false
@@ -616,6 +1282,9 @@ func scanPaths(ignorePatterns []string, filesRoot, root string) scannedPaths {
 					if filepath.Ext(fName) == ".pom" {
 						validPoms = append(validPoms, p)
 					}
+					if isGradleModule(fName) {
+						validModules = append(validModules, p)
+					}
 				}
 			} else {
 				nonMvnPaths = append(nonMvnPaths, p)
@@ -658,6 +1327,7 @@ func scanPaths(ignorePatterns []string, filesRoot, root string) scannedPaths {
 		topLevel: topLevel,
 		mvnPaths: validMvnPaths,
 		poms:     validPoms,
+		modules:  validModules,
 		dirs:     validDirs,
 	}
 }
@@ -672,7 +1342,7 @@ func generateMetadatas(s3 storage.S3Client, poms []string,
 	bucket, prefix, root string) map[string][]string {
 	gaMap := make(map[string]bool)
 	logger.Debug(fmt.Sprintf("Valid poms: %s", poms))
-	validGAVsMap := parseGAVs(poms, root)
+	validGAVsMap := parseLocalGAVs(poms, root)
 	for g, avs := range validGAVsMap {
 		for a := range avs {
 			logger.Debug(fmt.Sprintf("G: %s, A: %s", g, a))
@@ -684,6 +1354,7 @@ func generateMetadatas(s3 storage.S3Client, poms []string,
 	// have already been uploaded to s3 before calling this function
 	allPoms := []string{}
 	metaFiles := make(map[string][]string)
+	removedGAs := make(map[string]bool)
 	for p := range gaMap {
 		// avoid some wrong prefix, like searching org/apache
 		// but got org/apache-commons
@@ -700,6 +1371,7 @@ func generateMetadatas(s3 storage.S3Client, poms []string,
 				logger.Debug(
 					fmt.Sprintf("No poms found in s3 bucket %s for GA path %s",
 						bucket, p))
+				removedGAs[p] = true
 				metaFilesDeletion, ok := metaFiles[META_FILE_DEL_KEY]
 				if !ok {
 					metaFilesDeletion = []string{}
@@ -757,15 +1429,113 @@ func generateMetadatas(s3 storage.S3Client, poms []string,
 		}
 		metaFiles[META_FILE_GEN_KEY] = metaFilesGen
 	}
+	generatePluginMetadatas(s3, scanForPlugins(poms), removedGAs, metaFiles,
+		bucket, prefix, root)
 	return metaFiles
 }
 
+// Generate the group level maven-metadata.xml for the groups which contain
+// maven plugins. The plugins found in the local repo will be merged with the
+// ones already recorded in the bucket. The plugins whose GA has no poms left in
+// the bucket (like in rollback) will be removed, and if no plugins are left for
+// a group, its group level metadata will be deleted.
+func generatePluginMetadatas(s3 storage.S3Client, localPlugins map[string][]MavenPlugin,
+	removedGAs map[string]bool, metaFiles map[string][]string,
+	bucket, prefix, root string) {
+	for g, plugins := range localPlugins {
+		gPath := strings.Join(strings.Split(g, "."), "/")
+		metaPath := path.Join(gPath, MAVEN_METADATA_FILE)
+		remote := metaPath
+		if !util.IsBlankString(prefix) {
+			remote = path.Join(prefix, metaPath)
+		}
+		existed, err := s3.FileExistsInBucket(bucket, remote)
+		if err != nil {
+			logger.Warn(
+				fmt.Sprintf("An error happened when checking group metadata %s in bucket %s: %s",
+					remote, bucket, err))
+			metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED], metaPath)
+			continue
+		}
+		merged := make(map[string]MavenPlugin)
+		var existing *groupMetadata
+		if existed {
+			content, err := s3.ReadFileContent(bucket, remote)
+			if err != nil {
+				metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED], metaPath)
+				continue
+			}
+			existing, err = parseGroupMetadata(content)
+			if err != nil {
+				logger.Warn(
+					fmt.Sprintf("Failed to parse group metadata %s from bucket %s, will overwrite it.",
+						remote, bucket))
+			} else {
+				for _, p := range existing.Plugins {
+					merged[p.ArtifactId] = p
+				}
+			}
+		}
+		// The GA metadata generated for the same path in this run has the
+		// latest versioning, which should not be overwritten by the plugins
+		localMeta := path.Join(fixRoot(root), metaPath)
+		if slices.Contains(metaFiles[META_FILE_GEN_KEY], localMeta) {
+			content, err := files.ReadFile(localMeta)
+			if err == nil {
+				existing, err = parseGroupMetadata(content)
+			}
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Failed to read the generated metadata %s: %s", localMeta, err))
+				metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED], metaPath)
+				continue
+			}
+		}
+		versioned := existing != nil && len(existing.Versions) > 0
+		for _, p := range plugins {
+			merged[p.ArtifactId] = p
+		}
+		hadPlugins := len(merged) > 0
+		for a := range merged {
+			if removedGAs[path.Join(gPath, a)] {
+				logger.Debug(fmt.Sprintf("Removing plugin %s:%s from group metadata", g, a))
+				delete(merged, a)
+			}
+		}
+		if len(merged) == 0 && (!versioned || !hadPlugins) {
+			// The metadata in the group path without plugins may be a GA
+			// metadata of another artifact, so it should not be touched
+			if existed && hadPlugins {
+				metaFiles[META_FILE_DEL_KEY] = append(metaFiles[META_FILE_DEL_KEY], metaPath)
+				metaFiles[META_FILE_DEL_KEY] = append(metaFiles[META_FILE_DEL_KEY],
+					hashDecorateMetadata(gPath, MAVEN_METADATA_FILE)...)
+			}
+			continue
+		}
+		allPlugins := []MavenPlugin{}
+		for _, p := range merged {
+			allPlugins = append(allPlugins, p)
+		}
+		metas, err := genPluginMetaFile(g, allPlugins, existing, root, true)
+		if err != nil {
+			logger.Warn(
+				fmt.Sprintf("Failed to create or update group metadata file for group %s", g))
+			metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED], metaPath)
+		} else {
+			for _, m := range metas {
+				if !slices.Contains(metaFiles[META_FILE_GEN_KEY], m) {
+					metaFiles[META_FILE_GEN_KEY] = append(metaFiles[META_FILE_GEN_KEY], m)
+				}
+			}
+		}
+	}
+}
+
 // Determine whether the local archive contains /archetype-catalog.xml
 // in the repo contents.
 //
 // If so, determine whether the archetype-catalog.xml is already
-// available in the bucket. Merge (or unmerge) these catalogs and
-// return a boolean indicating whether the local file should be uploaded.
+// available in the bucket. Merge these catalogs and return a boolean
+// indicating whether the local file should be uploaded.
 func generateUploadArchetypeCatalog(s3 *storage.S3Client,
 	bucket, root, prefix string) bool {
 	remote := MAVEN_ARCH_FILE
@@ -773,20 +1543,10 @@ func generateUploadArchetypeCatalog(s3 *storage.S3Client,
 		remote = path.Join(prefix, MAVEN_ARCH_FILE)
 	}
 	local := path.Join(root, MAVEN_ARCH_FILE)
-	//  As the local archetype will be overwrittern later, we must keep
-	//  a cache of the original local for multi-targets support
-	localBak := path.Join(root, MAVEN_ARCH_FILE+".charon.bak")
-	if files.FileOrDirExists(local) && !files.FileOrDirExists(localBak) {
-		content, err := files.ReadFile(local)
-		if err != nil {
-			logger.Warn("Can not open file: " + local)
-		} else {
-			files.StoreFile(localBak, content, true)
-		}
-	}
+	localBak, ok := backupLocalArchetypeCatalog(root)
 
 	// If there is no local catalog, this is a NO-OP
-	if files.FileOrDirExists(localBak) {
+	if ok {
 		existed, err := s3.FileExistsInBucket(bucket, remote)
 		if err != nil {
 			logger.Error(
@@ -798,20 +1558,13 @@ func generateUploadArchetypeCatalog(s3 *storage.S3Client,
 			// If there is no catalog in the bucket, just upload what we have locally
 			return true
 		} else {
-			content, err := files.ReadFile(local)
+			localArchetypes, err := readArchetypes(localBak)
 			if err != nil {
 				logger.Warn(
 					fmt.Sprintf("Failed to parse archetype-catalog.xml from local archive with root: %s "+
 						"becuase of error: %s. SKIPPING invalid archetype processing.", root, err))
 				return false
 			}
-			localArchetypes, err := parseArchetypes(content)
-			if err != nil {
-				logger.Warn(
-					fmt.Sprintf("Failed to parse archetype-catalog.xml from local archive with root: %s. "+
-						"SKIPPING invalid archetype processing.", root))
-				return false
-			}
 			if len(localArchetypes) < 1 {
 				logger.Warn("No archetypes found in local archetype-catalog.xml, " +
 					"even though the file exists! Skipping.")
@@ -821,12 +1574,14 @@ func generateUploadArchetypeCatalog(s3 *storage.S3Client,
 				if err != nil {
 					logger.Warn(fmt.Sprintf("Failed to get archetype-catalog.xml from bucket: %s. "+
 						"OVERWRITING bucket archetype-catalog.xml with the valid, local copy.", bucket))
+					genAllDigestFiles(local)
 					return true
 				}
 				remoteArchetypes, err := parseArchetypes(remoteXml)
 				if err != nil {
 					logger.Warn(fmt.Sprintf("Failed to get archetype-catalog.xml from bucket: %s. "+
 						"OVERWRITING bucket archetype-catalog.xml with the valid, local copy.", bucket))
+					genAllDigestFiles(local)
 					return true
 				}
 				if len(remoteArchetypes) == 0 {
@@ -834,39 +1589,15 @@ func generateUploadArchetypeCatalog(s3 *storage.S3Client,
 					// Nothing in the bucket. Just push what we have locally.
 					return true
 				} else {
-					originalRemoteSize := len(remoteArchetypes)
-					for _, la := range localArchetypes {
-						// The cautious approach in this operation contradicts
-						// assumptions we make for the rollback case.
-						// That's because we should NEVER encounter a collision
-						// on archetype GAV...they should belong with specific
-						// product releases.
-						// Still, we will WARN, not ERROR if we encounter this.
-						if !slices.Contains(localArchetypes, la) {
-							remoteArchetypes = append(remoteArchetypes, la)
-						} else {
-							logger.Warn(fmt.Sprintf("\n\n\nDUPLICATE ARCHETYPE: %s. "+
-								"This makes rollback of the current release UNSAFE!\n\n\n", la))
-						}
-					}
-					if len(remoteArchetypes) != originalRemoteSize {
+					merged := mergeArchetypes(remoteArchetypes, localArchetypes)
+					if len(merged) != len(remoteArchetypes) {
 						// If the number of archetypes in the version of
 						// the file from the bucket has changed, we need
 						// to regenerate the file and re-upload it.
 						//
-						// Re-render the result of our archetype merge /
-						// un-merge to the local file, in preparation for
-						// upload.
-						arch := NewMavenArchetypeCatalog(remoteArchetypes)
-						content, err = arch.GenerateMetaFileContent()
-						if err != nil {
-							logger.Error(fmt.Sprintf(
-								"Error: Can not create file %s because of some missing folders", local))
-							return false
-						}
-						files.StoreFile(local, content, true)
-						genAllDigestFiles(local)
-						return true
+						// Re-render the result of our archetype merge
+						// to the local file, in preparation for upload.
+						return writeArchetypeCatalog(local, merged)
 					}
 				}
 			}
@@ -876,8 +1607,176 @@ func generateUploadArchetypeCatalog(s3 *storage.S3Client,
 	return false
 }
 
+// Determine whether the local archive contains /archetype-catalog.xml
+// in the repo contents.
+//
+// If so, determine whether the archetype-catalog.xml is already
+// available in the bucket. Un-merge the local archetypes from the bucket
+// catalog and return an integer, indicating whether the bucket file should
+// be replaced (+1), deleted (-1), or, in the case where no action is
+// required, it will return NO-OP (0).
+//
+// NOTE: There are three return values:
+//   - +1 - UPLOAD the local catalog with its rolled back changes
+//   - -1 - DELETE the (now empty) bucket catalog
+//   - 0 - take no action
+func generateRollbackArchetypeCatalog(s3 *storage.S3Client,
+	bucket, root, prefix string) int {
+	remote := MAVEN_ARCH_FILE
+	if !util.IsBlankString(prefix) {
+		remote = path.Join(prefix, MAVEN_ARCH_FILE)
+	}
+	local := path.Join(root, MAVEN_ARCH_FILE)
+	localBak, ok := backupLocalArchetypeCatalog(root)
+
+	// If there is no local catalog, this is a NO-OP
+	if !ok {
+		return 0
+	}
+	existed, err := s3.FileExistsInBucket(bucket, remote)
+	if err != nil {
+		logger.Error(
+			"Error: Can not generate archtype-catalog.xml due to: " + err.Error())
+		return 0
+	}
+	// If there is no catalog in the bucket, there is nothing to roll back
+	if !existed {
+		return 0
+	}
+	localArchetypes, err := readArchetypes(localBak)
+	if err != nil {
+		logger.Warn(
+			fmt.Sprintf("Failed to parse archetype-catalog.xml from local archive with root: %s "+
+				"becuase of error: %s. SKIPPING invalid archetype processing.", root, err))
+		return 0
+	}
+	if len(localArchetypes) < 1 {
+		logger.Warn("No archetypes found in local archetype-catalog.xml, " +
+			"even though the file exists! Skipping.")
+		return 0
+	}
+	// Read the archetypes from the bucket so we can do a merge / un-merge
+	remoteXml, err := s3.ReadFileContent(bucket, remote)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to get archetype-catalog.xml from bucket: %s. "+
+			"NOT ROLLING BACK the bucket archetype-catalog.xml.", bucket))
+		return 0
+	}
+	remoteArchetypes, err := parseArchetypes(remoteXml)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to parse archetype-catalog.xml from bucket: %s. "+
+			"NOT ROLLING BACK the bucket archetype-catalog.xml.", bucket))
+		return 0
+	}
+	if len(remoteArchetypes) < 1 {
+		// Nothing in the bucket, so the empty catalog should be deleted
+		return -1
+	}
+	remaining := unmergeArchetypes(remoteArchetypes, localArchetypes)
+	if len(remaining) < 1 {
+		return -1
+	} else if len(remaining) < len(remoteArchetypes) {
+		// Re-render the result of our archetype un-merge to the
+		// local file, in preparation for upload.
+		if writeArchetypeCatalog(local, remaining) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// As the local archetype will be overwrittern later, we must keep a cache
+// of the original local for multi-targets support. The local catalog will
+// also be restored from the cache, so that each target starts from the
+// original local catalog.
+//
+// Returns the path of the cache, and false if there is no local catalog.
+func backupLocalArchetypeCatalog(root string) (string, bool) {
+	local := path.Join(root, MAVEN_ARCH_FILE)
+	localBak := path.Join(root, MAVEN_ARCH_FILE+".charon.bak")
+	if files.FileOrDirExists(local) && !files.FileOrDirExists(localBak) {
+		content, err := files.ReadFile(local)
+		if err != nil {
+			logger.Warn("Can not open file: " + local)
+		} else if err := files.StoreFile(localBak, content, true); err != nil {
+			logger.Warn(fmt.Sprintf("Can not write file %s due to error: %s", localBak, err))
+		}
+	}
+	if !files.FileOrDirExists(localBak) {
+		return localBak, false
+	}
+	content, err := files.ReadFile(localBak)
+	if err != nil {
+		logger.Warn("Can not open file: " + localBak)
+		return localBak, false
+	}
+	if err := files.StoreFile(local, content, true); err != nil {
+		logger.Warn(fmt.Sprintf("Can not write file %s due to error: %s", local, err))
+		return localBak, false
+	}
+	return localBak, true
+}
+
+func readArchetypes(catalogFile string) ([]ArchetypeRef, error) {
+	content, err := files.ReadFile(catalogFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseArchetypes(content)
+}
+
+func writeArchetypeCatalog(local string, archetypes []ArchetypeRef) bool {
+	arch := NewMavenArchetypeCatalog(archetypes)
+	content, err := arch.GenerateMetaFileContent()
+	if err != nil {
+		logger.Error(fmt.Sprintf(
+			"Error: Can not create file %s because of some missing folders", local))
+		return false
+	}
+	if err := files.StoreFile(local, content, true); err != nil {
+		logger.Error(fmt.Sprintf("Error: Can not write file %s due to error: %s", local, err))
+		return false
+	}
+	genAllDigestFiles(local)
+	return true
+}
+
+// Merge the local archetypes into the remote ones, keyed on their GAVs.
+func mergeArchetypes(remote, local []ArchetypeRef) []ArchetypeRef {
+	merged := make([]ArchetypeRef, len(remote))
+	copy(merged, remote)
+	for _, la := range local {
+		// The cautious approach in this operation contradicts
+		// assumptions we make for the rollback case.
+		// That's because we should NEVER encounter a collision
+		// on archetype GAV...they should belong with specific
+		// product releases.
+		// Still, we will WARN, not ERROR if we encounter this.
+		if slices.ContainsFunc(merged, la.sameGAV) {
+			logger.Warn(fmt.Sprintf("\n\n\nDUPLICATE ARCHETYPE: %s. "+
+				"This makes rollback of the current release UNSAFE!\n\n\n", la))
+		} else {
+			merged = append(merged, la)
+		}
+	}
+	return merged
+}
+
+// Remove the local archetypes from the remote ones, keyed on their GAVs.
+func unmergeArchetypes(remote, local []ArchetypeRef) []ArchetypeRef {
+	remaining := []ArchetypeRef{}
+	for _, ra := range remote {
+		if !slices.ContainsFunc(local, ra.sameGAV) {
+			remaining = append(remaining, ra)
+		}
+	}
+	return remaining
+}
+
 func parseArchetypes(archXmlContent string) ([]ArchetypeRef, error) {
-	archCatalog := MavenArchetypeCatalog{}
+	archCatalog := struct {
+		Archetypes []ArchetypeRef `xml:"archetypes>archetype"`
+	}{}
 	err := xml.Unmarshal([]byte(archXmlContent), &archCatalog)
 	if err != nil {
 		logger.Error("Can not parse archetype-catalog file " + archXmlContent)
@@ -886,14 +1785,47 @@ func parseArchetypes(archXmlContent string) ([]ArchetypeRef, error) {
 	return archCatalog.Archetypes, nil
 }
 
-func validateMaven(paths []string) ([]string, bool) {
-	// Reminder: need to implement later
-	logger.Debug(fmt.Sprintf("Need to validate mvn paths: %s", paths))
-	return []string{}, true
+// Validate the maven paths with the rules, which is a map of rule name to its
+// severity. Returns all messages reported by the rules, and if the validation
+// passed, which means no rule with error severity is broken. A rule which is
+// not registered is reported as an error, as it is mostly a typo in the
+// validation_rules of a target.
+func validateMaven(paths []string, root, prodKey string, rules map[string]string) ([]ValidationMessage, bool) {
+	logger.Debug(fmt.Sprintf("Validating mvn paths with rules: %s", rules))
+	msgs := []ValidationMessage{}
+	passed := true
+	names := []string{}
+	for name := range rules {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		rule, ok := validationRules[name]
+		if !ok {
+			msgs = append(msgs, ValidationMessage{Rule: name, Severity: config.SEVERITY_ERROR,
+				Message: fmt.Sprintf("validation rule %s is not a known rule", name)})
+			passed = false
+			continue
+		}
+		severity := rules[name]
+		for _, m := range rule.Validate(paths, root, prodKey) {
+			msgs = append(msgs, ValidationMessage{Rule: name, Severity: severity, Message: m})
+			if severity == config.SEVERITY_ERROR {
+				passed = false
+			}
+		}
+	}
+	return msgs, passed
 }
 
-func handleError(errMsgs []string) {
-	// Reminder: will implement later
+func handleError(msgs []ValidationMessage) {
+	for _, msg := range msgs {
+		if msg.Severity == config.SEVERITY_ERROR {
+			logger.Error(fmt.Sprintf("Validation error: %s", msg))
+		} else {
+			logger.Warn(fmt.Sprintf("Validation warning: %s", msg))
+		}
+	}
 }
 
 func versionCompare(ver1, ver2 string) int {
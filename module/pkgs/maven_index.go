@@ -0,0 +1,679 @@
+package pkgs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/sync/errgroup"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+	"org.commonjava/charon/module/util/files"
+)
+
+const (
+	MAVEN_INDEX_DIR        = ".index"
+	MAVEN_INDEX_FILE       = "nexus-maven-repository-index.gz"
+	MAVEN_INDEX_PROPS_FILE = "nexus-maven-repository-index.properties"
+	// The max number of incremental chunks kept in the properties
+	MAVEN_INDEX_MAX_CHUNKS = 30
+
+	mavenIndexVersion        = 1
+	mavenIndexTimestampFmt   = "20060102150405.000 -0700"
+	mavenIndexFieldSeparator = "|"
+	mavenIndexNotAvailable   = "NA"
+
+	// The flags of index fields, same as the lucene document fields in maven indexer
+	mavenIndexFlagIndexed   = 1
+	mavenIndexFlagTokenized = 2
+	mavenIndexFlagStored    = 4
+)
+
+// This MavenIndexRecord represents an artifact record in maven indexer index,
+// which is a lucene document in maven indexer.
+type MavenIndexRecord struct {
+	GroupId         string
+	ArtifactId      string
+	Version         string
+	Classifier      string
+	Extension       string
+	Packaging       string
+	Sha1            string
+	Size            int64
+	LastModified    time.Time
+	SourcesExists   bool
+	JavadocExists   bool
+	SignatureExists bool
+	isMainArtifact  bool
+}
+
+// The "u" field, like groupId|artifactId|version|classifier|extension
+func (r MavenIndexRecord) uinfo() string {
+	classifier := r.Classifier
+	if util.IsBlankString(classifier) {
+		classifier = mavenIndexNotAvailable
+	}
+	uinfo := strings.Join([]string{r.GroupId, r.ArtifactId, r.Version, classifier}, mavenIndexFieldSeparator)
+	if !util.IsBlankString(r.Classifier) {
+		uinfo += mavenIndexFieldSeparator + r.Extension
+	}
+	return uinfo
+}
+
+// The "i" field, like packaging|lastModified|size|sourcesExists|javadocExists|signatureExists|extension
+func (r MavenIndexRecord) info() string {
+	availability := func(exists bool) string {
+		if exists {
+			return "1"
+		}
+		return "0"
+	}
+	packaging := r.Packaging
+	if util.IsBlankString(packaging) {
+		packaging = mavenIndexNotAvailable
+	}
+	return strings.Join([]string{
+		packaging,
+		strconv.FormatInt(r.LastModified.UnixMilli(), 10),
+		strconv.FormatInt(r.Size, 10),
+		availability(r.SourcesExists),
+		availability(r.JavadocExists),
+		availability(r.SignatureExists),
+		r.Extension,
+	}, mavenIndexFieldSeparator)
+}
+
+type mavenIndexField struct {
+	flags int
+	name  string
+	value string
+}
+
+func (r MavenIndexRecord) fields() []mavenIndexField {
+	fields := []mavenIndexField{
+		{mavenIndexFlagIndexed | mavenIndexFlagStored, "u", r.uinfo()},
+		{mavenIndexFlagStored, "m", strconv.FormatInt(r.LastModified.UnixMilli(), 10)},
+		{mavenIndexFlagStored, "i", r.info()},
+	}
+	if !util.IsBlankString(r.Sha1) {
+		fields = append(fields, mavenIndexField{mavenIndexFlagIndexed | mavenIndexFlagStored, "1", r.Sha1})
+	}
+	return fields
+}
+
+// Handle the maven indexer index exporting for the targets. All the artifacts
+// in the targets will be walked to generate the full index, and the artifacts
+// changed since the last exporting will be written as a new incremental chunk.
+//   - targets contains the target name with its bucket name and prefix
+//   - repoId is the repository id recorded in the index
+//   - dir_ is base dir for holding the index files, will use system
+//     tmp dir if empty.
+//
+// Returns the directory used for index files and if the exporting is successful
+func HandleMavenIndexing(
+	ctx context.Context,
+	targets []config.Target,
+	repoId,
+	awsProfile,
+	dir_ string,
+	dryRun bool,
+) (string, bool) {
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return "", false
+	}
+	workDir, err := os.MkdirTemp(dir_, "charon-index-*")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create work dir for index due to error: %s", err))
+		return "", false
+	}
+	succeeded := true
+	for _, t := range targets {
+		if interrupted(ctx, "maven index generation for bucket "+t.Bucket) {
+			succeeded = false
+			continue
+		}
+		t = fixTarget(t)
+		root := path.Join(workDir, t.Bucket)
+		logger.Info("Start generating maven index for bucket " + t.Bucket)
+		indexFiles, staleFiles, ok := generateMavenIndex(*s3Client, t.Bucket, t.Prefix, repoId, root, time.Now())
+		if !ok {
+			logger.Error("Failed to generate maven index for bucket " + t.Bucket)
+			succeeded = false
+			continue
+		}
+		logger.Info("Maven index generation done\n")
+		logger.Info("Start uploading maven index to s3 bucket " + t.Bucket)
+		failedMetas := s3Client.UploadMetadatas(indexFiles, t, "", root)
+		logger.Info("Maven index uploading done\n")
+		if len(failedMetas) > 0 {
+			logger.Error(fmt.Sprintf("Failed to upload maven index files: \n%s\n", failedMetas))
+			succeeded = false
+			// Keep the old chunks as the properties referring to them may be the old ones
+			continue
+		}
+		for _, stale := range staleFiles {
+			if !s3Client.SimpleDeleteFile(stale, t) {
+				logger.Error(fmt.Sprintf("Failed to delete stale maven index file %s in bucket %s", stale, t.Bucket))
+				succeeded = false
+			}
+		}
+	}
+	return workDir, succeeded
+}
+
+// Generate the maven indexer index files for the bucket under the root dir.
+// Returns the generated files which need to be uploaded, and the keys of the
+// incremental chunks which are not referred by the new properties any more
+// and need to be deleted from the bucket.
+func generateMavenIndex(s3 storage.S3Client, bucket, prefix, repoId, root string,
+	now time.Time) ([]string, []string, bool) {
+	listPrefix := prefix
+	if !util.IsBlankString(listPrefix) && !strings.HasSuffix(listPrefix, "/") {
+		listPrefix += "/"
+	}
+	fileInfos, ok := s3.GetFileInfos(bucket, listPrefix, "")
+	if !ok {
+		return nil, nil, false
+	}
+	records := collectMavenIndexRecords(s3, bucket, listPrefix, fileInfos)
+	logger.Info(fmt.Sprintf("Collected %d artifact records in bucket %s", len(records), bucket))
+
+	indexDir := path.Join(root, MAVEN_INDEX_DIR)
+	props := map[string]string{}
+	remoteProps := path.Join(prefix, MAVEN_INDEX_DIR, MAVEN_INDEX_PROPS_FILE)
+	if existed, err := s3.FileExistsInBucket(bucket, remoteProps); err == nil && existed {
+		content, err := s3.ReadFileContent(bucket, remoteProps)
+		if err == nil {
+			props = parseProperties(content)
+		}
+	}
+
+	indexFiles := []string{}
+	fullIndex := path.Join(indexDir, MAVEN_INDEX_FILE)
+	if err := writeMavenIndexFile(fullIndex, repoId, records, nil, now); err != nil {
+		logger.Error(fmt.Sprintf("Can not write maven index %s: %s", fullIndex, err))
+		return nil, nil, false
+	}
+	indexFiles = append(indexFiles, fullIndex)
+	indexFiles = append(indexFiles, genAllDigestFiles(fullIndex)...)
+
+	// The incremental chunk contains the artifacts changed after the last
+	// exporting, and the deletions of the artifacts which are in the last
+	// full index but gone now. It's only generated when there is a previous
+	// exporting to be continued
+	chunks := []int{}
+	staleChunks := []int{}
+	lastIncremental := -1
+	if lastTimestamp, err := time.Parse(mavenIndexTimestampFmt, props["nexus.index.timestamp"]); err == nil {
+		if n, err := strconv.Atoi(props["nexus.index.last-incremental"]); err == nil {
+			lastIncremental = n
+		}
+		for i := 0; ; i++ {
+			n, err := strconv.Atoi(props[fmt.Sprintf("nexus.index.incremental-%d", i)])
+			if err != nil {
+				break
+			}
+			chunks = append(chunks, n)
+		}
+		remoteIndex := path.Join(prefix, MAVEN_INDEX_DIR, MAVEN_INDEX_FILE)
+		previous, err := readRemoteMavenIndexUinfos(s3, bucket, remoteIndex)
+		if err != nil {
+			// Without the last full index the deletions are unknown, so a new
+			// chain is started to let the clients download the full index
+			logger.Warn(fmt.Sprintf("Can not read the last maven index %s, will start a new index chain: %s",
+				remoteIndex, err))
+			staleChunks = chunks
+			chunks = []int{}
+			lastIncremental = -1
+		} else {
+			current := map[string]bool{}
+			changed := []MavenIndexRecord{}
+			for _, r := range records {
+				current[r.uinfo()] = true
+				if r.LastModified.After(lastTimestamp) {
+					changed = append(changed, r)
+				}
+			}
+			deleted := []string{}
+			for _, uinfo := range previous {
+				if !current[uinfo] {
+					deleted = append(deleted, uinfo)
+				}
+			}
+			if len(changed) > 0 || len(deleted) > 0 {
+				lastIncremental++
+				chunkFile := path.Join(indexDir, mavenIndexChunkFile(lastIncremental))
+				if err := writeMavenIndexFile(chunkFile, repoId, changed, deleted, now); err != nil {
+					logger.Error(fmt.Sprintf("Can not write maven index chunk %s: %s", chunkFile, err))
+					return nil, nil, false
+				}
+				indexFiles = append(indexFiles, chunkFile)
+				indexFiles = append(indexFiles, genAllDigestFiles(chunkFile)...)
+				chunks = append([]int{lastIncremental}, chunks...)
+				if len(chunks) > MAVEN_INDEX_MAX_CHUNKS {
+					staleChunks = chunks[MAVEN_INDEX_MAX_CHUNKS:]
+					chunks = chunks[:MAVEN_INDEX_MAX_CHUNKS]
+				}
+				logger.Info(fmt.Sprintf("Generated incremental chunk %d with %d records and %d deletions",
+					lastIncremental, len(changed), len(deleted)))
+			}
+		}
+	}
+	staleFiles := []string{}
+	for _, n := range staleChunks {
+		chunkKey := path.Join(MAVEN_INDEX_DIR, mavenIndexChunkFile(n))
+		staleFiles = append(staleFiles, chunkKey, chunkKey+".md5", chunkKey+".sha1", chunkKey+".sha256")
+	}
+
+	chainId, ok := props["nexus.index.chain-id"]
+	if !ok || lastIncremental < 0 {
+		chainId = strconv.FormatInt(rand.Int63(), 10)
+	}
+	propsLines := []string{
+		"#" + now.UTC().Format(time.UnixDate),
+		"nexus.index.id=" + repoId,
+		"nexus.index.chain-id=" + chainId,
+		"nexus.index.timestamp=" + now.UTC().Format(mavenIndexTimestampFmt),
+	}
+	if lastIncremental >= 0 {
+		propsLines = append(propsLines, fmt.Sprintf("nexus.index.last-incremental=%d", lastIncremental))
+	}
+	for i, n := range chunks {
+		propsLines = append(propsLines, fmt.Sprintf("nexus.index.incremental-%d=%d", i, n))
+	}
+	propsFile := path.Join(indexDir, MAVEN_INDEX_PROPS_FILE)
+	if err := files.StoreFile(propsFile, strings.Join(propsLines, "\n")+"\n", true); err != nil {
+		logger.Error(fmt.Sprintf("Can not write %s due to error: %s", propsFile, err))
+		return nil, nil, false
+	}
+	indexFiles = append(indexFiles, propsFile)
+	indexFiles = append(indexFiles, genAllDigestFiles(propsFile)...)
+	return indexFiles, staleFiles, true
+}
+
+// The file name of the incremental chunk, like nexus-maven-repository-index.3.gz
+func mavenIndexChunkFile(n int) string {
+	return fmt.Sprintf("%s.%d.gz", strings.TrimSuffix(MAVEN_INDEX_FILE, ".gz"), n)
+}
+
+// Read the "u" fields of the artifact records in the maven index file in the bucket
+func readRemoteMavenIndexUinfos(s3 storage.S3Client, bucket, key string) ([]string, error) {
+	content, err := s3.ReadFileContent(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	r := bufio.NewReader(gz)
+	// header: version and timestamp
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != mavenIndexVersion {
+		return nil, fmt.Errorf("unsupported maven index version %d", version)
+	}
+	var timestamp int64
+	if err := binary.Read(r, binary.BigEndian, &timestamp); err != nil {
+		return nil, err
+	}
+	uinfos := []string{}
+	for {
+		fields, err := readMavenIndexDocument(r)
+		if err == io.EOF {
+			return uinfos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if f.name == "u" {
+				uinfos = append(uinfos, f.value)
+			}
+		}
+	}
+}
+
+// Collect the artifact records from the listed files. Every file under a
+// GAV folder which is not a checksum, signature or metadata will be an
+// artifact. The main artifact of a GAV has no classifier, and its packaging
+// is read from the <packaging> of the GAV pom, which is "jar" when absent.
+// The packaging of the other artifacts is their extension.
+func collectMavenIndexRecords(s3 storage.S3Client, bucket, prefix string,
+	fileInfos []storage.FileInfo) []MavenIndexRecord {
+	keys := map[string]bool{}
+	for _, f := range fileInfos {
+		keys[f.Key] = true
+	}
+	gavRecords := map[string][]MavenIndexRecord{}
+	gavs := []string{}
+	// The .sha1 files to read for the records, by the gav and the index of
+	// the record in the gav
+	type sha1Fetch struct {
+		gavKey string
+		index  int
+		key    string
+	}
+	fetches := []sha1Fetch{}
+	// The poms to read the packaging of the main artifacts from, by the gav
+	type pomFetch struct {
+		gavKey    string
+		key       string
+		packaging string
+	}
+	pomFetches := []pomFetch{}
+	for _, f := range fileInfos {
+		key := strings.TrimPrefix(f.Key, prefix)
+		if isVerificationFile(key) || IsMetadata(key) || isGradleModule(key) ||
+			strings.HasSuffix(key, util.PROD_INFO_SUFFIX) ||
+			strings.HasPrefix(key, MAVEN_INDEX_DIR+"/") ||
+			!isGAVPath(key, "") {
+			continue
+		}
+		gav := parseGAV(key, "")
+		fileName := path.Base(key)
+		base := gav[1] + "-" + gav[2]
+		if !strings.HasPrefix(fileName, base) {
+			continue
+		}
+		classifier, extension := splitClassifierAndExtension(strings.TrimPrefix(fileName, base))
+		if util.IsBlankString(extension) {
+			continue
+		}
+		r := MavenIndexRecord{
+			GroupId:         gav[0],
+			ArtifactId:      gav[1],
+			Version:         gav[2],
+			Classifier:      classifier,
+			Extension:       extension,
+			Size:            f.Size,
+			LastModified:    f.LastModified,
+			SignatureExists: keys[f.Key+".asc"],
+			isMainArtifact:  util.IsBlankString(classifier),
+		}
+		gavKey := strings.Join(gav[:], ":")
+		if _, ok := gavRecords[gavKey]; !ok {
+			gavs = append(gavs, gavKey)
+		}
+		if keys[f.Key+".sha1"] {
+			fetches = append(fetches, sha1Fetch{gavKey, len(gavRecords[gavKey]), f.Key + ".sha1"})
+		}
+		if r.isMainArtifact && extension == "pom" {
+			pomFetches = append(pomFetches, pomFetch{gavKey: gavKey, key: f.Key})
+		}
+		gavRecords[gavKey] = append(gavRecords[gavKey], r)
+	}
+
+	g := new(errgroup.Group)
+	g.SetLimit(storage.DEFAULT_CONCURRENT_LIMIT)
+	for _, f := range fetches {
+		f := f
+		g.Go(func() error {
+			sha1, err := s3.ReadFileContent(bucket, f.key)
+			if err == nil && len(strings.Fields(sha1)) > 0 {
+				gavRecords[f.gavKey][f.index].Sha1 = strings.Fields(sha1)[0]
+			}
+			return nil
+		})
+	}
+	for i := range pomFetches {
+		f := &pomFetches[i]
+		g.Go(func() error {
+			content, err := s3.ReadFileContent(bucket, f.key)
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Can not read pom %s for its packaging: %s", f.key, err))
+				return nil
+			}
+			pom := &MavenPom{}
+			if err := xml.Unmarshal([]byte(content), pom); err != nil {
+				logger.Warn(fmt.Sprintf("Can not parse pom %s for its packaging: %s", f.key, err))
+				return nil
+			}
+			f.packaging = strings.TrimSpace(pom.Packaging)
+			if util.IsBlankString(f.packaging) {
+				f.packaging = "jar"
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	packagings := map[string]string{}
+	for _, f := range pomFetches {
+		packagings[f.gavKey] = f.packaging
+	}
+
+	records := []MavenIndexRecord{}
+	slices.Sort(gavs)
+	for _, gavKey := range gavs {
+		rs := gavRecords[gavKey]
+		sources, javadoc := false, false
+		for _, r := range rs {
+			sources = sources || r.Classifier == "sources"
+			javadoc = javadoc || r.Classifier == "javadoc"
+		}
+		// The pom is only a main artifact when there is no other main artifact
+		hasOtherMain := slices.ContainsFunc(rs, func(r MavenIndexRecord) bool {
+			return r.isMainArtifact && r.Extension != "pom"
+		})
+		for _, r := range rs {
+			if r.isMainArtifact && r.Extension == "pom" && hasOtherMain {
+				continue
+			}
+			r.Packaging = r.Extension
+			if p := packagings[gavKey]; r.isMainArtifact && !util.IsBlankString(p) {
+				r.Packaging = p
+			}
+			if r.isMainArtifact {
+				r.SourcesExists = sources
+				r.JavadocExists = javadoc
+			}
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Split the part after artifactId-version in a file name to classifier and
+// extension, like "-sources.jar" to ("sources", "jar"), or ".tar.gz" to ("", "tar.gz")
+func splitClassifierAndExtension(remain string) (string, string) {
+	if strings.HasPrefix(remain, "-") {
+		remain = strings.TrimPrefix(remain, "-")
+		dot := strings.Index(remain, ".")
+		if dot < 0 {
+			return remain, ""
+		}
+		return remain[:dot], remain[dot+1:]
+	}
+	return "", strings.TrimPrefix(remain, ".")
+}
+
+// Write the maven indexer index data file, which is a gzipped java
+// DataOutput stream with the header, the descriptor, the groups and all
+// the artifact records
+func writeMavenIndexFile(indexFile, repoId string, records []MavenIndexRecord, deleted []string,
+	now time.Time) error {
+	if err := os.MkdirAll(path.Dir(indexFile), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(indexFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	w := bufio.NewWriter(gz)
+
+	// header: version and timestamp
+	w.WriteByte(mavenIndexVersion)
+	binary.Write(w, binary.BigEndian, now.UnixMilli())
+
+	writeMavenIndexDocument(w, []mavenIndexField{
+		{mavenIndexFlagIndexed | mavenIndexFlagStored, "DESCRIPTOR", "NexusIndex"},
+		{mavenIndexFlagStored, "IDXINFO", "1.0|" + repoId},
+	})
+	allGroups := []string{}
+	rootGroups := []string{}
+	for _, r := range records {
+		if !slices.Contains(allGroups, r.GroupId) {
+			allGroups = append(allGroups, r.GroupId)
+		}
+		rootGroup := strings.Split(r.GroupId, ".")[0]
+		if !slices.Contains(rootGroups, rootGroup) {
+			rootGroups = append(rootGroups, rootGroup)
+		}
+	}
+	slices.Sort(allGroups)
+	slices.Sort(rootGroups)
+	writeMavenIndexDocument(w, []mavenIndexField{
+		{mavenIndexFlagIndexed | mavenIndexFlagStored, "allGroups", "allGroups"},
+		{mavenIndexFlagStored, "allGroupsList", strings.Join(allGroups, mavenIndexFieldSeparator)},
+	})
+	writeMavenIndexDocument(w, []mavenIndexField{
+		{mavenIndexFlagIndexed | mavenIndexFlagStored, "rootGroups", "rootGroups"},
+		{mavenIndexFlagStored, "rootGroupsList", strings.Join(rootGroups, mavenIndexFieldSeparator)},
+	})
+	for _, r := range records {
+		writeMavenIndexDocument(w, r.fields())
+	}
+	// The deletion records only have the "del" field with the uinfo of the
+	// deleted artifact and the time of the deletion
+	for _, uinfo := range deleted {
+		writeMavenIndexDocument(w, []mavenIndexField{
+			{mavenIndexFlagStored, "del", uinfo},
+			{mavenIndexFlagStored, "m", strconv.FormatInt(now.UnixMilli(), 10)},
+		})
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeMavenIndexDocument(w io.Writer, fields []mavenIndexField) {
+	binary.Write(w, binary.BigEndian, int32(len(fields)))
+	for _, f := range fields {
+		binary.Write(w, binary.BigEndian, byte(f.flags))
+		// The field name is written as java writeUTF, with a 2 bytes length
+		name := javaModifiedUTF8(f.name)
+		binary.Write(w, binary.BigEndian, uint16(len(name)))
+		w.Write(name)
+		// The field value is written with a 4 bytes length to support long values
+		value := javaModifiedUTF8(f.value)
+		binary.Write(w, binary.BigEndian, int32(len(value)))
+		w.Write(value)
+	}
+}
+
+// Read a document written by writeMavenIndexDocument. Returns io.EOF when
+// there is no more document.
+func readMavenIndexDocument(r io.Reader) ([]mavenIndexField, error) {
+	var count int32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	fields := []mavenIndexField{}
+	for i := 0; i < int(count); i++ {
+		var flags byte
+		var nameLen uint16
+		var valueLen int32
+		if err := binary.Read(r, binary.BigEndian, &flags); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &nameLen); err != nil {
+			return nil, err
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &valueLen); err != nil {
+			return nil, err
+		}
+		if valueLen < 0 {
+			return nil, fmt.Errorf("invalid length %d of field %s", valueLen, name)
+		}
+		value := make([]byte, valueLen)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, mavenIndexField{int(flags),
+			decodeJavaModifiedUTF8(name), decodeJavaModifiedUTF8(value)})
+	}
+	return fields, nil
+}
+
+// Encode the string as java modified UTF-8, which is used by java DataOutput
+func javaModifiedUTF8(s string) []byte {
+	buf := []byte{}
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c >= 0x0001 && c <= 0x007F:
+			buf = append(buf, byte(c))
+		case c <= 0x07FF:
+			buf = append(buf, byte(0xC0|(c>>6&0x1F)), byte(0x80|(c&0x3F)))
+		default:
+			buf = append(buf, byte(0xE0|(c>>12&0x0F)), byte(0x80|(c>>6&0x3F)), byte(0x80|(c&0x3F)))
+		}
+	}
+	return buf
+}
+
+// Decode the java modified UTF-8 bytes written by java DataOutput
+func decodeJavaModifiedUTF8(b []byte) string {
+	units := []uint16{}
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c&0x80 == 0:
+			units = append(units, uint16(c))
+			i++
+		case c&0xE0 == 0xC0 && i+1 < len(b):
+			units = append(units, uint16(c&0x1F)<<6|uint16(b[i+1]&0x3F))
+			i += 2
+		case c&0xF0 == 0xE0 && i+2 < len(b):
+			units = append(units, uint16(c&0x0F)<<12|uint16(b[i+1]&0x3F)<<6|uint16(b[i+2]&0x3F))
+			i += 3
+		default:
+			// Skip the malformed byte
+			i++
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// Parse the java properties content, only the simple key=value lines
+// are supported.
+func parseProperties(content string) map[string]string {
+	props := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			props[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return props
+}
@@ -0,0 +1,180 @@
+package pkgs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestGenerateMavenIndex(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-index-test-*")
+	defer os.RemoveAll(root)
+	prefix := "ga"
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	objects := map[string]time.Time{
+		"ga/org/foo/bar/1.0/bar-1.0.pom":               old,
+		"ga/org/foo/bar/1.0/bar-1.0.jar":               old,
+		"ga/org/foo/bar/1.0/bar-1.0.jar.sha1":          old,
+		"ga/org/foo/bar/1.0/bar-1.0.jar.asc":           old,
+		"ga/org/foo/bar/1.0/bar-1.0-sources.jar":       old,
+		"ga/org/foo/bar/maven-metadata.xml":            old,
+		"ga/org/foo/bar/1.0/bar-1.0.jar.prodinfo":      old,
+		"ga/org/foo/foo-plugin/1.0/foo-plugin-1.0.pom": old,
+		"ga/org/foo/foo-plugin/1.0/foo-plugin-1.0.jar": old,
+		"ga/io/baz/baz-bom/2.0/baz-bom-2.0.pom":        recent,
+		"ga/io/baz/baz-bom/2.0/baz-bom-2.0.pom.sha1":   recent,
+	}
+	poms := map[string]string{
+		"ga/org/foo/bar/1.0/bar-1.0.pom": "<project><artifactId>bar</artifactId></project>",
+		"ga/org/foo/foo-plugin/1.0/foo-plugin-1.0.pom": "<project><artifactId>foo-plugin</artifactId>" +
+			"<packaging>maven-plugin</packaging></project>",
+		"ga/io/baz/baz-bom/2.0/baz-bom-2.0.pom": "<project><artifactId>baz-bom</artifactId>" +
+			"<packaging>pom</packaging></project>",
+	}
+	// The last exporting has the max number of chunks, from 30 to 1
+	props := "nexus.index.id=ga\nnexus.index.chain-id=123\n" +
+		"nexus.index.timestamp=20240301000000.000 +0000\nnexus.index.last-incremental=30\n"
+	for i := 0; i < MAVEN_INDEX_MAX_CHUNKS; i++ {
+		props += fmt.Sprintf("nexus.index.incremental-%d=%d\n", i, MAVEN_INDEX_MAX_CHUNKS-i)
+	}
+	// The last full index has an artifact which is removed since then
+	previousIndex := path.Join(root, "previous.gz")
+	assert.Nil(t, writeMavenIndexFile(previousIndex, "ga", []MavenIndexRecord{
+		{GroupId: "org.foo", ArtifactId: "bar", Version: "1.0", Extension: "jar", LastModified: old},
+		{GroupId: "org.foo", ArtifactId: "gone", Version: "1.0", Extension: "jar", LastModified: old},
+	}, nil, old))
+	previousContent, _ := os.ReadFile(previousIndex)
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			contents := []types.Object{}
+			for key, modified := range objects {
+				contents = append(contents, types.Object{
+					Key: aws.String(key), Size: aws.Int64(10), LastModified: aws.Time(modified)})
+			}
+			return &s3.ListObjectsV2Output{Contents: contents}, nil
+		},
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{}, nil
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			content := "0123456789abcdef0123456789abcdef01234567  file"
+			if strings.HasSuffix(*params.Key, MAVEN_INDEX_PROPS_FILE) {
+				content = props
+			}
+			if *params.Key == "ga/"+MAVEN_INDEX_DIR+"/"+MAVEN_INDEX_FILE {
+				content = string(previousContent)
+			}
+			if pom, ok := poms[*params.Key]; ok {
+				content = pom
+			}
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(content))}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	indexFiles, staleFiles, ok := generateMavenIndex(*s3client, storage.TEST_BUCKET, prefix, "ga", root, now)
+	assert.True(t, ok)
+	indexDir := path.Join(root, MAVEN_INDEX_DIR)
+	fullIndex := path.Join(indexDir, MAVEN_INDEX_FILE)
+	chunk := path.Join(indexDir, "nexus-maven-repository-index.31.gz")
+	propsFile := path.Join(indexDir, MAVEN_INDEX_PROPS_FILE)
+	assert.Contains(t, indexFiles, fullIndex)
+	assert.Contains(t, indexFiles, fullIndex+".sha1")
+	assert.Contains(t, indexFiles, chunk)
+	assert.Contains(t, indexFiles, propsFile)
+
+	docs := readMavenIndexFile(t, fullIndex, now)
+	assert.Equal(t, "NexusIndex", docs[0]["DESCRIPTOR"])
+	assert.Equal(t, "1.0|ga", docs[0]["IDXINFO"])
+	assert.Equal(t, "io.baz|org.foo", docs[1]["allGroupsList"])
+	assert.Equal(t, "io|org", docs[2]["rootGroupsList"])
+	artifacts := map[string]map[string]string{}
+	for _, d := range docs[3:] {
+		artifacts[d["u"]] = d
+	}
+	assert.Equal(t, 4, len(artifacts))
+	jar := artifacts["org.foo|bar|1.0|NA"]
+	assert.Equal(t, fmt.Sprintf("jar|%d|10|1|0|1|jar", old.UnixMilli()), jar["i"])
+	assert.Equal(t, "0123456789abcdef0123456789abcdef01234567", jar["1"])
+	assert.Equal(t, fmt.Sprintf("jar|%d|10|0|0|0|jar", old.UnixMilli()), artifacts["org.foo|bar|1.0|sources|jar"]["i"])
+	assert.Equal(t, fmt.Sprintf("maven-plugin|%d|10|0|0|0|jar", old.UnixMilli()),
+		artifacts["org.foo|foo-plugin|1.0|NA"]["i"])
+	assert.Equal(t, fmt.Sprintf("pom|%d|10|0|0|0|pom", recent.UnixMilli()), artifacts["io.baz|baz-bom|2.0|NA"]["i"])
+
+	docs = readMavenIndexFile(t, chunk, now)
+	assert.Equal(t, 5, len(docs))
+	assert.Equal(t, "io.baz|baz-bom|2.0|NA", docs[3]["u"])
+	assert.Equal(t, "org.foo|gone|1.0|NA", docs[4]["del"])
+	assert.Equal(t, strconv.FormatInt(now.UnixMilli(), 10), docs[4]["m"])
+	assert.Equal(t, []string{
+		".index/nexus-maven-repository-index.1.gz",
+		".index/nexus-maven-repository-index.1.gz.md5",
+		".index/nexus-maven-repository-index.1.gz.sha1",
+		".index/nexus-maven-repository-index.1.gz.sha256",
+	}, staleFiles)
+
+	content, _ := files.ReadFile(propsFile)
+	written := parseProperties(content)
+	assert.Equal(t, "123", written["nexus.index.chain-id"])
+	assert.Equal(t, "20240701000000.000 +0000", written["nexus.index.timestamp"])
+	assert.Equal(t, "31", written["nexus.index.last-incremental"])
+	assert.Equal(t, "31", written["nexus.index.incremental-0"])
+	assert.Equal(t, "30", written["nexus.index.incremental-1"])
+	assert.Equal(t, "2", written["nexus.index.incremental-29"])
+	assert.NotContains(t, written, "nexus.index.incremental-30")
+}
+
+// Read the documents of the maven index file as field name to value maps
+func readMavenIndexFile(t *testing.T, indexFile string, timestamp time.Time) []map[string]string {
+	f, err := os.Open(indexFile)
+	assert.Nil(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+	r := bufio.NewReader(gz)
+	version, _ := r.ReadByte()
+	assert.Equal(t, byte(1), version)
+	var ts int64
+	binary.Read(r, binary.BigEndian, &ts)
+	assert.Equal(t, timestamp.UnixMilli(), ts)
+	docs := []map[string]string{}
+	for {
+		var count int32
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			break
+		}
+		doc := map[string]string{}
+		for i := 0; i < int(count); i++ {
+			r.ReadByte()
+			var nameLen uint16
+			binary.Read(r, binary.BigEndian, &nameLen)
+			name := make([]byte, nameLen)
+			io.ReadFull(r, name)
+			var valueLen int32
+			binary.Read(r, binary.BigEndian, &valueLen)
+			value := make([]byte, valueLen)
+			io.ReadFull(r, value)
+			doc[string(name)] = string(value)
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
@@ -1,8 +1,11 @@
 package pkgs
 
 import (
+	"archive/zip"
 	"context"
+	"crypto"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -15,7 +18,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
 	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
 	"org.commonjava/charon/module/util/archive"
 	"org.commonjava/charon/module/util/files"
 )
@@ -260,7 +265,8 @@ func TestGenerateMetadata(t *testing.T) {
 }
 
 func TestGenMetaFile(t *testing.T) {
-	tmpRoot := extractTarball(TEST_REPO, "test", "")
+	tmpRoot, err := extractTarball(TEST_REPO, "test", "")
+	assert.Nil(t, err)
 	defer os.RemoveAll(tmpRoot)
 	root := path.Join(tmpRoot, "apache-commons-maven-repository/maven-repository")
 	poms := scanForPoms(root)
@@ -290,7 +296,8 @@ func TestGenMetaFile(t *testing.T) {
 }
 
 func TestScanPaths(t *testing.T) {
-	fRoot := extractTarball(TEST_REPO, "test", "")
+	fRoot, err := extractTarball(TEST_REPO, "test", "")
+	assert.Nil(t, err)
 	defer os.RemoveAll(fRoot)
 	assertPom := func(poms []string) {
 		for _, p := range poms {
@@ -314,3 +321,372 @@ func TestScanPaths(t *testing.T) {
 	assert.Equal(t, 18, len(scannedPaths.dirs))
 	fmt.Println(scannedPaths)
 }
+
+func TestGeneratePluginMetadata(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-test-*")
+	defer os.RemoveAll(root)
+	gaPath := "org/apache/maven/plugins/maven-compiler-plugin"
+	pom := path.Join(root, gaPath, "3.13.0/maven-compiler-plugin-3.13.0.pom")
+	files.StoreFile(pom, `<project>
+  <groupId>org.apache.maven.plugins</groupId>
+  <artifactId>maven-compiler-plugin</artifactId>
+  <version>3.13.0</version>
+  <packaging>maven-plugin</packaging>
+</project>`, true)
+	createPluginJar(t, strings.TrimSuffix(pom, ".pom")+".jar", `<plugin>
+  <name>Apache Maven Compiler Plugin</name>
+  <groupId>org.apache.maven.plugins</groupId>
+  <artifactId>maven-compiler-plugin</artifactId>
+  <version>3.13.0</version>
+  <goalPrefix>compiler</goalPrefix>
+</plugin>`)
+	remoteGroupMeta := `<metadata>
+  <plugins>
+    <plugin>
+      <name>Apache Maven Surefire Plugin</name>
+      <prefix>surefire</prefix>
+      <artifactId>maven-surefire-plugin</artifactId>
+    </plugin>
+  </plugins>
+</metadata>`
+	groupMetaKey := "org/apache/maven/plugins/maven-metadata.xml"
+	remotePoms := []string{gaPath + "/3.13.0/maven-compiler-plugin-3.13.0.pom"}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			contents := []types.Object{}
+			for _, pom := range remotePoms {
+				contents = append(contents, types.Object{Key: aws.String(pom)})
+			}
+			return &s3.ListObjectsV2Output{Contents: contents}, nil
+		},
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if *params.Key == groupMetaKey {
+				return &s3.HeadObjectOutput{}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(remoteGroupMeta))}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	// Uploading: the new plugin is merged with the one in the bucket
+	result := generateMetadatas(*s3client, []string{pom}, storage.TEST_BUCKET, "", root)
+	groupMetaFile := path.Join(root, groupMetaKey)
+	assert.Contains(t, result[META_FILE_GEN_KEY], groupMetaFile)
+	content, _ := files.ReadFile(groupMetaFile)
+	assert.Contains(t, content, "<prefix>compiler</prefix>")
+	assert.Contains(t, content, "<artifactId>maven-compiler-plugin</artifactId>")
+	assert.Contains(t, content, "<prefix>surefire</prefix>")
+	assert.NotContains(t, content, "<versioning>")
+
+	// Rollback: no poms left for the plugin GA, so its prefix is removed
+	remotePoms = []string{}
+	result = generateMetadatas(*s3client, []string{pom}, storage.TEST_BUCKET, "", root)
+	assert.Contains(t, result[META_FILE_GEN_KEY], groupMetaFile)
+	content, _ = files.ReadFile(groupMetaFile)
+	assert.NotContains(t, content, "<prefix>compiler</prefix>")
+	assert.Contains(t, content, "<prefix>surefire</prefix>")
+
+	// Rollback of the last plugin in the group deletes the group metadata
+	remoteGroupMeta = "<metadata><plugins></plugins></metadata>"
+	result = generateMetadatas(*s3client, []string{pom}, storage.TEST_BUCKET, "", root)
+	assert.Contains(t, result[META_FILE_DEL_KEY], groupMetaKey)
+	assert.NotContains(t, result[META_FILE_GEN_KEY], groupMetaFile)
+
+	// The group path is also the GA path of org.apache.maven:plugins, whose
+	// versioning is kept along with the plugins
+	remotePoms = []string{gaPath + "/3.13.0/maven-compiler-plugin-3.13.0.pom"}
+	remoteGroupMeta = `<metadata>
+  <groupId>org.apache.maven</groupId>
+  <artifactId>plugins</artifactId>
+  <versioning>
+    <latest>2.0</latest>
+    <release>2.0</release>
+    <versions>
+      <version>1.0</version>
+      <version>2.0</version>
+    </versions>
+    <lastUpdated>20240101000000</lastUpdated>
+  </versioning>
+</metadata>`
+	result = generateMetadatas(*s3client, []string{pom}, storage.TEST_BUCKET, "", root)
+	assert.Contains(t, result[META_FILE_GEN_KEY], groupMetaFile)
+	content, _ = files.ReadFile(groupMetaFile)
+	assert.Contains(t, content, "<prefix>compiler</prefix>")
+	assert.Contains(t, content, "<artifactId>plugins</artifactId>")
+	assert.Contains(t, content, "<version>1.0</version>")
+	assert.Contains(t, content, "<latest>2.0</latest>")
+	assert.Contains(t, content, "<lastUpdated>20240101000000</lastUpdated>")
+
+	// Rollback of the last plugin keeps the versioning instead of deleting it
+	remotePoms = []string{}
+	remoteGroupMeta = strings.Replace(remoteGroupMeta, "</metadata>", `  <plugins>
+    <plugin>
+      <name>Apache Maven Compiler Plugin</name>
+      <prefix>compiler</prefix>
+      <artifactId>maven-compiler-plugin</artifactId>
+    </plugin>
+  </plugins>
+</metadata>`, 1)
+	result = generateMetadatas(*s3client, []string{pom}, storage.TEST_BUCKET, "", root)
+	assert.NotContains(t, result[META_FILE_DEL_KEY], groupMetaKey)
+	assert.Contains(t, result[META_FILE_GEN_KEY], groupMetaFile)
+	content, _ = files.ReadFile(groupMetaFile)
+	assert.NotContains(t, content, "<plugins>")
+	assert.Contains(t, content, "<version>2.0</version>")
+}
+
+func createPluginJar(t *testing.T, jarPath, descriptor string) {
+	os.MkdirAll(path.Dir(jarPath), 0755)
+	f, err := os.Create(jarPath)
+	assert.Nil(t, err)
+	defer f.Close()
+	w := zip.NewWriter(f)
+	entry, err := w.Create(MAVEN_PLUGIN_FILE)
+	assert.Nil(t, err)
+	io.WriteString(entry, descriptor)
+	assert.Nil(t, w.Close())
+}
+
+func TestGenMissingDigestFiles(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-test-*")
+	defer os.RemoveAll(root)
+	jar := path.Join(root, "org/foo/bar/1.0.0/bar-1.0.0.jar")
+	files.StoreFile(jar, "jar content", true)
+	files.StoreFile(jar+".sha1", files.Digest(jar, crypto.SHA1)+"  bar-1.0.0.jar", true)
+	pom := path.Join(root, "org/foo/bar/1.0.0/bar-1.0.0.pom")
+	files.StoreFile(pom, "<project/>", true)
+	files.StoreFile(pom+".md5", "0123456789abcdef0123456789abcdef", true)
+	paths := []string{jar, jar + ".sha1", pom, pom + ".md5"}
+
+	generated, mismatched := genMissingDigestFiles(paths, 2)
+	assert.Equal(t, []string{pom + ".md5"}, mismatched)
+	assert.Equal(t, []string{
+		jar + ".md5", jar + ".sha256", jar + ".sha512",
+		pom + ".sha1", pom + ".sha256", pom + ".sha512",
+	}, generated)
+	for _, f := range generated {
+		assert.True(t, files.IsFile(f))
+	}
+	sha512, _ := files.ReadFile(jar + ".sha512")
+	assert.Equal(t, files.Digest(jar, files.SHA512), sha512)
+}
+
+func TestArchetypeCatalogMergeAndUnmerge(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-test-*")
+	defer os.RemoveAll(root)
+	files.StoreFile(path.Join(root, MAVEN_ARCH_FILE), `<?xml version="1.0" encoding="UTF-8"?>
+<archetype-catalog xsi:schemaLocation="http://maven.apache.org/plugins/maven-archetype-plugin/archetype-catalog/1.0.0 http://maven.apache.org/xsd/archetype-catalog-1.0.0.xsd"
+    xmlns="http://maven.apache.org/plugins/maven-archetype-plugin/archetype-catalog/1.0.0"
+    xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <archetypes>
+    <archetype>
+      <groupId>io.quarkus</groupId>
+      <artifactId>quarkus-archetype</artifactId>
+      <version>2.0</version>
+      <repository>https://maven.repository.redhat.com/ga/</repository>
+      <description>quarkus archetype 2.0</description>
+    </archetype>
+  </archetypes>
+</archetype-catalog>`, true)
+	remoteCatalog := `<archetype-catalog xmlns="http://maven.apache.org/plugins/maven-archetype-plugin/archetype-catalog/1.0.0">
+  <archetypes>
+    <archetype>
+      <groupId>io.quarkus</groupId>
+      <artifactId>quarkus-archetype</artifactId>
+      <version>1.0</version>
+      <description>quarkus archetype 1.0</description>
+    </archetype>
+  </archetypes>
+</archetype-catalog>`
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{}, nil
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(remoteCatalog))}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	// Uploading merges the new archetype into the remote catalog
+	assert.True(t, generateUploadArchetypeCatalog(s3client, storage.TEST_BUCKET, root, "ga"))
+	merged, _ := files.ReadFile(path.Join(root, MAVEN_ARCH_FILE))
+	assert.True(t, strings.HasPrefix(merged, "<?xml"))
+	assert.Contains(t, merged, `<archetype-catalog xmlns="`+ARCHETYPE_CATALOG_XMLNS+`"`)
+	assert.Contains(t, merged, `xsi:schemaLocation="`+ARCHETYPE_CATALOG_SCHEMA_LOCATION+`"`)
+	archs, err := parseArchetypes(merged)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(archs))
+	assert.Equal(t, "https://maven.repository.redhat.com/ga/", archs[1].Repository)
+	assert.Equal(t, "quarkus archetype 2.0", archs[1].Description)
+	assert.True(t, files.IsFile(path.Join(root, MAVEN_ARCH_FILE+".sha1")))
+
+	// Uploading the same archetype again changes nothing
+	remoteCatalog = merged
+	assert.False(t, generateUploadArchetypeCatalog(s3client, storage.TEST_BUCKET, root, "ga"))
+
+	// Rollback removes the archetype of the product
+	assert.Equal(t, 1, generateRollbackArchetypeCatalog(s3client, storage.TEST_BUCKET, root, "ga"))
+	rolledBack, _ := files.ReadFile(path.Join(root, MAVEN_ARCH_FILE))
+	archs, _ = parseArchetypes(rolledBack)
+	assert.Equal(t, 1, len(archs))
+	assert.Equal(t, "1.0", archs[0].Version)
+
+	// Rollback of the last archetype deletes the catalog
+	remoteCatalog = rolledBack
+	files.StoreFile(path.Join(root, MAVEN_ARCH_FILE+".charon.bak"), rolledBack, true)
+	assert.Equal(t, -1, generateRollbackArchetypeCatalog(s3client, storage.TEST_BUCKET, root, "ga"))
+}
+
+func TestMergeArchetypesKeyedOnGAV(t *testing.T) {
+	remote := []ArchetypeRef{{GroupId: "foo", ArtifactId: "bar", Version: "1.0", Description: "old"}}
+	local := []ArchetypeRef{
+		{GroupId: "foo", ArtifactId: "bar", Version: "1.0", Description: "new"},
+		{GroupId: "foo", ArtifactId: "bar", Version: "2.0"},
+	}
+	merged := mergeArchetypes(remote, local)
+	assert.Equal(t, 2, len(merged))
+	assert.Equal(t, "old", merged[0].Description)
+	assert.Equal(t, "2.0", merged[1].Version)
+	assert.Empty(t, unmergeArchetypes(merged, local))
+}
+
+func TestInterruptedUploading(t *testing.T) {
+	_, err := extractTarball("/not/existed.zip", "test", "")
+	assert.NotNil(t, err)
+
+	root := t.TempDir()
+	first := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	second := path.Join(root, "org/foo/bar/1.0/bar-1.0.jar")
+	for _, f := range []string{first, second} {
+		assert.Nil(t, os.MkdirAll(path.Dir(f), 0755))
+		assert.Nil(t, os.WriteFile(f, []byte(f), 0644))
+	}
+
+	// The run is interrupted during the first PUT, which should still be
+	// finished, but the second file should not be started
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requested := []string{}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return nil, &types.NotFound{}
+		},
+		PutObj: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			cancel()
+			assert.Nil(t, ctx.Err())
+			requested = append(requested, *params.Key)
+			return &s3.PutObjectOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+	s3client = s3client.WithContext(ctx)
+	failed := s3client.UploadFiles([]string{first, second},
+		[]config.Target{{Bucket: storage.TEST_BUCKET, Prefix: "ga"}}, "", root)[storage.TEST_BUCKET]
+	assert.Equal(t, []string{"ga/org/foo/bar/1.0/bar-1.0.pom"}, requested)
+	assert.Equal(t, []string{second}, failed)
+	assert.Contains(t, s3client.FailureCause(second), "interrupted")
+	assert.True(t, interrupted(ctx, "the test"))
+}
+
+func TestUploadWithMissingProductInfo(t *testing.T) {
+	bucket := storage.TEST_BUCKET
+	workDir := t.TempDir()
+	root := path.Join(workDir, "maven-repository")
+	pom := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	jar := path.Join(root, "org/foo/bar/1.0/bar-1.0.jar")
+	for _, f := range []string{pom, jar} {
+		assert.Nil(t, os.MkdirAll(path.Dir(f), 0755))
+		assert.Nil(t, os.WriteFile(f, []byte(f), 0644))
+	}
+	targets := []config.Target{{Bucket: bucket}}
+	journal, err := newUploadJournal(workDir, uploadParams{ProdKey: "foo-1.0", Targets: targets})
+	assert.Nil(t, err)
+	defer journal.close()
+
+	// The pom was put by an interrupted run before its product info was
+	// written, and the product info of the jar can not be written
+	remote := newMemS3()
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.pom", pom)
+	remote.failPut = "bar-1.0.jar" + util.PROD_INFO_SUFFIX
+	s3client := remote.client(t)
+	s3client.SetUploadJournal(journal)
+	failed := s3client.UploadFiles([]string{pom, jar}, targets, "foo-1.0", root)[bucket]
+	assert.Equal(t, []string{jar}, failed)
+	assert.Contains(t, s3client.FailureCause(jar), "can not update product info")
+	info, ok := remote.get(bucket, "org/foo/bar/1.0/bar-1.0.pom"+util.PROD_INFO_SUFFIX)
+	assert.True(t, ok)
+	assert.Equal(t, "foo-1.0", info.content)
+	assert.True(t, journal.IsUploaded(bucket, "org/foo/bar/1.0/bar-1.0.pom"))
+	assert.False(t, journal.IsUploaded(bucket, "org/foo/bar/1.0/bar-1.0.jar"))
+
+	// The jar is done again on resume, as its product info is missing
+	remote.failPut = ""
+	failed = s3client.UploadFiles([]string{pom, jar}, targets, "foo-1.0", root)[bucket]
+	assert.Empty(t, failed)
+	info, ok = remote.get(bucket, "org/foo/bar/1.0/bar-1.0.jar"+util.PROD_INFO_SUFFIX)
+	assert.True(t, ok)
+	assert.Equal(t, "foo-1.0", info.content)
+	assert.True(t, journal.IsUploaded(bucket, "org/foo/bar/1.0/bar-1.0.jar"))
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	bucket := storage.TEST_BUCKET
+	root := t.TempDir()
+	pom := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	assert.Nil(t, os.MkdirAll(path.Dir(pom), 0755))
+	assert.Nil(t, os.WriteFile(pom, []byte("new pom"), 0644))
+
+	// The file with a different checksum is rejected as the plan says
+	remote := newMemS3()
+	remote.put(bucket, "org/foo/bar/1.0/bar-1.0.pom", "old pom")
+	s3client := remote.client(t)
+	target := config.Target{Bucket: bucket}
+	actions, failed := s3client.PlanUploadFiles([]string{pom}, target, "foo-1.0", root)
+	assert.Empty(t, failed)
+	assert.Equal(t, []string{pom}, actions[storage.PLAN_MISMATCH])
+	failed = s3client.UploadFiles([]string{pom}, []config.Target{target}, "foo-1.0", root)[bucket]
+	assert.Equal(t, []string{pom}, failed)
+	assert.Contains(t, s3client.FailureCause(pom), "checksum differs in bucket "+bucket)
+	o, _ := remote.get(bucket, "org/foo/bar/1.0/bar-1.0.pom")
+	assert.Equal(t, "old pom", o.content)
+	_, ok := remote.get(bucket, "org/foo/bar/1.0/bar-1.0.pom"+util.PROD_INFO_SUFFIX)
+	assert.False(t, ok)
+}
+
+func TestUploadFailuresByBucket(t *testing.T) {
+	root := t.TempDir()
+	pom := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	jar := path.Join(root, "org/foo/bar/1.0/bar-1.0.jar")
+	for _, f := range []string{pom, jar} {
+		assert.Nil(t, os.MkdirAll(path.Dir(f), 0755))
+		assert.Nil(t, os.WriteFile(f, []byte(f), 0644))
+	}
+	// The jar conflicts with a different one only in the second bucket
+	remote := newMemS3()
+	remote.put("ga", "org/foo/bar/1.0/bar-1.0.jar", "another jar")
+	s3client := remote.client(t)
+	targets := []config.Target{{Bucket: "stage"}, {Bucket: "ga"}}
+	failed := s3client.UploadFiles([]string{pom, jar}, targets, "foo-1.0", root)
+	assert.Empty(t, failed["stage"])
+	assert.Equal(t, []string{jar}, failed["ga"])
+	_, ok := remote.get("stage", "org/foo/bar/1.0/bar-1.0.jar")
+	assert.True(t, ok)
+
+	report := newRunReport("upload", "foo-1.0", "foo.zip", false)
+	for _, target := range targets {
+		report.addTarget(target).setPaths(s3client, []string{pom, jar}, failed[target.Bucket], root,
+			PATH_OUTCOME_UPLOADED)
+	}
+	assert.Equal(t, []PathReport{
+		{Path: "org/foo/bar/1.0/bar-1.0.pom", Outcome: PATH_OUTCOME_UPLOADED},
+		{Path: "org/foo/bar/1.0/bar-1.0.jar", Outcome: PATH_OUTCOME_UPLOADED},
+	}, report.Targets[0].Paths)
+	assert.Equal(t, []PathReport{
+		{Path: "org/foo/bar/1.0/bar-1.0.pom", Outcome: PATH_OUTCOME_UPLOADED},
+		{Path: "org/foo/bar/1.0/bar-1.0.jar", Outcome: PATH_OUTCOME_FAILED, Cause: "checksum differs in bucket ga"},
+	}, report.Targets[1].Paths)
+}
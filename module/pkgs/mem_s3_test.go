@@ -0,0 +1,178 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+// An in memory s3 for the tests which need to read back what they wrote.
+// The objects are keyed by "bucket/key". The PUTs of the keys with suffix
+// failPut and the listings of the prefix failList will fail.
+type memS3 struct {
+	mu       sync.Mutex
+	objects  map[string]memObject
+	failPut  string
+	failList string
+}
+
+type memObject struct {
+	content string
+	meta    map[string]string
+	tags    []types.Tag
+}
+
+func newMemS3() *memS3 {
+	return &memS3{objects: map[string]memObject{}}
+}
+
+// Put an object with its checksum metadata
+func (m *memS3) put(bucket, key, content string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[bucket+"/"+key] = memObject{content: content,
+		meta: map[string]string{storage.CHECKSUM_META_KEY: files.DigestContent(content, crypto.SHA1)}}
+}
+
+func (m *memS3) get(bucket, key string) (memObject, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objects[bucket+"/"+key]
+	return o, ok
+}
+
+func (m *memS3) setTags(bucket, key string, tags []types.Tag) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.objects[bucket+"/"+key]; ok {
+		o.tags = tags
+		m.objects[bucket+"/"+key] = o
+	}
+}
+
+func (m *memS3) keys(bucket string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []string{}
+	for k := range m.objects {
+		if strings.HasPrefix(k, bucket+"/") {
+			keys = append(keys, strings.TrimPrefix(k, bucket+"/"))
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (m *memS3) client(t *testing.T) *storage.S3Client {
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			prefix := aws.ToString(params.Prefix)
+			delimiter := aws.ToString(params.Delimiter)
+			if m.failList != "" && prefix == m.failList {
+				return nil, fmt.Errorf("list %s failed", prefix)
+			}
+			output := &s3.ListObjectsV2Output{}
+			folders := map[string]bool{}
+			for _, k := range m.keys(aws.ToString(params.Bucket)) {
+				if !strings.HasPrefix(k, prefix) {
+					continue
+				}
+				if i := strings.Index(strings.TrimPrefix(k, prefix), delimiter); delimiter != "" && i >= 0 {
+					folder := prefix + strings.TrimPrefix(k, prefix)[:i+1]
+					if !folders[folder] {
+						folders[folder] = true
+						output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(folder)})
+					}
+					continue
+				}
+				o, _ := m.get(aws.ToString(params.Bucket), k)
+				output.Contents = append(output.Contents, types.Object{
+					Key: aws.String(k), Size: aws.Int64(int64(len(o.content)))})
+			}
+			return output, nil
+		},
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			o, ok := m.get(aws.ToString(params.Bucket), aws.ToString(params.Key))
+			if !ok {
+				return nil, &types.NotFound{}
+			}
+			return &s3.HeadObjectOutput{Metadata: o.meta}, nil
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			o, ok := m.get(aws.ToString(params.Bucket), aws.ToString(params.Key))
+			if !ok {
+				return nil, &types.NoSuchKey{}
+			}
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(o.content)), Metadata: o.meta}, nil
+		},
+		PutObj: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			content, _ := io.ReadAll(params.Body)
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.failPut != "" && strings.HasSuffix(aws.ToString(params.Key), m.failPut) {
+				return nil, fmt.Errorf("put %s failed", aws.ToString(params.Key))
+			}
+			// The tags are replaced by the tagging of the PUT, like in s3
+			tags := []types.Tag{}
+			query, _ := url.ParseQuery(aws.ToString(params.Tagging))
+			for k, vs := range query {
+				for _, v := range vs {
+					tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+				}
+			}
+			m.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = memObject{
+				content: string(content), meta: params.Metadata, tags: tags}
+			return &s3.PutObjectOutput{}, nil
+		},
+		GetObjTag: func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+			o, ok := m.get(aws.ToString(params.Bucket), aws.ToString(params.Key))
+			if !ok {
+				return nil, &types.NoSuchKey{}
+			}
+			return &s3.GetObjectTaggingOutput{TagSet: o.tags}, nil
+		},
+		PutObjTag: func(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+			m.setTags(aws.ToString(params.Bucket), aws.ToString(params.Key), params.Tagging.TagSet)
+			return &s3.PutObjectTaggingOutput{}, nil
+		},
+		DelObjTag: func(ctx context.Context, params *s3.DeleteObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectTaggingOutput, error) {
+			m.setTags(aws.ToString(params.Bucket), aws.ToString(params.Key), nil)
+			return &s3.DeleteObjectTaggingOutput{}, nil
+		},
+		CpObj: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			source, ok := m.objects[aws.ToString(params.CopySource)]
+			if !ok {
+				return nil, &types.NoSuchKey{}
+			}
+			copied := memObject{content: source.content, meta: source.meta, tags: source.tags}
+			if params.MetadataDirective == types.MetadataDirectiveReplace {
+				copied.meta = params.Metadata
+			}
+			m.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = copied
+			return &s3.CopyObjectOutput{}, nil
+		},
+		DelObj: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			delete(m.objects, aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+	return s3client
+}
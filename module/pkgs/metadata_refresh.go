@@ -0,0 +1,412 @@
+package pkgs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+	"org.commonjava/charon/module/util/files"
+)
+
+const SNAPSHOT_SUFFIX = "-SNAPSHOT"
+
+var snapshotFilePattern = regexp.MustCompile(`^(\d{8}\.\d{6})-(\d+)(.*)$`)
+
+// This SnapshotMetadata represents the version level maven-metadata.xml of
+// a SNAPSHOT version, which maps the SNAPSHOT version to the latest
+// timestamped files.
+type SnapshotMetadata struct {
+	GroupId          string
+	ArtifactId       string
+	Version          string
+	Timestamp        string
+	BuildNumber      int
+	SnapshotVersions []SnapshotVersion
+}
+
+type SnapshotVersion struct {
+	Classifier string
+	Extension  string
+	Value      string
+	Updated    string
+}
+
+// The lastUpdated is decided by the latest timestamp, so that the content
+// is stable when the snapshot files are not changed
+func (m *SnapshotMetadata) LastUpdated() string {
+	return strings.ReplaceAll(m.Timestamp, ".", "")
+}
+
+func (m *SnapshotMetadata) GenerateMetaFileContent() (string, error) {
+	t := template.Must(template.New("snapshot").Parse(SNAPSHOT_METADATA_TEMPLATE))
+	var buf bytes.Buffer
+	err := t.Execute(&buf, m)
+	if err != nil {
+		logger.Error(fmt.Sprintf("executing template: %s", err))
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Handle the refreshment of maven-metadata.xml for the GAs or the paths in
+// the targets. All the poms under the GAs or paths in the buckets will be
+// collected to rebuild the GA level metadata, the version level metadata for
+// SNAPSHOT versions and the group level metadata for plugins. Only the
+// metadata which differs from the remote one will be uploaded.
+//   - gas are the GAs to refresh, like org.foo:bar
+//   - paths are the paths to refresh all GAs under them, like org/foo/
+//   - targets contains the target name with its bucket name and prefix
+//   - dir_ is base dir for holding the metadata files, will use system
+//     tmp dir if empty.
+//
+// Returns the directory used for metadata files and if the refreshment is successful
+func HandleMetadataRefresh(
+	ctx context.Context,
+	gas []string,
+	paths []string,
+	targets []config.Target,
+	awsProfile,
+	dir_ string,
+	cfEnable bool,
+	dryRun bool,
+) (string, bool) {
+	for _, ga := range gas {
+		if parts := strings.Split(ga, ":"); len(parts) != 2 || slicesHasBlank(parts) {
+			logger.Error(fmt.Sprintf("Invalid GA %s, should be like groupId:artifactId", ga))
+			return "", false
+		}
+	}
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return "", false
+	}
+	workDir, err := os.MkdirTemp(dir_, "charon-metadata-*")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create work dir for metadata due to error: %s", err))
+		return "", false
+	}
+	succeeded := true
+	for _, target := range targets {
+		if interrupted(ctx, "metadata refreshment for bucket "+target.Bucket) {
+			succeeded = false
+			continue
+		}
+		t := fixTarget(target)
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		if !refreshTargetMetadatas(ctx, s3Client, t, gas, paths, path.Join(workDir, t.Bucket), awsProfile, cfEnable) {
+			succeeded = false
+		}
+	}
+	return workDir, succeeded
+}
+
+// Refresh the maven-metadata.xml of the GAs and paths in the target, the
+// metadata files are generated under root. Only the metadata which differs
+// from the remote one will be uploaded or deleted.
+//
+// Returns if all the metadata are refreshed
+func refreshTargetMetadatas(ctx context.Context, s3Client *storage.S3Client, t config.Target,
+	gas, paths []string, root, awsProfile string, cfEnable bool) bool {
+	bucketName := t.Bucket
+
+	logger.Info("Start regenerating maven-metadata.xml files for bucket " + bucketName)
+	metaFiles := refreshMetadatas(*s3Client, gas, paths, bucketName, t.Prefix, root)
+	logger.Info("maven-metadata.xml files regeneration done\n")
+	failedMetas := metaFiles[META_FILE_FAILED]
+
+	changed, deleted, diffs := diffRemoteMetadatas(*s3Client, metaFiles, bucketName, t.Prefix, root)
+	diffPaths := make([]string, 0, len(diffs))
+	for p := range diffs {
+		diffPaths = append(diffPaths, p)
+	}
+	slices.Sort(diffPaths)
+	for _, p := range diffPaths {
+		logger.Info(fmt.Sprintf("maven-metadata.xml %s in bucket %s is changed:\n%s", p, bucketName, diffs[p]))
+	}
+	if len(diffs) == 0 {
+		logger.Info("All maven-metadata.xml files are up to date in bucket " + bucketName)
+	}
+
+	if len(deleted) > 0 {
+		logger.Info("Start deleting stale maven-metadata.xml from s3 bucket " + bucketName)
+		failedMetas = append(failedMetas, s3Client.DeleteFiles(deleted, t, "", root)...)
+		logger.Info(fmt.Sprintf("maven-metadata.xml deletion done in bucket %s\n", bucketName))
+	}
+	if len(changed) > 0 {
+		logger.Info("Start updating maven-metadata.xml to s3 bucket " + bucketName)
+		failedMetas = append(failedMetas, s3Client.UploadMetadatas(changed, t, "", root)...)
+		logger.Info(fmt.Sprintf("maven-metadata.xml updating done in bucket %s\n", bucketName))
+	}
+
+	cfInvalidatePaths := append(append([]string{}, changed...), deleted...)
+	if cfEnable && len(cfInvalidatePaths) > 0 {
+		cfClient, err := storage.NewCFClient(ctx, awsProfile)
+		if err != nil {
+			logger.Error(
+				fmt.Sprintf("Cannot do Cloudfront cache invalidating due to error: %s", err))
+		} else {
+			cfInvalidatePaths = wildcardMetadataPaths(cfInvalidatePaths)
+			invalidateCFPaths(cfClient, t, cfInvalidatePaths, root, storage.INVALIDATION_BATCH_DEFAULT)
+		}
+	}
+
+	if len(failedMetas) > 0 {
+		logger.Error(fmt.Sprintf("Failed to refresh maven-metadata.xml files in bucket %s: \n%s\n",
+			bucketName, failedMetas))
+		return false
+	}
+	return true
+}
+
+// Collect the remote poms of the GAs and paths, and regenerate all the
+// metadata for them under the root. The result is the same as generateMetadatas.
+func refreshMetadatas(s3 storage.S3Client, gas, paths []string,
+	bucket, prefix, root string) map[string][]string {
+	metaFiles := make(map[string][]string)
+	// GA path to the local pom paths, which do not exist but only
+	// used to parse the GAVs
+	gaPoms := make(map[string][]string)
+	toLocal := func(key string) string {
+		return path.Join(root, strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/"))
+	}
+	for _, ga := range gas {
+		parts := strings.Split(ga, ":")
+		gaPath := path.Join(strings.ReplaceAll(parts[0], ".", "/"), parts[1])
+		gaPoms[gaPath] = []string{}
+		poms, ok := s3.GetFiles(bucket, path.Join(prefix, gaPath)+"/", ".pom")
+		if !ok {
+			metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED],
+				path.Join(gaPath, MAVEN_METADATA_FILE))
+			continue
+		}
+		for _, pom := range poms {
+			local := toLocal(pom)
+			// The poms of other GAs under this GA path, like org/foo/bar/baz/1.0/baz-1.0.pom
+			// for org.foo:bar, should not be counted
+			if path.Dir(path.Dir(trimRoot(local, root))) == gaPath {
+				gaPoms[gaPath] = append(gaPoms[gaPath], local)
+			}
+		}
+	}
+	for _, p := range paths {
+		p = strings.Trim(p, "/")
+		pathPrefix := path.Join(prefix, p)
+		if !util.IsBlankString(pathPrefix) {
+			pathPrefix += "/"
+		}
+		poms, ok := s3.GetFiles(bucket, pathPrefix, ".pom")
+		if !ok {
+			metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED], p)
+			continue
+		}
+		for _, pom := range poms {
+			local := toLocal(pom)
+			if !isGAVPath(local, root) {
+				continue
+			}
+			gaPath := path.Dir(path.Dir(trimRoot(local, root)))
+			gaPoms[gaPath] = append(gaPoms[gaPath], local)
+		}
+	}
+
+	removedGAs := make(map[string]bool)
+	groups := make(map[string][]MavenPlugin)
+	for gaPath, poms := range gaPoms {
+		ga := parseGA(path.Join(root, gaPath), root)
+		groups[ga[0]] = []MavenPlugin{}
+		if len(poms) == 0 {
+			logger.Debug(fmt.Sprintf("No poms found in s3 bucket %s for GA path %s", bucket, gaPath))
+			removedGAs[gaPath] = true
+			metaFiles[META_FILE_DEL_KEY] = append(metaFiles[META_FILE_DEL_KEY],
+				path.Join(gaPath, MAVEN_METADATA_FILE))
+			metaFiles[META_FILE_DEL_KEY] = append(metaFiles[META_FILE_DEL_KEY],
+				hashDecorateMetadata(gaPath, MAVEN_METADATA_FILE)...)
+			continue
+		}
+		versions := []string{}
+		for _, pom := range poms {
+			v := parseGAV(pom, root)[2]
+			if !slices.Contains(versions, v) {
+				versions = append(versions, v)
+			}
+		}
+		metas, err := genMetaFile(ga[0], ga[1], versions, root, true)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to create metadata file for GA %s", gaPath))
+			metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED],
+				path.Join(gaPath, MAVEN_METADATA_FILE))
+			continue
+		}
+		metaFiles[META_FILE_GEN_KEY] = append(metaFiles[META_FILE_GEN_KEY], metas...)
+		for _, v := range versions {
+			if strings.HasSuffix(v, SNAPSHOT_SUFFIX) {
+				refreshSnapshotMetadata(s3, ga[0], ga[1], v, metaFiles, bucket, prefix, root)
+			}
+		}
+	}
+	// The group level metadata only keeps the plugins which still exist
+	generatePluginMetadatas(s3, groups, removedGAs, metaFiles, bucket, prefix, root)
+	return metaFiles
+}
+
+// Regenerate the version level metadata for the SNAPSHOT version from the
+// timestamped files in the version folder
+func refreshSnapshotMetadata(s3 storage.S3Client, groupId, artifactId, version string,
+	metaFiles map[string][]string, bucket, prefix, root string) {
+	verPath := path.Join(strings.ReplaceAll(groupId, ".", "/"), artifactId, version)
+	fileInfos, ok := s3.GetFileInfos(bucket, path.Join(prefix, verPath)+"/", "")
+	if !ok {
+		metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED],
+			path.Join(verPath, MAVEN_METADATA_FILE))
+		return
+	}
+	names := make([]string, 0, len(fileInfos))
+	for _, f := range fileInfos {
+		names = append(names, path.Base(f.Key))
+	}
+	meta := parseSnapshotMetadata(groupId, artifactId, version, names)
+	if meta == nil {
+		logger.Debug(fmt.Sprintf("No timestamped files found for SNAPSHOT version %s", verPath))
+		return
+	}
+	content, err := meta.GenerateMetaFileContent()
+	if err != nil {
+		metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED],
+			path.Join(verPath, MAVEN_METADATA_FILE))
+		return
+	}
+	metaPath := path.Join(fixRoot(root), verPath, MAVEN_METADATA_FILE)
+	if err := files.StoreFile(metaPath, content, true); err != nil {
+		logger.Warn(fmt.Sprintf("Can not write metadata %s due to error: %s", metaPath, err))
+		metaFiles[META_FILE_FAILED] = append(metaFiles[META_FILE_FAILED],
+			path.Join(verPath, MAVEN_METADATA_FILE))
+		return
+	}
+	metaFiles[META_FILE_GEN_KEY] = append(metaFiles[META_FILE_GEN_KEY], metaPath)
+	metaFiles[META_FILE_GEN_KEY] = append(metaFiles[META_FILE_GEN_KEY], genAllDigestFiles(metaPath)...)
+}
+
+// Parse the snapshot metadata from the file names in the SNAPSHOT version
+// folder, like bar-1.0-20240101.120000-3-sources.jar. Returns nil if there
+// are no timestamped files.
+func parseSnapshotMetadata(groupId, artifactId, version string, names []string) *SnapshotMetadata {
+	base := fmt.Sprintf("%s-%s-", artifactId, strings.TrimSuffix(version, SNAPSHOT_SUFFIX))
+	meta := &SnapshotMetadata{GroupId: groupId, ArtifactId: artifactId, Version: version}
+	latest := map[string]SnapshotVersion{}
+	for _, name := range names {
+		if isVerificationFile(name) || IsMetadata(name) || strings.HasSuffix(name, util.PROD_INFO_SUFFIX) ||
+			!strings.HasPrefix(name, base) {
+			continue
+		}
+		matches := snapshotFilePattern.FindStringSubmatch(strings.TrimPrefix(name, base))
+		if matches == nil {
+			continue
+		}
+		timestamp, buildNumber := matches[1], matches[2]
+		classifier, extension := splitClassifierAndExtension(matches[3])
+		if util.IsBlankString(extension) {
+			continue
+		}
+		value := fmt.Sprintf("%s-%s-%s", strings.TrimSuffix(version, SNAPSHOT_SUFFIX), timestamp, buildNumber)
+		if build, err := strconv.Atoi(buildNumber); err == nil &&
+			(timestamp > meta.Timestamp || (timestamp == meta.Timestamp && build > meta.BuildNumber)) {
+			meta.Timestamp = timestamp
+			meta.BuildNumber = build
+		}
+		key := classifier + ":" + extension
+		if old, ok := latest[key]; !ok || versionCompare(value, old.Value) > 0 {
+			latest[key] = SnapshotVersion{
+				Classifier: classifier,
+				Extension:  extension,
+				Value:      value,
+				Updated:    strings.ReplaceAll(timestamp, ".", ""),
+			}
+		}
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+	for _, sv := range latest {
+		meta.SnapshotVersions = append(meta.SnapshotVersions, sv)
+	}
+	slices.SortFunc(meta.SnapshotVersions, func(s1, s2 SnapshotVersion) int {
+		if c := strings.Compare(s1.Extension, s2.Extension); c != 0 {
+			return c
+		}
+		return strings.Compare(s1.Classifier, s2.Classifier)
+	})
+	return meta
+}
+
+// Compare the regenerated metadata with the remote ones. Returns the metadata
+// files (with their digests) to upload, the remote metadata files to delete,
+// and the unified diffs of the changed metadata by their paths in bucket.
+func diffRemoteMetadatas(s3 storage.S3Client, metaFiles map[string][]string,
+	bucket, prefix, root string) ([]string, []string, map[string]string) {
+	changed, deleted := []string{}, []string{}
+	diffs := make(map[string]string)
+	generated := metaFiles[META_FILE_GEN_KEY]
+	for _, f := range generated {
+		if path.Base(f) != MAVEN_METADATA_FILE {
+			continue
+		}
+		key := path.Join(prefix, trimRoot(f, root))
+		local, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		remote := ""
+		if existed, err := s3.FileExistsInBucket(bucket, key); err == nil && existed {
+			remote, _ = s3.ReadFileContent(bucket, key)
+		}
+		if remote == string(local) {
+			continue
+		}
+		diffs[key] = unifiedDiff(key, remote, string(local))
+		changed = append(changed, f)
+		for _, d := range generated {
+			if strings.HasPrefix(d, f+".") {
+				changed = append(changed, d)
+			}
+		}
+	}
+	for _, f := range metaFiles[META_FILE_DEL_KEY] {
+		if path.Base(f) != MAVEN_METADATA_FILE {
+			continue
+		}
+		key := path.Join(prefix, f)
+		if existed, err := s3.FileExistsInBucket(bucket, key); err != nil || !existed {
+			continue
+		}
+		remote, _ := s3.ReadFileContent(bucket, key)
+		diffs[key] = unifiedDiff(key, remote, "")
+		deleted = append(deleted, f)
+		deleted = append(deleted, hashDecorateMetadata(path.Dir(f), MAVEN_METADATA_FILE)...)
+	}
+	return changed, deleted, diffs
+}
+
+func unifiedDiff(name, remote, local string) string {
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(remote),
+		B:        difflib.SplitLines(local),
+		FromFile: "remote/" + name,
+		ToFile:   "local/" + name,
+		Context:  3,
+	})
+	return diff
+}
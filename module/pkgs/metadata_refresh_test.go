@@ -0,0 +1,92 @@
+package pkgs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestRefreshMetadatas(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-refresh-test-*")
+	defer os.RemoveAll(root)
+	prefix := "ga"
+	objects := []string{
+		"ga/org/foo/bar/1.0/bar-1.0.pom",
+		"ga/org/foo/bar/1.1/bar-1.1.pom",
+		"ga/org/foo/bar/baz/2.0/baz-2.0.pom",
+		"ga/org/foo/bar/2.0-SNAPSHOT/bar-2.0-20240101.120000-1.pom",
+		"ga/org/foo/bar/2.0-SNAPSHOT/bar-2.0-20240101.120000-1.jar",
+		"ga/org/foo/bar/2.0-SNAPSHOT/bar-2.0-20240102.120000-2.pom",
+		"ga/org/foo/bar/2.0-SNAPSHOT/bar-2.0-20240102.120000-2.jar",
+		"ga/org/foo/bar/2.0-SNAPSHOT/bar-2.0-20240102.120000-2.jar.sha1",
+		"ga/org/foo/bar/2.0-SNAPSHOT/bar-2.0-20240101.120000-1-sources.jar",
+	}
+	barMeta := &MavenMetadata{GroupId: "org.foo", ArtifactId: "bar",
+		versions: []string{"1.0", "1.1", "2.0-SNAPSHOT"}}
+	barContent, _ := barMeta.GenerateMetaFileContent()
+	remote := map[string]string{
+		// Up to date
+		"ga/org/foo/bar/maven-metadata.xml": barContent,
+		// Stale one which should be deleted
+		"ga/org/foo/gone/maven-metadata.xml": "<metadata/>",
+	}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			contents := []types.Object{}
+			for _, o := range objects {
+				if strings.HasPrefix(o, *params.Prefix) {
+					contents = append(contents, types.Object{Key: aws.String(o)})
+				}
+			}
+			return &s3.ListObjectsV2Output{Contents: contents}, nil
+		},
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if _, ok := remote[*params.Key]; ok {
+				return &s3.HeadObjectOutput{}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(remote[*params.Key]))}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	metaFiles := refreshMetadatas(*s3client, []string{"org.foo:bar", "org.foo:gone"}, []string{"org/foo/bar/baz/"},
+		storage.TEST_BUCKET, prefix, root)
+	assert.Equal(t, 0, len(metaFiles[META_FILE_FAILED]))
+	assert.Contains(t, metaFiles[META_FILE_GEN_KEY], path.Join(root, "org/foo/bar/maven-metadata.xml"))
+	assert.Contains(t, metaFiles[META_FILE_GEN_KEY], path.Join(root, "org/foo/bar/baz/maven-metadata.xml"))
+	assert.Contains(t, metaFiles[META_FILE_DEL_KEY], "org/foo/gone/maven-metadata.xml")
+
+	snapshotMeta := path.Join(root, "org/foo/bar/2.0-SNAPSHOT/maven-metadata.xml")
+	assert.Contains(t, metaFiles[META_FILE_GEN_KEY], snapshotMeta)
+	content, _ := files.ReadFile(snapshotMeta)
+	assert.Contains(t, content, "<timestamp>20240102.120000</timestamp>")
+	assert.Contains(t, content, "<buildNumber>2</buildNumber>")
+	assert.Contains(t, content, "<lastUpdated>20240102120000</lastUpdated>")
+	assert.Contains(t, content, "<classifier>sources</classifier>\n        <extension>jar</extension>\n        <value>2.0-20240101.120000-1</value>")
+	assert.Contains(t, content, "<extension>jar</extension>\n        <value>2.0-20240102.120000-2</value>")
+	assert.Contains(t, content, "<extension>pom</extension>\n        <value>2.0-20240102.120000-2</value>")
+
+	changed, deleted, diffs := diffRemoteMetadatas(*s3client, metaFiles, storage.TEST_BUCKET, prefix, root)
+	assert.NotContains(t, changed, path.Join(root, "org/foo/bar/maven-metadata.xml"))
+	assert.Contains(t, changed, path.Join(root, "org/foo/bar/baz/maven-metadata.xml"))
+	assert.Contains(t, changed, path.Join(root, "org/foo/bar/baz/maven-metadata.xml.sha1"))
+	assert.Contains(t, changed, snapshotMeta)
+	assert.Equal(t, []string{"org/foo/gone/maven-metadata.xml", "org/foo/gone/maven-metadata.xml.md5",
+		"org/foo/gone/maven-metadata.xml.sha1", "org/foo/gone/maven-metadata.xml.sha256"}, deleted)
+	assert.Equal(t, 3, len(diffs))
+	assert.Contains(t, diffs["ga/org/foo/bar/baz/maven-metadata.xml"], "+    <release>2.0</release>")
+	assert.Contains(t, diffs["ga/org/foo/gone/maven-metadata.xml"], "-<metadata/>")
+}
@@ -0,0 +1,305 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/util"
+	"org.commonjava/charon/module/util/files"
+	"org.commonjava/charon/module/util/httpc"
+)
+
+// Handle the mirroring of the GAVs from an upstream maven repository, like
+// a patched dependency from an internal Nexus, into the targets.
+//   - repoURL is the base url of the upstream maven repository
+//   - gavs are the coordinates to mirror, like groupId:artifactId:version
+//   - withDeps is used to mirror the parent and the compile and runtime
+//     dependencies of the GAVs too, recursively
+//   - prodKey is the product which the mirrored files belong to
+//   - targets contains the target name with its bucket name and prefix
+//     for the bucket, which will be used to store artifacts with the
+//     prefix. See target definition in Charon configuration for details
+//   - dir_ is base dir for staging the fetched files, will use system
+//     tmp dir if empty.
+//
+// The pom, the artifacts with classifiers and their digest files of each GAV
+// are fetched into a maven repository layout in the work dir, and the
+// published digests are verified against the fetched files. Then the files
+// are uploaded to the targets with the same process as HandleMavenUploading,
+// so the uploading can also be resumed from the work dir.
+//
+// Returns the work dir and if the mirroring is successful
+func HandleMavenMirror(
+	ctx context.Context,
+	repoURL string,
+	gavs []string,
+	withDeps bool,
+	prodKey string,
+	targets []config.Target,
+	awsProfile,
+	dir_ string,
+	doIndex,
+	genSign,
+	cfEnable bool,
+	key string,
+	dryRun bool,
+	manifestBucketName,
+	configFilePath,
+	reportFile string,
+) (string, bool) {
+	report := newRunReport("mirror", prodKey, repoURL, dryRun)
+	succeeded := false
+	defer func() { report.finish(succeeded, reportFile) }()
+
+	workDir, err := os.MkdirTemp(dir_, "charon-mirror-*")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create work dir for mirroring due to error: %s", err))
+		return "", false
+	}
+
+	// step 1. fetch the GAVs into the maven repository layout
+	start := time.Now()
+	logger.Info(fmt.Sprintf("Start fetching %d GAVs from %s", len(gavs), repoURL))
+	mirrored, err := fetchMavenGAVs(ctx, repoURL, gavs, withDeps, path.Join(workDir, "maven-repository"))
+	report.timed("fetch", start)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not fetch the GAVs from %s due to error: %s", repoURL, err))
+		return workDir, false
+	}
+	logger.Info(fmt.Sprintf("Fetching done, %d GAVs are staged in %s\n", len(mirrored), workDir))
+
+	// step 2. upload the staged files as a product release
+	params := uploadParams{
+		Repo:           repoURL,
+		ProdKey:        prodKey,
+		Root:           "maven-repository",
+		Targets:        targets,
+		DoIndex:        doIndex,
+		GenSign:        genSign,
+		GenChecksum:    true,
+		CFEnable:       cfEnable,
+		Key:            key,
+		ManifestBucket: manifestBucketName,
+		ConfigFile:     configFilePath,
+	}
+	succeeded = uploadMavenJournaled(ctx, params, workDir, awsProfile, dryRun, report)
+	return workDir, succeeded
+}
+
+// Fetch the GAVs from the repoURL into root with maven repository layout.
+// Returns the GAVs fetched, including the dependencies if withDeps is set.
+func fetchMavenGAVs(ctx context.Context, repoURL string, gavs []string, withDeps bool,
+	root string) ([]string, error) {
+	m := &mavenMirror{
+		repoURL:  strings.TrimSuffix(repoURL, "/"),
+		root:     root,
+		withDeps: withDeps,
+		queued:   map[[3]string]bool{},
+	}
+	for _, g := range gavs {
+		gav, err := parseGAVCoordinate(g)
+		if err != nil {
+			return nil, err
+		}
+		m.enqueue(gav)
+	}
+	fetched := []string{}
+	for len(m.queue) > 0 {
+		gav := m.queue[0]
+		m.queue = m.queue[1:]
+		coordinate := strings.Join(gav[:], ":")
+		if interrupted(ctx, "fetching of "+coordinate) {
+			return fetched, fmt.Errorf("the fetching is interrupted before %s", coordinate)
+		}
+		if err := m.fetchGAV(gav); err != nil {
+			return fetched, err
+		}
+		fetched = append(fetched, coordinate)
+	}
+	return fetched, nil
+}
+
+// Parse the coordinate like groupId:artifactId:version
+func parseGAVCoordinate(coordinate string) ([3]string, error) {
+	parts := strings.Split(strings.TrimSpace(coordinate), ":")
+	if len(parts) != 3 || util.IsBlankString(parts[0]) ||
+		util.IsBlankString(parts[1]) || util.IsBlankString(parts[2]) {
+		return [3]string{}, fmt.Errorf("invalid GAV %s, should be groupId:artifactId:version", coordinate)
+	}
+	return [3]string{parts[0], parts[1], parts[2]}, nil
+}
+
+// mavenMirror fetches the GAVs from an upstream repository one by one, the
+// dependencies found in the poms are queued if withDeps is set.
+type mavenMirror struct {
+	repoURL  string
+	root     string
+	withDeps bool
+	queue    [][3]string
+	queued   map[[3]string]bool
+}
+
+func (m *mavenMirror) enqueue(gav [3]string) {
+	if !m.queued[gav] {
+		m.queued[gav] = true
+		m.queue = append(m.queue, gav)
+	}
+}
+
+// Fetch the pom and the artifacts of the GAV with their digest files. The
+// artifacts are found from the listing of the version folder, if the
+// repository does not support listing, the main artifact of the packaging
+// and the sources and javadoc jars are tried.
+func (m *mavenMirror) fetchGAV(gav [3]string) error {
+	logger.Info(fmt.Sprintf("Fetching %s", strings.Join(gav[:], ":")))
+	dir := path.Join(strings.ReplaceAll(gav[0], ".", "/"), gav[1], gav[2])
+	base := fmt.Sprintf("%s-%s", gav[1], gav[2])
+	pomPath := path.Join(dir, base+".pom")
+	if err := m.fetchVerified(pomPath, false); err != nil {
+		return err
+	}
+	pom, err := parsePom(path.Join(m.root, pomPath))
+	if err != nil {
+		return err
+	}
+
+	if listed, ok := m.listVersionFiles(dir, base); ok {
+		for _, name := range listed {
+			if err := m.fetchVerified(path.Join(dir, name), false); err != nil {
+				return err
+			}
+		}
+	} else {
+		packaging := strings.TrimSpace(pom.Packaging)
+		switch packaging {
+		case "", "bundle", "maven-plugin":
+			packaging = "jar"
+		}
+		if packaging != "pom" {
+			if err := m.fetchVerified(path.Join(dir, base+"."+packaging), false); err != nil {
+				return err
+			}
+		}
+		for _, classifier := range []string{"sources", "javadoc"} {
+			if err := m.fetchVerified(path.Join(dir, base+"-"+classifier+".jar"), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.withDeps {
+		if pom.Parent != nil {
+			m.enqueue([3]string{strings.TrimSpace(pom.Parent.GroupId),
+				strings.TrimSpace(pom.Parent.ArtifactId), strings.TrimSpace(pom.Parent.Version)})
+		}
+		for _, dep := range pom.Dependencies {
+			scope := strings.TrimSpace(dep.Scope)
+			if (scope != "" && scope != "compile" && scope != "runtime") ||
+				strings.TrimSpace(dep.Optional) == "true" {
+				continue
+			}
+			depGAV := resolveDependency(pom, gav, dep)
+			if util.IsBlankString(depGAV[2]) || !isResolved(strings.Join(depGAV[:], ":")) {
+				// The managed versions from the parents or BOMs are not resolved
+				logger.Warn(fmt.Sprintf("Can not resolve the version of dependency %s:%s of %s, skipped",
+					depGAV[0], depGAV[1], strings.Join(gav[:], ":")))
+				continue
+			}
+			m.enqueue(depGAV)
+		}
+	}
+	return nil
+}
+
+// Resolve the coordinate of the dependency with the properties of the pom
+func resolveDependency(pom *MavenPom, gav [3]string, dep MavenPomDependency) [3]string {
+	project := strings.NewReplacer(
+		"${project.groupId}", gav[0], "${pom.groupId}", gav[0],
+		"${project.version}", gav[2], "${pom.version}", gav[2], "${version}", gav[2])
+	resolve := func(v string) string {
+		return pom.resolve(project.Replace(strings.TrimSpace(v)))
+	}
+	return [3]string{resolve(dep.GroupId), resolve(dep.ArtifactId), resolve(dep.Version)}
+}
+
+// List the files of the GAV from the html listing of its version folder,
+// the pom and the digest files are not included as they are fetched along
+// with the artifacts. Returns false if the folder can not be listed.
+func (m *mavenMirror) listVersionFiles(dir, base string) ([]string, bool) {
+	content, _, ok := httpc.HTTPRequest(m.repoURL+"/"+dir+"/", httpc.MethodGet, nil, true, nil, nil, "")
+	if !ok || util.IsBlankString(content) {
+		return nil, false
+	}
+	digestSuffixes := map[string]bool{}
+	for _, suffix := range files.DIGEST_SUFFIXES {
+		digestSuffixes[suffix] = true
+	}
+	listed := []string{}
+	seen := map[string]bool{}
+	for _, match := range indexHrefPattern.FindAllStringSubmatch(content, -1) {
+		name := path.Base(strings.TrimSuffix(match[1], "/"))
+		if seen[name] || name == base+".pom" || digestSuffixes[path.Ext(name)] ||
+			(!strings.HasPrefix(name, base+".") && !strings.HasPrefix(name, base+"-")) {
+			continue
+		}
+		seen[name] = true
+		listed = append(listed, name)
+	}
+	if len(listed) == 0 {
+		// A pom only GAV, or the listing is not in the expected format
+		return nil, false
+	}
+	return listed, true
+}
+
+// Fetch the file with its digest files, and verify the digests published for
+// the file. If optional is set, a missing file is not an error.
+func (m *mavenMirror) fetchVerified(filePath string, optional bool) error {
+	local := path.Join(m.root, filePath)
+	if files.IsFile(local) {
+		return nil
+	}
+	if err := m.download(filePath); err != nil {
+		var httpErr httpc.HTTPError
+		if optional && errors.As(err, &httpErr) && httpErr.StatusCode == httpc.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("can not fetch %s: %w", filePath, err)
+	}
+	verified := 0
+	for _, h := range []crypto.Hash{files.SHA1, files.MD5, files.SHA256, files.SHA512} {
+		digestPath := filePath + files.DIGEST_SUFFIXES[h]
+		if err := m.download(digestPath); err != nil {
+			var httpErr httpc.HTTPError
+			if errors.As(err, &httpErr) && httpErr.StatusCode == httpc.StatusNotFound {
+				continue
+			}
+			return fmt.Errorf("can not fetch %s: %w", digestPath, err)
+		}
+		expected, err := files.ReadDigestFile(path.Join(m.root, digestPath))
+		if err != nil {
+			return err
+		}
+		if actual := files.Digest(local, h); actual != expected {
+			return fmt.Errorf("digest of %s is %s but %s is published in %s",
+				filePath, actual, expected, digestPath)
+		}
+		verified++
+	}
+	if verified == 0 {
+		logger.Warn(fmt.Sprintf("No digest is published for %s, the digest files will be generated", filePath))
+	}
+	return nil
+}
+
+func (m *mavenMirror) download(filePath string) error {
+	_, err := httpc.DownloadFile(m.repoURL+"/"+filePath, path.Join(m.root, filePath), nil)
+	return err
+}
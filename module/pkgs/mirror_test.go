@@ -0,0 +1,95 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestMavenMirrorFetching(t *testing.T) {
+	appPom := `<project><groupId>org.foo</groupId><artifactId>app</artifactId><version>1.0</version>
+<properties><lib.version>2.0</lib.version></properties>
+<dependencies>
+<dependency><groupId>${project.groupId}</groupId><artifactId>lib</artifactId><version>${lib.version}</version></dependency>
+<dependency><groupId>junit</groupId><artifactId>junit</artifactId><version>4.13</version><scope>test</scope></dependency>
+<dependency><groupId>org.bar</groupId><artifactId>managed</artifactId></dependency>
+</dependencies></project>`
+	libPom := `<project><groupId>org.foo</groupId><artifactId>lib</artifactId><version>2.0</version></project>`
+	served := map[string]string{
+		"/org/foo/app/1.0/app-1.0.pom":         appPom,
+		"/org/foo/app/1.0/app-1.0.pom.sha1":    files.DigestContent(appPom, crypto.SHA1),
+		"/org/foo/app/1.0/app-1.0.jar":         "app jar",
+		"/org/foo/app/1.0/app-1.0.jar.sha1":    files.DigestContent("app jar", crypto.SHA1) + "  app-1.0.jar",
+		"/org/foo/app/1.0/app-1.0.jar.md5":     files.DigestContent("app jar", crypto.MD5),
+		"/org/foo/app/1.0/app-1.0-sources.jar": "app sources",
+		"/org/foo/lib/2.0/lib-2.0.pom":         libPom,
+		"/org/foo/lib/2.0/lib-2.0.jar":         "lib jar",
+		"/org/foo/lib/2.0/lib-2.0.jar.sha1":    files.DigestContent("lib jar", crypto.SHA1),
+		"/org/foo/lib/2.0/lib-2.0-tests.jar":   "lib tests",
+		"/org/foo/bad/1.0/bad-1.0.pom":         "<project/>",
+		"/org/foo/bad/1.0/bad-1.0.pom.sha1":    files.DigestContent("changed", crypto.SHA1),
+		"/org/foo/lib/2.0/": `<a href="../">../</a><a href="lib-2.0.pom">lib-2.0.pom</a>` +
+			`<a href="lib-2.0.jar">lib-2.0.jar</a><a href="lib-2.0.jar.sha1">lib-2.0.jar.sha1</a>` +
+			`<a href="/repo/org/foo/lib/2.0/lib-2.0-tests.jar">lib-2.0-tests.jar</a>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := served[strings.TrimPrefix(r.URL.Path, "/repo")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/") {
+			w.Header().Set("Content-Type", "text/html")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		io.WriteString(w, content)
+	}))
+	defer server.Close()
+	repoURL := server.URL + "/repo/"
+
+	// Without dependencies only the GAV itself is fetched
+	root := path.Join(t.TempDir(), "maven-repository")
+	fetched, err := fetchMavenGAVs(context.Background(), repoURL, []string{"org.foo:app:1.0"}, false, root)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"org.foo:app:1.0"}, fetched)
+	for _, f := range []string{"app-1.0.pom", "app-1.0.pom.sha1", "app-1.0.jar", "app-1.0.jar.sha1",
+		"app-1.0.jar.md5", "app-1.0-sources.jar"} {
+		assert.True(t, files.IsFile(path.Join(root, "org/foo/app/1.0", f)), f)
+	}
+	// The optional javadoc jar is not published
+	assert.False(t, files.FileOrDirExists(path.Join(root, "org/foo/app/1.0/app-1.0-javadoc.jar")))
+	assert.False(t, files.FileOrDirExists(path.Join(root, "org/foo/lib")))
+
+	// The dependencies are fetched with the classifiers from the folder listing,
+	// the test and unresolved dependencies are skipped
+	root = path.Join(t.TempDir(), "maven-repository")
+	fetched, err = fetchMavenGAVs(context.Background(), repoURL, []string{"org.foo:app:1.0"}, true, root)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"org.foo:app:1.0", "org.foo:lib:2.0"}, fetched)
+	for _, f := range []string{"lib-2.0.pom", "lib-2.0.jar", "lib-2.0.jar.sha1", "lib-2.0-tests.jar"} {
+		assert.True(t, files.IsFile(path.Join(root, "org/foo/lib/2.0", f)), f)
+	}
+	content, _ := files.ReadFile(path.Join(root, "org/foo/lib/2.0/lib-2.0-tests.jar"))
+	assert.Equal(t, "lib tests", content)
+	// The staged files are scanned as a maven repository for uploading
+	scanned := scanPaths(nil, path.Dir(root), "maven-repository")
+	assert.Equal(t, root, scanned.topLevel)
+	assert.Len(t, scanned.poms, 2)
+
+	// The digests published must match the fetched files
+	_, err = fetchMavenGAVs(context.Background(), repoURL, []string{"org.foo:bad:1.0"}, false, t.TempDir())
+	assert.NotNil(t, err)
+	_, err = fetchMavenGAVs(context.Background(), repoURL, []string{"org.foo:missing:1.0"}, false, t.TempDir())
+	assert.NotNil(t, err)
+	_, err = fetchMavenGAVs(context.Background(), repoURL, []string{"org.foo:app"}, false, t.TempDir())
+	assert.NotNil(t, err)
+}
@@ -1,7 +1,9 @@
 package pkgs
 
 import (
+	"context"
 	"fmt"
+	"path"
 	"strings"
 
 	"org.commonjava/charon/module/config"
@@ -11,7 +13,8 @@ import (
 func IsMetadata(file string) bool {
 	return isMVNMetadata(file) ||
 		isNPMMetadata(file) ||
-		strings.HasSuffix(file, "index.html")
+		strings.HasSuffix(file, "index.html") ||
+		path.Base(file) == "index.json"
 }
 
 func isMVNMetadata(file string) bool {
@@ -23,17 +26,46 @@ func isNPMMetadata(file string) bool {
 	return strings.HasSuffix(strings.TrimSpace(file), "package.json")
 }
 
-func uploadPostProcess(
+const RETRY_CLIENT_S3 = "s3"
+
+// The name of the CloudFront client of the target in the retry counts, as
+// each target uses its own CloudFront client
+func retryClientCF(bucket string) string {
+	return "cloudfront " + bucket
+}
+
+// Get a copy of the target with the slashes around its prefix removed, so
+// the prefix can be joined with the paths to get the keys in the bucket.
+func fixTarget(target config.Target) config.Target {
+	t := target
+	t.Prefix = strings.Trim(target.Prefix, "/")
+	return t
+}
+
+// Check if the run is interrupted, like by SIGINT or SIGTERM. The work
+// described by skipped will not be started then.
+func interrupted(ctx context.Context, skipped string) bool {
+	if err := ctx.Err(); err != nil {
+		logger.Warn(fmt.Sprintf("The run is interrupted (%s), skipping %s", err, skipped))
+		return true
+	}
+	return false
+}
+
+func uploadPostProcess(errs *ErrorCollector, s3Client *storage.S3Client,
 	failedFiles, failedMetas []string, productKey, bucket string) {
-	postProcess(failedFiles, failedMetas, productKey, "uploaded to", bucket)
+	postProcess(errs, s3Client, failedFiles, failedMetas, productKey, "uploaded to", bucket)
 }
 
-func rollbackPostProcess(
+func rollbackPostProcess(errs *ErrorCollector, s3Client *storage.S3Client,
 	failedFiles, failedMetas []string, productKey, bucket string) {
-	postProcess(failedFiles, failedMetas, productKey, "rolled back from", bucket)
+	postProcess(errs, s3Client, failedFiles, failedMetas, productKey, "rolled back from", bucket)
 }
 
-func postProcess(failedFiles, failedMetas []string, productKey, operation, bucket string) {
+func postProcess(errs *ErrorCollector, s3Client *storage.S3Client,
+	failedFiles, failedMetas []string, productKey, operation, bucket string) {
+	errs.addFailures(s3Client, bucket, failedFiles, failedMetas)
+	errs.SetRetries(RETRY_CLIENT_S3, s3Client.RetryCounts())
 	if len(failedFiles) == 0 && len(failedMetas) == 0 {
 		logger.Info(
 			fmt.Sprintf("Product release %s is successfully %s Ronda service in bucket %s",
@@ -41,8 +73,8 @@ func postProcess(failedFiles, failedMetas []string, productKey, operation, bucke
 	} else {
 		total := len(failedFiles) + len(failedMetas)
 		logger.Error(
-			fmt.Sprintf("%d file(s) occur errors/warnings in bucket %s, please see errors.log for details.",
-				total, bucket))
+			fmt.Sprintf("%d file(s) occur errors/warnings in bucket %s, please see %s for details.",
+				total, bucket, errs.LogFile()))
 		logger.Error(
 			fmt.Sprintf("Product release %s is %s Ronda service in bucket %s, but has some failures as below:",
 				productKey, operation, bucket))
@@ -53,72 +85,73 @@ func postProcess(failedFiles, failedMetas []string, productKey, operation, bucke
 			logger.Error(fmt.Sprintf("Failed metadata files: \n%s\n", failedMetas))
 		}
 	}
+	errs.report(bucket)
 }
 
+// Invalidate the CloudFront cache of the paths for the target. Returns
+// all the invalidations created.
 func invalidateCFPaths(cfClient *storage.CFCLient,
 	target config.Target, invalidatePaths []string,
-	root string, batchSize int) {
-
+	root string, batchSize int) []storage.Invalidation {
+	logger.Info("Invalidating CF cache for " + target.Bucket)
+	finalPaths := cfPaths(target, invalidatePaths, root)
+	logger.Debug(fmt.Sprintf("Invalidating paths: %s, size: %d", finalPaths, len(finalPaths)))
+	domain := target.Domain
+	if domain == "" {
+		domain = cfClient.GetDomainByBucket(target.Bucket)
+	}
+	if domain == "" {
+		logger.Error(fmt.Sprintf(
+			"CF invalidating will not be performed because domain not found for bucket %s.", target.Bucket))
+		return nil
+	}
+	distrId, err := cfClient.GetDistIdByDomain(domain)
+	if err != nil || distrId == "" {
+		logger.Error(fmt.Sprintf(
+			"CF invalidating will not be performed because distribution not found for domain %s.", domain))
+		return nil
+	}
+	realBatchSize := batchSize
+	for _, p := range finalPaths {
+		if strings.HasSuffix(p, "*") {
+			realBatchSize = storage.INVALIDATION_BATCH_WILDCARD
+			break
+		}
+	}
+	result, err := cfClient.InvalidatePaths(distrId, finalPaths, realBatchSize)
+	if err != nil {
+		logger.Error(fmt.Sprintf("CF invalidating is not finished due to error: %s", err))
+	}
+	output := map[string][]string{}
+	nonCompleted := map[string][]string{}
+	for _, inv := range result {
+		output[inv.Status] = append(output[inv.Status], inv.Id)
+		if inv.Status != storage.INVALIDATION_STATUS_COMPLETED {
+			nonCompleted[inv.Status] = append(nonCompleted[inv.Status], inv.Id)
+		}
+	}
+	if len(result) > 0 {
+		logger.Info(fmt.Sprintf("The CF invalidating requests done, following requests are not completed yet:\n %s\n",
+			nonCompleted))
+		logger.Debug(fmt.Sprintf("All invalidations requested in this process:\n %s", output))
+	}
+	return result
 }
 
-// def invalidate_cf_paths(
-//     cf_client: CFClient,
-//     bucket: Tuple[str, str, str, str, str],
-//     invalidate_paths: List[str],
-//     root="/",
-//     batch_size=INVALIDATION_BATCH_DEFAULT
-// ):
-//     logger.info("Invalidating CF cache for %s", bucket[1])
-//     bucket_name = bucket[1]
-//     prefix = bucket[2]
-//     prefix = "/" + prefix if not prefix.startswith("/") else prefix
-//     domain = bucket[4]
-//     slash_root = root
-//     if not root.endswith("/"):
-//         slash_root = slash_root + "/"
-//     final_paths = []
-//     for full_path in invalidate_paths:
-//         path = full_path
-//         if path.startswith(slash_root):
-//             path = path[len(slash_root):]
-//         if prefix:
-//             path = os.path.join(prefix, path)
-//         final_paths.append(path)
-//     logger.debug("Invalidating paths: %s, size: %s", final_paths, len(final_paths))
-//     if not domain:
-//         domain = cf_client.get_domain_by_bucket(bucket_name)
-//     if domain:
-//         distr_id = cf_client.get_dist_id_by_domain(domain)
-//         if distr_id:
-//             real_batch_size = batch_size
-//             for path in final_paths:
-//                 if path.endswith('*'):
-//                     real_batch_size = INVALIDATION_BATCH_WILDCARD
-//                     break
-//             result = cf_client.invalidate_paths(
-//                 distr_id, final_paths, real_batch_size
-//             )
-//             if result:
-//                 output = {}
-//                 for invalidation in result:
-//                     status = invalidation.get('Status')
-//                     if status not in output:
-//                         output[status] = []
-//                     output[status].append(invalidation["Id"])
-//                 non_completed = {}
-//                 for status, ids in output.items():
-//                     if status != INVALIDATION_STATUS_COMPLETED:
-//                         non_completed[status] = ids
-//                 logger.info(
-//                     "The CF invalidating requests done, following requests "
-//                     "are not completed yet:\n %s\nPlease use 'cf check' command to "
-//                     "check its details.", non_completed
-//                 )
-//                 logger.debug(
-//                     "All invalidations requested in this process:\n %s", output
-//                 )
-//     else:
-//         logger.error(
-//             "CF invalidating will not be performed because domain not found for"
-//             " bucket %s. ", bucket_name
-//         )
+// Get the CloudFront paths of the files for the target. The paths are cut
+// down by root and prefixed with the prefix of the target.
+func cfPaths(target config.Target, fullPaths []string, root string) []string {
+	prefix := target.Prefix
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	slashRoot := root
+	if !strings.HasSuffix(root, "/") {
+		slashRoot = slashRoot + "/"
+	}
+	result := []string{}
+	for _, fullPath := range fullPaths {
+		result = append(result, path.Join(prefix, strings.TrimPrefix(fullPath, slashRoot)))
+	}
+	return result
+}
@@ -0,0 +1,187 @@
+package pkgs
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"org.commonjava/charon/module/util"
+)
+
+var pomPropertyPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// This MavenPom represents the parts of a pom.xml which are needed to
+// identify the coordinates of an artifact and its dependencies.
+type MavenPom struct {
+	Parent       *MavenPomParent      `xml:"parent"`
+	GroupId      string               `xml:"groupId"`
+	ArtifactId   string               `xml:"artifactId"`
+	Version      string               `xml:"version"`
+	Packaging    string               `xml:"packaging"`
+	Properties   pomProperties        `xml:"properties"`
+	Dependencies []MavenPomDependency `xml:"dependencies>dependency"`
+}
+
+type MavenPomParent struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	Version    string `xml:"version"`
+}
+
+type MavenPomDependency struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	Version    string `xml:"version"`
+	Scope      string `xml:"scope"`
+	Optional   string `xml:"optional"`
+}
+
+type pomProperties struct {
+	Entries []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+// Parse the pom file to get its coordinates.
+func parsePom(pomPath string) (*MavenPom, error) {
+	f, err := os.Open(pomPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pom := &MavenPom{}
+	if err := xml.NewDecoder(f).Decode(pom); err != nil {
+		return nil, fmt.Errorf("can not parse pom %s: %w", pomPath, err)
+	}
+	return pom, nil
+}
+
+// Get the groupId, artifactId and version of the pom. The groupId and version
+// will be inherited from the parent if they are not declared in the pom itself.
+// Simple property references like ${revision} will be resolved from the pom
+// properties, and the ones which can not be resolved will be kept as they are.
+func (p *MavenPom) GAV() [3]string {
+	groupId := strings.TrimSpace(p.GroupId)
+	version := strings.TrimSpace(p.Version)
+	if p.Parent != nil {
+		if util.IsBlankString(groupId) {
+			groupId = strings.TrimSpace(p.Parent.GroupId)
+		}
+		if util.IsBlankString(version) {
+			version = strings.TrimSpace(p.Parent.Version)
+		}
+	}
+	return [3]string{
+		p.resolve(groupId),
+		p.resolve(strings.TrimSpace(p.ArtifactId)),
+		p.resolve(version),
+	}
+}
+
+func (p *MavenPom) resolve(value string) string {
+	props := map[string]string{}
+	for _, e := range p.Properties.Entries {
+		props[e.XMLName.Local] = strings.TrimSpace(e.Value)
+	}
+	if p.Parent != nil {
+		props["project.parent.groupId"] = strings.TrimSpace(p.Parent.GroupId)
+		props["project.parent.version"] = strings.TrimSpace(p.Parent.Version)
+		props["parent.version"] = strings.TrimSpace(p.Parent.Version)
+	}
+	return pomPropertyPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		if v, ok := props[ref[2:len(ref)-1]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+func isResolved(value string) bool {
+	return !strings.Contains(value, "${")
+}
+
+// The error for the pom whose coordinates do not match its path. Such poms
+// must not be used for the metadata generation.
+type pomCoordinateError struct {
+	msg string
+}
+
+func (e *pomCoordinateError) Error() string {
+	return e.msg
+}
+
+// Parse the GAV from the pom content and cross-check it with the GAV parsed
+// from the pom path. Returns the GAV from the pom and a *pomCoordinateError
+// describing the mismatches if the pom is not located in the path of its
+// coordinates. If the pom content can not be read or parsed, the GAV from the
+// path will be returned together with the read or parse error. The pom which
+// is not in a GAV path is reported as a *pomCoordinateError without GAV.
+func parseAndCheckGAV(pomPath, root string) ([3]string, error) {
+	if !isGAVPath(pomPath, root) {
+		return [3]string{}, &pomCoordinateError{
+			fmt.Sprintf("pom %s is not in a groupId/artifactId/version path", pomPath)}
+	}
+	pathGAV := parseGAV(pomPath, root)
+	pom, err := parsePom(pomPath)
+	if err != nil {
+		return pathGAV, err
+	}
+	pomGAV := pom.GAV()
+	fields := []string{"groupId", "artifactId", "version"}
+	mismatches := []string{}
+	for i, field := range fields {
+		if !isResolved(pomGAV[i]) {
+			// Can not decide the real value, so trust the path
+			pomGAV[i] = pathGAV[i]
+			continue
+		}
+		if pomGAV[i] != pathGAV[i] {
+			mismatches = append(mismatches,
+				fmt.Sprintf("%s is %s in pom but %s in path", field, pomGAV[i], pathGAV[i]))
+		}
+	}
+	if len(mismatches) > 0 {
+		return pomGAV, &pomCoordinateError{fmt.Sprintf("pom %s does not match its path: %s",
+			pomPath, strings.Join(mismatches, ", "))}
+	}
+	return pomGAV, nil
+}
+
+// Give a list of local pom files and parse the maven groupId, artifactId and version
+// from the pom contents. The result will be a dict like {groupId: {artifactId: [versions list]}}.
+// The poms whose coordinates do not match their paths will be skipped, as they
+// will corrupt the maven-metadata.xml. Poms which can not be read or parsed will
+// fall back to use the coordinates from their paths.
+func parseLocalGAVs(pomPaths []string, root string) map[string]map[string][]string {
+	validPoms := []string{}
+	for _, pom := range pomPaths {
+		if _, err := parseAndCheckGAV(pom, root); err != nil {
+			var coordErr *pomCoordinateError
+			if errors.As(err, &coordErr) {
+				logger.Warn(fmt.Sprintf("Skipping pom for metadata generation: %s", err))
+				continue
+			}
+			if !os.IsNotExist(err) {
+				logger.Warn(fmt.Sprintf("Using path coordinates for metadata generation: %s", err))
+			}
+		}
+		validPoms = append(validPoms, pom)
+	}
+	return parseGAVs(validPoms, root)
+}
+
+// Check all poms to see if their coordinates match their paths. Returns the
+// error messages for the poms which do not match.
+func checkPomCoordinates(pomPaths []string, root string) []string {
+	errMsgs := []string{}
+	for _, pom := range pomPaths {
+		if _, err := parseAndCheckGAV(pom, root); err != nil {
+			errMsgs = append(errMsgs, err.Error())
+		}
+	}
+	return errMsgs
+}
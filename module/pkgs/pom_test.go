@@ -0,0 +1,68 @@
+package pkgs
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestParseAndCheckGAV(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-test-*")
+	defer os.RemoveAll(root)
+	inherited := path.Join(root, "org/foo/bar/1.0.0/bar-1.0.0.pom")
+	files.StoreFile(inherited, `<project xmlns="http://maven.apache.org/POM/4.0.0">
+  <parent>
+    <groupId>org.foo</groupId>
+    <artifactId>foo-parent</artifactId>
+    <version>1.0.0</version>
+  </parent>
+  <artifactId>bar</artifactId>
+</project>`, true)
+	gav, err := parseAndCheckGAV(inherited, root)
+	assert.Nil(t, err)
+	assert.Equal(t, [3]string{"org.foo", "bar", "1.0.0"}, gav)
+
+	property := path.Join(root, "org/foo/baz/2.0.0/baz-2.0.0.pom")
+	files.StoreFile(property, `<project>
+  <groupId>org.foo</groupId>
+  <artifactId>baz</artifactId>
+  <version>${revision}</version>
+  <properties>
+    <revision>2.0.0</revision>
+  </properties>
+</project>`, true)
+	gav, err = parseAndCheckGAV(property, root)
+	assert.Nil(t, err)
+	assert.Equal(t, [3]string{"org.foo", "baz", "2.0.0"}, gav)
+
+	misplaced := path.Join(root, "org/foo/bar/1.0.1/bar-1.0.1.pom")
+	files.StoreFile(misplaced, `<project>
+  <groupId>org.foo</groupId>
+  <artifactId>bar</artifactId>
+  <version>1.0.2</version>
+</project>`, true)
+	gav, err = parseAndCheckGAV(misplaced, root)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "version is 1.0.2 in pom but 1.0.1 in path")
+	assert.Equal(t, [3]string{"org.foo", "bar", "1.0.2"}, gav)
+
+	malformed := path.Join(root, "org/foo/qux/3.0.0/qux-3.0.0.pom")
+	files.StoreFile(malformed, `<project><groupId>org.foo</groupId>`, true)
+	gav, err = parseAndCheckGAV(malformed, root)
+	assert.NotNil(t, err)
+	assert.Equal(t, [3]string{"org.foo", "qux", "3.0.0"}, gav)
+
+	gavs := parseLocalGAVs([]string{inherited, property, misplaced, malformed}, root)
+	assert.Equal(t, []string{"1.0.0"}, gavs["org.foo"]["bar"])
+	assert.Equal(t, []string{"2.0.0"}, gavs["org.foo"]["baz"])
+	assert.Equal(t, []string{"3.0.0"}, gavs["org.foo"]["qux"])
+
+	msgs, passed := validateMaven([]string{inherited, property, misplaced}, root, "",
+		map[string]string{RULE_POM_COORDINATES: config.SEVERITY_ERROR})
+	assert.False(t, passed)
+	assert.Equal(t, 1, len(msgs))
+}
@@ -0,0 +1,104 @@
+package pkgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+)
+
+// Handle the migration of the .prodinfo sidecars in the targets to the
+// product info mode configured for the targets, like the object tags or
+// metadata. The products of each sidecar are merged into the new product
+// info of its file, and the sidecar is deleted after the new product info
+// is verified. The orphan sidecars whose files do not exist are kept.
+//   - subPath is the path in the targets to migrate, all the sidecars in
+//     the targets will be migrated if it is empty
+//
+// Returns if all the sidecars are migrated
+func HandleProdInfoMigration(
+	ctx context.Context,
+	targets []config.Target,
+	subPath,
+	awsProfile string,
+	dryRun bool,
+) bool {
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return false
+	}
+	succeeded := true
+	for _, target := range targets {
+		if interrupted(ctx, "product info migration for bucket "+target.Bucket) {
+			return false
+		}
+		t := fixTarget(target)
+		bucketName := t.Bucket
+		if t.ProductInfo == "" || t.ProductInfo == config.PRODUCT_INFO_SIDECAR {
+			logger.Error(fmt.Sprintf("The product_info of bucket %s is not set to %s or %s, nothing to migrate to",
+				bucketName, config.PRODUCT_INFO_TAGGING, config.PRODUCT_INFO_METADATA))
+			succeeded = false
+			continue
+		}
+		s3Client.SetProductInfoMode(bucketName, t.ProductInfo)
+		prefix := strings.Trim(path.Join(t.Prefix, subPath), "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+
+		logger.Info(fmt.Sprintf("Start migrating product info under %s in bucket %s to %s",
+			prefix, bucketName, t.ProductInfo))
+		sidecars, ok := s3Client.GetFiles(bucketName, prefix, util.PROD_INFO_SUFFIX)
+		if !ok {
+			succeeded = false
+			continue
+		}
+		migrated, orphans, failed := 0, []string{}, []string{}
+		for i, sidecar := range sidecars {
+			if interrupted(ctx, "product info migration for the rest files in bucket "+bucketName) {
+				succeeded = false
+				break
+			}
+			file := strings.TrimSuffix(sidecar, util.PROD_INFO_SUFFIX)
+			prods, err := s3Client.MigrateProductInfo(file, bucketName)
+			switch {
+			case errors.Is(err, storage.ErrOrphanProductInfo):
+				logger.Warn(fmt.Sprintf("(%d/%d) Kept orphan %s as its file does not exist",
+					i+1, len(sidecars), sidecar))
+				orphans = append(orphans, sidecar)
+			case err != nil:
+				logger.Error(fmt.Sprintf("(%d/%d) Can not migrate %s: %s", i+1, len(sidecars), sidecar, err))
+				failed = append(failed, sidecar)
+			default:
+				logger.Debug(fmt.Sprintf("(%d/%d) Migrated %s with products %s", i+1, len(sidecars), sidecar, prods))
+				migrated++
+			}
+		}
+		logger.Info(fmt.Sprintf("Product info migration done for bucket %s: %d migrated, %d orphans kept, %d failed\n",
+			bucketName, migrated, len(orphans), len(failed)))
+		if len(failed) > 0 {
+			succeeded = false
+			continue
+		}
+
+		// Verify no sidecar is left except the orphans
+		if !dryRun && ctx.Err() == nil {
+			left, ok := s3Client.GetFiles(bucketName, prefix, util.PROD_INFO_SUFFIX)
+			left = slices.DeleteFunc(left, func(s string) bool { return slices.Contains(orphans, s) })
+			if !ok || len(left) > 0 {
+				logger.Error(fmt.Sprintf("Verification failed, these sidecars are still left in bucket %s: %s",
+					bucketName, left))
+				succeeded = false
+			}
+		}
+	}
+	return succeeded
+}
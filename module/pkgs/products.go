@@ -0,0 +1,168 @@
+package pkgs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+)
+
+const (
+	PRODUCT_FILES_SOURCE_MANIFEST = "manifest"
+	PRODUCT_FILES_SOURCE_SCAN     = "scan"
+)
+
+// The products which own a file in a target
+type FileProducts struct {
+	Bucket   string   `json:"bucket"`
+	Key      string   `json:"key"`
+	Exists   bool     `json:"exists"`
+	Products []string `json:"products"`
+}
+
+// The files of a product in a target. The files are the paths relative to
+// the prefix of the target, and the source tells if they are read from the
+// manifest or scanned from the product info of the files.
+type ProductFiles struct {
+	Bucket  string   `json:"bucket"`
+	Prefix  string   `json:"prefix"`
+	Product string   `json:"product"`
+	Source  string   `json:"source"`
+	Files   []string `json:"files"`
+}
+
+// Handle the query of the products which own the file in the targets
+//   - filePath is the path of the file in the maven repository, like
+//     org/foo/bar/1.0/bar-1.0.jar
+//
+// Returns the products of the file in each target, and if the query succeeded
+func HandleProductsOf(
+	ctx context.Context,
+	filePath string,
+	targets []config.Target,
+	awsProfile string,
+) ([]FileProducts, bool) {
+	s3Client, err := storage.NewS3Client(ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, true)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return nil, false
+	}
+	results := []FileProducts{}
+	for _, t := range targets {
+		if interrupted(ctx, "product query in bucket "+t.Bucket) {
+			return results, false
+		}
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		key := strings.TrimPrefix(path.Join(strings.Trim(t.Prefix, "/"), strings.TrimPrefix(filePath, "/")), "/")
+		result := FileProducts{Bucket: t.Bucket, Key: key, Products: []string{}}
+		existed, err := s3Client.FileExistsInBucket(t.Bucket, key)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not check file %s in bucket %s due to error: %s", key, t.Bucket, err))
+			return results, false
+		}
+		if existed {
+			result.Exists = true
+			if prods, ok := s3Client.GetProductInfo(key, t.Bucket); ok {
+				result.Products = prods
+			}
+		}
+		results = append(results, result)
+	}
+	return results, true
+}
+
+// Handle the query of the files of the product in the targets. The files are
+// read from the manifest of the product in the manifest bucket. If there is
+// no manifest, the files under subPath of the target are scanned for their
+// product info, which needs a request for each file.
+//
+// Returns the files of the product in each target, and if the query succeeded
+func HandleProductFiles(
+	ctx context.Context,
+	product string,
+	targets []config.Target,
+	awsProfile,
+	manifestBucketName,
+	subPath string,
+) ([]ProductFiles, bool) {
+	s3Client, err := storage.NewS3Client(ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, true)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return nil, false
+	}
+	results := []ProductFiles{}
+	for _, t := range targets {
+		if interrupted(ctx, "product query in bucket "+t.Bucket) {
+			return results, false
+		}
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		prefix := strings.Trim(t.Prefix, "/")
+		result := ProductFiles{Bucket: t.Bucket, Prefix: prefix, Product: product}
+		if !util.IsBlankString(manifestBucketName) {
+			paths, ok, err := s3Client.ReadManifest(product, t.Bucket, manifestBucketName)
+			if err != nil {
+				logger.Error(fmt.Sprintf("Can not read manifest of %s for bucket %s due to error: %s",
+					product, t.Bucket, err))
+				return results, false
+			}
+			if ok {
+				result.Source = PRODUCT_FILES_SOURCE_MANIFEST
+				result.Files = paths
+				results = append(results, result)
+				continue
+			}
+		}
+		logger.Warn(fmt.Sprintf("No manifest of %s for bucket %s, scanning the product info of the files",
+			product, t.Bucket))
+		files, ok := scanProductFiles(ctx, s3Client, product, t, subPath)
+		if !ok {
+			return results, false
+		}
+		result.Source = PRODUCT_FILES_SOURCE_SCAN
+		result.Files = files
+		results = append(results, result)
+	}
+	return results, true
+}
+
+// Scan the files under subPath of the target which are owned by the product.
+// Only the .prodinfo files need to be listed in sidecar mode, otherwise all
+// the files are checked.
+func scanProductFiles(ctx context.Context, s3Client *storage.S3Client, product string,
+	t config.Target, subPath string) ([]string, bool) {
+	prefix := strings.Trim(t.Prefix, "/")
+	scanPrefix := strings.Trim(path.Join(prefix, subPath), "/")
+	if scanPrefix != "" {
+		scanPrefix += "/"
+	}
+	sidecarMode := t.ProductInfo == "" || t.ProductInfo == config.PRODUCT_INFO_SIDECAR
+	suffix := ""
+	if sidecarMode {
+		suffix = util.PROD_INFO_SUFFIX
+	}
+	keys, ok := s3Client.GetFiles(t.Bucket, scanPrefix, suffix)
+	if !ok {
+		return nil, false
+	}
+	files := []string{}
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			logger.Warn("Scanning is interrupted")
+			return files, false
+		}
+		if sidecarMode {
+			key = strings.TrimSuffix(key, util.PROD_INFO_SUFFIX)
+		} else if strings.HasSuffix(key, util.PROD_INFO_SUFFIX) {
+			continue
+		}
+		if prods, ok := s3Client.GetProductInfo(key, t.Bucket); ok && slices.Contains(prods, product) {
+			files = append(files, strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/"))
+		}
+	}
+	return files, true
+}
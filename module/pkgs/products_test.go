@@ -0,0 +1,52 @@
+package pkgs
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+)
+
+func TestScanProductFiles(t *testing.T) {
+	remote := map[string]string{
+		"ga/org/foo/foo.jar":          "",
+		"ga/org/foo/foo.jar.prodinfo": "foo-1.0,bar-1.0",
+		"ga/org/foo/bar.jar":          "",
+		"ga/org/foo/bar.jar.prodinfo": "bar-1.0",
+		"ga/org/baz/baz.jar":          "",
+		"ga/org/baz/baz.jar.prodinfo": "foo-1.0",
+	}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			contents := []types.Object{}
+			for k := range remote {
+				if strings.HasPrefix(k, aws.ToString(params.Prefix)) {
+					contents = append(contents, types.Object{Key: aws.String(k)})
+				}
+			}
+			slices.SortFunc(contents, func(a, b types.Object) int { return strings.Compare(*a.Key, *b.Key) })
+			return &s3.ListObjectsV2Output{Contents: contents}, nil
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(remote[*params.Key]))}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	target := config.Target{Bucket: storage.TEST_BUCKET, Prefix: "/ga"}
+	files, ok := scanProductFiles(context.Background(), s3client, "foo-1.0", target, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"org/baz/baz.jar", "org/foo/foo.jar"}, files)
+
+	files, ok = scanProductFiles(context.Background(), s3client, "foo-1.0", target, "org/foo")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"org/foo/foo.jar"}, files)
+}
@@ -0,0 +1,312 @@
+package pkgs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+	"org.commonjava/charon/module/util/files"
+)
+
+// Handle the promotion of a product from the source target to the targets,
+// like from stage to ga, without uploading the archive again.
+//   - prodKey is the product to promote, its files are enumerated from its
+//     manifest of the source in manifestBucketName
+//   - source is the target which the product is promoted from
+//   - targets contains the target name with its bucket name and prefix
+//     which the product is promoted to
+//   - dir_ is base dir for holding the generated files, will use system
+//     tmp dir if empty.
+//
+// The files are copied with server side copies which keep their checksum
+// metadata, and the product is added to their product info. Then the
+// maven-metadata.xml, archetype-catalog.xml and index.html are regenerated
+// in the targets, and the manifest of the product is uploaded for the
+// targets. The files already promoted are not copied again, so the promotion
+// can be done again safely after a failure.
+//
+// Returns the directory used for generated files and if the promotion is successful
+func HandleMavenPromotion(
+	ctx context.Context,
+	prodKey string,
+	source config.Target,
+	targets []config.Target,
+	awsProfile,
+	dir_,
+	manifestBucketName string,
+	doIndex,
+	cfEnable,
+	dryRun bool,
+) (string, bool) {
+	if util.IsBlankString(manifestBucketName) {
+		logger.Error("No manifest bucket is provided, can not enumerate the files of the product")
+		return "", false
+	}
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return "", false
+	}
+	workDir, err := os.MkdirTemp(dir_, "charon-promote-*")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create work dir for promotion due to error: %s", err))
+		return "", false
+	}
+	ok := promoteMaven(ctx, s3Client, prodKey, source, targets, workDir, awsProfile,
+		manifestBucketName, doIndex, cfEnable)
+	return workDir, ok
+}
+
+// Promote the product from the source to the targets with the s3 client, the
+// generated files are stored in workDir. Returns if the promotion is successful.
+func promoteMaven(ctx context.Context, s3Client *storage.S3Client, prodKey string,
+	source config.Target, targets []config.Target, workDir, awsProfile, manifestBucketName string,
+	doIndex, cfEnable bool) bool {
+	src := fixTarget(source)
+	s3Client.SetProductInfoMode(src.Bucket, src.ProductInfo)
+
+	// step 1. enumerate the files of the product from its manifest
+	paths, ok, err := s3Client.ReadManifest(prodKey, src.Bucket, manifestBucketName)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not read the manifest of product %s due to error: %s", prodKey, err))
+		return false
+	}
+	if !ok || len(paths) == 0 {
+		logger.Error(fmt.Sprintf("No manifest of product %s found for bucket %s", prodKey, src.Bucket))
+		return false
+	}
+	root := path.Join(workDir, "maven-repository")
+	errs := NewErrorCollector(workDir)
+
+	// The metadata are regenerated in the targets instead of being copied
+	promoted, poms := []string{}, []string{}
+	dirSet := map[string]bool{root: true}
+	withCatalog := false
+	for _, p := range paths {
+		if path.Base(p) == MAVEN_ARCH_FILE {
+			withCatalog = true
+		}
+		if IsMetadata(p) || (isVerificationFile(p) && IsMetadata(strings.TrimSuffix(p, path.Ext(p)))) {
+			continue
+		}
+		fullPath := path.Join(root, p)
+		promoted = append(promoted, fullPath)
+		if path.Ext(p) == ".pom" {
+			poms = append(poms, fullPath)
+		}
+		for dir := path.Dir(fullPath); dir != root && !dirSet[dir]; dir = path.Dir(dir) {
+			dirSet[dir] = true
+		}
+	}
+	dirs := make([]string, 0, len(dirSet))
+	for d := range dirSet {
+		dirs = append(dirs, d)
+	}
+	slices.Sort(dirs)
+	logger.Info(fmt.Sprintf("%d files of product %s are found in the manifest of bucket %s",
+		len(promoted), prodKey, src.Bucket))
+
+	// step 2. download the promoted poms and plugin jars, which are needed to
+	// generate the maven-metadata.xml of the targets
+	failedDownloads := downloadPromotedPoms(s3Client, src, poms, promoted, root)
+	for _, failed := range failedDownloads {
+		errs.Add(src.Bucket, ERROR_CATEGORY_METADATA, strings.TrimPrefix(failed, root+"/"),
+			"can not download the pom or plugin jar for metadata generation")
+	}
+
+	// step 3. collect the archetypes of the product from the source catalog
+	if withCatalog && !promotedArchetypeCatalog(s3Client, src, poms, root) {
+		errs.Add(src.Bucket, ERROR_CATEGORY_METADATA, MAVEN_ARCH_FILE,
+			"can not collect the archetypes of the product")
+	}
+
+	// The metadata generated without the poms or plugin jars may be incomplete
+	succeeded := len(failedDownloads) == 0
+	for _, target := range targets {
+		if interrupted(ctx, "promotion to bucket "+target.Bucket) {
+			succeeded = false
+			continue
+		}
+		t := fixTarget(target)
+		bucketName := t.Bucket
+		if bucketName == src.Bucket && t.Prefix == src.Prefix {
+			logger.Error(fmt.Sprintf("Can not promote product %s to its source bucket %s", prodKey, bucketName))
+			succeeded = false
+			continue
+		}
+		s3Client.SetProductInfoMode(bucketName, t.ProductInfo)
+		cfInvalidatePaths := []string{}
+
+		// step 4. copy the files to the target
+		logger.Info(fmt.Sprintf("Start promoting files of product %s from bucket %s to bucket %s",
+			prodKey, src.Bucket, bucketName))
+		failedFiles := s3Client.PromoteFiles(promoted, src, t, prodKey, root)
+		logger.Info("Files promoting done\n")
+		if interrupted(ctx, "metadata and index updating for bucket "+bucketName) {
+			promotePostProcess(errs, s3Client, failedFiles, nil, prodKey, bucketName)
+			succeeded = false
+			continue
+		}
+
+		// step 5. upload the manifest for the target
+		logger.Info("Start uploading manifest to s3 bucket " + manifestBucketName)
+		manifestUploaded := true
+		manifestName, manifestFullPath, err := files.WriteManifest(paths, root, prodKey)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not write manifest %s due to error: %s", manifestName, err))
+			errs.Add(bucketName, ERROR_CATEGORY_FILE, manifestName, fmt.Sprintf("can not write manifest: %s", err))
+			manifestUploaded = false
+		} else if s3Client.UploadManifest(manifestName, manifestFullPath, bucketName, manifestBucketName) {
+			logger.Info("Manifest uploading is done\n")
+		} else {
+			errs.Add(bucketName, ERROR_CATEGORY_FILE, manifestName, "manifest uploading failed")
+			manifestUploaded = false
+		}
+
+		// step 6. regenerate maven-metadata.xml with the poms in the target
+		logger.Info("Start generating maven-metadata.xml files for bucket " + bucketName)
+		metaFiles := generateMetadatas(*s3Client, poms, bucketName, t.Prefix, root)
+		logger.Info("maven-metadata.xml files generation done\n")
+		failedMetas := metaFiles[META_FILE_FAILED]
+		if v, ok := metaFiles[META_FILE_GEN_KEY]; ok {
+			logger.Info("Start updating maven-metadata.xml to s3 bucket " + bucketName)
+			failedMetas = append(failedMetas, s3Client.UploadMetadatas(v, t, "", root)...)
+			logger.Info(fmt.Sprintf("maven-metadata.xml updating done in bucket %s\n", bucketName))
+			cfInvalidatePaths = append(cfInvalidatePaths, v...)
+		}
+
+		// step 7. merge the archetypes of the product into the target catalog
+		if withCatalog {
+			logger.Info("Start generating archetype-catalog.xml for bucket " + bucketName)
+			if generateUploadArchetypeCatalog(s3Client, bucketName, root, t.Prefix) {
+				archetypeFiles := append([]string{path.Join(root, MAVEN_ARCH_FILE)},
+					hashDecorateMetadata(root, MAVEN_ARCH_FILE)...)
+				logger.Info("Start updating archetype-catalog.xml to s3 bucket " + bucketName)
+				failedMetas = append(failedMetas, s3Client.UploadMetadatas(archetypeFiles, t, "", root)...)
+				cfInvalidatePaths = append(cfInvalidatePaths, archetypeFiles...)
+			}
+			logger.Info(fmt.Sprintf("archetype-catalog.xml updating done in bucket %s\n", bucketName))
+		}
+
+		// step 8. regenerate the index.html of the promoted folders
+		if doIndex {
+			logger.Info("Start generating index files to s3 bucket " + bucketName)
+			createdIndex := generateIndexes(*s3Client, dirs, PACKAGE_TYPE_MAVEN, root, bucketName,
+				t.Prefix, t.IndexJson)
+			logger.Info("Index files generation done.\n")
+			logger.Info("Start updating index files to s3 bucket " + bucketName)
+			failedMetas = append(failedMetas, s3Client.UploadMetadatas(createdIndex, t, prodKey, root)...)
+			logger.Info("Index files updating done\n")
+		} else {
+			logger.Info("Bypass indexing")
+		}
+
+		// step 9. do the CF invalidating for metadata files
+		if cfEnable && len(cfInvalidatePaths) > 0 {
+			cfClient, err := storage.NewCFClient(ctx, awsProfile)
+			if err != nil {
+				logger.Error(
+					fmt.Sprintf("Cannot do Cloudfront cache invalidating due to error: %s", err))
+			} else {
+				cfInvalidatePaths = wildcardMetadataPaths(cfInvalidatePaths)
+				invalidateCFPaths(cfClient, t, cfInvalidatePaths, root, storage.INVALIDATION_BATCH_DEFAULT)
+				errs.SetRetries(retryClientCF(bucketName), cfClient.RetryCounts())
+			}
+		}
+
+		promotePostProcess(errs, s3Client, failedFiles, failedMetas, prodKey, bucketName)
+		succeeded = succeeded && len(failedFiles) <= 0 && len(failedMetas) <= 0 && manifestUploaded
+	}
+	return succeeded
+}
+
+// Download the poms from the source to root, and the jars of the poms which
+// are maven plugins, as the plugin prefixes are read from the descriptors in
+// the jars. Returns the paths which can not be downloaded.
+func downloadPromotedPoms(s3Client *storage.S3Client, source config.Target, poms, promoted []string,
+	root string) []string {
+	download := func(fullPath string) error {
+		key := path.Join(source.Prefix, strings.TrimPrefix(fullPath, root+"/"))
+		content, err := s3Client.ReadFileContent(source.Bucket, key)
+		if err != nil {
+			return err
+		}
+		return files.StoreFile(fullPath, content, true)
+	}
+	promotedSet := map[string]bool{}
+	for _, p := range promoted {
+		promotedSet[p] = true
+	}
+	var lock sync.Mutex
+	failed := []string{}
+	g := new(errgroup.Group)
+	g.SetLimit(storage.DEFAULT_CONCURRENT_LIMIT)
+	for _, pom := range poms {
+		pom := pom
+		g.Go(func() error {
+			fails := []string{}
+			if err := download(pom); err != nil {
+				fails = append(fails, pom)
+			} else if p, err := parsePom(pom); err == nil && strings.TrimSpace(p.Packaging) == "maven-plugin" {
+				jar := strings.TrimSuffix(pom, ".pom") + ".jar"
+				if promotedSet[jar] && download(jar) != nil {
+					fails = append(fails, jar)
+				}
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			failed = append(failed, fails...)
+			return nil
+		})
+	}
+	g.Wait()
+	slices.Sort(failed)
+	return failed
+}
+
+func promotePostProcess(errs *ErrorCollector, s3Client *storage.S3Client,
+	failedFiles, failedMetas []string, productKey, bucket string) {
+	postProcess(errs, s3Client, failedFiles, failedMetas, productKey, "promoted to", bucket)
+}
+
+// Write the archetypes of the promoted poms in the catalog of the source to
+// the local archetype-catalog.xml in root, so that they can be merged into
+// the catalogs of the targets. Returns false if the catalog of the source
+// can not be read.
+func promotedArchetypeCatalog(s3Client *storage.S3Client, source config.Target, poms []string, root string) bool {
+	remote := path.Join(source.Prefix, MAVEN_ARCH_FILE)
+	content, err := s3Client.ReadFileContent(source.Bucket, remote)
+	if err != nil {
+		return false
+	}
+	archetypes, err := parseArchetypes(content)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to parse archetype-catalog.xml in bucket %s: %s", source.Bucket, err))
+		return false
+	}
+	promoted := []ArchetypeRef{}
+	for _, pom := range poms {
+		if !isGAVPath(pom, root) {
+			continue
+		}
+		gav := parseGAV(pom, root)
+		ref := ArchetypeRef{GroupId: gav[0], ArtifactId: gav[1], Version: gav[2]}
+		if i := slices.IndexFunc(archetypes, ref.sameGAV); i >= 0 {
+			promoted = append(promoted, archetypes[i])
+		}
+	}
+	if len(promoted) == 0 {
+		logger.Info("No archetypes of the product found in archetype-catalog.xml of bucket " + source.Bucket)
+		return true
+	}
+	return writeArchetypeCatalog(path.Join(root, MAVEN_ARCH_FILE), promoted)
+}
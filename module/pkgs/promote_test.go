@@ -0,0 +1,117 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestMavenPromotion(t *testing.T) {
+	remote := newMemS3()
+	source := config.Target{Bucket: "stage"}
+	target := config.Target{Bucket: "ga", Prefix: "/ga"}
+	catalog := func(archetypes ...ArchetypeRef) string {
+		c := NewMavenArchetypeCatalog(archetypes)
+		content, err := c.GenerateMetaFileContent()
+		assert.Nil(t, err)
+		return content
+	}
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.pom", "pom-1.0")
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.jar", "jar-1.0")
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.jar.sha1", files.DigestContent("jar-1.0", crypto.SHA1))
+	remote.put("stage", "org/foo/bar/1.0/bar-1.0.jar.prodinfo", "foo-1.0,foo-1.0-ER1")
+	remote.put("stage", "org/foo/bar/maven-metadata.xml", "stage metadata")
+	pluginJar := path.Join(t.TempDir(), "foo-maven-plugin-1.0.jar")
+	createPluginJar(t, pluginJar, `<plugin>
+  <name>Foo Maven Plugin</name>
+  <groupId>org.foo</groupId>
+  <artifactId>foo-maven-plugin</artifactId>
+  <version>1.0</version>
+  <goalPrefix>foo</goalPrefix>
+</plugin>`)
+	pluginJarContent, _ := os.ReadFile(pluginJar)
+	remote.put("stage", "org/foo/foo-maven-plugin/1.0/foo-maven-plugin-1.0.pom", `<project>
+  <groupId>org.foo</groupId>
+  <artifactId>foo-maven-plugin</artifactId>
+  <version>1.0</version>
+  <packaging>maven-plugin</packaging>
+</project>`)
+	remote.put("stage", "org/foo/foo-maven-plugin/1.0/foo-maven-plugin-1.0.jar", string(pluginJarContent))
+	remote.put("stage", "archetype-catalog.xml", catalog(
+		ArchetypeRef{GroupId: "org.foo", ArtifactId: "bar", Version: "1.0"},
+		ArchetypeRef{GroupId: "org.baz", ArtifactId: "baz", Version: "2.0"}))
+	remote.put("manifests", "stage-charon-metadata/foo-1.0"+util.MANIFEST_SUFFIX, strings.Join([]string{
+		"org/foo/bar/1.0/bar-1.0.pom", "org/foo/bar/1.0/bar-1.0.jar", "org/foo/bar/1.0/bar-1.0.jar.sha1",
+		"org/foo/bar/maven-metadata.xml", "archetype-catalog.xml",
+		"org/foo/foo-maven-plugin/1.0/foo-maven-plugin-1.0.pom",
+		"org/foo/foo-maven-plugin/1.0/foo-maven-plugin-1.0.jar"}, "\n"))
+	remote.put("ga", "ga/org/foo/bar/0.9/bar-0.9.pom", "pom-0.9")
+	remote.put("ga", "ga/org/foo/bar/0.9/bar-0.9.pom.prodinfo", "foo-0.9")
+	remote.put("ga", "ga/archetype-catalog.xml", catalog(
+		ArchetypeRef{GroupId: "org.foo", ArtifactId: "qux", Version: "0.9"}))
+
+	promote := func() bool {
+		return promoteMaven(context.Background(), remote.client(t), "foo-1.0", source,
+			[]config.Target{target}, t.TempDir(), "", "manifests", true, false)
+	}
+	assert.True(t, promote())
+
+	jar, ok := remote.get("ga", "ga/org/foo/bar/1.0/bar-1.0.jar")
+	assert.True(t, ok)
+	assert.Equal(t, "jar-1.0", jar.content)
+	assert.Equal(t, files.DigestContent("jar-1.0", crypto.SHA1), jar.meta[storage.CHECKSUM_META_KEY])
+	prodInfo, _ := remote.get("ga", "ga/org/foo/bar/1.0/bar-1.0.jar.prodinfo")
+	assert.Equal(t, "foo-1.0", prodInfo.content)
+	_, ok = remote.get("ga", "ga/org/foo/bar/1.0/bar-1.0.jar.sha1")
+	assert.True(t, ok)
+	meta, _ := remote.get("ga", "ga/org/foo/bar/maven-metadata.xml")
+	assert.Contains(t, meta.content, "<version>0.9</version>")
+	assert.Contains(t, meta.content, "<version>1.0</version>")
+	groupMeta, _ := remote.get("ga", "ga/org/foo/maven-metadata.xml")
+	assert.Contains(t, groupMeta.content, "<prefix>foo</prefix>")
+	assert.Contains(t, groupMeta.content, "<artifactId>foo-maven-plugin</artifactId>")
+	arch, _ := remote.get("ga", "ga/archetype-catalog.xml")
+	assert.Contains(t, arch.content, "<artifactId>qux</artifactId>")
+	assert.Contains(t, arch.content, "<artifactId>bar</artifactId>")
+	assert.NotContains(t, arch.content, "<artifactId>baz</artifactId>")
+	index, _ := remote.get("ga", "ga/org/foo/bar/index.html")
+	assert.Contains(t, index.content, `href="0.9/"`)
+	assert.Contains(t, index.content, `href="1.0/"`)
+	manifest, ok := remote.get("manifests", "ga-charon-metadata/foo-1.0"+util.MANIFEST_SUFFIX)
+	assert.True(t, ok)
+	assert.Contains(t, manifest.content, "org/foo/bar/1.0/bar-1.0.jar")
+
+	// The promotion can be done again without any change
+	promoted := map[string]string{}
+	for _, k := range remote.keys("ga") {
+		o, _ := remote.get("ga", k)
+		promoted[k] = o.content
+	}
+	assert.True(t, promote())
+	again := map[string]string{}
+	for _, k := range remote.keys("ga") {
+		o, _ := remote.get("ga", k)
+		again[k] = o.content
+	}
+	assert.Equal(t, promoted, again)
+
+	// The promotion fails if the manifest can not be uploaded
+	remote.failPut = util.MANIFEST_SUFFIX
+	assert.False(t, promote())
+	remote.failPut = ""
+
+	// The file in the target with different content is not overwritten
+	remote.put("ga", "ga/org/foo/bar/1.0/bar-1.0.pom", "another pom-1.0")
+	assert.False(t, promote())
+	pom, _ := remote.get("ga", "ga/org/foo/bar/1.0/bar-1.0.pom")
+	assert.Equal(t, "another pom-1.0", pom.content)
+}
@@ -0,0 +1,210 @@
+package pkgs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util"
+	"org.commonjava/charon/module/util/files"
+)
+
+// This MavenRelocation represents the relocation of an artifact from the old
+// coordinates to the new ones, which will be published as a relocation pom
+// under the old coordinates.
+type MavenRelocation struct {
+	OldGroupId    string
+	OldArtifactId string
+	OldVersion    string
+	NewGroupId    string
+	NewArtifactId string
+	NewVersion    string
+	Message       string
+}
+
+// Create the relocation from the GAVs like "org.foo:bar:1.0". The new
+// groupId, artifactId or version can be omitted by leaving it empty, like
+// "org.baz::", which means it's the same as the old one.
+func NewMavenRelocation(oldGAV, newGAV, message string) (*MavenRelocation, error) {
+	oldParts := strings.Split(oldGAV, ":")
+	if len(oldParts) != 3 || slicesHasBlank(oldParts) {
+		return nil, fmt.Errorf("invalid old GAV %s, should be like groupId:artifactId:version", oldGAV)
+	}
+	newParts := strings.Split(newGAV, ":")
+	if len(newParts) != 3 {
+		return nil, fmt.Errorf("invalid new GAV %s, should be like groupId:artifactId:version", newGAV)
+	}
+	for i, p := range newParts {
+		if util.IsBlankString(p) {
+			newParts[i] = oldParts[i]
+		}
+	}
+	if strings.Join(oldParts, ":") == strings.Join(newParts, ":") {
+		return nil, fmt.Errorf("the new GAV %s is the same as the old one", newGAV)
+	}
+	return &MavenRelocation{
+		OldGroupId:    oldParts[0],
+		OldArtifactId: oldParts[1],
+		OldVersion:    oldParts[2],
+		NewGroupId:    newParts[0],
+		NewArtifactId: newParts[1],
+		NewVersion:    newParts[2],
+		Message:       message,
+	}, nil
+}
+
+func slicesHasBlank(values []string) bool {
+	for _, v := range values {
+		if util.IsBlankString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MavenRelocation) String() string {
+	return fmt.Sprintf("%s:%s:%s -> %s:%s:%s", r.OldGroupId, r.OldArtifactId, r.OldVersion,
+		r.NewGroupId, r.NewArtifactId, r.NewVersion)
+}
+
+func (r *MavenRelocation) GenerateRelocationPomContent() (string, error) {
+	t := template.Must(template.New("relocation").Parse(RELOCATION_POM_TEMPLATE))
+	var buf bytes.Buffer
+	err := t.Execute(&buf, r)
+	if err != nil {
+		logger.Error(fmt.Sprintf("executing template: %s", err))
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// The path of the pom under the old or new coordinates in the root
+func relocationPomPath(root, groupId, artifactId, version string) string {
+	gPath := strings.Join(strings.Split(groupId, "."), "/")
+	return path.Join(fixRoot(root), gPath, artifactId, version,
+		fmt.Sprintf("%s-%s.pom", artifactId, version))
+}
+
+// Generate the relocation pom with its digest files under the root.
+// Returns the relocation pom and its digest files.
+func genRelocationPom(r *MavenRelocation, root string) ([]string, error) {
+	content, err := r.GenerateRelocationPomContent()
+	if err != nil {
+		return []string{}, err
+	}
+	pomPath := relocationPomPath(root, r.OldGroupId, r.OldArtifactId, r.OldVersion)
+	if err := files.StoreFile(pomPath, content, true); err != nil {
+		return []string{}, err
+	}
+	return append([]string{pomPath}, genAllDigestFiles(pomPath)...), nil
+}
+
+// Handle the maven relocation process, which publishes a relocation pom
+// under the old coordinates pointing to the new ones.
+//   - relocation contains the old and new GAVs
+//   - prodKey is used to track the relocation pom as part of the product
+//   - targets contains the target name with its bucket name and prefix
+//   - dir_ is base dir for holding the generated files, will use system
+//     tmp dir if empty.
+//
+// The maven-metadata.xml of both the old and new GAs will be refreshed
+// from the poms in the buckets.
+//
+// Returns the directory used for generated files and if the relocation is successful
+func HandleMavenRelocation(
+	ctx context.Context,
+	relocation *MavenRelocation,
+	prodKey string,
+	targets []config.Target,
+	awsProfile,
+	dir_ string,
+	cfEnable bool,
+	dryRun bool,
+) (string, bool) {
+	workDir, err := os.MkdirTemp(dir_, "charon-relocation-*")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create work dir for relocation due to error: %s", err))
+		return "", false
+	}
+	root := path.Join(workDir, "maven-repository")
+	errs := NewErrorCollector(workDir)
+
+	// step 1. generate the relocation pom with its digests
+	logger.Info("Generating relocation pom for " + relocation.String())
+	pomFiles, err := genRelocationPom(relocation, root)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not generate relocation pom due to error: %s", err))
+		return workDir, false
+	}
+	oldPom := pomFiles[0]
+	newPom := relocationPomPath(root, relocation.NewGroupId, relocation.NewArtifactId, relocation.NewVersion)
+
+	s3Client, err := storage.NewS3Client(
+		ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, dryRun)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return workDir, false
+	}
+	succeeded := true
+	for _, target := range targets {
+		if interrupted(ctx, "relocation in bucket "+target.Bucket) {
+			succeeded = false
+			continue
+		}
+		t := fixTarget(target)
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		bucketName := t.Bucket
+		cfInvalidatePaths := []string{}
+
+		// step 2. upload the relocation pom under the old coordinates
+		logger.Info("Start uploading relocation pom to s3 bucket " + bucketName)
+		failedFiles := s3Client.UploadFiles(pomFiles, []config.Target{t}, prodKey, root)[bucketName]
+		logger.Info("Relocation pom uploading done\n")
+
+		// step 3. refresh maven-metadata.xml for both old and new GAs. The new
+		// pom does not exist locally, so its GA is collected from its path.
+		logger.Info("Start generating maven-metadata.xml files for bucket " + bucketName)
+		metaFiles := generateMetadatas(*s3Client, []string{oldPom, newPom}, bucketName, t.Prefix, root)
+		logger.Info("maven-metadata.xml files generation done\n")
+		failedMetas := metaFiles[META_FILE_FAILED]
+		if v, ok := metaFiles[META_FILE_DEL_KEY]; ok {
+			logger.Info("Start deleting stale maven-metadata.xml from s3 bucket " + bucketName)
+			failedMetas = append(failedMetas, s3Client.DeleteFiles(v, t, "", root)...)
+			logger.Info(fmt.Sprintf("maven-metadata.xml deletion done in bucket %s\n", bucketName))
+			if cfEnable {
+				cfInvalidatePaths = append(cfInvalidatePaths, v...)
+			}
+		}
+		if v, ok := metaFiles[META_FILE_GEN_KEY]; ok {
+			logger.Info("Start updating maven-metadata.xml to s3 bucket " + bucketName)
+			failedMetas = append(failedMetas, s3Client.UploadMetadatas(v, t, "", root)...)
+			logger.Info(fmt.Sprintf("maven-metadata.xml updating done in bucket %s\n", bucketName))
+			if cfEnable {
+				cfInvalidatePaths = append(cfInvalidatePaths, v...)
+			}
+		}
+
+		// step 4. do the CF invalidating for metadata files
+		if cfEnable && len(cfInvalidatePaths) > 0 {
+			cfClient, err := storage.NewCFClient(ctx, awsProfile)
+			if err != nil {
+				logger.Error(
+					fmt.Sprintf("Cannot do Cloudfront cache invalidating due to error: %s", err))
+			} else {
+				cfInvalidatePaths = wildcardMetadataPaths(cfInvalidatePaths)
+				invalidateCFPaths(cfClient, t, cfInvalidatePaths, root, storage.INVALIDATION_BATCH_DEFAULT)
+				errs.SetRetries(retryClientCF(t.Bucket), cfClient.RetryCounts())
+			}
+		}
+
+		uploadPostProcess(errs, s3Client, failedFiles, failedMetas, prodKey, bucketName)
+		succeeded = succeeded && len(failedFiles) <= 0 && len(failedMetas) <= 0
+	}
+	return workDir, succeeded
+}
@@ -0,0 +1,38 @@
+package pkgs
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestMavenRelocation(t *testing.T) {
+	_, err := NewMavenRelocation("org.foo:bar", "org.baz:bar:1.0", "")
+	assert.NotNil(t, err)
+	_, err = NewMavenRelocation("org.foo:bar:1.0", "::", "")
+	assert.NotNil(t, err)
+
+	relocation, err := NewMavenRelocation("org.foo:bar:1.0", "org.baz::", "moved to org.baz & renamed")
+	assert.Nil(t, err)
+	assert.Equal(t, "org.foo:bar:1.0 -> org.baz:bar:1.0", relocation.String())
+
+	root, _ := os.MkdirTemp("", "charon-relocation-test-*")
+	defer os.RemoveAll(root)
+	pomFiles, err := genRelocationPom(relocation, root)
+	assert.Nil(t, err)
+	pom := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	assert.Equal(t, pom, pomFiles[0])
+	assert.Contains(t, pomFiles, pom+".sha1")
+	assert.Contains(t, pomFiles, pom+".md5")
+
+	gav, err := parseAndCheckGAV(pom, root)
+	assert.Nil(t, err)
+	assert.Equal(t, [3]string{"org.foo", "bar", "1.0"}, gav)
+	content, _ := files.ReadFile(pom)
+	assert.Contains(t, content, "<packaging>pom</packaging>")
+	assert.Contains(t, content, "<relocation>\n      <groupId>org.baz</groupId>\n      <artifactId>bar</artifactId>\n      <version>1.0</version>")
+	assert.Contains(t, content, "<message>moved to org.baz &amp; renamed</message>")
+}
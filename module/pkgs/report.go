@@ -0,0 +1,161 @@
+package pkgs
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+const (
+	PATH_OUTCOME_UPLOADED = "uploaded"
+	PATH_OUTCOME_DELETED  = "deleted"
+	PATH_OUTCOME_FAILED   = "failed"
+)
+
+// RunReport is the machine-readable report of an uploading or a deletion,
+// which is written as json by --report
+type RunReport struct {
+	Operation     string           `json:"operation"`
+	ProductKey    string           `json:"product_key"`
+	Archive       string           `json:"archive"`
+	ArchiveSHA256 string           `json:"archive_sha256"`
+	DryRun        bool             `json:"dry_run"`
+	StartedAt     time.Time        `json:"started_at"`
+	FinishedAt    time.Time        `json:"finished_at"`
+	Timings       map[string]int64 `json:"timings_ms"`
+	Targets       []*TargetReport  `json:"targets"`
+	Retries       map[string]int   `json:"retries"`
+	Success       bool             `json:"success"`
+}
+
+// The report of a single target in the run. All paths are relative to the
+// root of the maven repository.
+type TargetReport struct {
+	Bucket            string           `json:"bucket"`
+	Prefix            string           `json:"prefix"`
+	Paths             []PathReport     `json:"paths"`
+	GeneratedMetadata []string         `json:"generated_metadata"`
+	DeletedMetadata   []string         `json:"deleted_metadata"`
+	FailedMetadata    []PathReport     `json:"failed_metadata"`
+	Indexes           []string         `json:"indexes"`
+	Signatures        []string         `json:"signatures"`
+	CFInvalidations   []string         `json:"cf_invalidations"`
+	Timings           map[string]int64 `json:"timings_ms"`
+	Success           bool             `json:"success"`
+}
+
+type PathReport struct {
+	Path    string `json:"path"`
+	Outcome string `json:"outcome"`
+	Cause   string `json:"cause,omitempty"`
+}
+
+func newRunReport(operation, productKey, archive string, dryRun bool) *RunReport {
+	return &RunReport{
+		Operation:  operation,
+		ProductKey: productKey,
+		Archive:    archive,
+		DryRun:     dryRun,
+		StartedAt:  time.Now(),
+		Timings:    map[string]int64{},
+		Targets:    []*TargetReport{},
+		Retries:    map[string]int{},
+	}
+}
+
+// Record the time used by the phase since the start
+func (r *RunReport) timed(phase string, start time.Time) {
+	r.Timings[phase] = time.Since(start).Milliseconds()
+}
+
+func (r *RunReport) addTarget(t config.Target) *TargetReport {
+	target := &TargetReport{
+		Bucket:            t.Bucket,
+		Prefix:            t.Prefix,
+		Paths:             []PathReport{},
+		GeneratedMetadata: []string{},
+		DeletedMetadata:   []string{},
+		FailedMetadata:    []PathReport{},
+		Indexes:           []string{},
+		Signatures:        []string{},
+		CFInvalidations:   []string{},
+		Timings:           map[string]int64{},
+	}
+	r.Targets = append(r.Targets, target)
+	return target
+}
+
+// Finish the report with the final result, and write it to the file as
+// json. Nothing will be written if the file is empty.
+func (r *RunReport) finish(succeeded bool, reportFile string) {
+	r.FinishedAt = time.Now()
+	r.Success = succeeded
+	if reportFile == "" {
+		return
+	}
+	if files.IsFile(r.Archive) {
+		r.ArchiveSHA256 = files.Digest(r.Archive, crypto.SHA256)
+	}
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = os.WriteFile(reportFile, content, 0644)
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not write report %s due to error: %s", reportFile, err))
+		return
+	}
+	logger.Info("Report is written to " + reportFile)
+}
+
+func (t *TargetReport) timed(phase string, start time.Time) {
+	t.Timings[phase] = time.Since(start).Milliseconds()
+}
+
+// Set the outcomes of the paths in the target, the failed ones will get
+// their causes in the bucket of the target from the s3 client
+func (t *TargetReport) setPaths(s3Client *storage.S3Client, paths, failed []string, root, outcome string) {
+	failedSet := map[string]bool{}
+	for _, f := range failed {
+		failedSet[f] = true
+	}
+	for _, p := range paths {
+		if failedSet[p] {
+			t.Paths = append(t.Paths, PathReport{
+				Path: reportPath(p, root), Outcome: PATH_OUTCOME_FAILED, Cause: s3Client.FailureCauseIn(t.Bucket, p)})
+		} else {
+			t.Paths = append(t.Paths, PathReport{Path: reportPath(p, root), Outcome: outcome})
+		}
+	}
+}
+
+func (t *TargetReport) setFailedMetadata(s3Client *storage.S3Client, failed []string, root string) {
+	for _, f := range failed {
+		t.FailedMetadata = append(t.FailedMetadata, PathReport{
+			Path: reportPath(f, root), Outcome: PATH_OUTCOME_FAILED, Cause: s3Client.FailureCauseIn(t.Bucket, f)})
+	}
+}
+
+func (t *TargetReport) setCFInvalidations(invalidations []storage.Invalidation) {
+	for _, inv := range invalidations {
+		t.CFInvalidations = append(t.CFInvalidations, inv.Id)
+	}
+}
+
+func reportPath(p, root string) string {
+	return strings.TrimPrefix(p, strings.TrimSuffix(root, "/")+"/")
+}
+
+func reportPaths(paths []string, root string) []string {
+	result := []string{}
+	for _, p := range paths {
+		result = append(result, reportPath(p, root))
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package pkgs
+
+import (
+	"crypto"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestRunReport(t *testing.T) {
+	root := t.TempDir()
+	okFile := path.Join(root, "org/foo/bar/1.0/bar-1.0.jar")
+	badFile := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{})
+	assert.Nil(t, err)
+
+	report := newRunReport("upload", "foo-1.0", TEST_REPO, false)
+	start := time.Now()
+	tReport := report.addTarget(config.Target{Bucket: storage.TEST_BUCKET, Prefix: "ga"})
+	tReport.setPaths(s3client, []string{okFile, badFile}, []string{badFile}, root, PATH_OUTCOME_UPLOADED)
+	tReport.GeneratedMetadata = reportPaths([]string{path.Join(root, "org/foo/bar/maven-metadata.xml")}, root)
+	tReport.setCFInvalidations([]storage.Invalidation{{Id: "I1", Status: storage.INVALIDATION_STATUS_INPROGRESS}})
+	tReport.timed("metadata", start)
+	report.timed("upload", start)
+
+	reportFile := path.Join(t.TempDir(), "report.json")
+	report.finish(false, reportFile)
+
+	content, err := os.ReadFile(reportFile)
+	assert.Nil(t, err)
+	var result RunReport
+	assert.Nil(t, json.Unmarshal(content, &result))
+	assert.Equal(t, "foo-1.0", result.ProductKey)
+	assert.Equal(t, files.Digest(TEST_REPO, crypto.SHA256), result.ArchiveSHA256)
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Timings, "upload")
+	assert.Equal(t, 1, len(result.Targets))
+	target := result.Targets[0]
+	assert.Equal(t, storage.TEST_BUCKET, target.Bucket)
+	assert.Equal(t, []PathReport{
+		{Path: "org/foo/bar/1.0/bar-1.0.jar", Outcome: PATH_OUTCOME_UPLOADED},
+		{Path: "org/foo/bar/1.0/bar-1.0.pom", Outcome: PATH_OUTCOME_FAILED},
+	}, target.Paths)
+	assert.Equal(t, []string{"org/foo/bar/maven-metadata.xml"}, target.GeneratedMetadata)
+	assert.Equal(t, []string{"I1"}, target.CFInvalidations)
+	assert.Contains(t, target.Timings, "metadata")
+}
@@ -0,0 +1,259 @@
+package pkgs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"org.commonjava/charon/module/config"
+)
+
+const (
+	UPLOAD_JOURNAL_FILE = "upload-journal.jsonl"
+
+	JOURNAL_PHASE_METADATA  = "metadata"
+	JOURNAL_PHASE_ARCHETYPE = "archetype"
+	JOURNAL_PHASE_SIGNATURE = "signature"
+	JOURNAL_PHASE_INDEX     = "index"
+	JOURNAL_PHASE_CF        = "cf"
+
+	journalEntryStart = "start"
+	journalEntryScan  = "scan"
+	journalEntryPath  = "path"
+	journalEntryPhase = "phase"
+)
+
+// The parameters of an uploading, which are kept in the journal so that
+// the uploading can be resumed with the same parameters
+type uploadParams struct {
+	Repo           string          `json:"repo"`
+	ProdKey        string          `json:"product_key"`
+	IgnorePatterns []string        `json:"ignore_patterns"`
+	Root           string          `json:"root"`
+	Targets        []config.Target `json:"targets"`
+	DoIndex        bool            `json:"do_index"`
+	GenSign        bool            `json:"gen_sign"`
+	GenChecksum    bool            `json:"gen_checksum"`
+	Prefetch       bool            `json:"prefetch"`
+	CFEnable       bool            `json:"cf_enable"`
+	Key            string          `json:"key"`
+	ManifestBucket string          `json:"manifest_bucket"`
+	ConfigFile     string          `json:"config_file"`
+}
+
+// The scanned paths of the extracted tarball, which are kept in the journal
+// as the generated files like manifest and index.html are also written into
+// the extracted tarball, which should not be uploaded as artifacts on resume
+type journalScan struct {
+	TopLevel string   `json:"top_level"`
+	MvnPaths []string `json:"mvn_paths"`
+	Poms     []string `json:"poms"`
+	Modules  []string `json:"modules"`
+	Dirs     []string `json:"dirs"`
+}
+
+// An entry in the journal file, one json object per line
+type journalEntry struct {
+	Type   string        `json:"type"`
+	Bucket string        `json:"bucket,omitempty"`
+	Key    string        `json:"key,omitempty"`
+	Phase  string        `json:"phase,omitempty"`
+	Paths  []string      `json:"paths,omitempty"`
+	Params *uploadParams `json:"params,omitempty"`
+	Scan   *journalScan  `json:"scan,omitempty"`
+}
+
+// uploadJournal records the progress of an uploading in the work dir: the
+// files uploaded to each bucket, and the phases done for each target. The
+// entries are appended to the journal file as soon as they are done, so
+// the journal is kept even if the process is killed.
+type uploadJournal struct {
+	file     *os.File
+	params   uploadParams
+	scan     *journalScan
+	uploaded map[string]bool
+	phases   map[string][]string
+	lock     sync.Mutex
+}
+
+// Create a new journal in the work dir for the uploading
+func newUploadJournal(workDir string, params uploadParams) (*uploadJournal, error) {
+	f, err := os.Create(path.Join(workDir, UPLOAD_JOURNAL_FILE))
+	if err != nil {
+		return nil, err
+	}
+	j := &uploadJournal{
+		file:     f,
+		params:   params,
+		uploaded: map[string]bool{},
+		phases:   map[string][]string{},
+	}
+	if err := j.append(journalEntry{Type: journalEntryStart, Params: &params}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Load the journal in the work dir of an interrupted uploading, the new
+// entries will be appended to it
+func loadUploadJournal(workDir string) (*uploadJournal, error) {
+	journalFile := path.Join(workDir, UPLOAD_JOURNAL_FILE)
+	f, err := os.Open(journalFile)
+	if err != nil {
+		return nil, err
+	}
+	j := &uploadJournal{
+		uploaded: map[string]bool{},
+		phases:   map[string][]string{},
+	}
+	started := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		// The last line may be partly written when the process is killed
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warn(fmt.Sprintf("Ignored broken entry in journal %s: %s", journalFile, scanner.Text()))
+			continue
+		}
+		switch entry.Type {
+		case journalEntryStart:
+			if entry.Params != nil {
+				j.params = *entry.Params
+				started = true
+			}
+		case journalEntryScan:
+			j.scan = entry.Scan
+		case journalEntryPath:
+			j.uploaded[journalKey(entry.Bucket, entry.Key)] = true
+		case journalEntryPhase:
+			j.phases[journalKey(entry.Bucket, entry.Phase)] = entry.Paths
+		}
+	}
+	err = scanner.Err()
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, fmt.Errorf("no uploading parameters found in journal %s", journalFile)
+	}
+	j.file, err = os.OpenFile(journalFile, os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Terminate the partly written entry, so that it will not break the
+	// entries appended after it
+	if info, err := j.file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := j.file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			j.file.Write([]byte{'\n'})
+		}
+	}
+	return j, nil
+}
+
+func journalKey(bucket, name string) string {
+	return bucket + "\x00" + name
+}
+
+func (j *uploadJournal) append(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(append(line, '\n'))
+	return err
+}
+
+// Get the scanned paths recorded in the journal
+func (j *uploadJournal) scanned() (scannedPaths, bool) {
+	if j == nil || j.scan == nil {
+		return scannedPaths{}, false
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return scannedPaths{
+		topLevel: j.scan.TopLevel,
+		mvnPaths: j.scan.MvnPaths,
+		poms:     j.scan.Poms,
+		modules:  j.scan.Modules,
+		dirs:     j.scan.Dirs,
+	}, true
+}
+
+// Record the scanned paths, so that the same paths are uploaded on resume
+func (j *uploadJournal) doneScan(s scannedPaths) {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.scan = &journalScan{
+		TopLevel: s.topLevel,
+		MvnPaths: s.mvnPaths,
+		Poms:     s.poms,
+		Modules:  s.modules,
+		Dirs:     s.dirs,
+	}
+	if err := j.append(journalEntry{Type: journalEntryScan, Scan: j.scan}); err != nil {
+		logger.Warn(fmt.Sprintf("Can not write upload journal due to error: %s", err))
+	}
+}
+
+func (j *uploadJournal) IsUploaded(bucket, key string) bool {
+	if j == nil {
+		return false
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.uploaded[journalKey(bucket, key)]
+}
+
+func (j *uploadJournal) Uploaded(bucket, key string) {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.uploaded[journalKey(bucket, key)] = true
+	if err := j.append(journalEntry{Type: journalEntryPath, Bucket: bucket, Key: key}); err != nil {
+		logger.Warn(fmt.Sprintf("Can not write upload journal due to error: %s", err))
+	}
+}
+
+// Check if the phase is done for the bucket. The paths recorded with the
+// phase are returned, which are needed by the later phases.
+func (j *uploadJournal) phaseDone(bucket, phase string) ([]string, bool) {
+	if j == nil {
+		return nil, false
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	paths, ok := j.phases[journalKey(bucket, phase)]
+	return paths, ok
+}
+
+// Record the phase is done for the bucket, with the paths needed by the
+// later phases
+func (j *uploadJournal) donePhase(bucket, phase string, paths []string) {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.phases[journalKey(bucket, phase)] = paths
+	if err := j.append(journalEntry{Type: journalEntryPhase, Bucket: bucket, Phase: phase, Paths: paths}); err != nil {
+		logger.Warn(fmt.Sprintf("Can not write upload journal due to error: %s", err))
+	}
+}
+
+func (j *uploadJournal) close() {
+	if j != nil {
+		j.file.Close()
+	}
+}
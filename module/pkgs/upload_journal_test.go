@@ -0,0 +1,91 @@
+package pkgs
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+)
+
+func TestUploadJournal(t *testing.T) {
+	workDir := t.TempDir()
+	root := path.Join(workDir, "maven-repository")
+	doneFile := path.Join(root, "org/foo/bar/1.0/bar-1.0.pom")
+	newFile := path.Join(root, "org/foo/bar/1.0/bar-1.0.jar")
+	for _, f := range []string{doneFile, newFile} {
+		assert.Nil(t, os.MkdirAll(path.Dir(f), 0755))
+		assert.Nil(t, os.WriteFile(f, []byte(f), 0644))
+	}
+	params := uploadParams{
+		Repo:    "foo.zip",
+		ProdKey: "foo-1.0",
+		Root:    "maven-repository",
+		Targets: []config.Target{{Bucket: storage.TEST_BUCKET, Prefix: "ga"}},
+		DoIndex: true,
+	}
+	journal, err := newUploadJournal(workDir, params)
+	assert.Nil(t, err)
+	scanned := scanPaths(nil, workDir, params.Root)
+	journal.doneScan(scanned)
+	journal.Uploaded(storage.TEST_BUCKET, "ga/org/foo/bar/1.0/bar-1.0.pom")
+	journal.donePhase(storage.TEST_BUCKET, JOURNAL_PHASE_METADATA, []string{"org/foo/bar/maven-metadata.xml"})
+	journal.close()
+	// A partly written entry of a killed process
+	f, err := os.OpenFile(path.Join(workDir, UPLOAD_JOURNAL_FILE), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	f.WriteString(`{"type":"path","buck`)
+	f.Close()
+
+	journal, err = loadUploadJournal(workDir)
+	assert.Nil(t, err)
+	defer journal.close()
+	assert.Equal(t, params, journal.params)
+	// The generated files in the tarball are not scanned again on resume
+	for _, f := range []string{"foo-1.0.txt", "org/foo/index.html"} {
+		assert.Nil(t, os.WriteFile(path.Join(root, f), []byte(f), 0644))
+	}
+	assert.Len(t, scanPaths(nil, workDir, params.Root).mvnPaths, 4)
+	resumed, ok := journal.scanned()
+	assert.True(t, ok)
+	assert.Equal(t, scanned, resumed)
+	assert.ElementsMatch(t, []string{doneFile, newFile}, resumed.mvnPaths)
+	assert.True(t, journal.IsUploaded(storage.TEST_BUCKET, "ga/org/foo/bar/1.0/bar-1.0.pom"))
+	assert.False(t, journal.IsUploaded(storage.TEST_BUCKET, "ga/org/foo/bar/1.0/bar-1.0.jar"))
+	paths, ok := journal.phaseDone(storage.TEST_BUCKET, JOURNAL_PHASE_METADATA)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"org/foo/bar/maven-metadata.xml"}, paths)
+	_, ok = journal.phaseDone(storage.TEST_BUCKET, JOURNAL_PHASE_INDEX)
+	assert.False(t, ok)
+
+	// The files recorded in the journal are skipped without any request
+	var lock sync.Mutex
+	requested := []string{}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			requested = append(requested, *params.Key)
+			return nil, &types.NotFound{}
+		},
+		PutObj: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			return &s3.PutObjectOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+	s3client.SetUploadJournal(journal)
+	failed := s3client.UploadFiles([]string{doneFile, newFile}, params.Targets, "", root)[storage.TEST_BUCKET]
+	assert.Empty(t, failed)
+	assert.Equal(t, []string{"ga/org/foo/bar/1.0/bar-1.0.jar"}, requested)
+
+	journal.close()
+	journal, err = loadUploadJournal(workDir)
+	assert.Nil(t, err)
+	assert.True(t, journal.IsUploaded(storage.TEST_BUCKET, "ga/org/foo/bar/1.0/bar-1.0.jar"))
+}
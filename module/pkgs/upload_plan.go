@@ -0,0 +1,219 @@
+package pkgs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+// UploadPlan shows what an uploading would change in the targets. It is
+// computed against the buckets without any writing.
+type UploadPlan struct {
+	ProductKey string        `json:"product_key"`
+	Targets    []*TargetPlan `json:"targets"`
+}
+
+// The plan of a single target. All paths are relative to the root of the
+// maven repository, except the index files and CF paths which are the
+// paths in the bucket.
+type TargetPlan struct {
+	Bucket          string            `json:"bucket"`
+	Prefix          string            `json:"prefix"`
+	Created         []string          `json:"created"`
+	Identical       []string          `json:"identical"`
+	Mismatched      []string          `json:"mismatched"`
+	ProductAdded    []string          `json:"product_added"`
+	Failed          []PathReport      `json:"failed"`
+	ChangedMetadata []string          `json:"changed_metadata"`
+	DeletedMetadata []string          `json:"deleted_metadata"`
+	MetadataDiffs   map[string]string `json:"metadata_diffs"`
+	Indexes         []string          `json:"indexes"`
+	CFPaths         []string          `json:"cf_paths"`
+}
+
+func (p *TargetPlan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Upload plan for bucket %s:\n", p.Bucket)
+	for _, part := range []struct {
+		title string
+		paths []string
+	}{
+		{"Files to create", p.Created},
+		{"Files skipped as identical", p.Identical},
+		{"Files rejected for checksum mismatch", p.Mismatched},
+		{"Files to add the product", p.ProductAdded},
+		{"Metadata to update", p.ChangedMetadata},
+		{"Metadata to delete", p.DeletedMetadata},
+		{"Index files to regenerate", p.Indexes},
+		{"CF paths to invalidate", p.CFPaths},
+	} {
+		fmt.Fprintf(&sb, "%s (%d):\n", part.title, len(part.paths))
+		for _, p := range part.paths {
+			fmt.Fprintf(&sb, "  %s\n", p)
+		}
+	}
+	if len(p.Failed) > 0 {
+		fmt.Fprintf(&sb, "Files failed to check (%d):\n", len(p.Failed))
+		for _, f := range p.Failed {
+			fmt.Fprintf(&sb, "  %s: %s\n", f.Path, f.Cause)
+		}
+	}
+	keys := make([]string, 0, len(p.MetadataDiffs))
+	for k := range p.MetadataDiffs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		sb.WriteString(p.MetadataDiffs[k])
+	}
+	return sb.String()
+}
+
+// Handle the plan of the maven product release tarball uploading. The
+// tarball is processed in the same way as HandleMavenUploading, but
+// nothing will be written to the buckets. The plan will be written as
+// json to planFile if it is not empty.
+//
+// Returns the directory used for archive processing and if the uploading
+// would succeed without any rejected or failed files
+func HandleMavenUploadPlan(
+	ctx context.Context,
+	repo,
+	prodKey string,
+	ignorePatterns []string,
+	root string,
+	targets []config.Target,
+	awsProfile,
+	dir_ string,
+	doIndex,
+	cfEnable bool,
+	planFile string,
+) (string, bool) {
+	// step 1. extract tarball
+	tmpRoot, err := extractTarball(repo, prodKey, dir_)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not extract tarball due to error: %s", err))
+		return tmpRoot, false
+	}
+
+	// step 2. scan for paths and filter out the ignored paths
+	scannedPaths := scanPaths(ignorePatterns, tmpRoot, root)
+	validMvnPaths, topLevel := scannedPaths.mvnPaths, scannedPaths.topLevel
+	if !files.IsDir(topLevel) {
+		logger.Error(fmt.Sprintf("The extracted top-level path %s does not exist", topLevel))
+		return tmpRoot, false
+	}
+
+	// step 3. do validation for the files
+	logger.Info("Validating paths with rules.")
+	msgs, passed := validateMaven(validMvnPaths, topLevel, prodKey, mergeValidationRules(targets))
+	handleError(msgs)
+	if !passed {
+		logger.Error("Validation failed, the uploading would be aborted before any file is uploaded.")
+		return tmpRoot, false
+	}
+
+	// The s3 client is always in dry run mode, so nothing can be written
+	s3Client, err := storage.NewS3Client(ctx, awsProfile, storage.DEFAULT_CONCURRENT_LIMIT, true)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can not create s3 client due to error: %s", err))
+		return tmpRoot, false
+	}
+	plan := &UploadPlan{ProductKey: prodKey, Targets: []*TargetPlan{}}
+	succeeded := true
+	for _, target := range targets {
+		if interrupted(ctx, "planning for bucket "+target.Bucket) {
+			return tmpRoot, false
+		}
+		t := fixTarget(target)
+		s3Client.SetProductInfoMode(t.Bucket, t.ProductInfo)
+		logger.Info("Start planning the uploading to s3 bucket " + t.Bucket)
+		tPlan := planMavenUpload(s3Client, scannedPaths, t, prodKey, doIndex, cfEnable)
+		plan.Targets = append(plan.Targets, tPlan)
+		logger.Info(tPlan.String())
+		succeeded = succeeded && len(tPlan.Mismatched) == 0 && len(tPlan.Failed) == 0
+	}
+
+	if planFile != "" {
+		content, err := json.MarshalIndent(plan, "", "  ")
+		if err == nil {
+			err = os.WriteFile(planFile, content, 0644)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can not write plan %s due to error: %s", planFile, err))
+			return tmpRoot, false
+		}
+		logger.Info("Plan is written to " + planFile)
+	}
+	return tmpRoot, succeeded
+}
+
+func planMavenUpload(s3Client *storage.S3Client, scannedPaths scannedPaths,
+	t config.Target, prodKey string, doIndex, cfEnable bool) *TargetPlan {
+	topLevel := scannedPaths.topLevel
+	tPlan := &TargetPlan{
+		Bucket:          t.Bucket,
+		Prefix:          t.Prefix,
+		Failed:          []PathReport{},
+		ChangedMetadata: []string{},
+		DeletedMetadata: []string{},
+		Indexes:         []string{},
+		CFPaths:         []string{},
+	}
+
+	actions, failed := s3Client.PlanUploadFiles(scannedPaths.mvnPaths, t, prodKey, topLevel)
+	tPlan.Created = reportPaths(actions[storage.PLAN_CREATE], topLevel)
+	tPlan.Identical = reportPaths(actions[storage.PLAN_IDENTICAL], topLevel)
+	tPlan.Mismatched = reportPaths(actions[storage.PLAN_MISMATCH], topLevel)
+	tPlan.ProductAdded = reportPaths(actions[storage.PLAN_ADD_PRODUCT], topLevel)
+	for _, f := range failed {
+		tPlan.Failed = append(tPlan.Failed, PathReport{
+			Path: reportPath(f, topLevel), Outcome: PATH_OUTCOME_FAILED, Cause: s3Client.FailureCause(f)})
+	}
+
+	// The metadata are generated locally in the same way as uploading, with
+	// the files to create seen as uploaded, and compared with the remote ones
+	pendingKeys := []string{}
+	for _, p := range tPlan.Created {
+		pendingKeys = append(pendingKeys, path.Join(t.Prefix, p))
+	}
+	pendingClient := s3Client.WithPendingFiles(t.Bucket, pendingKeys)
+	metaFiles := generateMetadatas(*pendingClient, scannedPaths.poms, t.Bucket, t.Prefix, topLevel)
+	changed, deleted, diffs := diffRemoteMetadatas(*s3Client, metaFiles, t.Bucket, t.Prefix, topLevel)
+	tPlan.ChangedMetadata = append(tPlan.ChangedMetadata, reportPaths(changed, topLevel)...)
+	tPlan.DeletedMetadata = append(tPlan.DeletedMetadata, deleted...)
+	tPlan.MetadataDiffs = diffs
+	cfInvalidatePaths := metaFiles[META_FILE_GEN_KEY]
+
+	if files.FileOrDirExists(path.Join(topLevel, MAVEN_ARCH_FILE)) &&
+		generateUploadArchetypeCatalog(s3Client, t.Bucket, topLevel, t.Prefix) {
+		archetypeFiles := []string{path.Join(topLevel, MAVEN_ARCH_FILE)}
+		archetypeFiles = append(archetypeFiles, hashDecorateMetadata(topLevel, MAVEN_ARCH_FILE)...)
+		tPlan.ChangedMetadata = append(tPlan.ChangedMetadata, reportPaths(archetypeFiles, topLevel)...)
+		cfInvalidatePaths = append(cfInvalidatePaths, archetypeFiles...)
+	}
+
+	if doIndex {
+		for _, folder := range indexFolders(scannedPaths.dirs, topLevel) {
+			tPlan.Indexes = append(tPlan.Indexes,
+				strings.TrimPrefix(path.Join(t.Prefix, folder, INDEX_HTML_FILE), "/"))
+			if t.IndexJson {
+				tPlan.Indexes = append(tPlan.Indexes,
+					strings.TrimPrefix(path.Join(t.Prefix, folder, INDEX_JSON_FILE), "/"))
+			}
+		}
+	}
+
+	if cfEnable && len(cfInvalidatePaths) > 0 {
+		tPlan.CFPaths = cfPaths(t, wildcardMetadataPaths(cfInvalidatePaths), topLevel)
+	}
+	return tPlan
+}
@@ -0,0 +1,99 @@
+package pkgs
+
+import (
+	"context"
+	"crypto"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestPlanMavenUpload(t *testing.T) {
+	root := t.TempDir()
+	newJar := path.Join(root, "org/foo/bar/1.1/bar-1.1.jar")
+	newPom := path.Join(root, "org/foo/bar/1.1/bar-1.1.pom")
+	sameFile := path.Join(root, "org/foo/baz/1.0/baz-1.0.jar")
+	otherProdFile := path.Join(root, "org/foo/baz/1.0/baz-1.0.pom")
+	changedFile := path.Join(root, "org/foo/baz/1.0/baz-1.0-sources.jar")
+	for _, f := range []string{newJar, newPom, sameFile, otherProdFile, changedFile} {
+		assert.Nil(t, os.MkdirAll(path.Dir(f), 0755))
+		assert.Nil(t, os.WriteFile(f, []byte(f), 0644))
+	}
+	assert.Nil(t, os.WriteFile(newPom, []byte(`<project><groupId>org.foo</groupId>`+
+		`<artifactId>bar</artifactId><version>1.1</version></project>`), 0644))
+	prefix := "ga"
+	remoteMeta := `<metadata><groupId>org.foo</groupId><artifactId>bar</artifactId></metadata>`
+	remote := map[string]string{
+		"ga/org/foo/bar/1.0/bar-1.0.pom":              "old",
+		"ga/org/foo/bar/maven-metadata.xml":           remoteMeta,
+		"ga/org/foo/baz/1.0/baz-1.0.jar":              sameFile,
+		"ga/org/foo/baz/1.0/baz-1.0.jar.prodinfo":     "foo-1.1",
+		"ga/org/foo/baz/1.0/baz-1.0.pom":              otherProdFile,
+		"ga/org/foo/baz/1.0/baz-1.0.pom.prodinfo":     "other-1.0",
+		"ga/org/foo/baz/1.0/baz-1.0-sources.jar":      "changed",
+		"ga/org/foo/baz/1.0/baz-1.0-sources.jar.sha1": "",
+	}
+	s3client, err := storage.S3ClientWithMock(storage.MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			contents := []types.Object{}
+			for k := range remote {
+				if strings.HasPrefix(k, aws.ToString(params.Prefix)) {
+					contents = append(contents, types.Object{Key: aws.String(k)})
+				}
+			}
+			return &s3.ListObjectsV2Output{Contents: contents}, nil
+		},
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if content, ok := remote[*params.Key]; ok {
+				return &s3.HeadObjectOutput{
+					Metadata: map[string]string{storage.CHECKSUM_META_KEY: files.DigestContent(content, crypto.SHA1)},
+				}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			content, ok := remote[*params.Key]
+			if !ok {
+				return nil, &types.NoSuchKey{}
+			}
+			return &s3.GetObjectOutput{
+				Body:     io.NopCloser(strings.NewReader(content)),
+				Metadata: map[string]string{storage.CHECKSUM_META_KEY: files.DigestContent(content, crypto.SHA1)},
+			}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	scanned := scannedPaths{
+		topLevel: root,
+		mvnPaths: []string{newJar, newPom, sameFile, otherProdFile, changedFile},
+		poms:     []string{newPom},
+		dirs: []string{path.Join(root, "org"), path.Join(root, "org/foo"),
+			path.Join(root, "org/foo/bar"), path.Join(root, "org/foo/bar/1.1")},
+	}
+	target := config.Target{Bucket: storage.TEST_BUCKET, Prefix: prefix}
+	plan := planMavenUpload(s3client, scanned, target, "foo-1.1", true, true)
+
+	assert.Equal(t, []string{"org/foo/bar/1.1/bar-1.1.jar", "org/foo/bar/1.1/bar-1.1.pom"}, plan.Created)
+	assert.Equal(t, []string{"org/foo/baz/1.0/baz-1.0.jar"}, plan.Identical)
+	assert.Equal(t, []string{"org/foo/baz/1.0/baz-1.0.pom"}, plan.ProductAdded)
+	assert.Equal(t, []string{"org/foo/baz/1.0/baz-1.0-sources.jar"}, plan.Mismatched)
+	assert.Empty(t, plan.Failed)
+	assert.Contains(t, plan.ChangedMetadata, "org/foo/bar/maven-metadata.xml")
+	diff := plan.MetadataDiffs["ga/org/foo/bar/maven-metadata.xml"]
+	assert.Contains(t, diff, "+      <version>1.1</version>")
+	assert.Equal(t, []string{"ga/org/index.html", "ga/org/foo/index.html", "ga/org/foo/bar/index.html",
+		"ga/org/foo/bar/1.1/index.html", "ga/index.html"}, plan.Indexes)
+	assert.Contains(t, plan.String(), "Files rejected for checksum mismatch (1)")
+	assert.Equal(t, []string{"/ga/org/foo/bar/maven-metadata.*"}, plan.CFPaths)
+}
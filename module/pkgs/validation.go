@@ -0,0 +1,237 @@
+package pkgs
+
+import (
+	"crypto"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/util/files"
+)
+
+const (
+	RULE_JAR_HAS_POM     = "jar-has-pom"
+	RULE_SHA1_CHECKSUM   = "sha1-checksum"
+	RULE_REDHAT_VERSION  = "redhat-version"
+	RULE_NO_SNAPSHOT     = "no-snapshot"
+	RULE_POM_COORDINATES = "pom-coordinates"
+	RULE_GRADLE_MODULE   = "gradle-module"
+)
+
+var (
+	redhatVersionPattern = regexp.MustCompile(`[.-]redhat-\d{5}$`)
+	redhatSuffixPattern  = regexp.MustCompile(`redhat-\d{5}`)
+	snapshotPattern      = regexp.MustCompile(`(-SNAPSHOT|-\d{8}\.\d{6}-\d+)$`)
+	// The suffixes of files which are used to verify other files, so they
+	// will not be checked by the rules for artifacts
+	verificationSuffixes = []string{".md5", ".sha1", ".sha256", ".sha512", ".asc"}
+
+	validationRules = map[string]ValidationRule{}
+)
+
+// The rules which will be used when a target does not configure any
+// validation rules
+var DEFAULT_VALIDATION_RULES = map[string]string{
+	RULE_POM_COORDINATES: config.SEVERITY_WARNING,
+	RULE_GRADLE_MODULE:   config.SEVERITY_WARNING,
+}
+
+// ValidationRule is a rule to check the maven paths of a product before they
+// are uploaded. A rule returns the messages for all the paths that break it.
+// New rules can be plugged in through RegisterValidationRule, and will be
+// enabled by its name in the validation_rules of a target in charon.yaml.
+type ValidationRule interface {
+	Name() string
+	Validate(paths []string, root, prodKey string) []string
+}
+
+// A validation message reported by a rule, with the severity configured
+// for the rule
+type ValidationMessage struct {
+	Rule     string
+	Severity string
+	Message  string
+}
+
+func (m ValidationMessage) String() string {
+	return fmt.Sprintf("[%s] %s", m.Rule, m.Message)
+}
+
+// A ValidationRule implemented by a single function
+type ruleFunc struct {
+	name     string
+	validate func(paths []string, root, prodKey string) []string
+}
+
+func (r ruleFunc) Name() string {
+	return r.name
+}
+
+func (r ruleFunc) Validate(paths []string, root, prodKey string) []string {
+	return r.validate(paths, root, prodKey)
+}
+
+func RegisterValidationRule(rule ValidationRule) {
+	validationRules[rule.Name()] = rule
+}
+
+func init() {
+	RegisterValidationRule(ruleFunc{RULE_JAR_HAS_POM, validateJarHasPom})
+	RegisterValidationRule(ruleFunc{RULE_SHA1_CHECKSUM, validateSHA1Checksum})
+	RegisterValidationRule(ruleFunc{RULE_REDHAT_VERSION, validateRedhatVersion})
+	RegisterValidationRule(ruleFunc{RULE_NO_SNAPSHOT, validateNoSnapshot})
+	RegisterValidationRule(ruleFunc{RULE_POM_COORDINATES, validatePomCoordinates})
+	RegisterValidationRule(ruleFunc{RULE_GRADLE_MODULE, validateGradleModules})
+}
+
+// Merge the validation rules of all targets. As the same files will be
+// uploaded to all targets, a rule is enabled if any target enables it, and
+// the error severity wins over the warning one.
+func mergeValidationRules(targets []config.Target) map[string]string {
+	rules := map[string]string{}
+	configured := false
+	for _, t := range targets {
+		for rule, severity := range t.ValidationRules {
+			configured = true
+			if rules[rule] != config.SEVERITY_ERROR {
+				rules[rule] = severity
+			}
+		}
+	}
+	if !configured {
+		for rule, severity := range DEFAULT_VALIDATION_RULES {
+			rules[rule] = severity
+		}
+	}
+	return rules
+}
+
+func toSet(paths []string) map[string]bool {
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	return set
+}
+
+// Check if the path is deep enough to be parsed as a GAV path
+func isGAVPath(p, root string) bool {
+	return len(strings.Split(trimRoot(p, root), "/")) > 3
+}
+
+func isVerificationFile(p string) bool {
+	return slices.Contains(verificationSuffixes, filepath.Ext(p))
+}
+
+// Every jar should have a pom with the same GAV in its version folder
+func validateJarHasPom(paths []string, root, _ string) []string {
+	msgs := []string{}
+	pathSet := toSet(paths)
+	for _, p := range paths {
+		if filepath.Ext(p) != ".jar" || !isGAVPath(p, root) {
+			continue
+		}
+		gav := parseGAV(p, root)
+		pom := path.Join(path.Dir(p), fmt.Sprintf("%s-%s.pom", gav[1], gav[2]))
+		if !pathSet[pom] {
+			msgs = append(msgs, fmt.Sprintf("jar %s does not have a pom %s", p, path.Base(pom)))
+		}
+	}
+	return msgs
+}
+
+// Every artifact should have a .sha1 file which matches its content
+func validateSHA1Checksum(paths []string, root, _ string) []string {
+	msgs := []string{}
+	pathSet := toSet(paths)
+	for _, p := range paths {
+		if isVerificationFile(p) {
+			continue
+		}
+		sha1File := p + ".sha1"
+		if !pathSet[sha1File] {
+			msgs = append(msgs, fmt.Sprintf("%s does not have a .sha1 file", p))
+			continue
+		}
+		content, err := files.ReadFile(sha1File)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("can not read %s: %s", sha1File, err))
+			continue
+		}
+		expected := strings.Fields(content)
+		actual := files.Digest(p, crypto.SHA1)
+		if len(expected) == 0 || !strings.EqualFold(expected[0], actual) {
+			msgs = append(msgs, fmt.Sprintf("%s does not match the sha1 of %s", sha1File, p))
+		}
+	}
+	return msgs
+}
+
+// All artifacts should be built with the redhat-NNNNN version suffix of the
+// product. The expected suffix is taken from the product version in prodKey,
+// any redhat-NNNNN suffix is accepted if the product version has none.
+func validateRedhatVersion(paths []string, root, prodKey string) []string {
+	msgs := []string{}
+	expected := redhatSuffixPattern.FindString(prodKey)
+	for _, p := range paths {
+		if filepath.Ext(p) != ".pom" {
+			continue
+		}
+		if !isGAVPath(p, root) {
+			msgs = append(msgs, fmt.Sprintf("pom %s is not in a groupId/artifactId/version path", p))
+			continue
+		}
+		version := parseGAV(p, root)[2]
+		if expected == "" {
+			if !redhatVersionPattern.MatchString(version) {
+				msgs = append(msgs, fmt.Sprintf("version %s of %s does not have a redhat-NNNNN suffix", version, p))
+			}
+		} else if !strings.HasSuffix(version, "."+expected) && !strings.HasSuffix(version, "-"+expected) {
+			msgs = append(msgs, fmt.Sprintf("version %s of %s does not have the %s suffix of product %s",
+				version, p, expected, prodKey))
+		}
+	}
+	return msgs
+}
+
+// No SNAPSHOT artifacts are allowed, which is normally used for GA targets
+func validateNoSnapshot(paths []string, root, _ string) []string {
+	msgs := []string{}
+	for _, p := range paths {
+		if !isGAVPath(p, root) {
+			continue
+		}
+		version := parseGAV(p, root)[2]
+		if snapshotPattern.MatchString(version) {
+			msgs = append(msgs, fmt.Sprintf("%s is a SNAPSHOT artifact", p))
+		}
+	}
+	return msgs
+}
+
+// The coordinates in pom should match its path
+func validatePomCoordinates(paths []string, root, _ string) []string {
+	poms := []string{}
+	for _, p := range paths {
+		if filepath.Ext(p) == ".pom" {
+			poms = append(poms, p)
+		}
+	}
+	return checkPomCoordinates(poms, root)
+}
+
+// The files referenced by a gradle module should be uploaded together with
+// it, and match the size and checksums declared in it
+func validateGradleModules(paths []string, root, _ string) []string {
+	modules := []string{}
+	for _, p := range paths {
+		if isGradleModule(p) {
+			modules = append(modules, p)
+		}
+	}
+	return checkGradleModules(modules, paths)
+}
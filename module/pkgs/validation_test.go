@@ -0,0 +1,121 @@
+package pkgs
+
+import (
+	"crypto"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/storage"
+	"org.commonjava/charon/module/util/files"
+)
+
+func TestValidateMavenWithRealPoms(t *testing.T) {
+	tmpRoot, err := extractTarball(TEST_REPO, "test", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpRoot)
+	scanned := scanPaths([]string{}, tmpRoot, "maven-repository")
+	msgs, passed := validateMaven(scanned.mvnPaths, scanned.topLevel, "",
+		mergeValidationRules([]config.Target{{Bucket: storage.TEST_BUCKET}}))
+	assert.True(t, passed)
+	assert.Empty(t, msgs)
+}
+
+func TestValidateMavenRules(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-test-*")
+	defer os.RemoveAll(root)
+	pom := path.Join(root, "org/foo/bar/1.0.0.redhat-00001/bar-1.0.0.redhat-00001.pom")
+	files.StoreFile(pom, `<project>
+  <groupId>org.foo</groupId>
+  <artifactId>bar</artifactId>
+  <version>1.0.0.redhat-00001</version>
+</project>`, true)
+	files.StoreFile(pom+".sha1", files.Digest(pom, crypto.SHA1), true)
+	jar := path.Join(root, "org/foo/bar/1.0.0.redhat-00001/bar-1.0.0.redhat-00001.jar")
+	files.StoreFile(jar, "jar content", true)
+	files.StoreFile(jar+".sha1", "0000000000000000000000000000000000000000", true)
+	orphanJar := path.Join(root, "org/foo/baz/1.0.0-SNAPSHOT/baz-1.0.0-SNAPSHOT.jar")
+	files.StoreFile(orphanJar, "jar content", true)
+	paths := []string{pom, pom + ".sha1", jar, jar + ".sha1", orphanJar}
+
+	allRules := map[string]string{
+		RULE_JAR_HAS_POM:     config.SEVERITY_ERROR,
+		RULE_SHA1_CHECKSUM:   config.SEVERITY_WARNING,
+		RULE_REDHAT_VERSION:  config.SEVERITY_ERROR,
+		RULE_NO_SNAPSHOT:     config.SEVERITY_ERROR,
+		RULE_POM_COORDINATES: config.SEVERITY_ERROR,
+	}
+	msgs, passed := validateMaven(paths, root, "bar-1.0.0.redhat-00001", allRules)
+	assert.False(t, passed)
+	byRule := map[string][]ValidationMessage{}
+	for _, m := range msgs {
+		byRule[m.Rule] = append(byRule[m.Rule], m)
+	}
+	assert.Equal(t, 1, len(byRule[RULE_JAR_HAS_POM]))
+	assert.Contains(t, byRule[RULE_JAR_HAS_POM][0].Message, orphanJar)
+	// The jar sha1 does not match and the orphan jar has no sha1
+	assert.Equal(t, 2, len(byRule[RULE_SHA1_CHECKSUM]))
+	assert.Equal(t, config.SEVERITY_WARNING, byRule[RULE_SHA1_CHECKSUM][0].Severity)
+	assert.Equal(t, 0, len(byRule[RULE_REDHAT_VERSION]))
+	assert.Equal(t, 1, len(byRule[RULE_NO_SNAPSHOT]))
+	assert.Equal(t, 0, len(byRule[RULE_POM_COORDINATES]))
+
+	// Only warnings will not fail the validation
+	msgs, passed = validateMaven(paths, root, "", map[string]string{RULE_SHA1_CHECKSUM: config.SEVERITY_WARNING})
+	assert.True(t, passed)
+	assert.Equal(t, 2, len(msgs))
+
+	// The version should have the redhat suffix of the product version
+	rules := map[string]string{RULE_REDHAT_VERSION: config.SEVERITY_ERROR}
+	msgs, passed = validateMaven(paths, root, "bar-1.0.0.redhat-00002", rules)
+	assert.False(t, passed)
+	assert.Equal(t, 1, len(msgs))
+	assert.Contains(t, msgs[0].Message, "does not have the redhat-00002 suffix")
+	_, passed = validateMaven(paths, root, "bar-1.0.0", rules)
+	assert.True(t, passed)
+
+	// The unknown rule fails the validation even with the warning severity
+	msgs, passed = validateMaven(paths, root, "", map[string]string{"no-snapshots": config.SEVERITY_WARNING})
+	assert.False(t, passed)
+	assert.Equal(t, []ValidationMessage{{Rule: "no-snapshots", Severity: config.SEVERITY_ERROR,
+		Message: "validation rule no-snapshots is not a known rule"}}, msgs)
+}
+
+func TestValidateMavenShallowPom(t *testing.T) {
+	root, _ := os.MkdirTemp("", "charon-test-*")
+	defer os.RemoveAll(root)
+	shallow := path.Join(root, "bar/1.0.0/bar-1.0.0.pom")
+	files.StoreFile(shallow, `<project>
+  <groupId>bar</groupId>
+  <artifactId>bar</artifactId>
+  <version>1.0.0</version>
+</project>`, true)
+
+	msgs, passed := validateMaven([]string{shallow}, root, "", map[string]string{
+		RULE_REDHAT_VERSION:  config.SEVERITY_ERROR,
+		RULE_POM_COORDINATES: config.SEVERITY_ERROR,
+	})
+	assert.False(t, passed)
+	assert.Equal(t, 2, len(msgs))
+	for _, m := range msgs {
+		assert.Contains(t, m.Message, "is not in a groupId/artifactId/version path")
+	}
+	assert.Empty(t, parseLocalGAVs([]string{shallow}, root))
+}
+
+func TestMergeValidationRules(t *testing.T) {
+	assert.Equal(t, DEFAULT_VALIDATION_RULES, mergeValidationRules([]config.Target{{Bucket: "a"}}))
+	rules := mergeValidationRules([]config.Target{
+		{Bucket: "a", ValidationRules: map[string]string{RULE_NO_SNAPSHOT: config.SEVERITY_ERROR}},
+		{Bucket: "b", ValidationRules: map[string]string{
+			RULE_NO_SNAPSHOT:   config.SEVERITY_WARNING,
+			RULE_SHA1_CHECKSUM: config.SEVERITY_WARNING,
+		}},
+	})
+	assert.Equal(t, map[string]string{
+		RULE_NO_SNAPSHOT:   config.SEVERITY_ERROR,
+		RULE_SHA1_CHECKSUM: config.SEVERITY_WARNING,
+	}, rules)
+}
@@ -2,10 +2,15 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
 	"org.commonjava/charon/module/util"
 )
 
@@ -33,22 +38,40 @@ const (
 	INVALIDATION_STATUS_INPROGRESS = "InProgress"
 )
 
+type cfClientIface interface {
+	cloudfront.ListDistributionsAPIClient
+	CreateInvalidation(ctx context.Context, params *cloudfront.CreateInvalidationInput, optFns ...func(*cloudfront.Options)) (*cloudfront.CreateInvalidationOutput, error)
+}
+
 type CFCLient struct {
+	ctx        context.Context
 	awsProfile string
-	client     *cloudfront.Client
+	client     cfClientIface
+	retryer    *retryer
 }
 
-func NewCFClient(awsProfile string) (*CFCLient, error) {
+// An invalidation request created in a CloudFront distribution
+type Invalidation struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Create the CloudFront client for a run. Once the ctx is cancelled, no new
+// invalidation will be created.
+func NewCFClient(ctx context.Context, awsProfile string) (*CFCLient, error) {
 	cfClient := &CFCLient{
+		ctx:        ctx,
 		awsProfile: awsProfile,
+		retryer:    newRetryer(),
 	}
 
 	var cfg aws.Config
 	var err error
 	if !util.IsBlankString(cfClient.awsProfile) {
-		cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithSharedConfigProfile(awsProfile))
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(awsProfile),
+			config.WithRetryer(noSdkRetry))
 	} else {
-		cfg, err = config.LoadDefaultConfig(context.TODO())
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithRetryer(noSdkRetry))
 	}
 
 	if err != nil {
@@ -60,3 +83,94 @@ func NewCFClient(awsProfile string) (*CFCLient, error) {
 
 	return cfClient, nil
 }
+
+// The CloudFront api which retries the failed requests with the retry policy
+func (c *CFCLient) api() cfClientIface {
+	return retryingCFClient{client: c.client, retryer: c.retryer, runCtx: c.ctx}
+}
+
+// Set the retry policy of the client, the zero fields of the policy will
+// use the defaults
+func (c *CFCLient) SetRetryPolicy(policy RetryPolicy) {
+	c.retryer.policy = policy.withDefaults()
+}
+
+// Get the number of the retried requests by the reasons of their failures
+func (c *CFCLient) RetryCounts() map[string]int {
+	return c.retryer.Counts()
+}
+
+// Get the domain of the bucket from the default bucket to domain mapping,
+// empty if the bucket is not a known one
+func (c *CFCLient) GetDomainByBucket(bucket string) string {
+	return DEFAULT_BUCKET_TO_DOMAIN[bucket]
+}
+
+// Get the id of the distribution which has the domain as its alias, empty
+// if no distribution is found
+func (c *CFCLient) GetDistIdByDomain(domain string) (string, error) {
+	paginator := cloudfront.NewListDistributionsPaginator(c.api(), &cloudfront.ListDistributionsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(c.ctx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("[CloudFront] Can not list distributions due to error: %s", err))
+			return "", err
+		}
+		if page.DistributionList == nil {
+			continue
+		}
+		for _, d := range page.DistributionList.Items {
+			if d.Aliases != nil && slices.Contains(d.Aliases.Items, domain) {
+				return aws.ToString(d.Id), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Invalidate the paths in the distribution. The paths will be split into
+// batches of batchSize, and one invalidation will be created for each batch.
+// Returns the invalidations created before any error happened.
+func (c *CFCLient) InvalidatePaths(distrId string, paths []string, batchSize int) ([]Invalidation, error) {
+	if batchSize <= 0 {
+		batchSize = INVALIDATION_BATCH_DEFAULT
+	}
+	invalidations := []Invalidation{}
+	for start := 0; start < len(paths); start += batchSize {
+		if err := c.ctx.Err(); err != nil {
+			logger.Warn(fmt.Sprintf("[CloudFront] Invalidation in distribution %s is interrupted, %d paths are not invalidated",
+				distrId, len(paths)-start))
+			return invalidations, err
+		}
+		batch := []string{}
+		for _, p := range paths[start:min(start+batchSize, len(paths))] {
+			if !strings.HasPrefix(p, "/") {
+				p = "/" + p
+			}
+			batch = append(batch, p)
+		}
+		logger.Debug(fmt.Sprintf("[CloudFront] Invalidating paths in distribution %s: %s", distrId, batch))
+		output, err := c.api().CreateInvalidation(context.WithoutCancel(c.ctx), &cloudfront.CreateInvalidationInput{
+			DistributionId: aws.String(distrId),
+			InvalidationBatch: &types.InvalidationBatch{
+				CallerReference: aws.String(fmt.Sprintf("charon-%d-%d", time.Now().UnixNano(), start)),
+				Paths: &types.Paths{
+					Quantity: aws.Int32(int32(len(batch))),
+					Items:    batch,
+				},
+			},
+		})
+		if err != nil {
+			logger.Error(fmt.Sprintf("[CloudFront] Can not invalidate paths in distribution %s due to error: %s",
+				distrId, err))
+			return invalidations, err
+		}
+		if output.Invalidation != nil {
+			invalidations = append(invalidations, Invalidation{
+				Id:     aws.ToString(output.Invalidation.Id),
+				Status: aws.ToString(output.Invalidation.Status),
+			})
+		}
+	}
+	return invalidations, nil
+}
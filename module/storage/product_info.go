@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	cfg "org.commonjava/charon/module/config"
+	"org.commonjava/charon/module/util"
+)
+
+const (
+	// The user metadata key of the products in metadata mode, the products
+	// are separated by ","
+	PRODUCTS_META_KEY = "products"
+	// The tag key of the products in tagging mode. As a tag value is limited
+	// to 256 characters, the products are separated by spaces and split into
+	// the tags "charon-products", "charon-products.1", "charon-products.2"...
+	PRODUCTS_TAG_KEY = "charon-products"
+
+	maxTagValueLength = 256
+	maxObjectTags     = 10
+)
+
+// The file of the product info sidecar does not exist, so the sidecar is
+// an orphan which can not be migrated
+var ErrOrphanProductInfo = errors.New("the file of the product info does not exist")
+
+// Set how the products of the files are tracked in the bucket, see
+// cfg.Target.ProductInfo. It should be set before the client is used.
+func (c *S3Client) SetProductInfoMode(bucket, mode string) {
+	if mode == "" {
+		mode = cfg.PRODUCT_INFO_SIDECAR
+	}
+	c.prodInfoModes[bucket] = mode
+}
+
+// Get the products of the file in the bucket, with the product info mode
+// of the bucket. Returns false if the product info can not be read, like
+// the file has no sidecar.
+func (c *S3Client) GetProductInfo(file, bucketName string) ([]string, bool) {
+	return c.getProductInfo(file, bucketName)
+}
+
+func (c *S3Client) productInfoMode(bucket string) string {
+	if mode, ok := c.prodInfoModes[bucket]; ok {
+		return mode
+	}
+	return cfg.PRODUCT_INFO_SIDECAR
+}
+
+// Delete the product info of a file which is deleted. Only the sidecar needs
+// to be deleted, as the tags and metadata are deleted along with the file.
+func (c *S3Client) deleteProductInfo(file, bucketName string) bool {
+	if c.productInfoMode(bucketName) != cfg.PRODUCT_INFO_SIDECAR {
+		return true
+	}
+	return c.updateProductInfo(file, bucketName, nil)
+}
+
+func splitProducts(content, sep string) []string {
+	prods := []string{}
+	for _, p := range strings.Split(content, sep) {
+		if p = strings.TrimSpace(p); p != "" {
+			prods = append(prods, p)
+		}
+	}
+	return prods
+}
+
+func isProductTag(key string) bool {
+	return key == PRODUCTS_TAG_KEY || strings.HasPrefix(key, PRODUCTS_TAG_KEY+".")
+}
+
+// Split the products into the tags with the limited value length
+func productTags(prods []string) []types.Tag {
+	tags := []types.Tag{}
+	value := ""
+	flush := func() {
+		key := PRODUCTS_TAG_KEY
+		if len(tags) > 0 {
+			key = fmt.Sprintf("%s.%d", PRODUCTS_TAG_KEY, len(tags))
+		}
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	for _, p := range prods {
+		if value != "" && len(value)+1+len(p) > maxTagValueLength {
+			flush()
+			value = ""
+		}
+		if value != "" {
+			value += " "
+		}
+		value += p
+	}
+	if value != "" {
+		flush()
+	}
+	return tags
+}
+
+// Encode the tags as the url query parameters, which is the format of the
+// tagging of PutObject
+func encodeTags(tags []types.Tag) string {
+	values := url.Values{}
+	for _, tag := range tags {
+		values.Add(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+func (c *S3Client) getObjectTags(file, bucketName string) ([]types.Tag, error) {
+	output, err := c.api().GetObjectTagging(c.ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(file),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.TagSet, nil
+}
+
+func (c *S3Client) getTaggingProductInfo(file, bucketName string) ([]string, bool) {
+	tags, err := c.getObjectTags(file, bucketName)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("[S3] WARN: Can not get product tags of file %s due to error: %s", file, err))
+		return []string{}, false
+	}
+	prods := []string{}
+	for _, tag := range tags {
+		if isProductTag(aws.ToString(tag.Key)) {
+			prods = append(prods, splitProducts(aws.ToString(tag.Value), " ")...)
+		}
+	}
+	logger.Debug(fmt.Sprintf("[S3] Got product information as below %s", prods))
+	return prods, true
+}
+
+// Update the product tags of a file, the other tags of the file are kept
+func (c *S3Client) updateTaggingProductInfo(file, bucketName string, prods []string) bool {
+	tags, err := c.getObjectTags(file, bucketName)
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not get tags of file %s in bucket %s due to error: %s",
+			file, bucketName, err))
+		return false
+	}
+	newTags := slices.DeleteFunc(tags, func(t types.Tag) bool { return isProductTag(aws.ToString(t.Key)) })
+	newTags = append(newTags, productTags(prods)...)
+	if len(newTags) > maxObjectTags {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not tag file %s in bucket %s with products %s, "+
+			"as it needs %d tags which is more than %d", file, bucketName, prods, len(newTags), maxObjectTags))
+		return false
+	}
+	if len(newTags) == 0 {
+		_, err = c.api().DeleteObjectTagging(c.writeCtx(), &s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(file),
+		})
+	} else {
+		_, err = c.api().PutObjectTagging(c.writeCtx(), &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(bucketName),
+			Key:     aws.String(file),
+			Tagging: &types.Tagging{TagSet: newTags},
+		})
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not update product tags of file %s in bucket %s due to error: %s",
+			file, bucketName, err))
+		return false
+	}
+	logger.Debug(fmt.Sprintf("[S3] Updated product information of file %s: %s", file, prods))
+	return true
+}
+
+func (c *S3Client) getMetadataProductInfo(file, bucketName string) ([]string, bool) {
+	existed, fMeta, err := c.headObject(bucketName, file)
+	if err != nil || !existed {
+		logger.Warn(fmt.Sprintf("[S3] WARN: Can not get product metadata of file %s, existed: %v, error: %v",
+			file, existed, err))
+		return []string{}, false
+	}
+	prods := splitProducts(fMeta[PRODUCTS_META_KEY], ",")
+	logger.Debug(fmt.Sprintf("[S3] Got product information as below %s", prods))
+	return prods, true
+}
+
+// Update the product metadata of a file
+func (c *S3Client) updateMetadataProductInfo(file, bucketName string, prods []string) bool {
+	ok := c.updateObjectMetadata(file, bucketName, func(fMeta map[string]string) {
+		if len(prods) == 0 {
+			delete(fMeta, PRODUCTS_META_KEY)
+		} else {
+			fMeta[PRODUCTS_META_KEY] = strings.Join(prods, ",")
+		}
+	})
+	if ok {
+		logger.Debug(fmt.Sprintf("[S3] Updated product information of file %s: %s", file, prods))
+	}
+	return ok
+}
+
+// Migrate the .prodinfo sidecar of the file to the product info mode of the
+// bucket. The products in the sidecar are merged with the products already
+// tracked in the new mode, and the sidecar is deleted only after the merged
+// products are read back from the new mode. Returns ErrOrphanProductInfo if
+// the file of the sidecar does not exist.
+func (c *S3Client) MigrateProductInfo(file, bucketName string) ([]string, error) {
+	mode := c.productInfoMode(bucketName)
+	if mode == cfg.PRODUCT_INFO_SIDECAR {
+		return nil, fmt.Errorf("bucket %s uses the %s product info", bucketName, mode)
+	}
+	sidecar := file + util.PROD_INFO_SUFFIX
+	content, err := c.ReadFileContent(bucketName, sidecar)
+	if err != nil {
+		return nil, fmt.Errorf("can not read %s: %s", sidecar, err)
+	}
+	existed, err := c.FileExistsInBucket(bucketName, file)
+	if err != nil {
+		return nil, fmt.Errorf("existence check failed: %s", err)
+	}
+	if !existed {
+		return nil, ErrOrphanProductInfo
+	}
+	prods, ok := c.getProductInfo(file, bucketName)
+	if !ok {
+		return nil, fmt.Errorf("can not read the %s product info", mode)
+	}
+	for _, p := range splitProducts(content, ",") {
+		if !slices.Contains(prods, p) {
+			prods = append(prods, p)
+		}
+	}
+	if c.dryRun {
+		return prods, nil
+	}
+	if !c.updateProductInfo(file, bucketName, prods) {
+		return nil, fmt.Errorf("can not write the %s product info", mode)
+	}
+	got, ok := c.getProductInfo(file, bucketName)
+	if !ok || len(got) != len(prods) || slices.ContainsFunc(prods, func(p string) bool { return !slices.Contains(got, p) }) {
+		return nil, fmt.Errorf("verification failed, expected products %s but got %s", prods, got)
+	}
+	_, err = c.api().DeleteObject(c.writeCtx(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(sidecar),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not delete %s: %s", sidecar, err)
+	}
+	return prods, nil
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	DEFAULT_RETRY_MAX_ATTEMPTS    = 3
+	DEFAULT_RETRY_INITIAL_BACKOFF = 200 * time.Millisecond
+	DEFAULT_RETRY_MAX_BACKOFF     = 20 * time.Second
+
+	RETRY_REASON_THROTTLED  = "throttled"
+	RETRY_REASON_CONNECTION = "connection error"
+)
+
+// RetryPolicy decides how the failed requests to s3 and CloudFront are
+// retried. The delay before each retry grows exponentially from the initial
+// backoff up to the max backoff, with a random jitter. The throttled requests,
+// like SlowDown or 503, wait twice as long before the retry.
+type RetryPolicy struct {
+	// The attempts of a request including the first one, 1 means no retry
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    DEFAULT_RETRY_MAX_ATTEMPTS,
+	InitialBackoff: DEFAULT_RETRY_INITIAL_BACKOFF,
+	MaxBackoff:     DEFAULT_RETRY_MAX_BACKOFF,
+}
+
+// Set the retry policy used by the clients created after it. The zero
+// fields of the policy will use the defaults.
+func SetDefaultRetryPolicy(policy RetryPolicy) {
+	defaultRetryPolicy = policy.withDefaults()
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DEFAULT_RETRY_MAX_ATTEMPTS
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DEFAULT_RETRY_INITIAL_BACKOFF
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DEFAULT_RETRY_MAX_BACKOFF
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	return p
+}
+
+// The delay before the retry after the attempt failed, with the jitter
+// in [delay/2, delay]
+func (p RetryPolicy) backoff(attempt int, throttled bool) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if throttled {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxBackoff)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// The retryer does the retrying for a client, and counts the retries by
+// their reasons. It is shared by the copies of the client.
+type retryer struct {
+	policy RetryPolicy
+	counts map[string]int
+	lock   sync.Mutex
+}
+
+func newRetryer() *retryer {
+	return &retryer{policy: defaultRetryPolicy.withDefaults(), counts: map[string]int{}}
+}
+
+// The retrying is done by the retryer, so the one of the aws sdk is disabled
+func noSdkRetry() aws.Retryer {
+	return aws.NopRetryer{}
+}
+
+// Do the request until it succeeds, fails with an error which can not be
+// retried, or runs out of the attempts. The waiting for the retry stops
+// when ctx is cancelled. Returns the error of the last attempt.
+func (r *retryer) do(ctx context.Context, name string, request func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := request()
+		if err == nil {
+			return nil
+		}
+		reason, retryable := retryReason(err)
+		if !retryable || attempt >= r.policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		delay := r.policy.backoff(attempt, reason == RETRY_REASON_THROTTLED)
+		r.count(reason)
+		logger.Warn(fmt.Sprintf("[Retry] %s failed as %s (attempt %d/%d), will retry in %s: %s",
+			name, reason, attempt, r.policy.MaxAttempts, delay, err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *retryer) count(reason string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.counts[reason]++
+}
+
+// Get the number of retries by their reasons
+func (r *retryer) Counts() map[string]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	counts := make(map[string]int, len(r.counts))
+	for k, v := range r.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// Get the reason of the error if it can be retried. The errors are
+// classified in the same way as the aws sdk, and 503 is also seen as
+// throttling as s3 uses it for SlowDown.
+func retryReason(err error) (string, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "", false
+	}
+	var re *awshttp.ResponseError
+	statusCode := 0
+	if errors.As(err, &re) {
+		statusCode = re.HTTPStatusCode()
+	}
+	if statusCode == 503 || retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err).Bool() {
+		return RETRY_REASON_THROTTLED, true
+	}
+	if !retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err).Bool() {
+		return "", false
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() != "" {
+		return ae.ErrorCode(), true
+	}
+	if statusCode != 0 {
+		return fmt.Sprintf("http %d", statusCode), true
+	}
+	return RETRY_REASON_CONNECTION, true
+}
+
+// The s3 api which retries the requests with the retryer. The waiting for
+// the retry stops when runCtx is cancelled, while the requests use their
+// own contexts.
+type retryingS3Client struct {
+	client  s3ClientIface
+	retryer *retryer
+	runCtx  context.Context
+}
+
+// The bodies of the uploading are rewound before each attempt
+func rewind(body io.Reader) error {
+	if s, ok := body.(io.Seeker); ok {
+		_, err := s.Seek(0, io.SeekStart)
+		return err
+	}
+	return nil
+}
+
+func s3RequestName(op string, bucket, key *string) string {
+	return fmt.Sprintf("[S3] %s %s/%s", op, aws.ToString(bucket), aws.ToString(key))
+}
+
+func (r retryingS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input,
+	optFns ...func(*s3.Options)) (output *s3.ListObjectsV2Output, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("ListObjectsV2", params.Bucket, params.Prefix), func() error {
+		output, err = r.client.ListObjectsV2(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput,
+	optFns ...func(*s3.Options)) (output *s3.HeadObjectOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("HeadObject", params.Bucket, params.Key), func() error {
+		output, err = r.client.HeadObject(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput,
+	optFns ...func(*s3.Options)) (output *s3.GetObjectOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("GetObject", params.Bucket, params.Key), func() error {
+		output, err = r.client.GetObject(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput,
+	optFns ...func(*s3.Options)) (output *s3.PutObjectOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("PutObject", params.Bucket, params.Key), func() error {
+		if err := rewind(params.Body); err != nil {
+			return err
+		}
+		output, err = r.client.PutObject(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput,
+	optFns ...func(*s3.Options)) (output *s3.DeleteObjectOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("DeleteObject", params.Bucket, params.Key), func() error {
+		output, err = r.client.DeleteObject(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput,
+	optFns ...func(*s3.Options)) (output *s3.CopyObjectOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("CopyObject", params.Bucket, params.Key), func() error {
+		output, err = r.client.CopyObject(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput,
+	optFns ...func(*s3.Options)) (output *s3.GetObjectTaggingOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("GetObjectTagging", params.Bucket, params.Key), func() error {
+		output, err = r.client.GetObjectTagging(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput,
+	optFns ...func(*s3.Options)) (output *s3.PutObjectTaggingOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("PutObjectTagging", params.Bucket, params.Key), func() error {
+		output, err = r.client.PutObjectTagging(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingS3Client) DeleteObjectTagging(ctx context.Context, params *s3.DeleteObjectTaggingInput,
+	optFns ...func(*s3.Options)) (output *s3.DeleteObjectTaggingOutput, err error) {
+	err = r.retryer.do(r.runCtx, s3RequestName("DeleteObjectTagging", params.Bucket, params.Key), func() error {
+		output, err = r.client.DeleteObjectTagging(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+// The CloudFront api which retries the requests with the retryer, like
+// retryingS3Client
+type retryingCFClient struct {
+	client  cfClientIface
+	retryer *retryer
+	runCtx  context.Context
+}
+
+func (r retryingCFClient) ListDistributions(ctx context.Context, params *cloudfront.ListDistributionsInput,
+	optFns ...func(*cloudfront.Options)) (output *cloudfront.ListDistributionsOutput, err error) {
+	err = r.retryer.do(r.runCtx, "[CloudFront] ListDistributions", func() error {
+		output, err = r.client.ListDistributions(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
+
+func (r retryingCFClient) CreateInvalidation(ctx context.Context, params *cloudfront.CreateInvalidationInput,
+	optFns ...func(*cloudfront.Options)) (output *cloudfront.CreateInvalidationOutput, err error) {
+	name := "[CloudFront] CreateInvalidation " + aws.ToString(params.DistributionId)
+	err = r.retryer.do(r.runCtx, name, func() error {
+		output, err = r.client.CreateInvalidation(ctx, params, optFns...)
+		return err
+	})
+	return output, err
+}
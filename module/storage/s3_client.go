@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"crypto"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +12,8 @@ import (
 	"path"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -26,7 +30,10 @@ var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 const (
 	DEFAULT_MIME_TYPE        = "application/octet-stream"
 	CHECKSUM_META_KEY        = "checksum"
+	MANIFEST_FOLDER_SUFFIX   = "-charon-metadata"
 	DEFAULT_CONCURRENT_LIMIT = 10
+	// The largest object which can be copied by a single CopyObject
+	MAX_COPY_OBJECT_SIZE = 5 * 1024 * 1024 * 1024
 )
 
 type s3ClientIface interface {
@@ -36,28 +43,77 @@ type s3ClientIface interface {
 	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error)
+	DeleteObjectTagging(ctx context.Context, params *s3.DeleteObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectTaggingOutput, error)
 }
 
 type S3Client struct {
+	ctx        context.Context
 	awsProfile string
 	conLimit   int
 	dryRun     bool
 	client     s3ClientIface
+	failures   *pathFailures
+	journal    UploadJournal
+	retryer    *retryer
+	prefetch   *prefetchCache
+	// The product info modes of the buckets, the buckets not set use sidecars
+	prodInfoModes map[string]string
 }
 
-func NewS3Client(awsProfile string, conLimit int, dryRun bool) (*S3Client, error) {
+// UploadJournal records the files completed by the uploading in each bucket,
+// so that an interrupted uploading can skip them when it is resumed
+type UploadJournal interface {
+	IsUploaded(bucket, key string) bool
+	Uploaded(bucket, key string)
+}
+
+// Set the journal used by UploadFiles to skip and record the completed files
+func (c *S3Client) SetUploadJournal(journal UploadJournal) {
+	c.journal = journal
+}
+
+func (c *S3Client) isUploaded(bucket, key string) bool {
+	return c.journal != nil && c.journal.IsUploaded(bucket, key)
+}
+
+func (c *S3Client) uploaded(bucket, key string) {
+	if c.journal != nil && !c.dryRun {
+		c.journal.Uploaded(bucket, key)
+	}
+}
+
+// The causes of the paths failed in the handlers, keyed by the full path.
+// It is shared by the copies of the S3Client.
+type pathFailures struct {
+	causes map[string]string
+	lock   sync.Mutex
+}
+
+// Create the s3 client for a run. The ctx is the context of the run: once
+// it is cancelled, no new file will be handled by UploadFiles, UploadMetadatas
+// and DeleteFiles, but the requests already sent will be finished.
+func NewS3Client(ctx context.Context, awsProfile string, conLimit int, dryRun bool) (*S3Client, error) {
 	s3Client := &S3Client{
+		ctx:        ctx,
 		awsProfile: awsProfile,
 		conLimit:   conLimit,
 		dryRun:     dryRun,
+		failures:   &pathFailures{causes: map[string]string{}},
+		retryer:    newRetryer(),
+		prefetch:   newPrefetchCache(),
+
+		prodInfoModes: map[string]string{},
 	}
 
 	var cfg aws.Config
 	var err error
 	if !util.IsBlankString(s3Client.awsProfile) {
-		cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithSharedConfigProfile(awsProfile))
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(awsProfile),
+			config.WithRetryer(noSdkRetry))
 	} else {
-		cfg, err = config.LoadDefaultConfig(context.TODO())
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithRetryer(noSdkRetry))
 	}
 
 	if err != nil {
@@ -69,39 +125,111 @@ func NewS3Client(awsProfile string, conLimit int, dryRun bool) (*S3Client, error
 	return s3Client, nil
 }
 
+// The context of the run which the client is created for
+func (c *S3Client) Context() context.Context {
+	return c.ctx
+}
+
+// The s3 api which retries the failed requests with the retry policy
+func (c *S3Client) api() s3ClientIface {
+	return retryingS3Client{client: c.client, retryer: c.retryer, runCtx: c.ctx}
+}
+
+// Set the retry policy of the client, the zero fields of the policy will
+// use the defaults
+func (c *S3Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryer.policy = policy.withDefaults()
+}
+
+// Get the number of the retried requests by the reasons of their failures
+func (c *S3Client) RetryCounts() map[string]int {
+	return c.retryer.Counts()
+}
+
+// Get a copy of the client which works in the ctx, like for a new run
+func (c *S3Client) WithContext(ctx context.Context) *S3Client {
+	copied := *c
+	copied.ctx = ctx
+	return &copied
+}
+
+// The context for the requests which change the buckets. It is not cancelled
+// with the run, so that a file being written is not left half done.
+func (c *S3Client) writeCtx() context.Context {
+	return context.WithoutCancel(c.ctx)
+}
+
+// This FileInfo represents an object in s3 bucket with the information
+// returned by the listing, which can be used without extra reading of
+// the object.
+type FileInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+func (f FileInfo) IsDir() bool {
+	return strings.HasSuffix(f.Key, "/")
+}
+
 // Get the file names from s3 bucket. Can use prefix and suffix to filter the
 // files wanted. If some error happend, will return an empty file list and false result
 func (c *S3Client) GetFiles(bucket string, prefix string, suffix string) ([]string, bool) {
+	infos, ok := c.GetFileInfos(bucket, prefix, suffix)
+	if !ok {
+		return []string{}, false
+	}
+	var files []string
+	for _, info := range infos {
+		files = append(files, info.Key)
+	}
+	return files, true
+}
+
+// Get the files with their listing information from s3 bucket. All pages of
+// the listing will be walked. Can use prefix and suffix to filter the files
+// wanted. If some error happend, will return an empty list and false result
+func (c *S3Client) GetFileInfos(bucket string, prefix string, suffix string) ([]FileInfo, bool) {
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
 	}
 	if !util.IsBlankString(prefix) {
 		input.Prefix = aws.String(prefix)
 	}
-	result, err := c.client.ListObjectsV2(context.TODO(), input)
-	var contents []types.Object
-	if err != nil {
-		logger.Error(fmt.Sprintf("[S3] ERROR: Can not get files under %s in bucket %s due to error: %s ", prefix,
-			bucket, err))
-		return []string{}, false
-	} else {
-		contents = result.Contents
-	}
-	var files []string
-
-	if !util.IsBlankString(suffix) {
-		for _, v := range contents {
+	paginator := s3.NewListObjectsV2Paginator(c.api(), input)
+	var infos []FileInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(c.ctx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("[S3] ERROR: Can not get files under %s in bucket %s due to error: %s ", prefix,
+				bucket, err))
+			return []FileInfo{}, false
+		}
+		for _, v := range page.Contents {
 			fileName := *v.Key
-			if strings.HasSuffix(fileName, suffix) {
-				files = append(files, fileName)
+			if !util.IsBlankString(suffix) && !strings.HasSuffix(fileName, suffix) {
+				continue
 			}
-		}
-	} else {
-		for _, v := range contents {
-			files = append(files, *v.Key)
+			info := toFileInfo(v)
+			infos = append(infos, info)
 		}
 	}
-	return files, true
+	return infos, true
+}
+
+func toFileInfo(o types.Object) FileInfo {
+	info := FileInfo{Key: aws.ToString(o.Key)}
+	if o.Size != nil {
+		info.Size = *o.Size
+	}
+	if o.LastModified != nil {
+		info.LastModified = *o.LastModified
+	}
+	if o.ETag != nil {
+		info.ETag = strings.Trim(*o.ETag, "\"")
+	}
+	return info
 }
 
 func (c *S3Client) ReadFileContent(bucket, key string) (string, error) {
@@ -122,63 +250,101 @@ func (c *S3Client) DownloadFile(bucket, key, filePath string) error {
 		return err
 	}
 	realFilePath := path.Join(filePath, key)
-	files.StoreFile(realFilePath, string(contentBytes), true)
-	return nil
+	return files.StoreFile(realFilePath, string(contentBytes), true)
+}
+
+// Calculate the digest of the file in the bucket with the hash. The content
+// is streamed through the hash, so large files are not loaded into memory.
+func (c *S3Client) DigestFile(bucket, key string, hash crypto.Hash) (string, error) {
+	output, err := c.api().GetObject(c.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not read file %s in bucket %s due to error: %s ", key,
+			bucket, err))
+		return "", err
+	}
+	defer output.Body.Close()
+	h := hash.New()
+	if _, err := io.Copy(h, output.Body); err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not read file %s in bucket %s due to error: %s ", key,
+			bucket, err))
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // List the content in folder in an s3 bucket. Note it's not recursive,
 // which means the content only contains the items in that folder, but
 // not in its subfolders.
 func (c *S3Client) ListFolderContent(bucket, folder string) []string {
+	infos, _ := c.ListFolderInfos(bucket, folder)
+	contents := make([]string, 0, len(infos))
+	for _, info := range infos {
+		contents = append(contents, info.Key)
+	}
+	return contents
+}
+
+// List the content in folder in an s3 bucket with the listing information,
+// like ListFolderContent. The sub folders are also in the result with the
+// key ending with "/" and no other information. If some error happend, will
+// return an empty list and false result
+func (c *S3Client) ListFolderInfos(bucket, folder string) ([]FileInfo, bool) {
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
 	}
-	if strings.HasSuffix(folder, "/") {
-		input.Prefix = aws.String(folder)
-	} else {
-		input.Prefix = aws.String(folder + "/")
+	// The root of bucket should be listed without prefix
+	if folder != "" && folder != "/" {
+		if strings.HasSuffix(folder, "/") {
+			input.Prefix = aws.String(folder)
+		} else {
+			input.Prefix = aws.String(folder + "/")
+		}
 	}
 	input.Delimiter = aws.String("/")
-	paginator := s3.NewListObjectsV2Paginator(c.client, input)
+	paginator := s3.NewListObjectsV2Paginator(c.api(), input)
 
-	contents := []string{}
+	contents := []FileInfo{}
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.TODO())
+		page, err := paginator.NextPage(c.ctx)
 		if err != nil {
 			logger.Error(fmt.Sprintf("[S3] ERROR: Can not get contents of %s from bucket %s due to error: %s", folder,
 				bucket, err.Error()))
-			return []string{}
+			return []FileInfo{}, false
 		}
 
-		folders := page.CommonPrefixes
-		if len(folders) > 0 {
-			for _, f := range folders {
-				contents = append(contents, *f.Prefix)
-			}
+		for _, f := range page.CommonPrefixes {
+			contents = append(contents, FileInfo{Key: aws.ToString(f.Prefix)})
 		}
-		files := page.Contents
-		if len(files) > 0 {
-			for _, f := range files {
-				contents = append(contents, *f.Key)
-			}
+		for _, f := range page.Contents {
+			contents = append(contents, toFileInfo(f))
 		}
 	}
-	return contents
+	return contents, true
 }
 
 func (c *S3Client) FileExistsInBucket(bucket, fPath string) (bool, error) {
-	_, err := c.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+	existed, _, err := c.headObject(bucket, fPath)
+	return existed, err
+}
+
+// Check the existence of the object and get its user metadata with a single
+// HEAD request, without downloading its content
+func (c *S3Client) headObject(bucket, key string) (bool, map[string]string, error) {
+	output, err := c.api().HeadObject(c.ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(fPath),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		var ae *types.NotFound
 		if errors.As(err, &ae) {
-			return false, nil
+			return false, nil, nil
 		}
-		return false, err
+		return false, nil, err
 	}
-	return true, nil
+	return true, output.Metadata, nil
 }
 
 // Deletes file in s3 bucket, regardless of any extra
@@ -195,7 +361,7 @@ func (c *S3Client) SimpleDeleteFile(filePath string, target cfg.Target) bool {
 	// try:
 	existed, _ := c.FileExistsInBucket(bucket, pathKey)
 	if existed {
-		_, err := c.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		_, err := c.api().DeleteObject(c.writeCtx(), &s3.DeleteObjectInput{
 			Bucket: aws.String(bucket),
 			Key:    aws.String(pathKey),
 		})
@@ -203,6 +369,7 @@ func (c *S3Client) SimpleDeleteFile(filePath string, target cfg.Target) bool {
 			logger.Error(fmt.Sprintf("Error: Can not delete file due to error: %s", err.Error()))
 			return false
 		}
+		c.prefetch.forget(bucket, pathKey)
 		return true
 	} else {
 		logger.Warn(
@@ -242,7 +409,7 @@ func (c *S3Client) SimpleUploadFile(filePath, fileContent string,
 			fMeta[CHECKSUM_META_KEY] = checksumSHA1
 		}
 		if !c.dryRun {
-			_, err := c.client.PutObject(context.TODO(), &s3.PutObjectInput{
+			_, err := c.api().PutObject(c.writeCtx(), &s3.PutObjectInput{
 				Bucket:      aws.String(bucket),
 				Key:         aws.String(pathKey),
 				Body:        strings.NewReader(fileContent),
@@ -255,6 +422,7 @@ func (c *S3Client) SimpleUploadFile(filePath, fileContent string,
 					filePath, bucket, err))
 				return err
 			}
+			c.prefetch.forget(bucket, pathKey)
 			logger.Debug(fmt.Sprintf("Uploaded %s to bucket %s", pathKey, bucket))
 		}
 	} else {
@@ -263,6 +431,53 @@ func (c *S3Client) SimpleUploadFile(filePath, fileContent string,
 	return nil
 }
 
+// Replace the content of the file in the bucket, like repairing a .sha1 file.
+// Overwriting an object drops its tags and user metadata, so the existing
+// ones are written along with the new content, which keeps the products in
+// tagging and metadata mode. The checksum metadata is set for the new content.
+func (c *S3Client) ReplaceFileContent(bucket, key, content string) bool {
+	if c.dryRun {
+		return true
+	}
+	existed, fMeta, err := c.headObject(bucket, key)
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not get metadata of file %s in bucket %s due to error: %s",
+			key, bucket, err))
+		return false
+	}
+	meta := map[string]string{}
+	for k, v := range fMeta {
+		meta[k] = v
+	}
+	meta[CHECKSUM_META_KEY] = files.DigestContent(content, files.SHA1)
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        strings.NewReader(content),
+		ContentType: aws.String(DEFAULT_MIME_TYPE),
+		Metadata:    meta,
+	}
+	if existed && c.productInfoMode(bucket) == cfg.PRODUCT_INFO_TAGGING {
+		tags, err := c.getObjectTags(key, bucket)
+		if err != nil {
+			logger.Error(fmt.Sprintf("[S3] ERROR: Can not get tags of file %s in bucket %s due to error: %s",
+				key, bucket, err))
+			return false
+		}
+		if len(tags) > 0 {
+			input.Tagging = aws.String(encodeTags(tags))
+		}
+	}
+	if _, err := c.api().PutObject(c.writeCtx(), input); err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: file %s not replaced in bucket %s due to error: %s",
+			key, bucket, err))
+		return false
+	}
+	c.prefetch.forget(bucket, key)
+	logger.Debug(fmt.Sprintf("[S3] Replaced content of file %s in bucket %s", key, bucket))
+	return true
+}
+
 // Upload a list of files to s3 bucket.
 //
 // * Use the cut down file path as s3 key. The cut down way is move root from the file path if it starts with root. Example: if file_path is
@@ -283,35 +498,35 @@ func (c *S3Client) SimpleUploadFile(filePath, fileContent string,
 // if the checksum does not match the existed one, will not upload it and report error.
 // Note that if file name match
 //
-// * Return all failed to upload files due to any exceptions.
+// * Return all failed to upload files due to any exceptions, keyed by the
+// buckets which they failed in. A file failed in the first target is seen as
+// failed in all the targets, as it is copied to the others from there.
 func (c *S3Client) UploadFiles(filePaths []string, targets []cfg.Target,
-	product string, root string) []string {
-	realRoot := root
-	if util.IsBlankString(realRoot) {
-		realRoot = "/"
-	}
-
+	product string, root string) map[string][]string {
 	mainTarget := targets[0]
 	mainBucket := mainTarget.Bucket
 	keyPrefix := mainTarget.Prefix
 	var extraPrefixedBuckets []cfg.Target
 	if len(targets) > 1 {
-		extraPrefixedBuckets = make([]cfg.Target, len(targets))
-		for i, t := range targets {
-			if i >= 1 {
-				extraPrefixedBuckets[i] = t
-			}
-		}
+		extraPrefixedBuckets = targets[1:]
 	}
-	return doPathCutAnd(product, mainBucket, keyPrefix, filePaths, extraPrefixedBuckets, c.pathUploadHandler, root)
+	return c.doPathCutAndByBucket(product, mainBucket, keyPrefix, filePaths, extraPrefixedBuckets,
+		c.pathUploadHandler, root)
 }
 
+// Upload the file to the main bucket and copy it to the extra buckets.
+// Returns the buckets which the file failed in.
 func (c *S3Client) pathUploadHandler(product, mainBucket, keyPrefix, fullFilePath, fPath string, index,
-	total int, extraPrefixedBuckets []cfg.Target) bool {
+	total int, extraPrefixedBuckets []cfg.Target) []string {
+	allBuckets := []string{mainBucket}
+	for _, target := range extraPrefixedBuckets {
+		allBuckets = append(allBuckets, target.Bucket)
+	}
 	if !files.IsFile(fullFilePath) {
 		logger.Warn(fmt.Sprintf("[S3] Warning: file %s does not exist during uploading. Product: %s",
 			fullFilePath, product))
-		return false
+		c.recordFailure(fullFilePath, "file does not exist")
+		return allBuckets
 	}
 	logger.Debug(fmt.Sprintf("[S3] (%d/%d) Uploading %s to bucket %s",
 		index, total, fullFilePath, mainBucket))
@@ -319,12 +534,68 @@ func (c *S3Client) pathUploadHandler(product, mainBucket, keyPrefix, fullFilePat
 	if !util.IsBlankString(keyPrefix) {
 		mainPathKey = path.Join(keyPrefix, fPath)
 	}
-	existed, err := c.FileExistsInBucket(mainBucket, mainPathKey)
+	sha1 := files.ReadSHA1(fullFilePath)
+	if !c.isUploaded(mainBucket, mainPathKey) {
+		if ok := c.uploadToMainBucket(product, mainBucket, mainPathKey, fullFilePath, fPath, sha1); !ok {
+			return allBuckets
+		}
+		c.uploaded(mainBucket, mainPathKey)
+	} else {
+		logger.Debug(fmt.Sprintf("[S3] %s is already uploaded to bucket %s, skipped", fPath, mainBucket))
+	}
+
+	failedBuckets := []string{}
+	for _, target := range extraPrefixedBuckets {
+		extraBucket := target.Bucket
+		extraPathKey := fPath
+		if !util.IsBlankString(target.Prefix) {
+			extraPathKey = path.Join(target.Prefix, fPath)
+		}
+		if c.isUploaded(extraBucket, extraPathKey) {
+			continue
+		}
+		if !c.copyToExtraBucket(product, mainBucket, mainPathKey, extraBucket, extraPathKey, fullFilePath, sha1) {
+			// The failure in an extra bucket does not affect the other buckets
+			c.scopeFailure(extraBucket, fullFilePath)
+			failedBuckets = append(failedBuckets, extraBucket)
+			continue
+		}
+		c.uploaded(extraBucket, extraPathKey)
+	}
+	return failedBuckets
+}
+
+func (c *S3Client) copyToExtraBucket(product, mainBucket, mainPathKey, extraBucket, extraPathKey,
+	fullFilePath, sha1 string) bool {
+	logger.Debug(fmt.Sprintf("Copyinging %s from bucket %s to bucket %s",
+		fullFilePath, mainBucket, extraBucket))
+	existed, checksum, _ := c.checkExisted(extraBucket, extraPathKey, fullFilePath, sha1)
+	if existed {
+		return c.handleExisted(fullFilePath, sha1, checksum, extraPathKey, extraBucket, product)
+	}
+	if c.dryRun {
+		return true
+	}
+	if !c.copyBetweenBucket(mainBucket, mainPathKey, extraBucket, extraPathKey) {
+		logger.Error(fmt.Sprintf("[S3] ERROR: copying failure happend for file %s to bucket %s",
+			fullFilePath, extraBucket))
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("copy from bucket %s to bucket %s failed", mainBucket, extraBucket))
+	}
+	if !util.IsBlankString(product) && !c.updateProductInfo(extraPathKey, extraBucket, []string{product}) {
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("can not update product info in bucket %s", extraBucket))
+	}
+	return true
+}
+
+func (c *S3Client) uploadToMainBucket(product, mainBucket, mainPathKey, fullFilePath, fPath, sha1 string) bool {
+	existed, checksum, err := c.checkExisted(mainBucket, mainPathKey, fullFilePath, sha1)
 	if err != nil {
 		logger.Error(fmt.Sprintf("[S3] Error: file existence check failed due to error: %s", err))
-		return false
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("existence check failed in bucket %s: %s", mainBucket, err))
 	}
-	sha1 := files.ReadSHA1(fullFilePath)
 	contentType := files.GuessMimetype(fullFilePath)
 	if contentType == "" {
 		contentType = DEFAULT_MIME_TYPE
@@ -334,75 +605,318 @@ func (c *S3Client) pathUploadHandler(product, mainBucket, keyPrefix, fullFilePat
 		if sha1 != "" {
 			fMeta[CHECKSUM_META_KEY] = sha1
 		}
+		// The product info in metadata is stored along with the new object
+		prodInMeta := !util.IsBlankString(product) && c.productInfoMode(mainBucket) == cfg.PRODUCT_INFO_METADATA
+		if prodInMeta {
+			fMeta[PRODUCTS_META_KEY] = product
+		}
 		if !c.dryRun {
-			var err error
+			f, err := os.Open(fullFilePath)
+			if err != nil {
+				logger.Error(fmt.Sprintf("[S3] ERROR: can not read file %s due to error: %s ", fullFilePath, err))
+				return c.recordFailure(fullFilePath, fmt.Sprintf("can not read file: %s", err))
+			}
+			defer f.Close()
+			input := &s3.PutObjectInput{
+				Bucket:      aws.String(mainBucket),
+				Key:         aws.String(mainPathKey),
+				Body:        f,
+				ContentType: aws.String(contentType),
+			}
 			if len(fMeta) > 0 {
-				_, err = c.client.PutObject(context.TODO(), &s3.PutObjectInput{
-					Bucket:      aws.String(mainBucket),
-					Key:         aws.String(mainPathKey),
-					Body:        strings.NewReader(fullFilePath),
-					ContentType: aws.String(contentType),
-					Metadata:    fMeta,
-				})
-			} else {
-				_, err = c.client.PutObject(context.TODO(), &s3.PutObjectInput{
-					Bucket:      aws.String(mainBucket),
-					Key:         aws.String(mainPathKey),
-					Body:        strings.NewReader(fullFilePath),
-					ContentType: aws.String(contentType),
-				})
+				input.Metadata = fMeta
 			}
+			_, err = c.api().PutObject(c.writeCtx(), input)
 			if err != nil {
 				logger.Error(fmt.Sprintf("[S3] ERROR: file %s not uploaded to bucket %s due to error: %s ", fullFilePath,
 					mainBucket, err))
-				return false
+				return c.recordFailure(fullFilePath, fmt.Sprintf("upload to bucket %s failed: %s", mainBucket, err))
 			}
-			if !util.IsBlankString(product) {
-				c.updateProductInfo(mainPathKey, mainBucket, []string{product})
+			c.prefetch.forget(mainBucket, mainPathKey)
+			if !util.IsBlankString(product) && !prodInMeta &&
+				!c.updateProductInfo(mainPathKey, mainBucket, []string{product}) {
+				return c.recordFailure(fullFilePath,
+					fmt.Sprintf("can not update product info in bucket %s", mainBucket))
 			}
 		}
 		logger.Debug(fmt.Sprintf("[S3] Uploaded %s to bucket %s", fPath, mainBucket))
-	} else {
-		c.handleExisted(fullFilePath, sha1, mainPathKey, mainBucket, product)
+	} else if !c.handleExisted(fullFilePath, sha1, checksum, mainPathKey, mainBucket, product) {
+		return false
 	}
+	return true
+}
 
-	for _, target := range extraPrefixedBuckets {
-		extraBucket := target.Bucket
-		extraPrefix := target.Prefix
-		extraPathKey := fPath
-		if !util.IsBlankString(extraPrefix) {
-			extraPathKey = path.Join(extraPrefix, fPath)
-		}
-		logger.Debug(fmt.Sprintf("Copyinging %s from bucket %s to bucket %s",
-			fullFilePath, mainBucket, extraBucket))
-		existed, _ := c.FileExistsInBucket(extraBucket, extraPathKey)
-		if !existed {
-			if !c.dryRun {
-				ok := c.copyBetweenBucket(mainBucket, mainPathKey, extraBucket, extraPathKey)
-				if !ok {
-					logger.Error(fmt.Sprintf("[S3] ERROR: copying failure happend for file %s to bucket %s due to error: %s ",
-						fullFilePath, extraBucket, err))
-					return false
-				}
-				if !util.IsBlankString(product) {
-					c.updateProductInfo(extraPathKey, extraBucket, []string{product})
-				}
+const (
+	PLAN_CREATE      = "create"
+	PLAN_IDENTICAL   = "identical"
+	PLAN_MISMATCH    = "mismatch"
+	PLAN_ADD_PRODUCT = "add-product"
+)
+
+// Plan the uploading of the files to the target without any writing. The
+// files are checked against the bucket in the same way as UploadFiles:
+//
+// * PLAN_CREATE: the file does not exist in the bucket and will be created
+//
+// * PLAN_IDENTICAL: the file exists with the same checksum and product, will be skipped
+//
+// * PLAN_MISMATCH: the file exists with a different checksum, will be rejected
+//
+// * PLAN_ADD_PRODUCT: the file exists with the same checksum, the product will be added
+//
+// Returns the full paths of the files grouped by the actions, and the files
+// which failed to be checked.
+func (c *S3Client) PlanUploadFiles(filePaths []string, target cfg.Target,
+	product, root string) (map[string][]string, []string) {
+	plan := map[string][]string{}
+	failed := c.doPathCutAnd(product, target.Bucket, target.Prefix, filePaths, nil,
+		func(product, bucket, keyPrefix, fullFilePath, fPath string, index, total int, _ []cfg.Target) bool {
+			logger.Debug(fmt.Sprintf("[S3] (%d/%d) Planning %s in bucket %s", index, total, fPath, bucket))
+			pathKey := fPath
+			if !util.IsBlankString(keyPrefix) {
+				pathKey = path.Join(keyPrefix, fPath)
 			}
-		} else {
-			c.handleExisted(fullFilePath, sha1, extraPathKey, extraBucket, product)
-		}
+			action, err := c.planUploadFile(fullFilePath, pathKey, bucket, product)
+			if err != nil {
+				return c.recordFailure(fullFilePath, err.Error())
+			}
+			plan[action] = append(plan[action], fullFilePath)
+			return true
+		}, root)
+	return plan, failed
+}
+
+func (c *S3Client) planUploadFile(fullFilePath, pathKey, bucket, product string) (string, error) {
+	if !files.IsFile(fullFilePath) {
+		return "", fmt.Errorf("file does not exist")
+	}
+	sha1 := files.ReadSHA1(fullFilePath)
+	existed, checksum, err := c.checkExisted(bucket, pathKey, fullFilePath, sha1)
+	if err != nil {
+		return "", fmt.Errorf("existence check failed in bucket %s: %s", bucket, err)
 	}
+	if !existed {
+		return PLAN_CREATE, nil
+	}
+	if checksum != "" && strings.TrimSpace(checksum) != sha1 {
+		return PLAN_MISMATCH, nil
+	}
+	if util.IsBlankString(product) {
+		return PLAN_IDENTICAL, nil
+	}
+	prods, _ := c.getProductInfo(pathKey, bucket)
+	if slices.Contains(prods, product) {
+		return PLAN_IDENTICAL, nil
+	}
+	return PLAN_ADD_PRODUCT, nil
+}
+
+// The key of a manifest in the manifest bucket, the manifests of a target
+// are stored in the folder "<target>-charon-metadata"
+func manifestKey(target, manifestName string) string {
+	if util.IsBlankString(target) {
+		target = "default"
+	}
+	return path.Join(target+MANIFEST_FOLDER_SUFFIX, manifestName)
+}
+
+// Upload the manifest, which lists the files of a product, to the manifest
+// bucket for the target
+func (c *S3Client) UploadManifest(manifestName, manifestFullPath, target, manifestBucketName string) bool {
+	pathKey := manifestKey(target, manifestName)
+	if c.dryRun {
+		return true
+	}
+	content, err := files.ReadFile(manifestFullPath)
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not read manifest %s due to error: %s", manifestFullPath, err))
+		return false
+	}
+	_, err = c.api().PutObject(c.writeCtx(), &s3.PutObjectInput{
+		Bucket:      aws.String(manifestBucketName),
+		Key:         aws.String(pathKey),
+		Body:        strings.NewReader(content),
+		ContentType: aws.String("text/plain"),
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not upload manifest %s to bucket %s due to error: %s",
+			pathKey, manifestBucketName, err))
+		return false
+	}
+	logger.Debug(fmt.Sprintf("[S3] Uploaded manifest %s to bucket %s", pathKey, manifestBucketName))
 	return true
 }
 
-func (c *S3Client) UploadManifest(manifestName, manifestFullPath, target, manifestBucketName string) {
-	// TODO: not implemented yet!
+// Delete the manifest of the product from the manifest bucket for the target
+func (c *S3Client) DeleteManifest(productKey, target, manifestBucketName string) bool {
+	pathKey := manifestKey(target, productKey+util.MANIFEST_SUFFIX)
+	if c.dryRun {
+		return true
+	}
+	_, err := c.api().DeleteObject(c.writeCtx(), &s3.DeleteObjectInput{
+		Bucket: aws.String(manifestBucketName),
+		Key:    aws.String(pathKey),
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not delete manifest %s from bucket %s due to error: %s",
+			pathKey, manifestBucketName, err))
+		return false
+	}
+	return true
 }
 
+// Read the files of the product from its manifest in the manifest bucket for
+// the target. Returns false if the manifest does not exist.
+func (c *S3Client) ReadManifest(productKey, target, manifestBucketName string) ([]string, bool, error) {
+	pathKey := manifestKey(target, productKey+util.MANIFEST_SUFFIX)
+	existed, err := c.FileExistsInBucket(manifestBucketName, pathKey)
+	if err != nil || !existed {
+		return nil, false, err
+	}
+	content, err := c.ReadFileContent(manifestBucketName, pathKey)
+	if err != nil {
+		return nil, false, err
+	}
+	paths := []string{}
+	for _, p := range strings.Split(content, "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, true, nil
+}
+
+// Promote a list of files of a product from the source target to the target
+// with server side copies.
+//
+// * Use the cut down file path as s3 key under the prefixes of both the source
+// and the target. The cut down way is the same as UploadFiles.
+//
+// * The copies keep the checksum metadata of the source files, and the product
+// is set as the product info of the copied files.
+//
+// * For the files which already exist in the target with the same checksum, only
+// the product will be added to their product info, so the promotion can be done
+// again safely. The files with different checksum will not be overwritten.
+//
+// * Return all failed to promote files.
+func (c *S3Client) PromoteFiles(filePaths []string, source, target cfg.Target,
+	product, root string) []string {
+	handler := func(product, bucket, keyPrefix, fullFilePath, fPath string, index,
+		total int, _ []cfg.Target) bool {
+		return c.pathPromoteHandler(source, product, bucket, keyPrefix, fullFilePath, fPath, index, total)
+	}
+	return c.doPathCutAnd(product, target.Bucket, target.Prefix, filePaths, nil, handler, root)
+}
+
+func (c *S3Client) pathPromoteHandler(source cfg.Target, product, bucket, keyPrefix, fullFilePath,
+	fPath string, index, total int) bool {
+	logger.Debug(fmt.Sprintf("[S3] (%d/%d) Promoting %s from bucket %s to bucket %s",
+		index, total, fPath, source.Bucket, bucket))
+	sourceKey := path.Join(source.Prefix, fPath)
+	pathKey := path.Join(keyPrefix, fPath)
+	existed, sourceMeta, err := c.headObject(source.Bucket, sourceKey)
+	if err != nil {
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("existence check failed in bucket %s: %s", source.Bucket, err))
+	}
+	if !existed {
+		logger.Warn(fmt.Sprintf("[S3] Warning: file %s does not exist in bucket %s during promoting. Product: %s",
+			sourceKey, source.Bucket, product))
+		return c.recordFailure(fullFilePath, fmt.Sprintf("file does not exist in bucket %s", source.Bucket))
+	}
+	sha1 := strings.TrimSpace(sourceMeta[CHECKSUM_META_KEY])
+	existed, fMeta, err := c.headObject(bucket, pathKey)
+	if err != nil {
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("existence check failed in bucket %s: %s", bucket, err))
+	}
+	if existed {
+		return c.handleExisted(fullFilePath, sha1, fMeta[CHECKSUM_META_KEY], pathKey, bucket, product)
+	}
+	if c.dryRun {
+		return true
+	}
+	if !c.copyBetweenBucket(source.Bucket, sourceKey, bucket, pathKey) {
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("copy from bucket %s to bucket %s failed", source.Bucket, bucket))
+	}
+	// The products of the source file are not promoted along with the copy
+	if !c.updateProductInfo(pathKey, bucket, []string{product}) {
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("can not update product info in bucket %s", bucket))
+	}
+	logger.Debug(fmt.Sprintf("[S3] Promoted %s to bucket %s", fPath, bucket))
+	return true
+}
+
+// Upload a list of metadata files to s3 bucket. This function is very similar to
+// UploadFiles, except:
+//
+// * The metadata files will always be overwritten for each uploading
+//
+// * The metadata files' checksum will also be overwritten each time
 func (c *S3Client) UploadMetadatas(metaFilePaths []string, target cfg.Target,
 	product string, root string) []string {
-	// TODO: not implemented yet!
-	return []string{}
+	bucket := target.Bucket
+	prefix := target.Prefix
+	return c.doPathCutAnd(product, bucket, prefix, metaFilePaths, nil, c.pathMetaUploadHandler, root)
+}
+
+func (c *S3Client) pathMetaUploadHandler(product, bucket, keyPrefix, fullFilePath, fPath string, index,
+	total int, extraPrefixedBuckets []cfg.Target) bool {
+	if !files.IsFile(fullFilePath) {
+		logger.Warn(fmt.Sprintf("[S3] Warning: file %s does not exist during uploading. Product: %s",
+			fullFilePath, product))
+		return c.recordFailure(fullFilePath, "file does not exist")
+	}
+	logger.Debug(fmt.Sprintf("[S3] (%d/%d) Updating metadata %s to bucket %s",
+		index, total, fPath, bucket))
+	pathKey := fPath
+	if !util.IsBlankString(keyPrefix) {
+		pathKey = path.Join(keyPrefix, fPath)
+	}
+	sha1 := files.ReadSHA1(fullFilePath)
+	existed, checksum, err := c.checkExisted(bucket, pathKey, fullFilePath, sha1)
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] Error: file existence check failed due to error: %s", err))
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("existence check failed in bucket %s: %s", bucket, err))
+	}
+	needOverwritten := !existed || strings.TrimSpace(checksum) != sha1
+	if needOverwritten && !c.dryRun {
+		content, err := files.ReadFile(fullFilePath)
+		if err != nil {
+			logger.Error(fmt.Sprintf("[S3] ERROR: Can not read metadata file %s due to error: %s", fullFilePath, err))
+			return c.recordFailure(fullFilePath, fmt.Sprintf("can not read file: %s", err))
+		}
+		contentType := files.GuessMimetype(fullFilePath)
+		if contentType == "" {
+			contentType = DEFAULT_MIME_TYPE
+		}
+		_, err = c.api().PutObject(c.writeCtx(), &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(pathKey),
+			Body:        strings.NewReader(content),
+			ContentType: aws.String(contentType),
+			Metadata:    map[string]string{CHECKSUM_META_KEY: sha1},
+		})
+		if err != nil {
+			logger.Error(fmt.Sprintf("[S3] ERROR: metadata %s not uploaded to bucket %s due to error: %s ",
+				fullFilePath, bucket, err))
+			return c.recordFailure(fullFilePath, fmt.Sprintf("upload to bucket %s failed: %s", bucket, err))
+		}
+		c.prefetch.forget(bucket, pathKey)
+	}
+	if !util.IsBlankString(product) && !c.dryRun {
+		prods, _ := c.getProductInfo(pathKey, bucket)
+		if !slices.Contains(prods, product) && !c.updateProductInfo(pathKey, bucket, append(prods, product)) {
+			return c.recordFailure(fullFilePath,
+				fmt.Sprintf("can not update product info in bucket %s", bucket))
+		}
+	}
+	logger.Debug(fmt.Sprintf("[S3] Updated metadata %s to bucket %s", fPath, bucket))
+	return true
 }
 
 func (c *S3Client) UploadSignatures(metaFilePaths []string, target cfg.Target,
@@ -427,7 +941,7 @@ func (c *S3Client) DeleteFiles(filePaths []string, target cfg.Target,
 	product, root string) []string {
 	bucket := target.Bucket
 	prefix := target.Prefix
-	return doPathCutAnd(product, bucket, prefix, filePaths, nil, c.pathDeleteHandler, root)
+	return c.doPathCutAnd(product, bucket, prefix, filePaths, nil, c.pathDeleteHandler, root)
 }
 
 func (c *S3Client) pathDeleteHandler(product, mainBucket, keyPrefix, fullFilePath, fPath string, index,
@@ -441,7 +955,8 @@ func (c *S3Client) pathDeleteHandler(product, mainBucket, keyPrefix, fullFilePat
 	if err != nil {
 		logger.Error(
 			fmt.Sprintf("Error: file existence check failed due to error: %s", err))
-		return false
+		return c.recordFailure(fullFilePath,
+			fmt.Sprintf("existence check failed in bucket %s: %s", mainBucket, err))
 	}
 	if existed {
 		// NOTE: If we're NOT using the product key to track collisions
@@ -452,10 +967,11 @@ func (c *S3Client) pathDeleteHandler(product, mainBucket, keyPrefix, fullFilePat
 		if !util.IsBlankString(product) {
 			prds, ok := c.getProductInfo(pathKey, mainBucket)
 			if !ok {
-				return false
+				return c.recordFailure(fullFilePath,
+					fmt.Sprintf("can not get product info in bucket %s", mainBucket))
 			}
-			if slices.Contains(prods, product) {
-				prds = collections.RemoveFromStringSlice(prods, product)
+			if slices.Contains(prds, product) {
+				prds = collections.RemoveFromStringSlice(prds, product)
 			}
 			prods = prds
 		}
@@ -466,13 +982,14 @@ func (c *S3Client) pathDeleteHandler(product, mainBucket, keyPrefix, fullFilePat
 			if !ok {
 				logger.Error(
 					fmt.Sprintf("ERROR: Failed to update metadata of file %s", fPath))
-				return false
+				return c.recordFailure(fullFilePath,
+					fmt.Sprintf("can not update product info in bucket %s", mainBucket))
 			}
 			logger.Debug(fmt.Sprintf("Removed product %s from metadata of file %s",
 				product, fPath))
 		} else if len(prods) == 0 {
 			if !c.dryRun {
-				_, err := c.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+				_, err := c.api().DeleteObject(c.writeCtx(), &s3.DeleteObjectInput{
 					Bucket: aws.String(mainBucket),
 					Key:    aws.String(pathKey),
 				})
@@ -480,11 +997,13 @@ func (c *S3Client) pathDeleteHandler(product, mainBucket, keyPrefix, fullFilePat
 					logger.Error(
 						fmt.Sprintf("ERROR: file %s failed to delete from bucket %s due to error: %s ",
 							fullFilePath, mainBucket, err))
-					return false
+					return c.recordFailure(fullFilePath, fmt.Sprintf("delete from bucket %s failed: %s", mainBucket, err))
 				}
-				ok := c.updateProductInfo(pathKey, mainBucket, prods)
+				c.prefetch.forget(mainBucket, pathKey)
+				ok := c.deleteProductInfo(pathKey, mainBucket)
 				if !ok {
-					return false
+					return c.recordFailure(fullFilePath,
+						fmt.Sprintf("can not delete product info in bucket %s", mainBucket))
 				}
 				logger.Info(fmt.Sprintf("[S3] Deleted %s from bucket %s", fPath, mainBucket))
 				return true
@@ -503,7 +1022,7 @@ func (c *S3Client) getObject(bucket, key string) ([]byte, map[string]string, err
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	}
-	output, err := c.client.GetObject(context.TODO(), input)
+	output, err := c.api().GetObject(c.ctx, input)
 	if err != nil {
 		logger.Error(fmt.Sprintf("[S3] ERROR: Can not read file %s in bucket %s due to error: %s ", key,
 			bucket, err))
@@ -514,40 +1033,49 @@ func (c *S3Client) getObject(bucket, key string) ([]byte, map[string]string, err
 	return content, output.Metadata, err
 }
 
-func (c *S3Client) handleExisted(filePath, fileSHA1, pathKey, bucketName, product string) bool {
+// Handle the file which already exists in the bucket, with the checksum
+// metadata got by the existence check. The failure cause is recorded for
+// the file if its checksum differs or its product info can not be updated.
+func (c *S3Client) handleExisted(filePath, fileSHA1, checksum, pathKey, bucketName, product string) bool {
 	logger.Debug(fmt.Sprintf("File %s already exists in bucket %s, check if need to update product.",
 		pathKey, bucketName))
-	_, fMeta, err := c.getObject(bucketName, pathKey)
-	if err != nil {
-		logger.Error(fmt.Sprintf("[S3] Can not get object for %s due to error: %s", pathKey, err))
-		return false
-	}
-	checksum := ""
-	if value, ok := fMeta[CHECKSUM_META_KEY]; ok {
-		checksum = value
-	}
 	if checksum != "" && strings.TrimSpace(checksum) != fileSHA1 {
 		logger.Warn(fmt.Sprintf("Warning: checksum check failed. The file %s is different from the one in S3 bucket %s. Product: %s",
 			pathKey, bucketName, product))
-		return false
+		return c.recordFailure(filePath, fmt.Sprintf("checksum differs in bucket %s", bucketName))
+	}
+	if util.IsBlankString(product) {
+		return true
 	}
 
+	// The product info can be missing when the file was put by an interrupted
+	// run before its product info was written, so it is seen as no product
 	prods, ok := c.getProductInfo(pathKey, bucketName)
-	if !c.dryRun && ok && !slices.Contains(prods, product) {
+	if !ok {
+		prods = []string{}
+	}
+	if !c.dryRun && !slices.Contains(prods, product) {
 		logger.Debug(
 			fmt.Sprintf("File %s has new product, updating the product %s",
 				filePath,
 				product,
 			))
 		prods = append(prods, product)
-		ok := c.updateProductInfo(pathKey, bucketName, prods)
-		return ok
+		if !c.updateProductInfo(pathKey, bucketName, prods) {
+			return c.recordFailure(filePath, fmt.Sprintf("can not update product info in bucket %s", bucketName))
+		}
 	}
 	return true
 }
 
 func (c *S3Client) getProductInfo(file, bucketName string) ([]string, bool) {
 	logger.Debug(fmt.Sprintf("[S3] Getting product infomation for file %s", file))
+	switch c.productInfoMode(bucketName) {
+	case cfg.PRODUCT_INFO_TAGGING:
+		return c.getTaggingProductInfo(file, bucketName)
+	case cfg.PRODUCT_INFO_METADATA:
+		return c.getMetadataProductInfo(file, bucketName)
+	}
 	prodInfoFile := file + util.PROD_INFO_SUFFIX
 	infoFileContent, err := c.ReadFileContent(bucketName, prodInfoFile)
 	if err != nil {
@@ -562,15 +1090,151 @@ func (c *S3Client) getProductInfo(file, bucketName string) ([]string, bool) {
 	return prods, true
 }
 
+// Update the product information of a file with the product info mode of the
+// bucket. An empty product list means the file is not owned by any product
+// any more, so the sidecar will be removed, or the tags or metadata cleared.
 func (c *S3Client) updateProductInfo(file, bucketName string, prods []string) bool {
-	//TODO: not implemented
-	return false
+	if c.dryRun {
+		return true
+	}
+	switch c.productInfoMode(bucketName) {
+	case cfg.PRODUCT_INFO_TAGGING:
+		return c.updateTaggingProductInfo(file, bucketName, prods)
+	case cfg.PRODUCT_INFO_METADATA:
+		return c.updateMetadataProductInfo(file, bucketName, prods)
+	}
+	prodInfoFile := file + util.PROD_INFO_SUFFIX
+	if len(prods) == 0 {
+		_, err := c.api().DeleteObject(c.writeCtx(), &s3.DeleteObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(prodInfoFile),
+		})
+		if err != nil {
+			logger.Error(fmt.Sprintf("[S3] ERROR: Can not delete product info %s in bucket %s due to error: %s",
+				prodInfoFile, bucketName, err))
+			return false
+		}
+		return true
+	}
+	_, err := c.api().PutObject(c.writeCtx(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(prodInfoFile),
+		Body:        strings.NewReader(strings.Join(prods, ",")),
+		ContentType: aws.String(DEFAULT_MIME_TYPE),
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not update product info %s in bucket %s due to error: %s",
+			prodInfoFile, bucketName, err))
+		return false
+	}
+	logger.Debug(fmt.Sprintf("[S3] Updated product information of file %s: %s", file, prods))
+	return true
+}
+
+// Get the user metadata of the file with a HEAD request. Returns false if
+// the file does not exist.
+func (c *S3Client) GetFileMetadata(bucket, key string) (map[string]string, bool, error) {
+	existed, fMeta, err := c.headObject(bucket, key)
+	return fMeta, existed, err
+}
+
+// Set the checksum metadata of the file, the content is not changed
+func (c *S3Client) SetChecksumMetadata(bucket, key, sha1 string) bool {
+	if c.dryRun {
+		return true
+	}
+	return c.updateObjectMetadata(key, bucket, func(fMeta map[string]string) {
+		fMeta[CHECKSUM_META_KEY] = sha1
+	})
+}
+
+// Update the user metadata of a file with the update function. The metadata
+// of an object can only be changed by copying the object to itself, the other
+// metadata and the content headers are kept by the copying. The copying can
+// not be done for the objects larger than 5GB. Note that in a versioned bucket
+// each update creates a new full version of the object.
+func (c *S3Client) updateObjectMetadata(file, bucketName string, update func(fMeta map[string]string)) bool {
+	output, err := c.api().HeadObject(c.ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(file),
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not get metadata of file %s in bucket %s due to error: %s",
+			file, bucketName, err))
+		return false
+	}
+	if size := aws.ToInt64(output.ContentLength); size > MAX_COPY_OBJECT_SIZE {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not update metadata of file %s in bucket %s, "+
+			"its size %d is larger than the 5GB limit of copying", file, bucketName, size))
+		return false
+	}
+	fMeta := map[string]string{}
+	for k, v := range output.Metadata {
+		fMeta[k] = v
+	}
+	update(fMeta)
+	_, err = c.api().CopyObject(c.writeCtx(), &s3.CopyObjectInput{
+		Bucket:             aws.String(bucketName),
+		CopySource:         aws.String(fmt.Sprintf("%v/%v", bucketName, file)),
+		Key:                aws.String(file),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		Metadata:           fMeta,
+		ContentType:        output.ContentType,
+		CacheControl:       output.CacheControl,
+		ContentDisposition: output.ContentDisposition,
+		ContentEncoding:    output.ContentEncoding,
+		ContentLanguage:    output.ContentLanguage,
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("[S3] ERROR: Can not update metadata of file %s in bucket %s due to error: %s",
+			file, bucketName, err))
+		return false
+	}
+	return true
+}
+
+// Copy the file between buckets with a server side copy, which keeps the
+// checksum metadata. If withProducts is set, the products of the source file
+// are set as the product info of the copied file, with the product info mode
+// of the target bucket.
+func (c *S3Client) CopyFile(source, sourceKey, target, targetKey string, withProducts bool) bool {
+	if c.dryRun {
+		return true
+	}
+	prods := []string{}
+	if withProducts {
+		got, _ := c.getProductInfo(sourceKey, source)
+		prods = slices.DeleteFunc(got, util.IsBlankString)
+	}
+	if !c.copyBetweenBucket(source, sourceKey, target, targetKey) {
+		return false
+	}
+	if len(prods) > 0 {
+		return c.updateProductInfo(targetKey, target, prods)
+	}
+	return true
+}
+
+// Add the products to the product info of the file, the products which
+// already exist are not added again
+func (c *S3Client) AddProducts(file, bucketName string, products []string) bool {
+	prods, _ := c.getProductInfo(file, bucketName)
+	merged := slices.Clone(prods)
+	for _, p := range products {
+		if !slices.Contains(merged, p) {
+			merged = append(merged, p)
+		}
+	}
+	if len(merged) == len(prods) {
+		return true
+	}
+	return c.updateProductInfo(file, bucketName, merged)
 }
 
 func (c *S3Client) copyBetweenBucket(source, sourceKey, target, targetKey string) bool {
 	logger.Debug(fmt.Sprintf("Copying file %s from bucket %s to target %s as %s",
 		sourceKey, source, target, targetKey))
-	_, err := c.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+	_, err := c.api().CopyObject(c.writeCtx(), &s3.CopyObjectInput{
 		Bucket:     aws.String(target),
 		CopySource: aws.String(fmt.Sprintf("%v/%v", source, sourceKey)),
 		Key:        aws.String(targetKey),
@@ -580,13 +1244,72 @@ func (c *S3Client) copyBetweenBucket(source, sourceKey, target, targetKey string
 			sourceKey, target, err))
 		return false
 	}
+	c.prefetch.forget(target, targetKey)
 	return true
 }
 
-func doPathCutAnd(product, mainBucket, keyPrefix string,
+// Record the cause of a path which failed in a handler, so that it can be
+// reported later by FailureCause. Always returns false as the handler result.
+func (c *S3Client) recordFailure(fullPath, cause string) bool {
+	c.failures.lock.Lock()
+	defer c.failures.lock.Unlock()
+	c.failures.causes[fullPath] = cause
+	return false
+}
+
+// Scope the last failure cause of the path to the bucket, for the path which
+// only failed in that bucket
+func (c *S3Client) scopeFailure(bucket, fullPath string) {
+	c.failures.lock.Lock()
+	defer c.failures.lock.Unlock()
+	c.failures.causes[bucket+"\x00"+fullPath] = c.failures.causes[fullPath]
+	delete(c.failures.causes, fullPath)
+}
+
+// Get the cause of the last failure of the path in the uploading or deletion,
+// empty if the cause is not known
+func (c *S3Client) FailureCause(fullPath string) string {
+	c.failures.lock.Lock()
+	defer c.failures.lock.Unlock()
+	return c.failures.causes[fullPath]
+}
+
+// Get the cause of the failure of the path in the bucket, which can be the
+// failure only in the bucket, or the failure in all the buckets
+func (c *S3Client) FailureCauseIn(bucket, fullPath string) string {
+	c.failures.lock.Lock()
+	defer c.failures.lock.Unlock()
+	if cause, ok := c.failures.causes[bucket+"\x00"+fullPath]; ok {
+		return cause
+	}
+	return c.failures.causes[fullPath]
+}
+
+// Handle the paths one by one with the pathHandler, and return the failed
+// ones. Once the context of the client is cancelled, the paths not handled
+// yet will be seen as failed without handling.
+func (c *S3Client) doPathCutAnd(product, mainBucket, keyPrefix string,
 	filePaths []string, extraPrefixedBuckets []cfg.Target,
 	pathHandler func(a, b, c, d, e string, f, g int, h []cfg.Target) bool,
 	root string) []string {
+	handler := func(product, mainBucket, keyPrefix, fullPath, fPath string, index, total int,
+		extraPrefixedBuckets []cfg.Target) []string {
+		if pathHandler(product, mainBucket, keyPrefix, fullPath, fPath, index, total, extraPrefixedBuckets) {
+			return nil
+		}
+		return []string{mainBucket}
+	}
+	return c.doPathCutAndByBucket(product, mainBucket, keyPrefix, filePaths, nil, handler, root)[mainBucket]
+}
+
+// Handle the paths one by one like doPathCutAnd, but the pathHandler returns
+// the buckets which the path failed in, and the failed paths are returned
+// keyed by the buckets. The paths not handled because of the cancelled
+// context are seen as failed in all the buckets.
+func (c *S3Client) doPathCutAndByBucket(product, mainBucket, keyPrefix string,
+	filePaths []string, extraPrefixedBuckets []cfg.Target,
+	pathHandler func(a, b, c, d, e string, f, g int, h []cfg.Target) []string,
+	root string) map[string][]string {
 	slashRoot := root
 	if util.IsBlankString(root) {
 		slashRoot = "/"
@@ -594,15 +1317,26 @@ func doPathCutAnd(product, mainBucket, keyPrefix string,
 	if !strings.HasSuffix(root, "/") {
 		slashRoot = slashRoot + "/"
 	}
-	var failedPaths []string
+	allBuckets := []string{mainBucket}
+	for _, target := range extraPrefixedBuckets {
+		allBuckets = append(allBuckets, target.Bucket)
+	}
+	failedPaths := map[string][]string{}
 	index := 1
 	filePathsCount := len(filePaths)
 	for _, fullPath := range filePaths {
+		if err := c.ctx.Err(); err != nil {
+			for _, b := range allBuckets {
+				failedPaths[b] = append(failedPaths[b], fullPath)
+			}
+			c.recordFailure(fullPath, fmt.Sprintf("not handled as the run is interrupted: %s", err))
+			continue
+		}
 		fPath := strings.TrimPrefix(fullPath, slashRoot)
-		if pathHandler(product, mainBucket,
+		for _, b := range pathHandler(product, mainBucket,
 			keyPrefix, fullPath, fPath, index,
 			filePathsCount, extraPrefixedBuckets) {
-			failedPaths = append(failedPaths, fullPath)
+			failedPaths[b] = append(failedPaths[b], fullPath)
 		}
 		index += 1
 	}
@@ -2,18 +2,21 @@ package storage
 
 import (
 	"context"
+	"crypto"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
+	cfg "org.commonjava/charon/module/config"
 	"org.commonjava/charon/module/util"
 	"org.commonjava/charon/module/util/files"
 )
@@ -109,6 +112,26 @@ func TestReadFileContent(t *testing.T) {
 	assert.Equal(t, testContet, content)
 }
 
+func TestDigestFile(t *testing.T) {
+	content := "This is a test."
+	s3client, err := S3ClientWithMock(MockAWSS3Client{
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			if aws.ToString(params.Key) != "foo.jar" {
+				return nil, &types.NoSuchKey{}
+			}
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(content))}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	digest, err := s3client.DigestFile(TEST_BUCKET, "foo.jar", files.SHA1)
+	assert.Nil(t, err)
+	assert.Equal(t, files.DigestContent(content, files.SHA1), digest)
+
+	_, err = s3client.DigestFile(TEST_BUCKET, "bar.jar", files.SHA1)
+	assert.NotNil(t, err)
+}
+
 func TestDownloadFile(t *testing.T) {
 	testKey := "foo/bar/foo-bar.txt"
 	testContet := "just test"
@@ -230,6 +253,216 @@ func TestFileExistsInBucket(t *testing.T) {
 	assert.False(t, ok)
 }
 
+func TestCheckExisted(t *testing.T) {
+	tmp := t.TempDir()
+	local := path.Join(tmp, "foo.jar")
+	assert.Nil(t, os.WriteFile(local, []byte("foo"), 0644))
+	sha1 := files.Digest(local, crypto.SHA1)
+	md5 := files.Digest(local, crypto.MD5)
+
+	heads := []string{}
+	listed := map[string]string{
+		"org/foo/foo/1.0/foo.jar":   md5,
+		"org/foo/foo/1.0/foo.pom":   "different",
+		"org/foo/foo/1.0/multi.jar": md5 + "-2",
+	}
+	s3client, err := S3ClientWithMock(MockAWSS3Client{
+		LsObjV2: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			contents := []types.Object{}
+			for k, etag := range listed {
+				if strings.HasPrefix(k, aws.ToString(params.Prefix)) {
+					contents = append(contents, types.Object{Key: aws.String(k), ETag: aws.String("\"" + etag + "\"")})
+				}
+			}
+			return &s3.ListObjectsV2Output{Contents: contents}, nil
+		},
+		// The content is never downloaded to check the metadata
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			heads = append(heads, *params.Key)
+			if _, ok := listed[*params.Key]; ok {
+				return &s3.HeadObjectOutput{Metadata: map[string]string{CHECKSUM_META_KEY: "remote-sha1"}}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+	})
+	assert.Nil(t, err)
+
+	// Without prefetching, a single HEAD gets both existence and checksum
+	existed, checksum, err := s3client.checkExisted(TEST_BUCKET, "org/foo/foo/1.0/foo.jar", local, sha1)
+	assert.Nil(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, "remote-sha1", checksum)
+	assert.Equal(t, []string{"org/foo/foo/1.0/foo.jar"}, heads)
+
+	heads = []string{}
+	assert.True(t, s3client.PrefetchPrefix(TEST_BUCKET, "org/foo/foo/"))
+	// The ETag matches the local md5, so no HEAD is needed
+	existed, checksum, err = s3client.checkExisted(TEST_BUCKET, "org/foo/foo/1.0/foo.jar", local, sha1)
+	assert.Nil(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, sha1, checksum)
+	// Not listed under the prefetched prefix, so it does not exist
+	existed, _, err = s3client.checkExisted(TEST_BUCKET, "org/foo/foo/1.0/bar.jar", local, sha1)
+	assert.Nil(t, err)
+	assert.False(t, existed)
+	assert.Empty(t, heads)
+
+	// The listing can not decide the different or multipart ETags, and
+	// the files outside the prefetched prefixes
+	for _, key := range []string{"org/foo/foo/1.0/foo.pom", "org/foo/foo/1.0/multi.jar", "org/bar/bar.jar"} {
+		s3client.checkExisted(TEST_BUCKET, key, local, sha1)
+	}
+	assert.Equal(t, []string{"org/foo/foo/1.0/foo.pom", "org/foo/foo/1.0/multi.jar", "org/bar/bar.jar"}, heads)
+
+	// The written file is checked with HEAD again
+	heads = []string{}
+	s3client.prefetch.forget(TEST_BUCKET, "org/foo/foo/1.0/bar.jar")
+	s3client.checkExisted(TEST_BUCKET, "org/foo/foo/1.0/bar.jar", local, sha1)
+	assert.Equal(t, []string{"org/foo/foo/1.0/bar.jar"}, heads)
+}
+
+func TestProductInfoModes(t *testing.T) {
+	objects := map[string]map[string]string{
+		"org/foo/foo.jar":  {CHECKSUM_META_KEY: "sha1"},
+		"org/foo/huge.iso": {CHECKSUM_META_KEY: "sha1"},
+	}
+	sizes := map[string]int64{"org/foo/huge.iso": MAX_COPY_OBJECT_SIZE + 1}
+	tags := map[string][]types.Tag{
+		"org/foo/foo.jar": {{Key: aws.String("owner"), Value: aws.String("team")}},
+	}
+	copied := []*s3.CopyObjectInput{}
+	s3client, err := S3ClientWithMock(MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if fMeta, ok := objects[*params.Key]; ok {
+				return &s3.HeadObjectOutput{Metadata: fMeta, ContentType: aws.String("application/java-archive"),
+					CacheControl: aws.String("max-age=600"), ContentEncoding: aws.String("gzip"),
+					ContentDisposition: aws.String("attachment"), ContentLength: aws.Int64(sizes[*params.Key])}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+		CpObj: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			copied = append(copied, params)
+			objects[*params.Key] = params.Metadata
+			return &s3.CopyObjectOutput{}, nil
+		},
+		GetObjTag: func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+			return &s3.GetObjectTaggingOutput{TagSet: tags[*params.Key]}, nil
+		},
+		PutObjTag: func(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+			tags[*params.Key] = params.Tagging.TagSet
+			return &s3.PutObjectTaggingOutput{}, nil
+		},
+		DelObjTag: func(ctx context.Context, params *s3.DeleteObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectTaggingOutput, error) {
+			delete(tags, *params.Key)
+			return &s3.DeleteObjectTaggingOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	// The metadata is updated by a self copy, with the checksum kept
+	s3client.SetProductInfoMode(TEST_BUCKET, cfg.PRODUCT_INFO_METADATA)
+	assert.True(t, s3client.updateProductInfo("org/foo/foo.jar", TEST_BUCKET, []string{"foo-1.0", "bar-1.0"}))
+	assert.Equal(t, 1, len(copied))
+	assert.Equal(t, TEST_BUCKET+"/org/foo/foo.jar", *copied[0].CopySource)
+	assert.Equal(t, types.MetadataDirectiveReplace, copied[0].MetadataDirective)
+	assert.Equal(t, "application/java-archive", *copied[0].ContentType)
+	assert.Equal(t, "max-age=600", *copied[0].CacheControl)
+	assert.Equal(t, "gzip", *copied[0].ContentEncoding)
+	assert.Equal(t, "attachment", *copied[0].ContentDisposition)
+	assert.Equal(t, map[string]string{CHECKSUM_META_KEY: "sha1", PRODUCTS_META_KEY: "foo-1.0,bar-1.0"},
+		objects["org/foo/foo.jar"])
+	prods, ok := s3client.getProductInfo("org/foo/foo.jar", TEST_BUCKET)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"foo-1.0", "bar-1.0"}, prods)
+	assert.True(t, s3client.updateProductInfo("org/foo/foo.jar", TEST_BUCKET, nil))
+	assert.Equal(t, map[string]string{CHECKSUM_META_KEY: "sha1"}, objects["org/foo/foo.jar"])
+	// The object larger than 5GB can not be copied to itself
+	copiedCount := len(copied)
+	assert.False(t, s3client.updateProductInfo("org/foo/huge.iso", TEST_BUCKET, []string{"foo-1.0"}))
+	assert.Equal(t, copiedCount, len(copied))
+
+	// The products are split into tags with the limited length, and the
+	// other tags are kept
+	s3client.SetProductInfoMode(TEST_BUCKET, cfg.PRODUCT_INFO_TAGGING)
+	many := []string{}
+	for i := 0; i < 30; i++ {
+		many = append(many, fmt.Sprintf("product-with-a-long-name-%d", i))
+	}
+	assert.True(t, s3client.updateProductInfo("org/foo/foo.jar", TEST_BUCKET, many))
+	assert.Equal(t, 5, len(tags["org/foo/foo.jar"]))
+	assert.Equal(t, "owner", *tags["org/foo/foo.jar"][0].Key)
+	assert.Equal(t, PRODUCTS_TAG_KEY, *tags["org/foo/foo.jar"][1].Key)
+	assert.Equal(t, PRODUCTS_TAG_KEY+".3", *tags["org/foo/foo.jar"][4].Key)
+	for _, tag := range tags["org/foo/foo.jar"] {
+		assert.True(t, len(*tag.Value) <= maxTagValueLength)
+	}
+	prods, ok = s3client.getProductInfo("org/foo/foo.jar", TEST_BUCKET)
+	assert.True(t, ok)
+	assert.Equal(t, many, prods)
+	assert.True(t, s3client.updateProductInfo("org/foo/foo.jar", TEST_BUCKET, []string{"foo-1.0"}))
+	assert.Equal(t, 2, len(tags["org/foo/foo.jar"]))
+	assert.True(t, s3client.updateProductInfo("org/foo/foo.jar", TEST_BUCKET, nil))
+	assert.Equal(t, 1, len(tags["org/foo/foo.jar"]))
+	// Too many products can not be tagged
+	assert.False(t, s3client.updateProductInfo("org/foo/foo.jar", TEST_BUCKET, append(many, append(many, many...)...)))
+
+	// The tags and metadata are deleted along with the file
+	assert.True(t, s3client.deleteProductInfo("org/foo/foo.jar", TEST_BUCKET))
+}
+
+func TestMigrateProductInfo(t *testing.T) {
+	objects := map[string]string{
+		"org/foo/foo.jar":           "foo",
+		"org/foo/foo.jar.prodinfo":  "foo-1.0, bar-1.0",
+		"org/foo/gone.jar.prodinfo": "foo-1.0",
+	}
+	tags := map[string][]types.Tag{
+		"org/foo/foo.jar": {{Key: aws.String(PRODUCTS_TAG_KEY), Value: aws.String("baz-1.0 foo-1.0")}},
+	}
+	s3client, err := S3ClientWithMock(MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if _, ok := objects[*params.Key]; ok {
+				return &s3.HeadObjectOutput{}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			if content, ok := objects[*params.Key]; ok {
+				return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(content))}, nil
+			}
+			return nil, &types.NoSuchKey{}
+		},
+		DelObj: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			delete(objects, *params.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+		GetObjTag: func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+			return &s3.GetObjectTaggingOutput{TagSet: tags[*params.Key]}, nil
+		},
+		PutObjTag: func(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+			tags[*params.Key] = params.Tagging.TagSet
+			return &s3.PutObjectTaggingOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	_, err = s3client.MigrateProductInfo("org/foo/foo.jar", TEST_BUCKET)
+	assert.NotNil(t, err)
+
+	// The sidecar products are merged with the existing tags
+	s3client.SetProductInfoMode(TEST_BUCKET, cfg.PRODUCT_INFO_TAGGING)
+	prods, err := s3client.MigrateProductInfo("org/foo/foo.jar", TEST_BUCKET)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"baz-1.0", "foo-1.0", "bar-1.0"}, prods)
+	assert.Equal(t, "baz-1.0 foo-1.0 bar-1.0", *tags["org/foo/foo.jar"][0].Value)
+	assert.NotContains(t, objects, "org/foo/foo.jar.prodinfo")
+
+	// The orphan sidecar is kept
+	_, err = s3client.MigrateProductInfo("org/foo/gone.jar", TEST_BUCKET)
+	assert.ErrorIs(t, err, ErrOrphanProductInfo)
+	assert.Contains(t, objects, "org/foo/gone.jar.prodinfo")
+}
+
 func TestSimpleUploadFile(t *testing.T) {
 	assert.Fail(t, "not implemented yet!")
 }
@@ -241,3 +474,106 @@ func TestSimpleDeleteFile(t *testing.T) {
 func TestUploadFiles(t *testing.T) {
 	assert.Fail(t, "not implemented yet!")
 }
+
+func TestRetry(t *testing.T) {
+	bodies := []string{}
+	s3client, err := S3ClientWithMock(MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if *params.Key == "missing" {
+				return nil, &types.NotFound{}
+			}
+			return nil, &smithy.GenericAPIError{Code: "RequestTimeout"}
+		},
+		PutObj: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			content, _ := io.ReadAll(params.Body)
+			bodies = append(bodies, string(content))
+			if len(bodies) < 3 {
+				return nil, &smithy.GenericAPIError{Code: "SlowDown"}
+			}
+			return &s3.PutObjectOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+	s3client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	// The throttled uploading is retried with the body rewound
+	_, err = s3client.api().PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(TEST_BUCKET),
+		Key:    aws.String("foo/bar.txt"),
+		Body:   strings.NewReader("content"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"content", "content", "content"}, bodies)
+
+	// The not found is not retried, and the retries are limited
+	existed, err := s3client.FileExistsInBucket(TEST_BUCKET, "missing")
+	assert.Nil(t, err)
+	assert.False(t, existed)
+	_, err = s3client.FileExistsInBucket(TEST_BUCKET, "timeout")
+	assert.NotNil(t, err)
+	assert.Equal(t, map[string]int{RETRY_REASON_THROTTLED: 2, "RequestTimeout": 2}, s3client.RetryCounts())
+
+	// The waiting for retry stops when the run is interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s3client.WithContext(ctx).FileExistsInBucket(TEST_BUCKET, "timeout")
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, s3client.RetryCounts()["RequestTimeout"])
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+	assert.Equal(t, DEFAULT_RETRY_MAX_ATTEMPTS, policy.MaxAttempts)
+	for i := 0; i < 10; i++ {
+		d := policy.backoff(1, false)
+		assert.True(t, d >= 50*time.Millisecond && d <= 100*time.Millisecond, d)
+		d = policy.backoff(3, false)
+		assert.True(t, d >= 200*time.Millisecond && d <= 400*time.Millisecond, d)
+		d = policy.backoff(3, true)
+		assert.True(t, d >= 400*time.Millisecond && d <= 800*time.Millisecond, d)
+		d = policy.backoff(10, true)
+		assert.True(t, d >= 500*time.Millisecond && d <= time.Second, d)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	objects := map[string]string{}
+	s3client, err := S3ClientWithMock(MockAWSS3Client{
+		HeadObj: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if _, ok := objects[*params.Bucket+"/"+*params.Key]; ok {
+				return &s3.HeadObjectOutput{}, nil
+			}
+			return nil, &types.NotFound{}
+		},
+		GetObj: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(objects[*params.Bucket+"/"+*params.Key]))}, nil
+		},
+		PutObj: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			content, _ := io.ReadAll(params.Body)
+			objects[*params.Bucket+"/"+*params.Key] = string(content)
+			return &s3.PutObjectOutput{}, nil
+		},
+		DelObj: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			delete(objects, *params.Bucket+"/"+*params.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	root := t.TempDir()
+	manifestName, manifestPath, err := files.WriteManifest(
+		[]string{path.Join(root, "org/foo/foo.jar"), path.Join(root, "org/foo/foo.pom")}, root, "foo-1.0")
+	assert.Nil(t, err)
+	assert.True(t, s3client.UploadManifest(manifestName, manifestPath, TEST_BUCKET, "manifest"))
+	assert.Contains(t, objects, "manifest/"+TEST_BUCKET+"-charon-metadata/foo-1.0.txt")
+
+	paths, ok, err := s3client.ReadManifest("foo-1.0", TEST_BUCKET, "manifest")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"org/foo/foo.jar", "org/foo/foo.pom"}, paths)
+
+	assert.True(t, s3client.DeleteManifest("foo-1.0", TEST_BUCKET, "manifest"))
+	_, ok, err = s3client.ReadManifest("foo-1.0", TEST_BUCKET, "manifest")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
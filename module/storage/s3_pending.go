@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Get a dry run copy of the client, in which the pending keys are seen as
+// existing in the bucket by the listing and existence checking. It is used
+// to compute what would be changed after the pending files are uploaded,
+// without uploading them.
+func (c *S3Client) WithPendingFiles(bucket string, keys []string) *S3Client {
+	pending := *c
+	pending.dryRun = true
+	pending.client = pendingS3Client{s3ClientIface: c.client, bucket: bucket, keys: keys}
+	return &pending
+}
+
+type pendingS3Client struct {
+	s3ClientIface
+	bucket string
+	keys   []string
+}
+
+func (p pendingS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput,
+	optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if aws.ToString(params.Bucket) == p.bucket && slices.Contains(p.keys, aws.ToString(params.Key)) {
+		return &s3.HeadObjectOutput{}, nil
+	}
+	return p.s3ClientIface.HeadObject(ctx, params, optFns...)
+}
+
+func (p pendingS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input,
+	optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	output, err := p.s3ClientIface.ListObjectsV2(ctx, params, optFns...)
+	if err != nil || aws.ToString(params.Bucket) != p.bucket || aws.ToBool(output.IsTruncated) {
+		return output, err
+	}
+	// The pending keys are added to the last page of the listing
+	prefix := aws.ToString(params.Prefix)
+	delimiter := aws.ToString(params.Delimiter)
+	for _, key := range p.keys {
+		if !strings.HasPrefix(key, prefix) || containsKey(output.Contents, key) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := prefix + rest[:i+len(delimiter)]
+			if !containsPrefix(output.CommonPrefixes, commonPrefix) {
+				output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(commonPrefix)})
+			}
+			continue
+		}
+		output.Contents = append(output.Contents, types.Object{Key: aws.String(key)})
+	}
+	return output, nil
+}
+
+func containsKey(objects []types.Object, key string) bool {
+	return slices.ContainsFunc(objects, func(o types.Object) bool { return aws.ToString(o.Key) == key })
+}
+
+func containsPrefix(prefixes []types.CommonPrefix, prefix string) bool {
+	return slices.ContainsFunc(prefixes, func(p types.CommonPrefix) bool { return aws.ToString(p.Prefix) == prefix })
+}
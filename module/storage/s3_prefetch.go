@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"crypto"
+	"fmt"
+	"strings"
+	"sync"
+
+	"org.commonjava/charon/module/util/files"
+)
+
+// The objects listed under the prefetched prefixes, which are used to check
+// the existence and content of the objects without a request for each one.
+// It is shared by the copies of the S3Client.
+type prefetchCache struct {
+	// The prefetched prefixes of each bucket
+	prefixes map[string][]string
+	objects  map[string]FileInfo
+	// The objects written after the prefetching, whose listing is stale
+	stale map[string]bool
+	lock  sync.Mutex
+}
+
+func newPrefetchCache() *prefetchCache {
+	return &prefetchCache{
+		prefixes: map[string][]string{},
+		objects:  map[string]FileInfo{},
+		stale:    map[string]bool{},
+	}
+}
+
+func prefetchKey(bucket, key string) string {
+	return bucket + "\x00" + key
+}
+
+// Look up the object in the cache. Returns the listing info of the object,
+// if it is listed, and if the cache knows about the object at all.
+func (p *prefetchCache) lookup(bucket, key string) (FileInfo, bool, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	k := prefetchKey(bucket, key)
+	if p.stale[k] {
+		return FileInfo{}, false, false
+	}
+	covered := false
+	for _, prefix := range p.prefixes[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			covered = true
+			break
+		}
+	}
+	if !covered {
+		return FileInfo{}, false, false
+	}
+	info, listed := p.objects[k]
+	return info, listed, true
+}
+
+func (p *prefetchCache) add(bucket, prefix string, infos []FileInfo) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, info := range infos {
+		k := prefetchKey(bucket, info.Key)
+		p.objects[k] = info
+		delete(p.stale, k)
+	}
+	p.prefixes[bucket] = append(p.prefixes[bucket], prefix)
+}
+
+// Forget the object after it is written, so that it will be checked with
+// a request again
+func (p *prefetchCache) forget(bucket, key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stale[prefetchKey(bucket, key)] = true
+}
+
+// Prefetch the objects under the prefix, like a GA path, with one paginated
+// listing. The existence of the objects under the prefix will be checked with
+// the listing instead of a HEAD request for each one, and the content of an
+// existing object is seen as the same as the local file if its ETag is the
+// md5 of the local file. The objects which can not be decided by the listing
+// are still checked with HEAD.
+//
+// Returns false if the listing failed, then nothing is prefetched.
+func (c *S3Client) PrefetchPrefix(bucket, prefix string) bool {
+	infos, ok := c.GetFileInfos(bucket, prefix, "")
+	if !ok {
+		logger.Warn(fmt.Sprintf("[S3] Can not prefetch %s in bucket %s, will check the files one by one",
+			prefix, bucket))
+		return false
+	}
+	c.prefetch.add(bucket, prefix, infos)
+	logger.Debug(fmt.Sprintf("[S3] Prefetched %d files under %s in bucket %s", len(infos), prefix, bucket))
+	return true
+}
+
+// Check if the object exists in the bucket, and get its checksum metadata.
+// The prefetched listing is used if it can decide, otherwise a single HEAD
+// request is sent for both the existence and the metadata.
+func (c *S3Client) checkExisted(bucket, key, fullFilePath, fileSHA1 string) (bool, string, error) {
+	if info, listed, covered := c.prefetch.lookup(bucket, key); covered {
+		if !listed {
+			return false, "", nil
+		}
+		// The ETag of an object not uploaded in parts is the md5 of its content
+		if info.ETag != "" && !strings.Contains(info.ETag, "-") &&
+			info.ETag == files.Digest(fullFilePath, crypto.MD5) {
+			return true, fileSHA1, nil
+		}
+	}
+	existed, fMeta, err := c.headObject(bucket, key)
+	return existed, fMeta[CHECKSUM_META_KEY], err
+}
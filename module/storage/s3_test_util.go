@@ -15,6 +15,10 @@ type MockAWSS3Client struct {
 	PutObj  func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 	DelObj  func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 	CpObj   func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+
+	GetObjTag func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	PutObjTag func(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error)
+	DelObjTag func(ctx context.Context, params *s3.DeleteObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectTaggingOutput, error)
 }
 
 func (m MockAWSS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
@@ -35,8 +39,17 @@ func (m MockAWSS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObje
 func (m MockAWSS3Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
 	return m.CpObj(ctx, params, optFns...)
 }
+func (m MockAWSS3Client) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return m.GetObjTag(ctx, params, optFns...)
+}
+func (m MockAWSS3Client) PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+	return m.PutObjTag(ctx, params, optFns...)
+}
+func (m MockAWSS3Client) DeleteObjectTagging(ctx context.Context, params *s3.DeleteObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectTaggingOutput, error) {
+	return m.DelObjTag(ctx, params, optFns...)
+}
 func S3ClientWithMock(mockAWSS3Client MockAWSS3Client) (*S3Client, error) {
-	s3client, err := NewS3Client("", 10, false)
+	s3client, err := NewS3Client(context.Background(), "", 10, false)
 	if err != nil {
 		return nil, err
 	}
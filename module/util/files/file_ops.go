@@ -18,8 +18,13 @@ package files
 
 import (
 	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"os"
@@ -35,9 +40,21 @@ const (
 	MD5    crypto.Hash = crypto.MD5
 	SHA1   crypto.Hash = crypto.SHA1
 	SHA256 crypto.Hash = crypto.SHA256
+	SHA512 crypto.Hash = crypto.SHA512
 )
 
-func StoreFile(fileName string, content string, overWrite bool) {
+// The file suffixes of the digest files for each hash type, which follow
+// the maven repository rule like ${file}.sha1
+var DIGEST_SUFFIXES = map[crypto.Hash]string{
+	MD5:    ".md5",
+	SHA1:   ".sha1",
+	SHA256: ".sha256",
+	SHA512: ".sha512",
+}
+
+// Store the content to the file, the folders of the file will be created
+// if not existed. Returns the error if the file can not be written.
+func StoreFile(fileName string, content string, overWrite bool) error {
 	exists := false
 	if FileOrDirExists(fileName) {
 		if overWrite {
@@ -53,24 +70,24 @@ func StoreFile(fileName string, content string, overWrite bool) {
 	if !exists {
 		folder := path.Dir(fileName)
 		if !FileOrDirExists(folder) {
-			os.MkdirAll(folder, 0700)
+			if err = os.MkdirAll(folder, 0700); err != nil {
+				return err
+			}
 		}
 		f, err = os.Create(fileName)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	} else {
-		f, err = os.Open(fileName)
+		f, err = os.OpenFile(fileName, os.O_WRONLY|os.O_TRUNC, 0)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
+	defer f.Close()
 
 	_, err = f.Write([]byte(content))
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
+	return err
 }
 
 func FileOrDirExists(name string) bool {
@@ -139,7 +156,7 @@ func ReadSHA1(file string) string {
 	if !slices.Contains(nonSearchSuffix, suffix) {
 		sha1File := file + ".sha1"
 		if IsFile(sha1File) {
-			content, _ := ReadFile(sha1File)
+			content, _ := ReadDigestFile(sha1File)
 			return content
 		}
 	}
@@ -164,6 +181,50 @@ func Digest(file string, hash crypto.Hash) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// This function will caculate the hash values of a file for all the hash types
+// with only one reading of the file content. Returns an empty map if the
+// file can not be read.
+func DigestAll(file string, hashes ...crypto.Hash) map[crypto.Hash]string {
+	result := map[crypto.Hash]string{}
+	if !IsFile(file) {
+		return result
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return result
+	}
+	defer f.Close()
+
+	writers := make([]io.Writer, len(hashes))
+	hs := make([]hash.Hash, len(hashes))
+	for i, h := range hashes {
+		hs[i] = h.New()
+		writers[i] = hs[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return result
+	}
+	for i, h := range hashes {
+		result[h] = hex.EncodeToString(hs[i].Sum(nil))
+	}
+	return result
+}
+
+// Read the hash value from a digest file. Some tools will append the file
+// name after the hash value, like "${hash}  ${file}", so only the first
+// field will be used.
+func ReadDigestFile(digestFile string) (string, error) {
+	content, err := ReadFile(digestFile)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
 // This function will caculate the hash value for the string content with the specified hash type
 func DigestContent(content string, hash crypto.Hash) string {
 	h := hash.New()
@@ -171,7 +232,7 @@ func DigestContent(content string, hash crypto.Hash) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func WriteManifest(paths []string, root, productKey string) (string, string) {
+func WriteManifest(paths []string, root, productKey string) (string, string, error) {
 	manifestName := productKey + util.MANIFEST_SUFFIX
 	manifestPath := path.Join(root, manifestName)
 	artifacts := []string{}
@@ -180,6 +241,6 @@ func WriteManifest(paths []string, root, productKey string) (string, string) {
 		p = strings.TrimPrefix(p, "/")
 		artifacts = append(artifacts, p)
 	}
-	StoreFile(manifestPath, strings.Join(artifacts, "\n"), true)
-	return manifestName, manifestPath
+	err := StoreFile(manifestPath, strings.Join(artifacts, "\n"), true)
+	return manifestName, manifestPath, err
 }
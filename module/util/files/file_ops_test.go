@@ -29,6 +29,15 @@ func TestStoreFile(t *testing.T) {
 	actual, _ := io.ReadAll(f)
 	assert.Equal(t, fileContent, string(actual), "Stored file content should be correct")
 
+	// The folder of the file can not be created under a regular file
+	err := StoreFile(path.Join(fileName, "sub", "file"), fileContent, true)
+	assert.NotNil(t, err)
+
+	// The existing file is rewritten in place without overWrite
+	err = StoreFile(fileName, "short", false)
+	assert.Nil(t, err)
+	content, _ := ReadFile(fileName)
+	assert.Equal(t, "short", content)
 }
 
 func TestIsFile(t *testing.T) {
@@ -88,6 +97,27 @@ func TestReadSHA1(t *testing.T) {
 	assert.Equal(t, Digest(testFile, crypto.SHA1), ReadSHA1(testFile))
 }
 
+func TestDigestAll(t *testing.T) {
+	testFile := path.Join("../../../tests/input", "commons-lang3.zip")
+	digests := DigestAll(testFile, SHA1, SHA256, SHA512)
+	assert.Equal(t, 3, len(digests))
+	assert.Equal(t, "bd4fe0a8111df64430b6b419a91e4218ddf44734", digests[SHA1])
+	assert.Equal(t,
+		"61ff1d38cfeb281b05fcd6b9a2318ed47cd62c7f99b8a9d3e819591c03fe6804",
+		digests[SHA256])
+	assert.Equal(t, Digest(testFile, SHA512), digests[SHA512])
+	assert.Empty(t, DigestAll("/kljsdflksdjf", SHA1))
+}
+
+func TestReadDigestFile(t *testing.T) {
+	digestFile := fmt.Sprintf("/tmp/%d.sha1", nowInMillis())
+	defer os.Remove(digestFile)
+	StoreFile(digestFile, "BD4FE0A8111DF64430B6B419A91E4218DDF44734  commons-lang3.zip\n", true)
+	digest, err := ReadDigestFile(digestFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "bd4fe0a8111df64430b6b419a91e4218ddf44734", digest)
+}
+
 func nowInMillis() int64 {
 	return time.Now().UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
 }
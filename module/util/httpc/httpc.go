@@ -150,6 +150,12 @@ func DownloadFile(url, filename string, auth Authenticate) (string, error) {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", HTTPError{
+			Message:    fmt.Sprintf("Can not download file %s, status: %s", url, resp.Status),
+			StatusCode: resp.StatusCode,
+		}
+	}
 	logger.Debug("The api is trying to download a file")
 	conDispo := resp.Header.Get("Content-Disposition")
 	filePath := ""
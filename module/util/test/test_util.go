@@ -95,7 +95,9 @@ func (b *BaseTest) ChangeConfigContent(content string) {
 
 func prepareConfig(configBase, fileContent string) error {
 	configPath := path.Join(configBase, "charon.yaml")
-	files.StoreFile(configPath, fileContent, true)
+	if err := files.StoreFile(configPath, fileContent, true); err != nil {
+		return err
+	}
 	if !files.FileOrDirExists(configPath) {
 		return fmt.Errorf("configuration initilization failed")
 	}